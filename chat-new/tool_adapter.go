@@ -11,6 +11,7 @@ import (
 	"github.com/sashabaranov/go-openai"
 
 	"gentica/llm/tools"
+	"gentica/permission"
 )
 
 // ToolAdapter wraps an llm/tools.BaseTool to work with chat-new's Function interface
@@ -97,18 +98,27 @@ func generateToolCallID() string {
 
 // RegisterLLMTools registers all llm/tools with the function registry
 func RegisterLLMTools(registry *FunctionRegistry, workingDir string) {
+	// The chat has no way to answer permission requests, so tools run
+	// without asking, as they always did here.
+	permissions := permission.NewPermissionService(workingDir, permission.Options{SkipRequests: true})
+	// Nor does it keep sessions, so file changes have no history to go to.
+	jobs := tools.NewJobs()
+
 	// Initialize all tools from llm/tools package
 	llmTools := []tools.BaseTool{
-		tools.NewBashTool(workingDir),
+		tools.NewBashTool(workingDir, tools.BashOptions{Permissions: permissions, Jobs: jobs}),
+		tools.NewJobOutputTool(jobs),
+		tools.NewJobInputTool(jobs),
+		tools.NewJobKillTool(jobs),
 		tools.NewViewTool(workingDir),
-		tools.NewWriteTool(workingDir),
-		tools.NewEditTool(workingDir),
-		tools.NewMultiEditTool(workingDir),
+		tools.NewWriteTool(nil, workingDir),
+		tools.NewEditTool(nil, workingDir),
+		tools.NewMultiEditTool(nil, workingDir),
 		tools.NewGrepTool(workingDir),
 		tools.NewGlobTool(workingDir),
 		tools.NewLsTool(workingDir),
-		tools.NewFetchTool(workingDir),
-		tools.NewDownloadTool(workingDir),
+		tools.NewFetchTool(permissions, workingDir),
+		tools.NewDownloadTool(permissions, workingDir),
 	}
 
 	// Convert and register each tool
@@ -128,7 +128,7 @@ func TestBashToolIntegration(t *testing.T) {
 	}
 
 	// Create BashTool adapter
-	bashTool := tools.NewBashTool(workingDir)
+	bashTool := tools.NewBashTool(workingDir, tools.BashOptions{})
 	adapter := NewToolAdapter(bashTool)
 	function := adapter.ConvertToFunction()
 
@@ -154,7 +154,7 @@ func TestWriteToolIntegration(t *testing.T) {
 	testFile := filepath.Join(tempDir, "output.txt")
 
 	// Create WriteTool adapter
-	writeTool := tools.NewWriteTool(tempDir)
+	writeTool := tools.NewWriteTool(nil, tempDir)
 	adapter := NewToolAdapter(writeTool)
 	function := adapter.ConvertToFunction()
 
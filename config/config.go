@@ -132,10 +132,55 @@ type TUIOptions struct {
 }
 
 type Permissions struct {
-	AllowedTools []string `json:"allowed_tools,omitempty" jsonschema:"description=List of tools that don't require permission prompts,example=bash,example=view"` // Tools that don't require permission prompts
-	SkipRequests bool     `json:"-"`                                                                                                                              // Automatically accept all permissions (YOLO mode)
+	AllowedTools []string     `json:"allowed_tools,omitempty" jsonschema:"description=List of tools that don't require permission prompts,example=bash,example=view"` // Tools that don't require permission prompts
+	SkipRequests bool         `json:"-"`                                                                                                                              // Automatically accept all permissions (YOLO mode)
+	Commands     CommandRules `json:"commands,omitempty" jsonschema:"description=Rules for the shell commands of the bash tool"`
+	Sandbox      Sandbox      `json:"sandbox,omitempty" jsonschema:"description=Confinement of the shell commands of the bash tool"`
 }
 
+// CommandRules allow, ask about or deny shell commands that start with the
+// given words. The rule matching most words of a command wins; of equally
+// long rules, deny wins over ask and ask over allow. They take precedence
+// over the built-in rules.
+type CommandRules struct {
+	Allow []string `json:"allow,omitempty" jsonschema:"description=Commands that run without asking,example=go test,example=npm run"`
+	Ask   []string `json:"ask,omitempty" jsonschema:"description=Commands that need permission,example=git push"`
+	Deny  []string `json:"deny,omitempty" jsonschema:"description=Commands that never run,example=rm -rf,example=docker"`
+}
+
+type SandboxMode string
+
+const (
+	SandboxModeOff SandboxMode = "off"
+	SandboxModeOn  SandboxMode = "on"
+)
+
+// Sandbox confines the commands of the bash tool: they only write to the
+// working directory and a temporary directory, reach the network when
+// allowed, and have limited CPU time, memory and processes. It needs Linux
+// with landlock, and user namespaces to turn off the network.
+type Sandbox struct {
+	Mode      SandboxMode `json:"mode,omitempty" jsonschema:"description=Whether commands run in the sandbox; on fails with the reason when the system cannot confine them,enum=off,enum=on,default=off"`
+	Network   bool        `json:"network,omitempty" jsonschema:"description=Let commands reach the network,default=false"`
+	CPUTime   int         `json:"cpu_time,omitempty" jsonschema:"description=CPU time in seconds each process may use,default=600"`
+	Memory    int         `json:"memory,omitempty" jsonschema:"description=Data memory in MiB each process may use,default=4096"`
+	Processes int         `json:"processes,omitempty" jsonschema:"description=Number of processes that may run at once; not limited when network is on,default=512"`
+}
+
+// HookConfig is a command to run on an agent event. The command reads the
+// event as JSON on stdin. Its stdout, when not JSON, is added as context;
+// after the model's answer, only JSON output with "continue": true prompts
+// the model again.
+type HookConfig struct {
+	Command string   `json:"command" jsonschema:"required,description=Shell command to run; it reads the event as JSON on stdin,example=gofmt -l ."`
+	Tools   []string `json:"tools,omitempty" jsonschema:"description=Tools the hook runs for; all tools if empty,example=bash,example=edit"`
+	Timeout int      `json:"timeout,omitempty" jsonschema:"description=Timeout in seconds for the command,default=60,example=10"`
+}
+
+// Hooks maps events to the commands that run on them, in order. The events
+// are pre_tool_use, post_tool_use, pre_turn, post_turn and session_start.
+type Hooks map[string][]HookConfig
+
 type Options struct {
 	ContextPaths         []string    `json:"context_paths,omitempty" jsonschema:"description=Paths to files containing context information for the AI,example=.cursorrules,example=CRUSH.md"`
 	TUI                  *TUIOptions `json:"tui,omitempty" jsonschema:"description=Terminal user interface options"`
@@ -253,6 +298,8 @@ type Config struct {
 
 	Permissions *Permissions `json:"permissions,omitempty" jsonschema:"description=Permission settings for tool usage"`
 
+	Hooks Hooks `json:"hooks,omitempty" jsonschema:"description=Commands to run before and after tool calls and turns, and when a session starts"`
+
 	// Internal
 	workingDir string `json:"-"`
 	// TODO: most likely remove this concept when I come back to it
@@ -415,6 +462,22 @@ func (c *Config) SetProviderAPIKey(providerID, apiKey string) error {
 	return nil
 }
 
+// AllowTool adds an entry to the tools that never ask for permission and
+// saves it to the config file.
+func (c *Config) AllowTool(allowedTool string) error {
+	if c.Permissions == nil {
+		c.Permissions = &Permissions{}
+	}
+	if slices.Contains(c.Permissions.AllowedTools, allowedTool) {
+		return nil
+	}
+	c.Permissions.AllowedTools = append(c.Permissions.AllowedTools, allowedTool)
+	if err := c.SetConfigField("permissions.allowed_tools", c.Permissions.AllowedTools); err != nil {
+		return fmt.Errorf("failed to save allowed tools: %w", err)
+	}
+	return nil
+}
+
 func (c *Config) SetupAgents() {
 	agents := map[string]Agent{
 		"coder": {
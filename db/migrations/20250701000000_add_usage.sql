@@ -0,0 +1,45 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Usage of every model response. Session token counts and costs are
+-- derived from it.
+CREATE TABLE IF NOT EXISTS usage (
+    id TEXT PRIMARY KEY,
+    session_id TEXT NOT NULL,
+    message_id TEXT,
+    model TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    input_tokens INTEGER NOT NULL DEFAULT 0 CHECK (input_tokens >= 0),
+    output_tokens INTEGER NOT NULL DEFAULT 0 CHECK (output_tokens >= 0),
+    cache_creation_tokens INTEGER NOT NULL DEFAULT 0 CHECK (cache_creation_tokens >= 0),
+    cache_read_tokens INTEGER NOT NULL DEFAULT 0 CHECK (cache_read_tokens >= 0),
+    cost REAL NOT NULL DEFAULT 0.0 CHECK (cost >= 0.0),
+    created_at INTEGER NOT NULL,  -- Unix timestamp in seconds
+    FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_session_id ON usage (session_id);
+CREATE INDEX IF NOT EXISTS idx_usage_created_at ON usage (created_at);
+
+-- Sessions add up what was recorded for them. Their cost also includes what
+-- their task sessions spent.
+CREATE TRIGGER IF NOT EXISTS update_session_usage_on_insert
+AFTER INSERT ON usage
+BEGIN
+UPDATE sessions SET
+    prompt_tokens = prompt_tokens + new.input_tokens + new.cache_creation_tokens + new.cache_read_tokens,
+    completion_tokens = completion_tokens + new.output_tokens,
+    cost = cost + new.cost
+WHERE id = new.session_id;
+UPDATE sessions SET
+    cost = cost + new.cost
+WHERE id = (SELECT parent_session_id FROM sessions WHERE id = new.session_id);
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS update_session_usage_on_insert;
+DROP INDEX IF EXISTS idx_usage_created_at;
+DROP INDEX IF EXISTS idx_usage_session_id;
+DROP TABLE IF EXISTS usage;
+-- +goose StatementEnd
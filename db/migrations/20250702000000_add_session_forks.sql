@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Forks are sessions that branched off their parent session at a message.
+-- They are listed like top-level sessions and, unlike task sessions, do not
+-- add to the cost of their parent.
+ALTER TABLE sessions ADD COLUMN fork_message_id TEXT;
+
+DROP TRIGGER IF EXISTS update_session_usage_on_insert;
+
+CREATE TRIGGER IF NOT EXISTS update_session_usage_on_insert
+AFTER INSERT ON usage
+BEGIN
+UPDATE sessions SET
+    prompt_tokens = prompt_tokens + new.input_tokens + new.cache_creation_tokens + new.cache_read_tokens,
+    completion_tokens = completion_tokens + new.output_tokens,
+    cost = cost + new.cost
+WHERE id = new.session_id;
+UPDATE sessions SET
+    cost = cost + new.cost
+WHERE id = (SELECT parent_session_id FROM sessions WHERE id = new.session_id AND fork_message_id IS NULL);
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS update_session_usage_on_insert;
+
+CREATE TRIGGER IF NOT EXISTS update_session_usage_on_insert
+AFTER INSERT ON usage
+BEGIN
+UPDATE sessions SET
+    prompt_tokens = prompt_tokens + new.input_tokens + new.cache_creation_tokens + new.cache_read_tokens,
+    completion_tokens = completion_tokens + new.output_tokens,
+    cost = cost + new.cost
+WHERE id = new.session_id;
+UPDATE sessions SET
+    cost = cost + new.cost
+WHERE id = (SELECT parent_session_id FROM sessions WHERE id = new.session_id);
+END;
+
+ALTER TABLE sessions DROP COLUMN fork_message_id;
+-- +goose StatementEnd
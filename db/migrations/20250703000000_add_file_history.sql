@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Versions saved by a tool call belong to the message that made the call.
+-- Versions that record a file as it was before a change have no message;
+-- is_new marks those that record that the file did not exist yet.
+ALTER TABLE files ADD COLUMN message_id TEXT;
+ALTER TABLE files ADD COLUMN is_new INTEGER NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE files DROP COLUMN is_new;
+ALTER TABLE files DROP COLUMN message_id;
+-- +goose StatementEnd
@@ -26,13 +26,31 @@ ORDER BY version DESC, created_at DESC;
 INSERT INTO files (
     id,
     session_id,
+    message_id,
     path,
     content,
     version,
+    is_new,
     created_at,
     updated_at
 ) VALUES (
-    ?, ?, ?, ?, ?, strftime('%s', 'now'), strftime('%s', 'now')
+    ?, ?, ?, ?, ?, ?, ?, strftime('%s', 'now'), strftime('%s', 'now')
+)
+RETURNING *;
+
+-- name: CopyFile :one
+INSERT INTO files (
+    id,
+    session_id,
+    message_id,
+    path,
+    content,
+    version,
+    is_new,
+    created_at,
+    updated_at
+) VALUES (
+    ?, ?, ?, ?, ?, ?, ?, ?, ?
 )
 RETURNING *;
 
@@ -0,0 +1,70 @@
+-- name: CreateUsage :one
+INSERT INTO usage (
+    id,
+    session_id,
+    message_id,
+    model,
+    provider,
+    input_tokens,
+    output_tokens,
+    cache_creation_tokens,
+    cache_read_tokens,
+    cost,
+    created_at
+) VALUES (
+    ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, strftime('%s', 'now')
+)
+RETURNING *;
+
+-- name: ListUsageBySession :many
+SELECT *
+FROM usage
+WHERE session_id = ?
+ORDER BY created_at ASC, rowid ASC;
+
+-- name: GetLastMessageUsage :one
+SELECT *
+FROM usage
+WHERE session_id = ? AND message_id IS NOT NULL
+ORDER BY created_at DESC, rowid DESC
+LIMIT 1;
+
+-- name: GetSessionUsageTotals :one
+SELECT
+    COUNT(*) AS responses,
+    CAST(COALESCE(SUM(input_tokens), 0) AS INTEGER) AS input_tokens,
+    CAST(COALESCE(SUM(output_tokens), 0) AS INTEGER) AS output_tokens,
+    CAST(COALESCE(SUM(cache_creation_tokens), 0) AS INTEGER) AS cache_creation_tokens,
+    CAST(COALESCE(SUM(cache_read_tokens), 0) AS INTEGER) AS cache_read_tokens,
+    CAST(COALESCE(SUM(cost), 0.0) AS REAL) AS cost
+FROM usage
+WHERE session_id = ?;
+
+-- name: ListUsageTotalsByModel :many
+SELECT
+    provider,
+    model,
+    COUNT(*) AS responses,
+    CAST(COALESCE(SUM(input_tokens), 0) AS INTEGER) AS input_tokens,
+    CAST(COALESCE(SUM(output_tokens), 0) AS INTEGER) AS output_tokens,
+    CAST(COALESCE(SUM(cache_creation_tokens), 0) AS INTEGER) AS cache_creation_tokens,
+    CAST(COALESCE(SUM(cache_read_tokens), 0) AS INTEGER) AS cache_read_tokens,
+    CAST(COALESCE(SUM(cost), 0.0) AS REAL) AS cost
+FROM usage
+WHERE created_at >= ?
+GROUP BY provider, model
+ORDER BY cost DESC;
+
+-- name: ListUsageTotalsByDay :many
+SELECT
+    CAST(date(created_at, 'unixepoch') AS TEXT) AS day,
+    COUNT(*) AS responses,
+    CAST(COALESCE(SUM(input_tokens), 0) AS INTEGER) AS input_tokens,
+    CAST(COALESCE(SUM(output_tokens), 0) AS INTEGER) AS output_tokens,
+    CAST(COALESCE(SUM(cache_creation_tokens), 0) AS INTEGER) AS cache_creation_tokens,
+    CAST(COALESCE(SUM(cache_read_tokens), 0) AS INTEGER) AS cache_read_tokens,
+    CAST(COALESCE(SUM(cost), 0.0) AS REAL) AS cost
+FROM usage
+WHERE created_at >= ?
+GROUP BY day
+ORDER BY day ASC;
@@ -0,0 +1,314 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"gentica/db"
+	"gentica/pubsub"
+	"github.com/google/uuid"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// File is one version of a file in the history of a session. Versions
+// saved by a tool call have the MessageID of the message that made the
+// call. Versions without a MessageID record the file as it was before a
+// change; IsNew marks those that record that it did not exist yet.
+type File struct {
+	ID        string
+	SessionID string
+	MessageID string
+	Path      string
+	Content   string
+	Version   int64
+	IsNew     bool
+	CreatedAt int64
+	UpdatedAt int64
+}
+
+// Change is a tool call about to change the file at Path from Before to
+// After. Created is set when the file does not exist yet.
+type Change struct {
+	MessageID string
+	Path      string
+	Before    string
+	After     string
+	Created   bool
+}
+
+// FileDiff compares a file as it was before the session first changed it
+// to its latest version.
+type FileDiff struct {
+	Path      string
+	Created   bool
+	Deleted   bool
+	Additions int
+	Removals  int
+	// Diff is a unified diff of the two versions.
+	Diff string
+}
+
+type Service interface {
+	pubsub.Suscriber[File]
+	// Record saves the change as a new version of the file. The file as it
+	// was before is saved first when it is not the latest version.
+	Record(ctx context.Context, sessionID string, change Change) error
+	List(ctx context.Context, sessionID string) ([]File, error)
+	// Rewind restores the files changed by messageID, and by the messages
+	// of the session after it, to how they were before messageID. It
+	// returns the paths it restored.
+	Rewind(ctx context.Context, sessionID, messageID string) ([]string, error)
+	// Diff reports the files the session changed, sorted by path.
+	Diff(ctx context.Context, sessionID string) ([]FileDiff, error)
+}
+
+type service struct {
+	*pubsub.Broker[File]
+	q db.Querier
+	// mu keeps versions of a path from being numbered concurrently.
+	mu sync.Mutex
+}
+
+func NewService(q db.Querier) Service {
+	return &service{
+		Broker: pubsub.NewBroker[File](),
+		q:      q,
+	}
+}
+
+func (s *service) Record(ctx context.Context, sessionID string, change Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	latest, err := s.q.GetFileByPathAndSession(ctx, db.GetFileByPathAndSessionParams{
+		Path:      change.Path,
+		SessionID: sessionID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		latest, err = s.create(ctx, sessionID, "", change.Path, change.Before, 0, change.Created)
+	} else if err == nil && !matches(latest, change) {
+		// The file was changed outside of the session since.
+		latest, err = s.create(ctx, sessionID, "", change.Path, change.Before, latest.Version+1, change.Created)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %w", change.Path, err)
+	}
+	if _, err := s.create(ctx, sessionID, change.MessageID, change.Path, change.After, latest.Version+1, false); err != nil {
+		return fmt.Errorf("failed to save %s: %w", change.Path, err)
+	}
+	return nil
+}
+
+// matches reports whether file is the file as it was before change.
+func matches(file db.File, change Change) bool {
+	if file.IsNew == 1 {
+		return change.Created
+	}
+	return !change.Created && file.Content == change.Before
+}
+
+func (s *service) create(ctx context.Context, sessionID, messageID, path, content string, version int64, isNew bool) (db.File, error) {
+	dbFile, err := s.q.CreateFile(ctx, db.CreateFileParams{
+		ID:        uuid.New().String(),
+		SessionID: sessionID,
+		MessageID: sql.NullString{String: messageID, Valid: messageID != ""},
+		Path:      path,
+		Content:   content,
+		Version:   version,
+		IsNew:     boolToInt(isNew),
+	})
+	if err != nil {
+		return db.File{}, err
+	}
+	s.Publish(pubsub.CreatedEvent, fromDBItem(dbFile))
+	return dbFile, nil
+}
+
+func (s *service) List(ctx context.Context, sessionID string) ([]File, error) {
+	dbFiles, err := s.q.ListFilesBySession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]File, len(dbFiles))
+	for i, dbFile := range dbFiles {
+		files[i] = fromDBItem(dbFile)
+	}
+	return files, nil
+}
+
+func (s *service) Rewind(ctx context.Context, sessionID, messageID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs, err := s.q.ListMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	at := slices.IndexFunc(msgs, func(msg db.Message) bool {
+		return msg.ID == messageID
+	})
+	if at == -1 {
+		return nil, fmt.Errorf("message %s is not in session %s", messageID, sessionID)
+	}
+	undone := make(map[string]bool, len(msgs)-at)
+	for _, msg := range msgs[at:] {
+		undone[msg.ID] = true
+	}
+
+	paths, versions, err := s.listByPath(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var restored []string
+	for _, path := range paths {
+		files := versions[path]
+		first := slices.IndexFunc(files, func(file db.File) bool {
+			return undone[file.MessageID.String]
+		})
+		if first < 1 {
+			continue
+		}
+		before := files[first-1]
+		if err := restore(before); err != nil {
+			return restored, fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		// Save the restored version, so that the history stays in step
+		// with the files.
+		latest := files[len(files)-1]
+		if _, err := s.create(ctx, sessionID, "", path, before.Content, latest.Version+1, before.IsNew == 1); err != nil {
+			return restored, fmt.Errorf("failed to save %s: %w", path, err)
+		}
+		restored = append(restored, path)
+	}
+	slices.Sort(restored)
+	return restored, nil
+}
+
+func (s *service) Diff(ctx context.Context, sessionID string) ([]FileDiff, error) {
+	paths, versions, err := s.listByPath(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(paths)
+	var diffs []FileDiff
+	for _, path := range paths {
+		files := versions[path]
+		first, last := files[0], files[len(files)-1]
+		if first.IsNew == last.IsNew && first.Content == last.Content {
+			continue
+		}
+		diff, err := fileDiff(path, first, last)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// listByPath returns the paths of the files of the session, and their
+// versions, oldest first.
+func (s *service) listByPath(ctx context.Context, sessionID string) ([]string, map[string][]db.File, error) {
+	files, err := s.q.ListFilesBySession(ctx, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var paths []string
+	versions := make(map[string][]db.File)
+	for _, file := range files {
+		if _, ok := versions[file.Path]; !ok {
+			paths = append(paths, file.Path)
+		}
+		versions[file.Path] = append(versions[file.Path], file)
+	}
+	return paths, versions, nil
+}
+
+func restore(file db.File) error {
+	if file.IsNew == 1 {
+		if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(file.Path); err == nil {
+		mode = info.Mode()
+	}
+	if err := os.MkdirAll(filepath.Dir(file.Path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(file.Path, []byte(file.Content), mode)
+}
+
+func fileDiff(path string, first, last db.File) (FileDiff, error) {
+	var a, b []string
+	if first.IsNew == 0 {
+		a = splitLines(first.Content)
+	}
+	if last.IsNew == 0 {
+		b = splitLines(last.Content)
+	}
+	diff := FileDiff{
+		Path:    path,
+		Created: first.IsNew == 1 && last.IsNew == 0,
+		Deleted: first.IsNew == 0 && last.IsNew == 1,
+	}
+	for _, op := range difflib.NewMatcher(a, b).GetOpCodes() {
+		if op.Tag == 'e' {
+			continue
+		}
+		diff.Removals += op.I2 - op.I1
+		diff.Additions += op.J2 - op.J1
+	}
+	text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        a,
+		B:        b,
+		FromFile: path,
+		ToFile:   path,
+		Context:  3,
+	})
+	if err != nil {
+		return FileDiff{}, fmt.Errorf("failed to diff %s: %w", path, err)
+	}
+	diff.Diff = text
+	return diff, nil
+}
+
+// splitLines splits content after each newline. Unlike difflib.SplitLines it
+// does not add an empty line to content that ends with one.
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func fromDBItem(item db.File) File {
+	return File{
+		ID:        item.ID,
+		SessionID: item.SessionID,
+		MessageID: item.MessageID.String,
+		Path:      item.Path,
+		Content:   item.Content,
+		Version:   item.Version,
+		IsNew:     item.IsNew == 1,
+		CreatedAt: item.CreatedAt,
+		UpdatedAt: item.UpdatedAt,
+	}
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
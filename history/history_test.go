@@ -0,0 +1,189 @@
+package history_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gentica/db"
+	"gentica/history"
+	"gentica/message"
+	"gentica/session"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testEnv struct {
+	files     history.Service
+	messages  message.Service
+	sessionID string
+	dir       string
+}
+
+func newTestEnv(t *testing.T) testEnv {
+	t.Helper()
+	ctx := context.Background()
+	conn, err := db.Connect(ctx, t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	q := db.New(conn)
+	sess, err := session.NewService(q).Create(ctx, "test")
+	require.NoError(t, err)
+	return testEnv{
+		files:     history.NewService(q),
+		messages:  message.NewService(q),
+		sessionID: sess.ID,
+		dir:       t.TempDir(),
+	}
+}
+
+func (env testEnv) newMessage(t *testing.T) string {
+	t.Helper()
+	msg, err := env.messages.Create(context.Background(), env.sessionID, message.CreateMessageParams{
+		Role:  message.Assistant,
+		Parts: []message.ContentPart{message.TextContent{Text: "editing"}},
+	})
+	require.NoError(t, err)
+	return msg.ID
+}
+
+// write records the change and makes it, like the tools do.
+func (env testEnv) write(t *testing.T, messageID, path, content string) {
+	t.Helper()
+	before, err := os.ReadFile(path)
+	created := os.IsNotExist(err)
+	if !created {
+		require.NoError(t, err)
+	}
+	err = env.files.Record(context.Background(), env.sessionID, history.Change{
+		MessageID: messageID,
+		Path:      path,
+		Before:    string(before),
+		After:     content,
+		Created:   created,
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestRecord(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	env := newTestEnv(t)
+	path := filepath.Join(env.dir, "main.go")
+	first := env.newMessage(t)
+	env.write(t, first, path, "package main\n")
+	second := env.newMessage(t)
+	env.write(t, second, path, "package main\n\nfunc main() {}\n")
+
+	files, err := env.files.List(ctx, env.sessionID)
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+	require.True(t, files[0].IsNew)
+	require.Empty(t, files[0].MessageID)
+	require.Equal(t, first, files[1].MessageID)
+	require.Equal(t, second, files[2].MessageID)
+	require.Equal(t, []int64{0, 1, 2}, []int64{files[0].Version, files[1].Version, files[2].Version})
+
+	t.Run("saves changes made outside of the session", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("package lib\n"), 0o644))
+		env.write(t, env.newMessage(t), path, "package app\n")
+
+		files, err := env.files.List(ctx, env.sessionID)
+		require.NoError(t, err)
+		require.Len(t, files, 5)
+		require.Empty(t, files[3].MessageID)
+		require.Equal(t, "package lib\n", files[3].Content)
+		require.Equal(t, "package app\n", files[4].Content)
+	})
+}
+
+func TestRewind(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	env := newTestEnv(t)
+	existing := filepath.Join(env.dir, "existing.go")
+	require.NoError(t, os.WriteFile(existing, []byte("package existing\n"), 0o644))
+	created := filepath.Join(env.dir, "created.go")
+
+	first := env.newMessage(t)
+	env.write(t, first, existing, "package changed\n")
+	second := env.newMessage(t)
+	env.write(t, second, existing, "package changed_again\n")
+	env.write(t, second, created, "package created\n")
+
+	t.Run("restores the files changed from the message on", func(t *testing.T) {
+		restored, err := env.files.Rewind(ctx, env.sessionID, second)
+		require.NoError(t, err)
+		require.Equal(t, []string{created, existing}, restored)
+		require.Equal(t, "package changed\n", readFile(t, existing))
+		require.NoFileExists(t, created)
+	})
+
+	t.Run("can rewind further", func(t *testing.T) {
+		restored, err := env.files.Rewind(ctx, env.sessionID, first)
+		require.NoError(t, err)
+		require.Equal(t, []string{created, existing}, restored)
+		require.Equal(t, "package existing\n", readFile(t, existing))
+		require.NoFileExists(t, created)
+	})
+
+	t.Run("keeps recording afterwards", func(t *testing.T) {
+		env.write(t, env.newMessage(t), existing, "package rewritten\n")
+
+		files, err := env.files.List(ctx, env.sessionID)
+		require.NoError(t, err)
+		last := files[len(files)-1]
+		require.Equal(t, "package rewritten\n", last.Content)
+		require.Equal(t, "package existing\n", files[len(files)-2].Content)
+	})
+
+	t.Run("needs a message of the session", func(t *testing.T) {
+		_, err := env.files.Rewind(ctx, env.sessionID, "missing")
+		require.Error(t, err)
+	})
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	env := newTestEnv(t)
+	existing := filepath.Join(env.dir, "existing.go")
+	require.NoError(t, os.WriteFile(existing, []byte("package existing\n\nvar a = 1\n"), 0o644))
+	created := filepath.Join(env.dir, "created.go")
+	unchanged := filepath.Join(env.dir, "unchanged.go")
+	require.NoError(t, os.WriteFile(unchanged, []byte("package unchanged\n"), 0o644))
+
+	msg := env.newMessage(t)
+	env.write(t, msg, existing, "package existing\n\nvar a = 2\nvar b = 3\n")
+	env.write(t, msg, created, "package created\n")
+	env.write(t, msg, unchanged, "package changed\n")
+	env.write(t, env.newMessage(t), unchanged, "package unchanged\n")
+
+	diffs, err := env.files.Diff(ctx, env.sessionID)
+	require.NoError(t, err)
+	require.Len(t, diffs, 2)
+
+	require.Equal(t, created, diffs[0].Path)
+	require.True(t, diffs[0].Created)
+	require.Equal(t, 1, diffs[0].Additions)
+	require.Zero(t, diffs[0].Removals)
+
+	require.Equal(t, existing, diffs[1].Path)
+	require.False(t, diffs[1].Created)
+	require.Equal(t, 2, diffs[1].Additions)
+	require.Equal(t, 1, diffs[1].Removals)
+	require.Contains(t, diffs[1].Diff, "-var a = 1\n")
+	require.Contains(t, diffs[1].Diff, "+var a = 2\n+var b = 3\n")
+}
@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"gentica/config"
+	"gentica/llm/hooks"
+	"gentica/llm/policy"
+	"gentica/llm/tools"
+)
+
+// NewAgentConfig builds the configuration of the agent defined in cfg.
+// Built-in and MCP tools are filtered by the agent's allow lists, and the
+// agent's context files are read from workingDir into the system prompt.
+func NewAgentConfig(cfg config.Agent, systemPrompt, workingDir string, builtinTools, mcpTools []tools.BaseTool) AgentConfig {
+	var agentTools []tools.BaseTool
+	for _, tool := range builtinTools {
+		// A nil list allows every tool.
+		if cfg.AllowedTools == nil || slices.Contains(cfg.AllowedTools, tool.Name()) {
+			agentTools = append(agentTools, tool)
+		}
+	}
+	for _, tool := range mcpTools {
+		if mcpToolAllowed(cfg, tool) {
+			agentTools = append(agentTools, tool)
+		}
+	}
+
+	return AgentConfig{
+		ID:           cfg.ID,
+		Name:         cfg.Name,
+		SystemPrompt: systemPrompt,
+		Tools:        agentTools,
+		WorkingDir:   workingDir,
+		ContextPaths: cfg.ContextPaths,
+	}
+}
+
+// mcpToolAllowed reports whether the agent may use an MCP tool. A nil
+// AllowedMCP allows every server and a nil tool list every tool of the
+// server.
+func mcpToolAllowed(cfg config.Agent, tool tools.BaseTool) bool {
+	if cfg.AllowedMCP == nil {
+		return true
+	}
+	mcpTool, ok := tool.(*McpTool)
+	if !ok {
+		return false
+	}
+	allowedTools, ok := cfg.AllowedMCP[mcpTool.mcpName]
+	if !ok {
+		return false
+	}
+	return allowedTools == nil || slices.Contains(allowedTools, mcpTool.tool.Name)
+}
+
+// NewHooks builds the hooks configured in cfg. Their commands run in
+// workingDir.
+func NewHooks(cfg config.Hooks, workingDir string) (hooks.Hooks, error) {
+	var result hooks.Hooks
+	for _, name := range slices.Sorted(maps.Keys(cfg)) {
+		event := hooks.Event(name)
+		if !slices.Contains(hooks.Events, event) {
+			return nil, fmt.Errorf("unknown hook event: %s", name)
+		}
+		for _, hook := range cfg[name] {
+			result = append(result, hooks.Hook{
+				Event: event,
+				Tools: hook.Tools,
+				Run:   hooks.Command(hook.Command, workingDir, time.Duration(hook.Timeout)*time.Second),
+			})
+		}
+	}
+	return result, nil
+}
+
+// NewCommandPolicy builds the policy of the bash tool from the built-in
+// rules and the rules configured in cfg, which may be nil.
+func NewCommandPolicy(cfg *config.Permissions) *policy.Policy {
+	rules := policy.DefaultRules()
+	if cfg == nil {
+		return policy.New(rules...)
+	}
+	// Later rules win over equally long ones.
+	for _, group := range []struct {
+		action   policy.Action
+		commands []string
+	}{
+		{policy.Allow, cfg.Commands.Allow},
+		{policy.Ask, cfg.Commands.Ask},
+		{policy.Deny, cfg.Commands.Deny},
+	} {
+		for _, command := range group.commands {
+			rules = append(rules, policy.Rule{Action: group.action, Command: command})
+		}
+	}
+	return policy.New(rules...)
+}
+
+// NewSandbox builds the sandbox of the bash tool configured in cfg, which
+// may be nil. It returns nil when the sandbox is off, and an error telling
+// why when the system cannot confine commands.
+func NewSandbox(cfg *config.Permissions, workingDir string) (*tools.Sandbox, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	switch cfg.Sandbox.Mode {
+	case "", config.SandboxModeOff:
+		return nil, nil
+	case config.SandboxModeOn:
+	default:
+		return nil, fmt.Errorf("unknown sandbox mode: %s", cfg.Sandbox.Mode)
+	}
+	return tools.NewSandbox(tools.SandboxConfig{
+		Network:   cfg.Sandbox.Network,
+		CPUTime:   cfg.Sandbox.CPUTime,
+		Memory:    cfg.Sandbox.Memory,
+		Processes: cfg.Sandbox.Processes,
+	}, workingDir)
+}
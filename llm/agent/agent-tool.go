@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
+	"gentica/config"
 	"gentica/llm"
 	"gentica/llm/tools"
 	"gentica/message"
@@ -14,6 +16,7 @@ import (
 
 type agentTool struct {
 	agent    Service
+	config   config.Agent
 	sessions llm.SessionService
 	messages message.Service
 }
@@ -30,10 +33,35 @@ func (b *agentTool) Name() string {
 	return AgentToolName
 }
 
+const agentToolUsage = `Usage notes:
+1. Launch multiple agents concurrently whenever possible, to maximize performance; to do that, use a single message with multiple tool uses
+2. When the agent is done, it will return a single message back to you. The result returned by the agent is not visible to the user. To show the user the result, you should send a text message back to the user with a concise summary of the result.
+3. Each agent invocation is stateless. You will not be able to send additional messages to the agent, nor will the agent be able to communicate with you outside of its final report. Therefore, your prompt should contain a highly detailed task description for the agent to perform autonomously and you should specify exactly what information the agent should return back to you in its final and only message to you.
+4. The agent's outputs should generally be trusted`
+
+func (b *agentTool) description() string {
+	var d strings.Builder
+	d.WriteString("Launch a new agent")
+	if b.config.Description != "" {
+		fmt.Fprintf(&d, " (%s)", strings.TrimSuffix(b.config.Description, "."))
+	}
+	if b.config.AllowedTools == nil {
+		d.WriteString(" that has access to the same tools as you.")
+	} else {
+		fmt.Fprintf(&d, " that has access to the following tools: %s.", strings.Join(b.config.AllowedTools, ", "))
+	}
+	d.WriteString(" When you are searching for a keyword or file and are not confident that you will find the right match on the first try, use the Agent tool to perform the search for you.\n\n")
+	d.WriteString(agentToolUsage)
+	if b.config.AllowedTools != nil {
+		d.WriteString("\n5. IMPORTANT: The agent can only use the tools listed above. If you need any other tool, use it directly instead of going through the agent.")
+	}
+	return d.String()
+}
+
 func (b *agentTool) Info() tools.ToolInfo {
 	return tools.ToolInfo{
 		Name:        AgentToolName,
-		Description: "Launch a new agent that has access to the following tools: GlobTool, GrepTool, LS, View. When you are searching for a keyword or file and are not confident that you will find the right match on the first try, use the Agent tool to perform the search for you. For example:\n\n- If you are searching for a keyword like \"config\" or \"logger\", or for questions like \"which file does X?\", the Agent tool is strongly recommended\n- If you want to read a specific file path, use the View or GlobTool tool instead of the Agent tool, to find the match more quickly\n- If you are searching for a specific class definition like \"class Foo\", use the GlobTool tool instead, to find the match more quickly\n\nUsage notes:\n1. Launch multiple agents concurrently whenever possible, to maximize performance; to do that, use a single message with multiple tool uses\n2. When the agent is done, it will return a single message back to you. The result returned by the agent is not visible to the user. To show the user the result, you should send a text message back to the user with a concise summary of the result.\n3. Each agent invocation is stateless. You will not be able to send additional messages to the agent, nor will the agent be able to communicate with you outside of its final report. Therefore, your prompt should contain a highly detailed task description for the agent to perform autonomously and you should specify exactly what information the agent should return back to you in its final and only message to you.\n4. The agent's outputs should generally be trusted\n5. IMPORTANT: The agent can not use Bash, Replace, Edit, so can not modify files. If you want to use these tools, use them directly instead of going through the agent.",
+		Description: b.description(),
 		Parameters: map[string]any{
 			"prompt": map[string]any{
 				"type":        "string",
@@ -41,9 +69,21 @@ func (b *agentTool) Info() tools.ToolInfo {
 			},
 		},
 		Required: []string{"prompt"},
+		ReadOnly: b.readOnly(),
 	}
 }
 
+// readOnly reports whether the sub-agent only has read-only tools, so that
+// its calls can run alongside other read-only calls.
+func (b *agentTool) readOnly() bool {
+	for _, tool := range b.agent.Tools() {
+		if !tool.Info().ReadOnly {
+			return false
+		}
+	}
+	return true
+}
+
 func (b *agentTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolResponse, error) {
 	var params AgentParams
 	if err := json.Unmarshal([]byte(call.Input), &params); err != nil {
@@ -67,7 +107,9 @@ func (b *agentTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolRes
 	if err != nil {
 		return tools.ToolResponse{}, fmt.Errorf("error generating agent: %s", err)
 	}
-	result := <-done
+	// What the sub-agent spends is recorded for its task session, which
+	// adds it to the cost of the parent session.
+	result := Wait(done)
 	if result.Error != nil {
 		return tools.ToolResponse{}, fmt.Errorf("error generating agent: %s", result.Error)
 	}
@@ -76,27 +118,14 @@ func (b *agentTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolRes
 	if response.Role != message.Assistant {
 		return tools.NewTextErrorResponse("no response"), nil
 	}
-
-	updatedSession, err := b.sessions.Get(ctx, session.ID)
-	if err != nil {
-		return tools.ToolResponse{}, fmt.Errorf("error getting session: %s", err)
-	}
-	parentSession, err := b.sessions.Get(ctx, sessionID)
-	if err != nil {
-		return tools.ToolResponse{}, fmt.Errorf("error getting parent session: %s", err)
-	}
-
-	parentSession.Cost += updatedSession.Cost
-
-	err = b.sessions.Save(ctx, parentSession)
-	if err != nil {
-		return tools.ToolResponse{}, fmt.Errorf("error saving parent session: %s", err)
-	}
 	return tools.NewTextResponse(response.Content().String()), nil
 }
 
+// NewAgentTool creates the tool that hands tasks to a sub-agent. cfg is the
+// definition the sub-agent was built from, usually the "task" agent.
 func NewAgentTool(
 	agent Service,
+	cfg config.Agent,
 	sessions llm.SessionService,
 	messages message.Service,
 ) tools.BaseTool {
@@ -104,5 +133,6 @@ func NewAgentTool(
 		sessions: sessions,
 		messages: messages,
 		agent:    agent,
+		config:   cfg,
 	}
 }
@@ -5,27 +5,46 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 
 	"gentica/pubsub"
 	"gentica/session"
+	"gentica/usage"
 
+	"gentica/llm/hooks"
 	"gentica/llm/tools"
 	"gentica/message"
+	"gentica/permission"
 )
 
 // Common errors
 var (
 	ErrRequestCancelled = errors.New("request canceled by user")
+	// ErrDeniedByHook is returned when a hook stops a run.
+	ErrDeniedByHook = errors.New("denied by hook")
 )
 
+// defaultMaxParallelTools is the number of read-only tool calls that run at
+// the same time unless AgentConfig.MaxParallelTools says otherwise.
+const defaultMaxParallelTools = 4
+
 // AgentState represents the current state of the agent
 type AgentState string
 
 const (
 	AgentStateIdle        AgentState = "idle"
 	AgentStateProcessing  AgentState = "processing"
+	// AgentStatePausing is the state of a run that will pause once its
+	// current tool call is done, and AgentStatePaused of one that waits to
+	// be resumed.
+	AgentStatePausing AgentState = "pausing"
+	AgentStatePaused  AgentState = "paused"
+	// AgentStateInterjecting is the state of a run with notes from the user
+	// that wait for the next safe point.
+	AgentStateInterjecting AgentState = "interjecting"
 )
 
 type AgentEventType string
@@ -33,13 +52,73 @@ type AgentEventType string
 const (
 	AgentEventTypeError     AgentEventType = "error"
 	AgentEventTypeResponse  AgentEventType = "response"
+	AgentEventTypeSummarize AgentEventType = "summarize"
+	// AgentEventTypeQueued is the result of a run that was queued because
+	// the session was busy.
+	AgentEventTypeQueued AgentEventType = "queued"
+	// AgentEventTypeBudgetExceeded is published when a run stops because
+	// its budget or the session's ran out. Error is a *BudgetError.
+	AgentEventTypeBudgetExceeded AgentEventType = "budget_exceeded"
+
+	// Progress of a run. Turns are the model's responses; a run takes one
+	// turn plus one for every round of tool calls.
+	AgentEventTypeTurnStarted      AgentEventType = "turn_started"
+	AgentEventTypeTurnFinished     AgentEventType = "turn_finished"
+	AgentEventTypeContentDelta     AgentEventType = "content_delta"
+	AgentEventTypeThinkingDelta    AgentEventType = "thinking_delta"
+	AgentEventTypeToolCallStarted  AgentEventType = "tool_call_started"
+	AgentEventTypeToolCallFinished AgentEventType = "tool_call_finished"
+	AgentEventTypeUsage            AgentEventType = "usage"
+
+	// Steering of a run. Message is the note of an interjection once it is
+	// delivered.
+	AgentEventTypeInterjected AgentEventType = "interjected"
+	AgentEventTypePaused      AgentEventType = "paused"
+	AgentEventTypeResumed     AgentEventType = "resumed"
 )
 
+// runEventBuffer is how many progress events a run buffers for a caller
+// that reads them slower than they are produced. Progress events that do
+// not fit are dropped rather than holding up the run.
+const runEventBuffer = 64
+
 type AgentEvent struct {
-	Type    AgentEventType
+	Type      AgentEventType
+	SessionID string
+	// Message is the assistant message of the turn for turn events, and
+	// the final message for responses.
 	Message message.Message
 	Error   error
 	Done    bool
+
+	// Delta is the text added by content and thinking deltas.
+	Delta string
+	// ToolCall is set on tool call events.
+	ToolCall *message.ToolCall
+	// ToolResponse is what a finished tool call returned.
+	ToolResponse *tools.ToolResponse
+	// Usage is the token usage of a turn, with Cost what it cost.
+	Usage *TokenUsage
+	Cost  float64
+
+	// QueuedPrompt is the prompt of a queued run and QueuePosition its place
+	// in the queue, 1 being the next to be sent.
+	QueuedPrompt  *QueuedPrompt
+	QueuePosition int
+}
+
+// Wait reads the events of a run until it is over and returns its result,
+// the event of type AgentEventTypeResponse, AgentEventTypeError or
+// AgentEventTypeQueued.
+func Wait(events <-chan AgentEvent) AgentEvent {
+	var result AgentEvent
+	for event := range events {
+		switch event.Type {
+		case AgentEventTypeResponse, AgentEventTypeError, AgentEventTypeQueued:
+			result = event
+		}
+	}
+	return result
 }
 
 // LLMProvider defines a generic interface for LLM providers
@@ -48,12 +127,17 @@ type LLMProvider interface {
 	Model() ModelInfo
 }
 
-// ModelInfo contains basic model information
+// ModelInfo contains basic model information. CostPer1MInCached is the
+// price of writing to the prompt cache and CostPer1MOutCached the price of
+// reading from it.
 type ModelInfo struct {
 	ID                 string
 	Name               string
 	SupportsImages     bool
 	SupportsTools      bool
+	CanReason          bool
+	ContextWindow      int64
+	DefaultMaxTokens   int64
 	CostPer1MIn        float64
 	CostPer1MOut       float64
 	CostPer1MInCached  float64
@@ -86,18 +170,24 @@ const (
 
 // ProviderResponse contains the complete response from the provider
 type ProviderResponse struct {
-	Content      string
-	FinishReason message.FinishReason
-	ToolCalls    []tools.ToolCall
-	Usage        TokenUsage
+	Content      string               `json:"content,omitempty"`
+	FinishReason message.FinishReason `json:"finish_reason"`
+	ToolCalls    []tools.ToolCall     `json:"tool_calls,omitempty"`
+	Usage        TokenUsage           `json:"usage"`
+	// Provider names the provider that served the response when it differs
+	// from the one the message was created with, e.g. after a fail-over.
+	Provider string `json:"provider,omitempty"`
+	// Model is the model that served the response when the provider routes
+	// requests to several, so that its usage is priced at its own rates.
+	Model *ModelInfo `json:"model,omitempty"`
 }
 
 // TokenUsage tracks token consumption
 type TokenUsage struct {
-	InputTokens         int64
-	OutputTokens        int64
-	CacheCreationTokens int64
-	CacheReadTokens     int64
+	InputTokens         int64 `json:"input_tokens"`
+	OutputTokens        int64 `json:"output_tokens"`
+	CacheCreationTokens int64 `json:"cache_creation_tokens,omitempty"`
+	CacheReadTokens     int64 `json:"cache_read_tokens,omitempty"`
 }
 
 // AgentConfig contains configuration for the agent
@@ -107,6 +197,41 @@ type AgentConfig struct {
 	SystemPrompt string
 	Tools        []tools.BaseTool
 	Capabilities AgentCapabilities
+
+	// WorkingDir is the directory the agent works in. When it is set, the
+	// system prompt describes the environment and includes the context
+	// files.
+	WorkingDir string
+	// ContextPaths are files and rule directories, relative to WorkingDir,
+	// that are added to the system prompt.
+	ContextPaths []string
+	// PromptTokenBudget bounds the size of the system prompt. Context files
+	// that do not fit are truncated or left out.
+	PromptTokenBudget int
+
+	// SummaryProvider runs the model that summarizes sessions whose history
+	// no longer fits the context window. Without it sessions are never
+	// summarized.
+	SummaryProvider LLMProvider
+	// DisableAutoSummarize keeps the agent from summarizing on its own;
+	// Summarize can still be called.
+	DisableAutoSummarize bool
+	// TitleProvider runs the model that names a session after its first
+	// prompt. Sessions keep their title when it is nil.
+	TitleProvider LLMProvider
+	// MaxParallelTools limits how many read-only tool calls of a turn run
+	// concurrently.
+	MaxParallelTools int
+
+	// SessionBudget limits every session of the agent unless
+	// SetSessionBudget replaces it, and RunBudget every call to Run. A
+	// session that ran out of budget refuses new runs.
+	SessionBudget Budget
+	RunBudget     Budget
+
+	// Hooks run around tool calls and turns. NewHooks builds the ones of
+	// the configuration.
+	Hooks hooks.Hooks
 }
 
 // AgentCapabilities defines what the agent can do
@@ -118,13 +243,30 @@ type AgentCapabilities struct {
 type Service interface {
 	pubsub.Suscriber[AgentEvent]
 	Model() ModelInfo
+	// Tools returns the tools the agent may use.
+	Tools() []tools.BaseTool
+	// Run sends content to the model and returns the events of the run:
+	// progress events, which are dropped when the caller falls behind, and
+	// the result, which is always the last. Callers read the channel until
+	// it is closed, e.g. with Wait.
 	Run(ctx context.Context, sessionID string, content string, attachments ...message.Attachment) (<-chan AgentEvent, error)
+	EditAndResubmit(ctx context.Context, sessionID, messageID, content string, attachments ...message.Attachment) (session.Session, <-chan AgentEvent, error)
 	Cancel(sessionID string)
 	CancelAll()
+	Summarize(ctx context.Context, sessionID string) error
 	IsSessionBusy(sessionID string) bool
 	IsBusy() bool
 	QueuedPrompts(sessionID string) int
+	ListQueuedPrompts(sessionID string) []QueuedPrompt
+	UpdateQueuedPrompt(sessionID string, prompt QueuedPrompt) error
+	MoveQueuedPrompt(sessionID, id string, position int) error
+	RemoveQueuedPrompt(sessionID, id string) error
 	ClearQueue(sessionID string)
+	SetSessionBudget(sessionID string, budget Budget)
+	Interject(sessionID, content string) error
+	Pause(sessionID string) error
+	Resume(sessionID string) error
+	SessionState(sessionID string) AgentState
 	GetState() AgentState
 }
 
@@ -135,14 +277,27 @@ type agent struct {
 	model    ModelInfo
 	sessions session.Service
 	messages message.Service
+	ledger   usage.Service
+	prompt   *promptBuilder
 
 	// State management
-	state          AgentState
-	stateMutex     sync.RWMutex
 	activeRequests map[string]context.CancelFunc
 	requestMutex   sync.RWMutex
-	promptQueue    map[string][]string
-	queueMutex     sync.RWMutex
+	// runEvents holds the channel returned by Run for each running session,
+	// so that progress reaches the caller as well as the subscribers.
+	runEvents   map[string]chan<- AgentEvent
+	promptQueue map[string][]QueuedPrompt
+	queueMutex  sync.RWMutex
+	// sessionMutex serializes session updates, which the title generation
+	// makes concurrently with the request.
+	sessionMutex sync.Mutex
+
+	sessionBudgets map[string]Budget
+	sessionUsage   map[string]budgetUsage
+	budgetMutex    sync.Mutex
+
+	steering   map[string]*steering
+	steerMutex sync.Mutex
 }
 
 // NewAgent creates a new agent with the given configuration and dependencies
@@ -152,6 +307,7 @@ func NewAgent(
 	model ModelInfo,
 	sessions session.Service,
 	messages message.Service,
+	ledger usage.Service,
 ) Service {
 	a := &agent{
 		Broker:         pubsub.NewBroker[AgentEvent](),
@@ -160,9 +316,17 @@ func NewAgent(
 		model:          model,
 		messages:       messages,
 		sessions:       sessions,
-		state:          AgentStateIdle,
+		ledger:         ledger,
+		prompt:         newPromptBuilder(config),
 		activeRequests: make(map[string]context.CancelFunc),
-		promptQueue:    make(map[string][]string),
+		runEvents:      make(map[string]chan<- AgentEvent),
+		promptQueue:    make(map[string][]QueuedPrompt),
+		sessionBudgets: make(map[string]Budget),
+		sessionUsage:   make(map[string]budgetUsage),
+		steering:       make(map[string]*steering),
+	}
+	if len(a.sessionTools()) > 0 || config.WorkingDir != "" {
+		go a.closeDeletedSessions(sessions.Subscribe(context.Background()))
 	}
 
 	return a
@@ -173,18 +337,29 @@ func (a *agent) Model() ModelInfo {
 	return a.model
 }
 
-// GetState returns the current state of the agent
-func (a *agent) GetState() AgentState {
-	a.stateMutex.RLock()
-	defer a.stateMutex.RUnlock()
-	return a.state
+func (a *agent) Tools() []tools.BaseTool {
+	return a.config.Tools
 }
 
-// setState updates the agent's state
-func (a *agent) setState(state AgentState) {
-	a.stateMutex.Lock()
-	defer a.stateMutex.Unlock()
-	a.state = state
+// GetState returns the state the running sessions are in, or
+// AgentStateProcessing when they are not all in the same state.
+func (a *agent) GetState() AgentState {
+	a.requestMutex.RLock()
+	sessionIDs := slices.Collect(maps.Keys(a.activeRequests))
+	a.requestMutex.RUnlock()
+
+	state := AgentStateIdle
+	for _, sessionID := range sessionIDs {
+		switch sessionState := a.SessionState(sessionID); {
+		case sessionState == AgentStateIdle:
+			// The run finished meanwhile.
+		case state == AgentStateIdle:
+			state = sessionState
+		case state != sessionState:
+			return AgentStateProcessing
+		}
+	}
+	return state
 }
 
 func (a *agent) Cancel(sessionID string) {
@@ -197,6 +372,8 @@ func (a *agent) Cancel(sessionID string) {
 		cancel()
 		delete(a.activeRequests, sessionID)
 	}
+	a.dropNotes(sessionID)
+	a.cancelSessionTools(sessionID)
 
 
 	a.queueMutex.Lock()
@@ -238,87 +415,108 @@ func (a *agent) Run(ctx context.Context, sessionID string, content string, attac
 	if !a.model.SupportsImages && attachments != nil {
 		attachments = nil
 	}
-	events := make(chan AgentEvent)
+	events := make(chan AgentEvent, runEventBuffer)
 
 	if a.IsSessionBusy(sessionID) {
-		a.queueMutex.Lock()
-		a.promptQueue[sessionID] = append(a.promptQueue[sessionID], content)
-		a.queueMutex.Unlock()
-		return nil, nil
+		prompt, position := a.queuePrompt(sessionID, content, attachments)
+		result := AgentEvent{
+			Type:          AgentEventTypeQueued,
+			SessionID:     sessionID,
+			Done:          true,
+			QueuedPrompt:  &prompt,
+			QueuePosition: position,
+		}
+		a.Publish(pubsub.CreatedEvent, result)
+		events <- result
+		close(events)
+		return events, nil
+	}
+
+	run, err := a.startRun(ctx, sessionID)
+	if err != nil {
+		return nil, err
 	}
 
 	genCtx, cancel := context.WithCancel(ctx)
 
+	a.startSteering(sessionID)
 	a.requestMutex.Lock()
 	a.activeRequests[sessionID] = cancel
+	a.runEvents[sessionID] = events
 	a.requestMutex.Unlock()
-	a.setState(AgentStateProcessing)
 
 	go func() {
 		slog.Debug("Request started", "sessionID", sessionID)
 		defer func() {
 			if r := recover(); r != nil {
-				events <- a.err(fmt.Errorf("panic while running the agent: %v", r))
+				sendResult(events, a.err(fmt.Errorf("panic while running the agent: %v", r)))
 			}
 			close(events)
 		}()
 
-		var attachmentParts []message.ContentPart
-		for _, attachment := range attachments {
-			attachmentParts = append(attachmentParts, message.BinaryContent{Path: attachment.FilePath, MIMEType: attachment.MimeType, Data: attachment.Content})
-		}
-
-		result := a.processGeneration(genCtx, sessionID, content, attachmentParts)
+		runCtx, cancelRun := a.deadline(genCtx, run)
+		result := a.processGeneration(runCtx, run, content, attachmentParts(attachments))
+		cancelRun()
+		a.finishRun(run)
 		if result.Error != nil && !errors.Is(result.Error, ErrRequestCancelled) && !errors.Is(result.Error, context.Canceled) {
 			slog.Error(result.Error.Error())
 		}
 
 		slog.Debug("Request completed", "sessionID", sessionID)
 
+		a.finishSteering(sessionID)
 		a.requestMutex.Lock()
 		delete(a.activeRequests, sessionID)
+		delete(a.runEvents, sessionID)
 		a.requestMutex.Unlock()
 
-		if !a.IsBusy() {
-			a.setState(AgentStateIdle)
-		}
-
 		cancel()
+		result.SessionID = sessionID
 		a.Publish(pubsub.CreatedEvent, result)
-
-		select {
-		case events <- result:
-		case <-genCtx.Done():
-		}
+		sendResult(events, result)
 	}()
 
 	return events, nil
 }
 
-func (a *agent) processGeneration(ctx context.Context, sessionID, content string, attachmentParts []message.ContentPart) AgentEvent {
-	// List existing messages; if none, start title generation asynchronously.
-	msgs, err := a.messages.List(ctx, sessionID)
-	if err != nil {
-		return a.err(fmt.Errorf("failed to list messages: %w", err))
+// sendResult sends the result of a run without waiting for its caller,
+// dropping the oldest progress events the caller has not read to make room.
+func sendResult(events chan AgentEvent, result AgentEvent) {
+	for {
+		select {
+		case events <- result:
+			return
+		default:
+		}
+		select {
+		case <-events:
+		default:
+		}
 	}
+}
 
-
-	sess, err := a.sessions.Get(ctx, sessionID)
+func (a *agent) processGeneration(ctx context.Context, run *runBudget, content string, attachmentParts []message.ContentPart) AgentEvent {
+	sessionID := run.sessionID
+	msgs, err := a.history(ctx, sessionID)
 	if err != nil {
-		return a.err(fmt.Errorf("failed to get session: %w", err))
-	}
-	if sess.SummaryMessageID != "" {
-		summaryMsgIndex := -1
-		for i, msg := range msgs {
-			if msg.ID == sess.SummaryMessageID {
-				summaryMsgIndex = i
-				break
-			}
+		return a.err(err)
+	}
+	var startMsgs []message.Message
+	if len(msgs) == 0 {
+		startMsgs, err = a.runSessionStartHooks(ctx, sessionID, content)
+		if err != nil {
+			return a.err(err)
 		}
-		if summaryMsgIndex != -1 {
-			msgs = msgs[summaryMsgIndex:]
-			msgs[0].Role = message.User
+		if a.config.TitleProvider != nil {
+			go a.generateTitle(context.WithoutCancel(ctx), sessionID, content)
+		}
+	}
+	if len(msgs) > 0 && a.shouldSummarize(ctx, sessionID) {
+		summaryMsg, err := a.summarize(ctx, sessionID, msgs)
+		if err != nil {
+			return a.err(err)
 		}
+		msgs = []message.Message{summaryMsg}
 	}
 
 	userMsg, err := a.createUserMessage(ctx, sessionID, content, attachmentParts)
@@ -326,17 +524,38 @@ func (a *agent) processGeneration(ctx context.Context, sessionID, content string
 		return a.err(fmt.Errorf("failed to create user message: %w", err))
 	}
 	// Append the new user message to the conversation history.
-	msgHistory := append(msgs, userMsg)
+	msgHistory := slices.Concat(msgs, startMsgs, []message.Message{userMsg})
 
 	for {
+		// A pause takes effect before the next turn at the latest. Holding
+		// only fails when the run is canceled, which is handled below.
+		_ = a.holdIfPaused(ctx, sessionID)
 		// Check for cancellation before each iteration
 		select {
 		case <-ctx.Done():
+			if cause := context.Cause(ctx); errors.Is(cause, ErrBudgetExceeded) {
+				return a.stopForBudget(ctx, sessionID, nil, cause)
+			}
 			return a.err(ctx.Err())
 		default:
 			// Continue processing
 		}
-		agentMessage, toolResults, err := a.streamAndHandleEvents(ctx, sessionID, msgHistory)
+		if err := a.checkBudget(ctx, run); err != nil {
+			return a.stopForBudget(ctx, sessionID, nil, err)
+		}
+		// The system prompt is built once per turn so that context files
+		// edited in between, e.g. by the model's tool calls, are picked up.
+		turnHistory, err := a.runPreTurnHooks(ctx, sessionID, slices.Concat(a.systemMessages(ctx, sessionID), msgHistory))
+		if err != nil {
+			return a.err(err)
+		}
+		agentMessage, toolResults, err := a.streamAndHandleEvents(ctx, run, turnHistory)
+		if cause := context.Cause(ctx); errors.Is(cause, ErrBudgetExceeded) {
+			err = cause
+		}
+		if errors.Is(err, ErrBudgetExceeded) {
+			return a.stopForBudget(ctx, sessionID, &agentMessage, err)
+		}
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
 				agentMessage.AddFinish(message.FinishReasonCanceled, "Request cancelled", "")
@@ -350,42 +569,29 @@ func (a *agent) processGeneration(ctx context.Context, sessionID, content string
 		if (agentMessage.FinishReason() == message.FinishReasonToolUse) && toolResults != nil {
 			// We are not done, we need to respond with the tool response
 			msgHistory = append(msgHistory, agentMessage, *toolResults)
-			// Check for queued prompts
-			a.queueMutex.Lock()
-			queuedPrompts := a.promptQueue[sessionID]
-			if len(queuedPrompts) > 0 {
-				a.promptQueue[sessionID] = nil
-			}
-			a.queueMutex.Unlock()
-
-			for _, prompt := range queuedPrompts {
-				userMsg, err := a.createUserMessage(ctx, sessionID, prompt, nil)
+			if a.shouldSummarize(ctx, sessionID) {
+				// Carry on from the summary so the turn keeps going with
+				// room left in the context window.
+				summaryMsg, err := a.summarize(ctx, sessionID, msgHistory)
 				if err != nil {
-					return a.err(fmt.Errorf("failed to create user message for queued prompt: %w", err))
+					return a.err(err)
 				}
-				msgHistory = append(msgHistory, userMsg)
+				msgHistory = []message.Message{summaryMsg}
 			}
-
+			followUps, err := a.followUps(ctx, sessionID, agentMessage, false)
+			if err != nil {
+				return a.err(err)
+			}
+			msgHistory = append(msgHistory, followUps...)
 			continue
 		} else if agentMessage.FinishReason() == message.FinishReasonEndTurn {
-			a.queueMutex.Lock()
-			queuedPrompts := a.promptQueue[sessionID]
-			if len(queuedPrompts) > 0 {
-				a.promptQueue[sessionID] = nil
+			followUps, err := a.followUps(ctx, sessionID, agentMessage, true)
+			if err != nil {
+				return a.err(err)
 			}
-			a.queueMutex.Unlock()
-
-			for _, prompt := range queuedPrompts {
-				if prompt == "" {
-					continue
-				}
-				userMsg, err := a.createUserMessage(ctx, sessionID, prompt, nil)
-				if err != nil {
-					return a.err(fmt.Errorf("failed to create user message for queued prompt: %w", err))
-				}
-				msgHistory = append(msgHistory, userMsg)
-			}
-			if len(queuedPrompts) > 0 {
+			if len(followUps) > 0 {
+				msgHistory = append(msgHistory, agentMessage)
+				msgHistory = append(msgHistory, followUps...)
 				continue
 			}
 		}
@@ -403,6 +609,25 @@ func (a *agent) processGeneration(ctx context.Context, sessionID, content string
 	}
 }
 
+// followUps returns the prompts that continue the run after a turn: the
+// context added by post_turn hooks, the user's interjections, then the
+// queued prompts. ended tells whether the turn ended the model's answer.
+func (a *agent) followUps(ctx context.Context, sessionID string, response message.Message, ended bool) ([]message.Message, error) {
+	hookMsgs, err := a.runPostTurnHooks(ctx, sessionID, response, ended)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := a.deliverNotes(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	queuedMsgs, err := a.drainQueue(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Concat(hookMsgs, notes, queuedMsgs), nil
+}
+
 func (a *agent) createUserMessage(ctx context.Context, sessionID, content string, attachmentParts []message.ContentPart) (message.Message, error) {
 	parts := []message.ContentPart{message.TextContent{Text: content}}
 	parts = append(parts, attachmentParts...)
@@ -412,7 +637,21 @@ func (a *agent) createUserMessage(ctx context.Context, sessionID, content string
 	})
 }
 
-func (a *agent) streamAndHandleEvents(ctx context.Context, sessionID string, msgHistory []message.Message) (message.Message, *message.Message, error) {
+// systemMessages returns the system prompt of the session's next turn.
+func (a *agent) systemMessages(ctx context.Context, sessionID string) []message.Message {
+	prompt := a.prompt.build(ctx, sessionID)
+	if prompt == "" {
+		return nil
+	}
+	return []message.Message{{
+		Role:      message.System,
+		SessionID: sessionID,
+		Parts:     []message.ContentPart{message.TextContent{Text: prompt}},
+	}}
+}
+
+func (a *agent) streamAndHandleEvents(ctx context.Context, run *runBudget, msgHistory []message.Message) (message.Message, *message.Message, error) {
+	sessionID := run.sessionID
 	ctx = context.WithValue(ctx, tools.SessionIDContextKey, sessionID)
 
 	// Create the assistant message first so the spinner shows immediately
@@ -426,6 +665,11 @@ func (a *agent) streamAndHandleEvents(ctx context.Context, sessionID string, msg
 		return assistantMsg, nil, fmt.Errorf("failed to create assistant message: %w", err)
 	}
 
+	a.emit(AgentEvent{Type: AgentEventTypeTurnStarted, SessionID: sessionID, Message: assistantMsg})
+	defer func() {
+		a.emit(AgentEvent{Type: AgentEventTypeTurnFinished, SessionID: sessionID, Message: assistantMsg})
+	}()
+
 	// Stream response from provider with configured tools
 	eventChan := a.provider.StreamResponse(ctx, msgHistory, a.config.Tools)
 
@@ -442,111 +686,45 @@ func (a *agent) streamAndHandleEvents(ctx context.Context, sessionID string, msg
 			}
 			return assistantMsg, nil, processErr
 		}
+		if event.Type == EventComplete {
+			a.recordTurn(run, event.Response.Usage)
+		}
 		if ctx.Err() != nil {
 			a.finishMessage(context.Background(), &assistantMsg, message.FinishReasonCanceled, "Request cancelled", "")
 			return assistantMsg, nil, ctx.Err()
 		}
 	}
 
-	toolResults := make([]message.ToolResult, len(assistantMsg.ToolCalls()))
 	toolCalls := assistantMsg.ToolCalls()
-	for i, toolCall := range toolCalls {
-		select {
-		case <-ctx.Done():
-			a.finishMessage(context.Background(), &assistantMsg, message.FinishReasonCanceled, "Request cancelled", "")
-			// Make all future tool calls cancelled
-			for j := i; j < len(toolCalls); j++ {
-				toolResults[j] = message.ToolResult{
-					ToolCallID: toolCalls[j].ID,
-					Content:    "Tool execution canceled by user",
-					IsError:    true,
-				}
-			}
-			goto out
-		default:
-			// Continue processing
-			var tool tools.BaseTool
-			for _, availableTool := range a.config.Tools {
-				if availableTool.Name() == toolCall.Name {
-					tool = availableTool
-					break
-				}
-			}
-
-			// Tool not found
-			if tool == nil {
-				toolResults[i] = message.ToolResult{
-					ToolCallID: toolCall.ID,
-					Content:    fmt.Sprintf("Tool not found: %s", toolCall.Name),
-					IsError:    true,
-				}
-				continue
-			}
-
-			// Run tool in goroutine to allow cancellation
-			type toolExecResult struct {
-				response tools.ToolResponse
-				err      error
-			}
-			resultChan := make(chan toolExecResult, 1)
-
-			go func() {
-				response, err := tool.Run(ctx, tools.ToolCall{
-					ID:    toolCall.ID,
-					Name:  toolCall.Name,
-					Input: toolCall.Input,
-				})
-				resultChan <- toolExecResult{response: response, err: err}
-			}()
-
-			var toolResponse tools.ToolResponse
-			var toolErr error
-
-			select {
-			case <-ctx.Done():
-				a.finishMessage(context.Background(), &assistantMsg, message.FinishReasonCanceled, "Request cancelled", "")
-				// Mark remaining tool calls as cancelled
-				for j := i; j < len(toolCalls); j++ {
-					toolResults[j] = message.ToolResult{
-						ToolCallID: toolCalls[j].ID,
-						Content:    "Tool execution canceled by user",
-						IsError:    true,
-					}
-				}
-				goto out
-			case result := <-resultChan:
-				toolResponse = result.response
-				toolErr = result.err
-			}
-
-			if toolErr != nil {
-				slog.Error("Tool execution error", "toolCall", toolCall.ID, "error", toolErr)
-				if errors.Is(toolErr, fmt.Errorf("permission denied")) {
-					toolResults[i] = message.ToolResult{
-						ToolCallID: toolCall.ID,
-						Content:    "Permission denied",
-						IsError:    true,
-					}
-					for j := i + 1; j < len(toolCalls); j++ {
-						toolResults[j] = message.ToolResult{
-							ToolCallID: toolCalls[j].ID,
-							Content:    "Tool execution canceled by user",
-							IsError:    true,
-						}
-					}
-					a.finishMessage(ctx, &assistantMsg, message.FinishReasonPermissionDenied, "Permission denied", "")
-					break
-				}
-			}
-			toolResults[i] = message.ToolResult{
+	var budgetErr error
+	if len(toolCalls) > 0 {
+		// The model wants another turn, so this is the last chance to stop
+		// before more is spent.
+		if err := a.checkBudget(ctx, run); errors.Is(err, ErrBudgetExceeded) {
+			budgetErr = err
+		}
+	}
+	var (
+		toolResults  []message.ToolResult
+		finishReason message.FinishReason
+	)
+	if budgetErr != nil {
+		for _, toolCall := range toolCalls {
+			toolResults = append(toolResults, message.ToolResult{
 				ToolCallID: toolCall.ID,
-				Content:    toolResponse.Content,
-				Metadata:   toolResponse.Metadata,
-				IsError:    toolResponse.IsError,
-			}
+				Content:    "Tool execution skipped: " + budgetErr.Error(),
+				IsError:    true,
+			})
 		}
+	} else {
+		toolResults, finishReason = a.runToolCalls(ctx, sessionID, toolCalls)
+	}
+	switch finishReason {
+	case message.FinishReasonCanceled:
+		a.finishMessage(context.Background(), &assistantMsg, message.FinishReasonCanceled, "Request cancelled", "")
+	case message.FinishReasonPermissionDenied:
+		a.finishMessage(ctx, &assistantMsg, message.FinishReasonPermissionDenied, "Permission denied", "")
 	}
-out:
 	if len(toolResults) == 0 {
 		return assistantMsg, nil, nil
 	}
@@ -562,10 +740,207 @@ out:
 	if err != nil {
 		return assistantMsg, nil, fmt.Errorf("failed to create cancelled tool message: %w", err)
 	}
+	if budgetErr != nil {
+		return assistantMsg, &msg, budgetErr
+	}
 
 	return assistantMsg, &msg, err
 }
 
+// runToolCalls runs the tool calls of a turn and returns their results in
+// the order of toolCalls. Consecutive read-only tools run concurrently, up to
+// MaxParallelTools at a time, while any other tool waits for the calls before
+// it and runs alone. When the run stops early, the reason is returned and
+// the calls that did not finish are reported as canceled. A pause holds the
+// calls that have not started, and an interjection skips them.
+func (a *agent) runToolCalls(ctx context.Context, sessionID string, toolCalls []message.ToolCall) ([]message.ToolResult, message.FinishReason) {
+	toolResults := make([]message.ToolResult, len(toolCalls))
+	finished := make([]bool, len(toolCalls))
+	var (
+		wg               sync.WaitGroup
+		mu               sync.Mutex
+		permissionDenied bool
+		interjected      bool
+	)
+	workers := make(chan struct{}, a.maxParallelTools())
+
+	stopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return permissionDenied || ctx.Err() != nil
+	}
+
+	for i, toolCall := range toolCalls {
+		tool := a.findTool(toolCall.Name)
+		readOnly := tool == nil || tool.Info().ReadOnly
+		if !readOnly {
+			wg.Wait()
+		}
+		if stopped() {
+			break
+		}
+		if a.holdIfPaused(ctx, sessionID) != nil {
+			break
+		}
+		if a.hasNotes(sessionID) {
+			interjected = true
+			break
+		}
+		if tool == nil {
+			response := tools.NewTextErrorResponse(fmt.Sprintf("Tool not found: %s", toolCall.Name))
+			a.emit(AgentEvent{Type: AgentEventTypeToolCallFinished, SessionID: sessionID, ToolCall: &toolCall, ToolResponse: &response})
+			toolResults[i] = message.ToolResult{
+				ToolCallID: toolCall.ID,
+				Content:    response.Content,
+				IsError:    true,
+			}
+			finished[i] = true
+			continue
+		}
+
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			result, ok, denied := a.runTool(ctx, sessionID, tool, toolCall)
+			mu.Lock()
+			defer mu.Unlock()
+			toolResults[i], finished[i] = result, ok
+			permissionDenied = permissionDenied || denied
+		}()
+		if !readOnly {
+			wg.Wait()
+		}
+	}
+	wg.Wait()
+
+	var finishReason message.FinishReason
+	switch {
+	case permissionDenied:
+		finishReason = message.FinishReasonPermissionDenied
+	case interjected:
+	case slices.Contains(finished, false):
+		finishReason = message.FinishReasonCanceled
+	}
+	for i, toolCall := range toolCalls {
+		if finished[i] {
+			continue
+		}
+		content := "Tool execution canceled by user"
+		if finishReason == "" {
+			content = "Tool call skipped: the user interjected"
+		}
+		toolResults[i] = message.ToolResult{
+			ToolCallID: toolCall.ID,
+			Content:    content,
+			IsError:    true,
+		}
+	}
+	return toolResults, finishReason
+}
+
+// runTool runs a single tool call, between its pre_tool_use and
+// post_tool_use hooks. It reports whether the call finished before ctx was
+// canceled and whether permission for it was denied.
+func (a *agent) runTool(ctx context.Context, sessionID string, tool tools.BaseTool, toolCall message.ToolCall) (message.ToolResult, bool, bool) {
+	a.emit(AgentEvent{Type: AgentEventTypeToolCallStarted, SessionID: sessionID, ToolCall: &toolCall})
+
+	call := tools.ToolCall{
+		ID:    toolCall.ID,
+		Name:  toolCall.Name,
+		Input: toolCall.Input,
+	}
+	pre, err := a.config.Hooks.Run(ctx, hooks.Input{Event: hooks.PreToolUse, SessionID: sessionID, ToolCall: &call})
+	if ctx.Err() != nil {
+		return message.ToolResult{}, false, false
+	}
+	if err != nil || pre.Deny {
+		return a.toolResult(ctx, sessionID, toolCall, toolDeniedByHook(pre, err)), true, false
+	}
+	if len(pre.Input) > 0 {
+		call.Input = string(pre.Input)
+	}
+
+	// Run tool in goroutine to allow cancellation
+	type toolExecResult struct {
+		response tools.ToolResponse
+		err      error
+	}
+	resultChan := make(chan toolExecResult, 1)
+
+	go func() {
+		response, err := tool.Run(ctx, call)
+		resultChan <- toolExecResult{response: response, err: err}
+	}()
+
+	var result toolExecResult
+	select {
+	case <-ctx.Done():
+		return message.ToolResult{}, false, false
+	case result = <-resultChan:
+	}
+
+	denied := false
+	if result.err != nil {
+		slog.Error("Tool execution error", "toolCall", toolCall.ID, "error", result.err)
+		if errors.Is(result.err, permission.ErrPermissionDenied) {
+			result.response = tools.NewTextErrorResponse("Permission denied")
+			denied = true
+		}
+	}
+	post, err := a.config.Hooks.Run(ctx, hooks.Input{Event: hooks.PostToolUse, SessionID: sessionID, ToolCall: &call, ToolResponse: &result.response})
+	if ctx.Err() != nil {
+		return message.ToolResult{}, false, false
+	}
+	return a.toolResult(ctx, sessionID, toolCall, withToolHooks(result.response, pre.Context, post, err)), true, denied
+}
+
+// toolResult reports that a tool call finished with response. Images are
+// only passed on to models that support them; others are told the image
+// is not shown.
+func (a *agent) toolResult(ctx context.Context, sessionID string, toolCall message.ToolCall, response tools.ToolResponse) message.ToolResult {
+	a.emit(AgentEvent{Type: AgentEventTypeToolCallFinished, SessionID: sessionID, ToolCall: &toolCall, ToolResponse: &response})
+	result := message.ToolResult{
+		ToolCallID: toolCall.ID,
+		Content:    response.Content,
+		Metadata:   response.Metadata,
+		IsError:    response.IsError,
+	}
+	if response.Type == tools.ToolResponseTypeImage && len(response.Data) > 0 {
+		if a.model.SupportsImages {
+			result.Data, result.MIMEType = response.Data, response.MIMEType
+		} else {
+			result.Content += "\n\n(The image is not shown because the model does not support images.)"
+		}
+	}
+	return result
+}
+
+func (a *agent) findTool(name string) tools.BaseTool {
+	for _, tool := range a.config.Tools {
+		if tool.Name() == name {
+			return tool
+		}
+	}
+	return nil
+}
+
+func (a *agent) maxParallelTools() int {
+	if a.config.MaxParallelTools > 0 {
+		return a.config.MaxParallelTools
+	}
+	return defaultMaxParallelTools
+}
+
 func (a *agent) finishMessage(ctx context.Context, msg *message.Message, finishReason message.FinishReason, message, details string) {
 	msg.AddFinish(finishReason, message, details)
 	_ = a.messages.Update(ctx, *msg)
@@ -582,6 +957,7 @@ func (a *agent) processEvent(ctx context.Context, sessionID string, assistantMsg
 	switch event.Type {
 	case EventThinkingDelta:
 		assistantMsg.AppendReasoningContent(event.Thinking)
+		a.emit(AgentEvent{Type: AgentEventTypeThinkingDelta, SessionID: sessionID, Delta: event.Thinking})
 		return a.messages.Update(ctx, *assistantMsg)
 	case EventSignatureDelta:
 		assistantMsg.AppendReasoningSignature(event.Signature)
@@ -589,6 +965,7 @@ func (a *agent) processEvent(ctx context.Context, sessionID string, assistantMsg
 	case EventContentDelta:
 		assistantMsg.FinishThinking()
 		assistantMsg.AppendContent(event.Content)
+		a.emit(AgentEvent{Type: AgentEventTypeContentDelta, SessionID: sessionID, Delta: event.Content})
 		return a.messages.Update(ctx, *assistantMsg)
 	case EventToolUseStart:
 		assistantMsg.FinishThinking()
@@ -614,39 +991,104 @@ func (a *agent) processEvent(ctx context.Context, sessionID string, assistantMsg
 		var msgToolCalls []message.ToolCall
 		for _, tc := range event.Response.ToolCalls {
 			msgToolCalls = append(msgToolCalls, message.ToolCall{
-				ID:    tc.ID,
-				Name:  tc.Name,
-				Input: tc.Input,
+				ID:       tc.ID,
+				Name:     tc.Name,
+				Input:    tc.Input,
+				Finished: true,
 			})
 		}
 		assistantMsg.SetToolCalls(msgToolCalls)
+		if event.Response.Provider != "" {
+			assistantMsg.Provider = event.Response.Provider
+		}
+		model := a.model
+		if event.Response.Model != nil {
+			model = *event.Response.Model
+			assistantMsg.Model = model.ID
+		}
 		assistantMsg.AddFinish(event.Response.FinishReason, "", "")
 		if err := a.messages.Update(ctx, *assistantMsg); err != nil {
 			return fmt.Errorf("failed to update message: %w", err)
 		}
-		return a.TrackUsage(ctx, sessionID, a.model, event.Response.Usage)
+		if err := a.TrackUsage(ctx, *assistantMsg, model, event.Response.Usage); err != nil {
+			return err
+		}
+		usage := event.Response.Usage
+		a.emit(AgentEvent{Type: AgentEventTypeUsage, SessionID: sessionID, Usage: &usage, Cost: usageCost(model, usage)})
 	}
 
 	return nil
 }
 
-func (a *agent) TrackUsage(ctx context.Context, sessionID string, model ModelInfo, usage TokenUsage) error {
-	sess, err := a.sessions.Get(ctx, sessionID)
+// emit publishes a progress event and sends it to the caller of the run of
+// the event's session, if there is one. The event is dropped when the run's
+// buffer is full, so that a caller that only waits for the result never
+// holds up the run.
+func (a *agent) emit(event AgentEvent) {
+	a.Publish(pubsub.CreatedEvent, event)
+
+	a.requestMutex.RLock()
+	events, ok := a.runEvents[event.SessionID]
+	a.requestMutex.RUnlock()
+	if !ok {
+		return
+	}
+	select {
+	case events <- event:
+	default:
+	}
+}
+
+// TrackUsage records what the response stored as msg consumed in the usage
+// ledger.
+func (a *agent) TrackUsage(ctx context.Context, msg message.Message, model ModelInfo, tokens TokenUsage) error {
+	provider := msg.Provider
+	if provider == "" {
+		provider = model.Name
+	}
+	return a.recordUsage(ctx, msg.SessionID, msg.ID, provider, model, tokens)
+}
+
+// recordUsage adds a response to the usage ledger, which updates the totals
+// of the session, and publishes the session with its new totals. messageID
+// is empty for responses that are not stored as messages.
+func (a *agent) recordUsage(ctx context.Context, sessionID, messageID, provider string, model ModelInfo, tokens TokenUsage) error {
+	_, err := a.ledger.Create(ctx, sessionID, usage.CreateUsageParams{
+		MessageID:           messageID,
+		Model:               model.ID,
+		Provider:            provider,
+		InputTokens:         tokens.InputTokens,
+		OutputTokens:        tokens.OutputTokens,
+		CacheCreationTokens: tokens.CacheCreationTokens,
+		CacheReadTokens:     tokens.CacheReadTokens,
+		Cost:                usageCost(model, tokens),
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get session: %w", err)
+		return fmt.Errorf("failed to record usage: %w", err)
 	}
+	return a.updateSession(ctx, sessionID, func(*session.Session) {})
+}
 
-	cost := model.CostPer1MInCached/1e6*float64(usage.CacheCreationTokens) +
+// usageCost prices usage the way catwalk does: cache writes at
+// CostPer1MInCached and cache reads at CostPer1MOutCached.
+func usageCost(model ModelInfo, usage TokenUsage) float64 {
+	return model.CostPer1MInCached/1e6*float64(usage.CacheCreationTokens) +
 		model.CostPer1MOutCached/1e6*float64(usage.CacheReadTokens) +
 		model.CostPer1MIn/1e6*float64(usage.InputTokens) +
 		model.CostPer1MOut/1e6*float64(usage.OutputTokens)
+}
 
-	sess.Cost += cost
-	sess.CompletionTokens = usage.OutputTokens + usage.CacheReadTokens
-	sess.PromptTokens = usage.InputTokens + usage.CacheCreationTokens
+// updateSession applies update to the stored session and saves it.
+func (a *agent) updateSession(ctx context.Context, sessionID string, update func(sess *session.Session)) error {
+	a.sessionMutex.Lock()
+	defer a.sessionMutex.Unlock()
 
-	_, err = a.sessions.Save(ctx, sess)
+	sess, err := a.sessions.Get(ctx, sessionID)
 	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	update(&sess)
+	if _, err := a.sessions.Save(ctx, sess); err != nil {
 		return fmt.Errorf("failed to save session: %w", err)
 	}
 	return nil
@@ -0,0 +1,1219 @@
+package agent_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gentica/config"
+	"gentica/db"
+	"gentica/llm/agent"
+	"gentica/llm/hooks"
+	"gentica/llm/policy"
+	"gentica/llm/provider"
+	"gentica/llm/tools"
+	"gentica/message"
+	"gentica/permission"
+	"gentica/session"
+	"gentica/usage"
+
+	"github.com/stretchr/testify/require"
+)
+
+type echoTool struct{}
+
+func (echoTool) Name() string { return "echo" }
+
+func (echoTool) Info() tools.ToolInfo {
+	return tools.ToolInfo{
+		Name:        "echo",
+		Description: "Echoes the given text",
+		Parameters: map[string]any{
+			"text": map[string]any{"type": "string"},
+		},
+		Required: []string{"text"},
+	}
+}
+
+func (echoTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolResponse, error) {
+	var params struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal([]byte(call.Input), &params); err != nil {
+		return tools.NewTextErrorResponse(err.Error()), nil
+	}
+	return tools.NewTextResponse("echo: " + params.Text), nil
+}
+
+// meetTool is a read-only tool whose calls wait for each other, so they only
+// succeed when they run concurrently.
+type meetTool struct {
+	arrived *sync.WaitGroup
+	done    *atomic.Int32
+}
+
+func (meetTool) Name() string { return "meet" }
+
+func (meetTool) Info() tools.ToolInfo {
+	return tools.ToolInfo{Name: "meet", ReadOnly: true}
+}
+
+func (m meetTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolResponse, error) {
+	defer m.done.Add(1)
+	m.arrived.Done()
+	met := make(chan struct{})
+	go func() {
+		m.arrived.Wait()
+		close(met)
+	}()
+	select {
+	case <-met:
+		return tools.NewTextResponse("met " + call.Input), nil
+	case <-time.After(2 * time.Second):
+		return tools.NewTextErrorResponse("alone " + call.Input), nil
+	}
+}
+
+// countTool is a mutating tool that reports how many meet calls are done.
+type countTool struct {
+	done *atomic.Int32
+}
+
+func (countTool) Name() string { return "count" }
+
+func (countTool) Info() tools.ToolInfo {
+	return tools.ToolInfo{Name: "count"}
+}
+
+func (c countTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolResponse, error) {
+	return tools.NewTextResponse(fmt.Sprintf("%d done", c.done.Load())), nil
+}
+
+// deniedTool is a tool the user never lets run.
+type deniedTool struct{}
+
+func (deniedTool) Name() string { return "denied" }
+
+func (deniedTool) Info() tools.ToolInfo {
+	return tools.ToolInfo{Name: "denied"}
+}
+
+func (deniedTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolResponse, error) {
+	return tools.ToolResponse{}, permission.ErrPermissionDenied
+}
+
+// blockTool runs until it is released.
+type blockTool struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (blockTool) Name() string { return "block" }
+
+func (blockTool) Info() tools.ToolInfo {
+	return tools.ToolInfo{Name: "block"}
+}
+
+func (b blockTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolResponse, error) {
+	close(b.started)
+	<-b.release
+	return tools.NewTextResponse("released"), nil
+}
+
+// imageTool returns an image.
+type imageTool struct{}
+
+func (imageTool) Name() string { return "image" }
+
+func (imageTool) Info() tools.ToolInfo {
+	return tools.ToolInfo{Name: "image", ReadOnly: true}
+}
+
+func (imageTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolResponse, error) {
+	return tools.NewImageResponse("a screenshot", []byte("png data"), "image/png"), nil
+}
+
+// sessionTool reports the sessions it is asked to stop.
+type sessionTool struct {
+	echoTool
+	canceled chan string
+	closed   chan string
+}
+
+func (t sessionTool) CancelSession(sessionID string) { t.canceled <- sessionID }
+
+func (t sessionTool) CloseSession(sessionID string) { t.closed <- sessionID }
+
+type testEnv struct {
+	sessions  session.Service
+	messages  message.Service
+	usage     usage.Service
+	sessionID string
+}
+
+func newTestEnv(t *testing.T) testEnv {
+	t.Helper()
+	ctx := context.Background()
+	conn, err := db.Connect(ctx, t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	q := db.New(conn)
+	sessions := session.NewService(q)
+	sess, err := sessions.Create(ctx, "test")
+	require.NoError(t, err)
+	return testEnv{
+		sessions:  sessions,
+		messages:  message.NewService(q),
+		usage:     usage.NewService(q),
+		sessionID: sess.ID,
+	}
+}
+
+func (e testEnv) newAgent(p agent.LLMProvider) agent.Service {
+	return e.newAgentWithConfig(agent.AgentConfig{ID: "coder", Tools: []tools.BaseTool{echoTool{}}}, p)
+}
+
+func (e testEnv) newAgentWithConfig(cfg agent.AgentConfig, p agent.LLMProvider) agent.Service {
+	return agent.NewAgent(
+		cfg,
+		p,
+		p.Model(),
+		e.sessions,
+		e.messages,
+		e.usage,
+	)
+}
+
+func run(t *testing.T, svc agent.Service, sessionID, prompt string) agent.AgentEvent {
+	t.Helper()
+	events, err := svc.Run(context.Background(), sessionID, prompt)
+	require.NoError(t, err)
+	done := make(chan agent.AgentEvent, 1)
+	go func() { done <- agent.Wait(events) }()
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not finish")
+		return agent.AgentEvent{}
+	}
+}
+
+func TestAgentReplay(t *testing.T) {
+	t.Parallel()
+
+	t.Run("runs the tool loop from a fixture", func(t *testing.T) {
+		env := newTestEnv(t)
+		turns, err := provider.LoadReplayTurns(filepath.Join("testdata", "tool_loop.json"))
+		require.NoError(t, err)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay", SupportsTools: true}, turns...)
+
+		result := run(t, env.newAgent(p), env.sessionID, "say hello")
+		require.NoError(t, result.Error)
+		require.Equal(t, agent.AgentEventTypeResponse, result.Type)
+		require.True(t, result.Done)
+		require.Equal(t, "The tool said hello.", result.Message.Content().String())
+		require.Zero(t, p.Remaining())
+
+		msgs, err := env.messages.List(context.Background(), env.sessionID)
+		require.NoError(t, err)
+		require.Len(t, msgs, 4)
+		require.Equal(t, []message.MessageRole{message.User, message.Assistant, message.Tool, message.Assistant},
+			[]message.MessageRole{msgs[0].Role, msgs[1].Role, msgs[2].Role, msgs[3].Role})
+
+		require.Equal(t, "The user wants an echo.", msgs[1].ReasoningContent().Thinking)
+		require.Equal(t, "c2lnbmF0dXJl", msgs[1].ReasoningContent().Signature)
+		require.Equal(t, message.FinishReasonToolUse, msgs[1].FinishReason())
+		require.Equal(t, []message.ToolCall{{ID: "toolu_01", Name: "echo", Input: `{"text":"hello"}`, Finished: true}}, msgs[1].ToolCalls())
+
+		results := msgs[2].ToolResults()
+		require.Len(t, results, 1)
+		require.Equal(t, "toolu_01", results[0].ToolCallID)
+		require.Equal(t, "echo: hello", results[0].Content)
+
+		requests := p.Requests()
+		require.Len(t, requests, 2)
+		require.Equal(t, []string{"echo"}, requests[0].Tools)
+		history := requests[1].Messages
+		require.Len(t, history, 3)
+		require.Equal(t, "echo: hello", history[2].ToolResults()[0].Content)
+	})
+
+	t.Run("reports provider errors", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, provider.ErrorTurn("overloaded"))
+
+		result := run(t, env.newAgent(p), env.sessionID, "hi")
+		require.Equal(t, agent.AgentEventTypeError, result.Type)
+		require.ErrorContains(t, result.Error, "overloaded")
+
+		msgs, err := env.messages.List(context.Background(), env.sessionID)
+		require.NoError(t, err)
+		require.Len(t, msgs, 2)
+		require.Equal(t, message.FinishReasonError, msgs[1].FinishReason())
+	})
+
+	t.Run("records the provider that served the turn", func(t *testing.T) {
+		env := newTestEnv(t)
+		turn := provider.TextTurn("hello")
+		turn.Events[len(turn.Events)-1].Response.Usage = agent.TokenUsage{OutputTokens: 1}
+		p := provider.NewFallbackProvider([]provider.Route{
+			{Name: "primary", Provider: provider.NewReplayProvider(agent.ModelInfo{ID: "replay", CostPer1MOut: 1e6}, provider.ErrorTurn("unavailable"))},
+			{Name: "backup", Provider: provider.NewReplayProvider(agent.ModelInfo{ID: "cheap", CostPer1MOut: 1e5}, turn)},
+		})
+
+		result := run(t, env.newAgent(p), env.sessionID, "hi")
+		require.NoError(t, result.Error)
+		require.Equal(t, "backup", result.Message.Provider)
+		require.Equal(t, "cheap", result.Message.Model)
+
+		msgs, err := env.messages.List(context.Background(), env.sessionID)
+		require.NoError(t, err)
+		require.Equal(t, "backup", msgs[1].Provider)
+
+		// Usage is priced at the rates of the model that served the turn.
+		ledger, err := env.usage.List(context.Background(), env.sessionID)
+		require.NoError(t, err)
+		require.Len(t, ledger, 1)
+		require.Equal(t, "cheap", ledger[0].Model)
+		require.Equal(t, 0.1, ledger[0].Cost)
+	})
+
+	t.Run("reports unknown tools to the model", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"},
+			provider.ToolUseTurn(tools.ToolCall{ID: "call_1", Name: "missing", Input: "{}"}),
+			provider.TextTurn("sorry"),
+		)
+
+		result := run(t, env.newAgent(p), env.sessionID, "hi")
+		require.NoError(t, result.Error)
+
+		toolMessage := p.Requests()[1].Messages[2]
+		require.Equal(t, []message.ToolResult{{ToolCallID: "call_1", Content: "Tool not found: missing", IsError: true}}, toolMessage.ToolResults())
+	})
+
+	t.Run("stops when permission is denied", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, provider.ToolUseTurn(
+			tools.ToolCall{ID: "call_1", Name: "denied", Input: "{}"},
+			tools.ToolCall{ID: "call_2", Name: "echo", Input: `{"text":"hi"}`},
+		))
+		svc := env.newAgentWithConfig(agent.AgentConfig{Tools: []tools.BaseTool{deniedTool{}, echoTool{}}}, p)
+
+		result := run(t, svc, env.sessionID, "hi")
+		require.NoError(t, result.Error)
+		require.Equal(t, message.FinishReasonPermissionDenied, result.Message.FinishReason())
+		require.Zero(t, p.Remaining())
+
+		msgs, err := env.messages.List(context.Background(), env.sessionID)
+		require.NoError(t, err)
+		require.Equal(t, []message.ToolResult{
+			{ToolCallID: "call_1", Content: "Permission denied", IsError: true},
+			{ToolCallID: "call_2", Content: "Tool execution canceled by user", IsError: true},
+		}, msgs[2].ToolResults())
+	})
+
+	t.Run("cancels mid-stream", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, provider.ReplayTurn{
+			Events:        []provider.ReplayEvent{{Type: agent.EventContentDelta, Content: "partial"}},
+			WaitForCancel: true,
+		})
+		svc := env.newAgent(p)
+
+		events, err := svc.Run(context.Background(), env.sessionID, "hi")
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			msgs, err := env.messages.List(context.Background(), env.sessionID)
+			return err == nil && len(msgs) == 2 && msgs[1].Content().String() == "partial"
+		}, 5*time.Second, 10*time.Millisecond)
+		require.True(t, svc.IsSessionBusy(env.sessionID))
+
+		svc.Cancel(env.sessionID)
+		result := agent.Wait(events)
+		require.ErrorIs(t, result.Error, agent.ErrRequestCancelled)
+		require.False(t, svc.IsSessionBusy(env.sessionID))
+
+		msgs, err := env.messages.List(context.Background(), env.sessionID)
+		require.NoError(t, err)
+		require.Equal(t, message.FinishReasonCanceled, msgs[1].FinishReason())
+	})
+}
+
+func TestAgentSummarize(t *testing.T) {
+	t.Parallel()
+
+	withUsage := func(turn provider.ReplayTurn, inputTokens int64) provider.ReplayTurn {
+		turn.Events[len(turn.Events)-1].Response.Usage.InputTokens = inputTokens
+		return turn
+	}
+
+	t.Run("summarizes mid-turn when the context window fills up", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay", ContextWindow: 1000},
+			withUsage(provider.ToolUseTurn(tools.ToolCall{ID: "call_1", Name: "echo", Input: `{"text":"hi"}`}), 900),
+			provider.TextTurn("done"),
+		)
+		small := provider.NewReplayProvider(agent.ModelInfo{ID: "small", Name: "Small"}, provider.TextTurn("We echoed hi."))
+		svc := env.newAgentWithConfig(agent.AgentConfig{Tools: []tools.BaseTool{echoTool{}}, SummaryProvider: small}, p)
+
+		result := run(t, svc, env.sessionID, "echo hi")
+		require.NoError(t, result.Error)
+		require.Equal(t, "done", result.Message.Content().String())
+
+		summaryRequest := small.Requests()[0].Messages
+		require.Len(t, summaryRequest, 4)
+		require.Equal(t, message.Tool, summaryRequest[2].Role)
+
+		history := p.Requests()[1].Messages
+		require.Len(t, history, 1)
+		require.Equal(t, message.User, history[0].Role)
+		require.Equal(t, "We echoed hi.", history[0].Content().String())
+
+		sess, err := env.sessions.Get(context.Background(), env.sessionID)
+		require.NoError(t, err)
+		require.Equal(t, history[0].ID, sess.SummaryMessageID)
+	})
+
+	t.Run("does not summarize when disabled", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay", ContextWindow: 1000},
+			withUsage(provider.ToolUseTurn(tools.ToolCall{ID: "call_1", Name: "echo", Input: `{"text":"hi"}`}), 900),
+			provider.TextTurn("done"),
+		)
+		small := provider.NewReplayProvider(agent.ModelInfo{ID: "small"})
+		svc := env.newAgentWithConfig(agent.AgentConfig{
+			Tools:                []tools.BaseTool{echoTool{}},
+			SummaryProvider:      small,
+			DisableAutoSummarize: true,
+		}, p)
+
+		result := run(t, svc, env.sessionID, "echo hi")
+		require.NoError(t, result.Error)
+		require.Empty(t, small.Requests())
+		require.Len(t, p.Requests()[1].Messages, 3)
+	})
+
+	t.Run("summarizes on demand", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, provider.TextTurn("hello"), provider.TextTurn("again"))
+		small := provider.NewReplayProvider(agent.ModelInfo{ID: "small"}, provider.TextTurn("We said hello."))
+		svc := env.newAgentWithConfig(agent.AgentConfig{SummaryProvider: small}, p)
+
+		run(t, svc, env.sessionID, "hi")
+		require.NoError(t, svc.Summarize(context.Background(), env.sessionID))
+
+		run(t, svc, env.sessionID, "and now?")
+		history := p.Requests()[1].Messages
+		require.Len(t, history, 2)
+		require.Equal(t, "We said hello.", history[0].Content().String())
+		require.Equal(t, "and now?", history[1].Content().String())
+	})
+
+	t.Run("needs a summary provider", func(t *testing.T) {
+		env := newTestEnv(t)
+		svc := env.newAgent(provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}))
+		require.ErrorIs(t, svc.Summarize(context.Background(), env.sessionID), agent.ErrSummaryUnavailable)
+	})
+}
+
+func TestAgentTitle(t *testing.T) {
+	t.Parallel()
+
+	env := newTestEnv(t)
+	title := provider.TextTurn("\"Echo a greeting\"\n")
+	title.Events[len(title.Events)-1].Response.Usage = agent.TokenUsage{InputTokens: 1_000_000}
+	small := provider.NewReplayProvider(agent.ModelInfo{ID: "small", CostPer1MIn: 0.5}, title)
+	p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, provider.TextTurn("hello"), provider.TextTurn("again"))
+	svc := env.newAgentWithConfig(agent.AgentConfig{TitleProvider: small}, p)
+
+	run(t, svc, env.sessionID, "say hello")
+	require.Eventually(t, func() bool {
+		sess, err := env.sessions.Get(context.Background(), env.sessionID)
+		return err == nil && sess.Title == "Echo a greeting" && sess.Cost == 0.5
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Contains(t, small.Requests()[0].Messages[0].Content().String(), "say hello")
+
+	// Only the first prompt names the session.
+	run(t, svc, env.sessionID, "again")
+	require.Len(t, small.Requests(), 1)
+}
+
+func TestAgentEditAndResubmit(t *testing.T) {
+	t.Parallel()
+
+	env := newTestEnv(t)
+	p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"},
+		provider.TextTurn("hello"),
+		provider.TextTurn("again"),
+		provider.TextTurn("edited"),
+	)
+	svc := env.newAgent(p)
+	run(t, svc, env.sessionID, "first")
+	run(t, svc, env.sessionID, "second")
+	original, err := env.messages.List(context.Background(), env.sessionID)
+	require.NoError(t, err)
+	require.Len(t, original, 4)
+
+	_, _, err = svc.EditAndResubmit(context.Background(), env.sessionID, original[1].ID, "no")
+	require.ErrorIs(t, err, agent.ErrNotUserMessage)
+
+	fork, events, err := svc.EditAndResubmit(context.Background(), env.sessionID, original[2].ID, "second, edited")
+	require.NoError(t, err)
+	result := agent.Wait(events)
+	require.NoError(t, result.Error)
+	require.Equal(t, "edited", result.Message.Content().String())
+	require.Equal(t, env.sessionID, fork.ParentSessionID)
+	require.Equal(t, original[2].ID, fork.ForkMessageID)
+
+	history := p.Requests()[2].Messages
+	require.Len(t, history, 3)
+	require.Equal(t, "first", history[0].Content().String())
+	require.Equal(t, "hello", history[1].Content().String())
+	require.Equal(t, "second, edited", history[2].Content().String())
+
+	// The original conversation is untouched.
+	msgs, err := env.messages.List(context.Background(), env.sessionID)
+	require.NoError(t, err)
+	require.Len(t, msgs, 4)
+	require.Equal(t, "second", msgs[2].Content().String())
+
+	forked, err := env.messages.List(context.Background(), fork.ID)
+	require.NoError(t, err)
+	require.Len(t, forked, 4)
+	require.NotEqual(t, original[0].ID, forked[0].ID)
+	require.Equal(t, "edited", forked[3].Content().String())
+}
+
+func TestAgentUsage(t *testing.T) {
+	t.Parallel()
+
+	withUsage := func(turn provider.ReplayTurn, usage agent.TokenUsage) provider.ReplayTurn {
+		turn.Events[len(turn.Events)-1].Response.Usage = usage
+		return turn
+	}
+	model := agent.ModelInfo{ID: "replay", Name: "Replay", CostPer1MIn: 1e6, CostPer1MOut: 2e6, CostPer1MInCached: 3e6, CostPer1MOutCached: 4e6}
+
+	env := newTestEnv(t)
+	p := provider.NewReplayProvider(model,
+		withUsage(provider.ToolUseTurn(tools.ToolCall{ID: "call_1", Name: "echo", Input: `{"text":"hi"}`}),
+			agent.TokenUsage{InputTokens: 10, OutputTokens: 2, CacheCreationTokens: 100}),
+		withUsage(provider.TextTurn("done"),
+			agent.TokenUsage{InputTokens: 5, OutputTokens: 3, CacheReadTokens: 100}),
+	)
+	result := run(t, env.newAgent(p), env.sessionID, "echo hi")
+	require.NoError(t, result.Error)
+
+	msgs, err := env.messages.List(context.Background(), env.sessionID)
+	require.NoError(t, err)
+	ledger, err := env.usage.List(context.Background(), env.sessionID)
+	require.NoError(t, err)
+	require.Len(t, ledger, 2)
+	require.Equal(t, msgs[1].ID, ledger[0].MessageID)
+	require.Equal(t, msgs[3].ID, ledger[1].MessageID)
+	require.Equal(t, "replay", ledger[0].Model)
+	require.Equal(t, "Replay", ledger[0].Provider)
+	require.Equal(t, int64(100), ledger[0].CacheCreationTokens)
+	require.Equal(t, int64(100), ledger[1].CacheReadTokens)
+	require.Equal(t, float64(10+2*2+3*100), ledger[0].Cost)
+	require.Equal(t, float64(5+2*3+4*100), ledger[1].Cost)
+
+	// The session adds up the ledger instead of keeping the last response.
+	sess, err := env.sessions.Get(context.Background(), env.sessionID)
+	require.NoError(t, err)
+	require.Equal(t, int64(10+100+5+100), sess.PromptTokens)
+	require.Equal(t, int64(2+3), sess.CompletionTokens)
+	require.Equal(t, ledger[0].Cost+ledger[1].Cost, sess.Cost)
+}
+
+func TestAgentParallelTools(t *testing.T) {
+	t.Parallel()
+
+	env := newTestEnv(t)
+	arrived := &sync.WaitGroup{}
+	arrived.Add(2)
+	done := &atomic.Int32{}
+	p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"},
+		provider.ToolUseTurn(
+			tools.ToolCall{ID: "call_1", Name: "meet", Input: "1"},
+			tools.ToolCall{ID: "call_2", Name: "meet", Input: "2"},
+			tools.ToolCall{ID: "call_3", Name: "count", Input: "{}"},
+		),
+		provider.TextTurn("done"),
+	)
+	svc := env.newAgentWithConfig(agent.AgentConfig{
+		Tools: []tools.BaseTool{meetTool{arrived: arrived, done: done}, countTool{done: done}},
+	}, p)
+
+	result := run(t, svc, env.sessionID, "meet")
+	require.NoError(t, result.Error)
+
+	toolResults := p.Requests()[1].Messages[2].ToolResults()
+	require.Equal(t, []string{"met 1", "met 2", "2 done"}, []string{toolResults[0].Content, toolResults[1].Content, toolResults[2].Content})
+	require.Equal(t, []string{"call_1", "call_2", "call_3"}, []string{toolResults[0].ToolCallID, toolResults[1].ToolCallID, toolResults[2].ToolCallID})
+}
+
+func TestAgentTool(t *testing.T) {
+	t.Parallel()
+
+	t.Run("is read-only when the sub-agent's tools are", func(t *testing.T) {
+		t.Parallel()
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"})
+		for _, tt := range []struct {
+			tools    []tools.BaseTool
+			readOnly bool
+		}{
+			{[]tools.BaseTool{imageTool{}, meetTool{}}, true},
+			{[]tools.BaseTool{imageTool{}, echoTool{}}, false},
+			{nil, true},
+		} {
+			sub := env.newAgentWithConfig(agent.AgentConfig{ID: "task", Tools: tt.tools}, p)
+			tool := agent.NewAgentTool(sub, config.Agent{ID: "task"}, env.sessions, env.messages)
+			require.Equal(t, tt.readOnly, tool.Info().ReadOnly)
+		}
+	})
+}
+
+func TestAgentToolImages(t *testing.T) {
+	t.Parallel()
+
+	for _, supportsImages := range []bool{true, false} {
+		t.Run(fmt.Sprintf("supports images %v", supportsImages), func(t *testing.T) {
+			t.Parallel()
+
+			env := newTestEnv(t)
+			p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay", SupportsImages: supportsImages},
+				provider.ToolUseTurn(tools.ToolCall{ID: "call_1", Name: "image", Input: "{}"}),
+				provider.TextTurn("done"),
+			)
+			svc := env.newAgentWithConfig(agent.AgentConfig{Tools: []tools.BaseTool{imageTool{}}}, p)
+
+			result := run(t, svc, env.sessionID, "look")
+			require.NoError(t, result.Error)
+
+			toolResult := p.Requests()[1].Messages[2].ToolResults()[0]
+			if supportsImages {
+				require.Equal(t, "a screenshot", toolResult.Content)
+				require.Equal(t, []byte("png data"), toolResult.Data)
+				require.Equal(t, "image/png", toolResult.MIMEType)
+				return
+			}
+			require.Equal(t, "a screenshot\n\n(The image is not shown because the model does not support images.)", toolResult.Content)
+			require.Empty(t, toolResult.Data)
+		})
+	}
+}
+
+func TestNewAgentConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "AGENTS.md"), []byte("Use tabs.\n"), 0o644))
+	builtin := []tools.BaseTool{echoTool{}, countTool{}}
+
+	t.Run("filters built-in tools", func(t *testing.T) {
+		t.Parallel()
+		cfg := agent.NewAgentConfig(config.Agent{ID: "task", AllowedTools: []string{"echo"}, AllowedMCP: map[string][]string{}}, "prompt", dir, builtin, nil)
+		require.Equal(t, "task", cfg.ID)
+		require.Len(t, cfg.Tools, 1)
+		require.Equal(t, "echo", cfg.Tools[0].Name())
+
+		cfg = agent.NewAgentConfig(config.Agent{ID: "coder"}, "prompt", dir, builtin, nil)
+		require.Len(t, cfg.Tools, 2)
+	})
+
+	t.Run("reads context files from the working directory", func(t *testing.T) {
+		t.Parallel()
+		cfg := agent.NewAgentConfig(config.Agent{ContextPaths: []string{"AGENTS.md"}}, "prompt", dir, nil, nil)
+		require.Equal(t, "prompt", cfg.SystemPrompt)
+		require.Equal(t, dir, cfg.WorkingDir)
+		require.Equal(t, []string{"AGENTS.md"}, cfg.ContextPaths)
+	})
+}
+
+func TestNewCommandPolicy(t *testing.T) {
+	t.Parallel()
+
+	p := agent.NewCommandPolicy(&config.Permissions{Commands: config.CommandRules{
+		Allow: []string{"curl", "git push"},
+		Deny:  []string{"git push"},
+	}})
+	check := func(script string) policy.Action {
+		decision, err := p.Check(script)
+		require.NoError(t, err)
+		return decision.Action
+	}
+	require.Equal(t, policy.Allow, check("curl example.com"))
+	require.Equal(t, policy.Deny, check("git push"))
+	require.Equal(t, policy.Deny, check("wget example.com"))
+	require.Equal(t, policy.Allow, check("ls"))
+
+	p = agent.NewCommandPolicy(nil)
+	require.Equal(t, policy.Deny, check("curl example.com"))
+}
+
+func TestNewSandbox(t *testing.T) {
+	t.Parallel()
+
+	sandbox, err := agent.NewSandbox(nil, t.TempDir())
+	require.NoError(t, err)
+	require.Nil(t, sandbox)
+
+	sandbox, err = agent.NewSandbox(&config.Permissions{Sandbox: config.Sandbox{Mode: config.SandboxModeOff}}, t.TempDir())
+	require.NoError(t, err)
+	require.Nil(t, sandbox)
+
+	_, err = agent.NewSandbox(&config.Permissions{Sandbox: config.Sandbox{Mode: "strict"}}, t.TempDir())
+	require.EqualError(t, err, "unknown sandbox mode: strict")
+
+	sandbox, err = agent.NewSandbox(&config.Permissions{Sandbox: config.Sandbox{Mode: config.SandboxModeOn, Network: true, Memory: 1024}}, t.TempDir())
+	if errors.Is(err, tools.ErrSandboxUnsupported) {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { sandbox.Close() })
+	require.Equal(t, tools.SandboxConfig{
+		Network:   true,
+		CPUTime:   tools.DefaultSandboxCPUTime,
+		Memory:    1024,
+		Processes: 0, // not limited with the network, see tools.SandboxConfig
+	}, sandbox.Config())
+}
+
+// writeFileTool writes its input to a file, like the model editing it.
+type writeFileTool struct{ path string }
+
+func (writeFileTool) Name() string { return "write_file" }
+
+func (writeFileTool) Info() tools.ToolInfo {
+	return tools.ToolInfo{Name: "write_file"}
+}
+
+func (w writeFileTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolResponse, error) {
+	if err := os.WriteFile(w.path, []byte(call.Input), 0o644); err != nil {
+		return tools.NewTextErrorResponse(err.Error()), nil
+	}
+	return tools.NewTextResponse("written"), nil
+}
+
+func TestAgentSystemPrompt(t *testing.T) {
+	t.Parallel()
+
+	systemPrompt := func(t *testing.T, p *provider.ReplayProvider, request int) string {
+		t.Helper()
+		msgs := p.Requests()[request].Messages
+		require.Equal(t, message.System, msgs[0].Role)
+		require.Equal(t, message.User, msgs[1].Role)
+		return msgs[0].Content().String()
+	}
+
+	t.Run("includes environment and context files", func(t *testing.T) {
+		t.Parallel()
+		env := newTestEnv(t)
+		dir := t.TempDir()
+		rules := filepath.Join(dir, ".cursor", "rules", "go")
+		require.NoError(t, os.MkdirAll(rules, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "AGENTS.md"), []byte("Use tabs.\n"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "CLAUDE.md"), []byte("Use tabs.\n"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(rules, "errors.mdc"), []byte("Wrap errors."), 0o644))
+
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, provider.TextTurn("hi"), provider.TextTurn("hi"))
+		svc := env.newAgentWithConfig(agent.AgentConfig{
+			SystemPrompt: "You are a coding agent.",
+			WorkingDir:   dir,
+			ContextPaths: []string{"AGENTS.md", "CLAUDE.md", ".cursor/rules/", "missing.md"},
+		}, p)
+
+		require.NoError(t, run(t, svc, env.sessionID, "hello").Error)
+		prompt := systemPrompt(t, p, 0)
+		require.True(t, strings.HasPrefix(prompt, "You are a coding agent.\n\n<env>\n"))
+		require.Contains(t, prompt, "Working directory: "+dir)
+		require.Contains(t, prompt, "# From: AGENTS.md\nUse tabs.")
+		require.NotContains(t, prompt, "CLAUDE.md")
+		require.Contains(t, prompt, "# From: "+filepath.Join(".cursor", "rules", "go", "errors.mdc")+"\nWrap errors.")
+
+		// Changed files are read again on the next turn.
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "AGENTS.md"), []byte("Use spaces, not tabs.\n"), 0o644))
+		require.NoError(t, run(t, svc, env.sessionID, "again").Error)
+		prompt = systemPrompt(t, p, 1)
+		require.Contains(t, prompt, "# From: AGENTS.md\nUse spaces, not tabs.")
+		require.Contains(t, prompt, "# From: CLAUDE.md\nUse tabs.")
+	})
+
+	t.Run("is rebuilt for each turn of a run", func(t *testing.T) {
+		t.Parallel()
+		env := newTestEnv(t)
+		dir := t.TempDir()
+		path := filepath.Join(dir, "AGENTS.md")
+		require.NoError(t, os.WriteFile(path, []byte("Use tabs."), 0o644))
+
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"},
+			provider.ToolUseTurn(tools.ToolCall{ID: "call_1", Name: "write_file", Input: "Use spaces."}),
+			provider.TextTurn("done"),
+		)
+		svc := env.newAgentWithConfig(agent.AgentConfig{
+			Tools:        []tools.BaseTool{writeFileTool{path: path}},
+			WorkingDir:   dir,
+			ContextPaths: []string{"AGENTS.md"},
+		}, p)
+
+		require.NoError(t, run(t, svc, env.sessionID, "hello").Error)
+		require.Contains(t, systemPrompt(t, p, 0), "# From: AGENTS.md\nUse tabs.")
+		require.Contains(t, systemPrompt(t, p, 1), "# From: AGENTS.md\nUse spaces.")
+	})
+
+	t.Run("respects the token budget", func(t *testing.T) {
+		t.Parallel()
+		env := newTestEnv(t)
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "AGENTS.md"), []byte(strings.Repeat("rule ", 1000)), 0o644))
+
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, provider.TextTurn("hi"))
+		svc := env.newAgentWithConfig(agent.AgentConfig{
+			WorkingDir:        dir,
+			ContextPaths:      []string{"AGENTS.md"},
+			PromptTokenBudget: 300,
+		}, p)
+
+		require.NoError(t, run(t, svc, env.sessionID, "hello").Error)
+		prompt := systemPrompt(t, p, 0)
+		require.True(t, strings.HasSuffix(prompt, "\n[truncated]"))
+		require.LessOrEqual(t, len(prompt), 300*4+len("\n\n\n[truncated]"))
+	})
+}
+
+func TestAgentEvents(t *testing.T) {
+	t.Parallel()
+
+	env := newTestEnv(t)
+	p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay", CostPer1MOut: 1e6},
+		provider.ToolUseTurn(tools.ToolCall{ID: "call_1", Name: "echo", Input: `{"text":"hi"}`}),
+		provider.TextTurn("done"),
+	)
+	svc := env.newAgentWithConfig(agent.AgentConfig{Tools: []tools.BaseTool{echoTool{}}}, p)
+	subscription := svc.Subscribe(t.Context())
+
+	events, err := svc.Run(context.Background(), env.sessionID, "echo")
+	require.NoError(t, err)
+	var types []agent.AgentEventType
+	var received []agent.AgentEvent
+	for event := range events {
+		require.Equal(t, env.sessionID, event.SessionID)
+		types = append(types, event.Type)
+		received = append(received, event)
+	}
+
+	require.Equal(t, []agent.AgentEventType{
+		agent.AgentEventTypeTurnStarted,
+		agent.AgentEventTypeUsage,
+		agent.AgentEventTypeToolCallStarted,
+		agent.AgentEventTypeToolCallFinished,
+		agent.AgentEventTypeTurnFinished,
+		agent.AgentEventTypeTurnStarted,
+		agent.AgentEventTypeContentDelta,
+		agent.AgentEventTypeUsage,
+		agent.AgentEventTypeTurnFinished,
+		agent.AgentEventTypeResponse,
+	}, types)
+	require.Equal(t, "call_1", received[3].ToolCall.ID)
+	require.Equal(t, "echo: hi", received[3].ToolResponse.Content)
+	require.Equal(t, message.FinishReasonToolUse, received[4].Message.FinishReason())
+	require.Equal(t, "done", received[6].Delta)
+	require.Equal(t, "done", received[9].Message.Content().String())
+
+	// Subscribers see the same events.
+	for _, want := range types {
+		select {
+		case event := <-subscription:
+			require.Equal(t, want, event.Payload.Type)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("subscriber did not receive %s", want)
+		}
+	}
+
+	// A caller that reads nothing until the run is over does not hold it
+	// up: the progress events that do not fit are dropped.
+	turn := provider.TextTurn("done")
+	for range 200 {
+		turn.Events = append([]provider.ReplayEvent{{Type: agent.EventContentDelta, Content: "."}}, turn.Events...)
+	}
+	svc = env.newAgent(provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, turn))
+	events, err = svc.Run(context.Background(), env.sessionID, "talk")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return !svc.IsSessionBusy(env.sessionID) }, 5*time.Second, 10*time.Millisecond)
+	result := agent.Wait(events)
+	require.Equal(t, agent.AgentEventTypeResponse, result.Type)
+	require.NoError(t, result.Error)
+}
+
+func TestAgentQueue(t *testing.T) {
+	t.Parallel()
+
+	env := newTestEnv(t)
+	p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay", SupportsImages: true},
+		provider.ToolUseTurn(tools.ToolCall{ID: "call_1", Name: "block", Input: "{}"}),
+		provider.TextTurn("done"),
+	)
+	block := blockTool{started: make(chan struct{}), release: make(chan struct{})}
+	svc := env.newAgentWithConfig(agent.AgentConfig{Tools: []tools.BaseTool{block}}, p)
+
+	events, err := svc.Run(context.Background(), env.sessionID, "start")
+	require.NoError(t, err)
+	<-block.started
+
+	queue := func(content string, attachments ...message.Attachment) agent.AgentEvent {
+		t.Helper()
+		queued, err := svc.Run(context.Background(), env.sessionID, content, attachments...)
+		require.NoError(t, err)
+		result := agent.Wait(queued)
+		require.Equal(t, agent.AgentEventTypeQueued, result.Type)
+		return result
+	}
+	image := message.Attachment{FilePath: "cat.png", FileName: "cat.png", MimeType: "image/png", Content: []byte("png")}
+	first := queue("look at this", image)
+	require.Equal(t, 1, first.QueuePosition)
+	second := queue("second")
+	require.Equal(t, 2, second.QueuePosition)
+	third := queue("third")
+	require.Equal(t, 3, third.QueuePosition)
+
+	require.NoError(t, svc.MoveQueuedPrompt(env.sessionID, third.QueuedPrompt.ID, 1))
+	edited := *second.QueuedPrompt
+	edited.Content = "second, edited"
+	require.NoError(t, svc.UpdateQueuedPrompt(env.sessionID, edited))
+	require.NoError(t, svc.RemoveQueuedPrompt(env.sessionID, first.QueuedPrompt.ID))
+	require.ErrorIs(t, svc.RemoveQueuedPrompt(env.sessionID, first.QueuedPrompt.ID), agent.ErrPromptNotQueued)
+	require.NoError(t, svc.MoveQueuedPrompt(env.sessionID, third.QueuedPrompt.ID, 5))
+	queued := svc.ListQueuedPrompts(env.sessionID)
+	require.Len(t, queued, 2)
+	require.Equal(t, "second, edited", queued[0].Content)
+	require.Equal(t, "third", queued[1].Content)
+
+	// The attachment survives the queue.
+	queue("with image", image)
+
+	close(block.release)
+	result := agent.Wait(events)
+	require.NoError(t, result.Error)
+	require.Equal(t, 0, svc.QueuedPrompts(env.sessionID))
+
+	msgs := p.Requests()[1].Messages
+	require.Len(t, msgs, 6)
+	require.Equal(t, "second, edited", msgs[3].Content().String())
+	require.Equal(t, "third", msgs[4].Content().String())
+	require.Equal(t, "with image", msgs[5].Content().String())
+	require.Equal(t, []message.BinaryContent{{Path: "cat.png", MIMEType: "image/png", Data: []byte("png")}}, msgs[5].BinaryContent())
+}
+
+func TestAgentBudget(t *testing.T) {
+	t.Parallel()
+
+	// costlyTurn ends the turn at a cost of one dollar with the model below.
+	costlyTurn := provider.ReplayTurn{Events: []provider.ReplayEvent{
+		{Type: agent.EventContentDelta, Content: "done"},
+		{Type: agent.EventComplete, Response: &agent.ProviderResponse{
+			Content:      "done",
+			FinishReason: message.FinishReasonEndTurn,
+			Usage:        agent.TokenUsage{OutputTokens: 1},
+		}},
+	}}
+	model := agent.ModelInfo{ID: "replay", CostPer1MOut: 1e6}
+
+	lastMessage := func(t *testing.T, env testEnv) message.Message {
+		t.Helper()
+		msgs, err := env.messages.List(context.Background(), env.sessionID)
+		require.NoError(t, err)
+		return msgs[len(msgs)-1]
+	}
+
+	t.Run("stops the tool loop after max iterations", func(t *testing.T) {
+		t.Parallel()
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(model,
+			provider.ToolUseTurn(tools.ToolCall{ID: "call_1", Name: "echo", Input: `{"text":"hi"}`}),
+			provider.TextTurn("done"),
+		)
+		svc := env.newAgentWithConfig(agent.AgentConfig{
+			Tools:     []tools.BaseTool{echoTool{}},
+			RunBudget: agent.Budget{MaxIterations: 1},
+		}, p)
+
+		result := run(t, svc, env.sessionID, "echo")
+		var budgetErr *agent.BudgetError
+		require.ErrorAs(t, result.Error, &budgetErr)
+		require.Equal(t, agent.BudgetError{Scope: agent.BudgetScopeRun, Limit: "iterations"}, *budgetErr)
+		require.Equal(t, 1, p.Remaining())
+
+		msgs, err := env.messages.List(context.Background(), env.sessionID)
+		require.NoError(t, err)
+		require.Len(t, msgs, 3)
+		require.Equal(t, message.FinishReasonBudgetExceeded, msgs[1].FinishReason())
+		require.Contains(t, msgs[2].ToolResults()[0].Content, "Tool execution skipped")
+
+		// The run budget is per run.
+		require.NoError(t, run(t, svc, env.sessionID, "again").Error)
+	})
+
+	t.Run("refuses runs until the session budget is raised", func(t *testing.T) {
+		t.Parallel()
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(model, costlyTurn, costlyTurn)
+		svc := env.newAgentWithConfig(agent.AgentConfig{SessionBudget: agent.Budget{MaxCost: 0.5}}, p)
+
+		require.NoError(t, run(t, svc, env.sessionID, "first").Error)
+		_, err := svc.Run(context.Background(), env.sessionID, "second")
+		require.ErrorIs(t, err, agent.ErrBudgetExceeded)
+
+		svc.SetSessionBudget(env.sessionID, agent.Budget{MaxCost: 5})
+		require.NoError(t, run(t, svc, env.sessionID, "second").Error)
+		require.Equal(t, 0, p.Remaining())
+	})
+
+	t.Run("stops a run that takes too long", func(t *testing.T) {
+		t.Parallel()
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(model, provider.ReplayTurn{
+			Events:        []provider.ReplayEvent{{Type: agent.EventContentDelta, Content: "partial"}},
+			WaitForCancel: true,
+		})
+		svc := env.newAgentWithConfig(agent.AgentConfig{RunBudget: agent.Budget{MaxDuration: 50 * time.Millisecond}}, p)
+		events := svc.Subscribe(t.Context())
+
+		result := run(t, svc, env.sessionID, "hi")
+		require.ErrorIs(t, result.Error, agent.ErrBudgetExceeded)
+		msg := lastMessage(t, env)
+		require.Equal(t, message.FinishReasonBudgetExceeded, msg.FinishReason())
+		require.False(t, svc.IsSessionBusy(env.sessionID))
+
+		for event := range events {
+			if event.Payload.Type == agent.AgentEventTypeBudgetExceeded {
+				require.Equal(t, "partial", event.Payload.Message.Content().String())
+				break
+			}
+		}
+	})
+}
+
+func TestAgentHooks(t *testing.T) {
+	t.Parallel()
+
+	hook := func(event hooks.Event, output hooks.Output, err error) hooks.Hook {
+		return hooks.Hook{Event: event, Run: func(ctx context.Context, input hooks.Input) (hooks.Output, error) {
+			return output, err
+		}}
+	}
+	echoCall := provider.ToolUseTurn(tools.ToolCall{ID: "call_1", Name: "echo", Input: `{"text":"hello"}`})
+	toolResult := func(t *testing.T, p *provider.ReplayProvider) message.ToolResult {
+		t.Helper()
+		return p.Requests()[1].Messages[2].ToolResults()[0]
+	}
+
+	t.Run("rewrites tool input and adds context", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, echoCall, provider.TextTurn("done"))
+		svc := env.newAgentWithConfig(agent.AgentConfig{
+			Tools: []tools.BaseTool{echoTool{}},
+			Hooks: hooks.Hooks{
+				hook(hooks.PreToolUse, hooks.Output{Input: json.RawMessage(`{"text":"rewritten"}`)}, nil),
+				hook(hooks.PostToolUse, hooks.Output{Context: "formatted"}, nil),
+			},
+		}, p)
+
+		result := run(t, svc, env.sessionID, "hi")
+		require.NoError(t, result.Error)
+		require.Equal(t, "echo: rewritten\n\nformatted", toolResult(t, p).Content)
+	})
+
+	t.Run("reports denied tool calls to the model", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, echoCall, provider.TextTurn("done"))
+		svc := env.newAgentWithConfig(agent.AgentConfig{
+			Tools: []tools.BaseTool{echoTool{}},
+			Hooks: hooks.Hooks{hook(hooks.PreToolUse, hooks.Output{Deny: true, Reason: "no echoes"}, nil)},
+		}, p)
+
+		result := run(t, svc, env.sessionID, "hi")
+		require.NoError(t, result.Error)
+		got := toolResult(t, p)
+		require.True(t, got.IsError)
+		require.Equal(t, "Tool call denied by hook: no echoes", got.Content)
+	})
+
+	t.Run("reports hook failures as tool errors", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, echoCall, provider.TextTurn("done"))
+		svc := env.newAgentWithConfig(agent.AgentConfig{
+			Tools: []tools.BaseTool{echoTool{}},
+			Hooks: hooks.Hooks{hook(hooks.PostToolUse, hooks.Output{}, errors.New("lint failed"))},
+		}, p)
+
+		result := run(t, svc, env.sessionID, "hi")
+		require.NoError(t, result.Error)
+		got := toolResult(t, p)
+		require.True(t, got.IsError)
+		require.Equal(t, "echo: hello\n\npost_tool_use hook failed: lint failed", got.Content)
+	})
+
+	t.Run("continues the run with context added after a turn", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, provider.TextTurn("first"), provider.TextTurn("second"))
+		var turns atomic.Int32
+		svc := env.newAgentWithConfig(agent.AgentConfig{
+			Hooks: hooks.Hooks{
+				hook(hooks.SessionStart, hooks.Output{Context: "project rules"}, nil),
+				{Event: hooks.PostTurn, Run: func(ctx context.Context, input hooks.Input) (hooks.Output, error) {
+					if turns.Add(1) == 1 {
+						return hooks.Output{Context: "fix the lint errors in " + input.Content, Continue: true}, nil
+					}
+					// Context without continue does not prompt the model.
+					return hooks.Output{Context: "still unformatted"}, nil
+				}},
+			},
+		}, p)
+
+		result := run(t, svc, env.sessionID, "hi")
+		require.NoError(t, result.Error)
+		require.Equal(t, "second", result.Message.Content().String())
+		require.Len(t, p.Requests(), 2)
+		msgs := p.Requests()[1].Messages
+		require.Len(t, msgs, 4)
+		require.Equal(t, "project rules", msgs[0].Content().String())
+		require.Equal(t, "first", msgs[2].Content().String())
+		require.Equal(t, "fix the lint errors in first", msgs[3].Content().String())
+	})
+
+	t.Run("stops the run when a turn is denied", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, provider.TextTurn("hello"))
+		svc := env.newAgentWithConfig(agent.AgentConfig{
+			Hooks: hooks.Hooks{hook(hooks.PreTurn, hooks.Output{Deny: true, Reason: "outside office hours"}, nil)},
+		}, p)
+
+		result := run(t, svc, env.sessionID, "hi")
+		require.ErrorIs(t, result.Error, agent.ErrDeniedByHook)
+		require.Empty(t, p.Requests())
+	})
+
+	t.Run("builds command hooks from the configuration", func(t *testing.T) {
+		_, err := agent.NewHooks(config.Hooks{"pre_commit": {{Command: "true"}}}, t.TempDir())
+		require.Error(t, err)
+
+		h, err := agent.NewHooks(config.Hooks{
+			"pre_tool_use": {{Command: "echo 'not here' >&2; exit 2", Tools: []string{"echo"}}},
+		}, t.TempDir())
+		require.NoError(t, err)
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}, echoCall, provider.TextTurn("done"))
+		svc := env.newAgentWithConfig(agent.AgentConfig{Tools: []tools.BaseTool{echoTool{}}, Hooks: h}, p)
+
+		result := run(t, svc, env.sessionID, "hi")
+		require.NoError(t, result.Error)
+		require.Equal(t, "Tool call denied by hook: not here", toolResult(t, p).Content)
+	})
+}
+
+func TestAgentSteering(t *testing.T) {
+	t.Parallel()
+
+	t.Run("delivers interjections before the next tool call", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"},
+			provider.ToolUseTurn(
+				tools.ToolCall{ID: "call_1", Name: "block", Input: "{}"},
+				tools.ToolCall{ID: "call_2", Name: "echo", Input: `{"text":"hello"}`},
+			),
+			provider.TextTurn("done"),
+		)
+		block := blockTool{started: make(chan struct{}), release: make(chan struct{})}
+		svc := env.newAgentWithConfig(agent.AgentConfig{Tools: []tools.BaseTool{block, echoTool{}}}, p)
+
+		events, err := svc.Run(context.Background(), env.sessionID, "start")
+		require.NoError(t, err)
+		<-block.started
+		require.NoError(t, svc.Interject(env.sessionID, "use the other file"))
+		require.Equal(t, agent.AgentStateInterjecting, svc.SessionState(env.sessionID))
+		require.Equal(t, agent.AgentStateInterjecting, svc.GetState())
+
+		close(block.release)
+		result := agent.Wait(events)
+		require.NoError(t, result.Error)
+		require.Equal(t, "done", result.Message.Content().String())
+
+		msgs := p.Requests()[1].Messages
+		require.Len(t, msgs, 4)
+		results := msgs[2].ToolResults()
+		require.Equal(t, "released", results[0].Content)
+		require.True(t, results[1].IsError)
+		require.Equal(t, "Tool call skipped: the user interjected", results[1].Content)
+		require.Equal(t, "use the other file", msgs[3].Content().String())
+		require.Equal(t, agent.AgentStateIdle, svc.GetState())
+	})
+
+	t.Run("pauses after the current tool call", func(t *testing.T) {
+		env := newTestEnv(t)
+		p := provider.NewReplayProvider(agent.ModelInfo{ID: "replay"},
+			provider.ToolUseTurn(tools.ToolCall{ID: "call_1", Name: "block", Input: "{}"}),
+			provider.TextTurn("done"),
+		)
+		block := blockTool{started: make(chan struct{}), release: make(chan struct{})}
+		svc := env.newAgentWithConfig(agent.AgentConfig{Tools: []tools.BaseTool{block}}, p)
+
+		events, err := svc.Run(context.Background(), env.sessionID, "start")
+		require.NoError(t, err)
+		<-block.started
+		require.NoError(t, svc.Pause(env.sessionID))
+		require.Equal(t, agent.AgentStatePausing, svc.GetState())
+
+		close(block.release)
+		require.Eventually(t, func() bool {
+			return svc.SessionState(env.sessionID) == agent.AgentStatePaused
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Len(t, p.Requests(), 1)
+
+		require.NoError(t, svc.Resume(env.sessionID))
+		result := agent.Wait(events)
+		require.NoError(t, result.Error)
+		require.Equal(t, "done", result.Message.Content().String())
+	})
+
+	t.Run("needs a running session", func(t *testing.T) {
+		env := newTestEnv(t)
+		svc := env.newAgent(provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}))
+
+		require.ErrorIs(t, svc.Interject(env.sessionID, "note"), agent.ErrSessionNotBusy)
+		require.ErrorIs(t, svc.Pause(env.sessionID), agent.ErrSessionNotBusy)
+		require.ErrorIs(t, svc.Resume(env.sessionID), agent.ErrSessionNotBusy)
+		require.Equal(t, agent.AgentStateIdle, svc.SessionState(env.sessionID))
+	})
+}
+
+func TestAgentSessionTools(t *testing.T) {
+	t.Parallel()
+
+	env := newTestEnv(t)
+	tool := sessionTool{canceled: make(chan string, 1), closed: make(chan string, 1)}
+	svc := env.newAgentWithConfig(agent.AgentConfig{Tools: []tools.BaseTool{tool}},
+		provider.NewReplayProvider(agent.ModelInfo{ID: "replay"}))
+
+	svc.Cancel(env.sessionID)
+	require.Equal(t, env.sessionID, <-tool.canceled)
+
+	require.NoError(t, env.sessions.Delete(context.Background(), env.sessionID))
+	select {
+	case sessionID := <-tool.closed:
+		require.Equal(t, env.sessionID, sessionID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("session tool was not closed")
+	}
+}
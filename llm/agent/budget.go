@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gentica/message"
+)
+
+// ErrBudgetExceeded is wrapped by the errors of runs that were stopped, or
+// refused, because a budget ran out.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// Budget limits what a session or a single run may consume. Zero fields
+// are not limited.
+type Budget struct {
+	// MaxCost is in USD and includes what sub-agents spent.
+	MaxCost float64
+	// MaxInputTokens counts cached and uncached input tokens.
+	MaxInputTokens  int64
+	MaxOutputTokens int64
+	// MaxIterations limits the number of model turns, i.e. the rounds of
+	// tool calls the agent may make.
+	MaxIterations int
+	MaxDuration   time.Duration
+}
+
+const (
+	BudgetScopeSession = "session"
+	BudgetScopeRun     = "run"
+)
+
+// BudgetError tells which limit of which budget was reached.
+type BudgetError struct {
+	Scope string
+	Limit string
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s budget exceeded: %s limit reached", e.Scope, e.Limit)
+}
+
+func (e *BudgetError) Unwrap() error {
+	return ErrBudgetExceeded
+}
+
+// budgetUsage is what a session or a run has consumed.
+type budgetUsage struct {
+	cost         float64
+	inputTokens  int64
+	outputTokens int64
+	iterations   int
+	duration     time.Duration
+}
+
+func (u *budgetUsage) addTurn(usage TokenUsage) {
+	u.inputTokens += usage.InputTokens + usage.CacheCreationTokens + usage.CacheReadTokens
+	u.outputTokens += usage.OutputTokens
+	u.iterations++
+}
+
+// exceeded returns the first limit of b that used has reached, or "".
+func (b Budget) exceeded(used budgetUsage) string {
+	switch {
+	case b.MaxCost > 0 && used.cost >= b.MaxCost:
+		return "cost"
+	case b.MaxInputTokens > 0 && used.inputTokens >= b.MaxInputTokens:
+		return "input_tokens"
+	case b.MaxOutputTokens > 0 && used.outputTokens >= b.MaxOutputTokens:
+		return "output_tokens"
+	case b.MaxIterations > 0 && used.iterations >= b.MaxIterations:
+		return "iterations"
+	case b.MaxDuration > 0 && used.duration >= b.MaxDuration:
+		return "duration"
+	}
+	return ""
+}
+
+// runBudget tracks the consumption of one run.
+type runBudget struct {
+	sessionID string
+	start     time.Time
+	startCost float64
+	usage     budgetUsage
+}
+
+// SetSessionBudget replaces the budget of a session, e.g. to let a session
+// that ran out of budget continue.
+func (a *agent) SetSessionBudget(sessionID string, budget Budget) {
+	a.budgetMutex.Lock()
+	defer a.budgetMutex.Unlock()
+	a.sessionBudgets[sessionID] = budget
+}
+
+func (a *agent) sessionBudget(sessionID string) Budget {
+	a.budgetMutex.Lock()
+	defer a.budgetMutex.Unlock()
+	if budget, ok := a.sessionBudgets[sessionID]; ok {
+		return budget
+	}
+	return a.config.SessionBudget
+}
+
+// startRun checks that the session has budget left and starts tracking the
+// run.
+func (a *agent) startRun(ctx context.Context, sessionID string) (*runBudget, error) {
+	sess, err := a.sessions.Get(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	run := &runBudget{sessionID: sessionID, start: time.Now(), startCost: sess.Cost}
+	if err := a.checkBudget(ctx, run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// recordTurn adds the usage of a model turn to the run, and the turn to its
+// session. The session's tokens are read from its usage ledger.
+func (a *agent) recordTurn(run *runBudget, usage TokenUsage) {
+	a.budgetMutex.Lock()
+	defer a.budgetMutex.Unlock()
+	run.usage.addTurn(usage)
+	sessionUsage := a.sessionUsage[run.sessionID]
+	sessionUsage.iterations++
+	a.sessionUsage[run.sessionID] = sessionUsage
+}
+
+// finishRun adds the duration of the run to its session.
+func (a *agent) finishRun(run *runBudget) {
+	a.budgetMutex.Lock()
+	defer a.budgetMutex.Unlock()
+	sessionUsage := a.sessionUsage[run.sessionID]
+	sessionUsage.duration += time.Since(run.start)
+	a.sessionUsage[run.sessionID] = sessionUsage
+}
+
+// checkBudget returns a *BudgetError when the run or its session reached a
+// limit. Costs and session tokens are read from the session, so that they
+// include what sub-agents, titles and summaries added to it.
+func (a *agent) checkBudget(ctx context.Context, run *runBudget) error {
+	sess, err := a.sessions.Get(ctx, run.sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	elapsed := time.Since(run.start)
+
+	a.budgetMutex.Lock()
+	runUsage := run.usage
+	sessionUsage := a.sessionUsage[run.sessionID]
+	a.budgetMutex.Unlock()
+
+	runUsage.cost = sess.Cost - run.startCost
+	runUsage.duration = elapsed
+	if limit := a.config.RunBudget.exceeded(runUsage); limit != "" {
+		return &BudgetError{Scope: BudgetScopeRun, Limit: limit}
+	}
+	sessionUsage.cost = sess.Cost
+	sessionUsage.inputTokens = sess.PromptTokens
+	sessionUsage.outputTokens = sess.CompletionTokens
+	sessionUsage.duration += elapsed
+	if limit := a.sessionBudget(run.sessionID).exceeded(sessionUsage); limit != "" {
+		return &BudgetError{Scope: BudgetScopeSession, Limit: limit}
+	}
+	return nil
+}
+
+// deadline bounds the duration of the run by the time left in the run and
+// session budgets. It returns ctx unchanged when neither limits it.
+func (a *agent) deadline(ctx context.Context, run *runBudget) (context.Context, context.CancelFunc) {
+	var (
+		timeout time.Duration
+		cause   error
+	)
+	if limit := a.config.RunBudget.MaxDuration; limit > 0 {
+		timeout, cause = limit, &BudgetError{Scope: BudgetScopeRun, Limit: "duration"}
+	}
+	if limit := a.sessionBudget(run.sessionID).MaxDuration; limit > 0 {
+		a.budgetMutex.Lock()
+		left := limit - a.sessionUsage[run.sessionID].duration
+		a.budgetMutex.Unlock()
+		if cause == nil || left < timeout {
+			timeout, cause = left, &BudgetError{Scope: BudgetScopeSession, Limit: "duration"}
+		}
+	}
+	if cause == nil {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, timeout, cause)
+}
+
+// stopForBudget finishes msg, when there is one, because of err and
+// publishes that the budget ran out.
+func (a *agent) stopForBudget(ctx context.Context, sessionID string, msg *message.Message, err error) AgentEvent {
+	event := AgentEvent{Type: AgentEventTypeBudgetExceeded, SessionID: sessionID, Error: err}
+	if msg != nil && msg.ID != "" {
+		a.finishMessage(context.WithoutCancel(ctx), msg, message.FinishReasonBudgetExceeded, "Budget exceeded", err.Error())
+		event.Message = *msg
+	}
+	a.emit(event)
+	return a.err(err)
+}
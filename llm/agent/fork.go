@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gentica/message"
+	"gentica/session"
+)
+
+// ErrNotUserMessage is returned by EditAndResubmit for messages the user
+// did not send.
+var ErrNotUserMessage = errors.New("not a user message")
+
+// EditAndResubmit forks the session at one of its user messages and runs
+// content in the fork in place of that message. The original session is
+// left as it was; the fork is returned so that callers can switch to it.
+func (a *agent) EditAndResubmit(ctx context.Context, sessionID, messageID, content string, attachments ...message.Attachment) (session.Session, <-chan AgentEvent, error) {
+	msg, err := a.messages.Get(ctx, messageID)
+	if err != nil {
+		return session.Session{}, nil, fmt.Errorf("failed to get message: %w", err)
+	}
+	if msg.SessionID != sessionID {
+		return session.Session{}, nil, fmt.Errorf("message %s is not in session %s", messageID, sessionID)
+	}
+	if msg.Role != message.User {
+		return session.Session{}, nil, fmt.Errorf("%w: %s", ErrNotUserMessage, messageID)
+	}
+
+	fork, err := a.sessions.Fork(ctx, sessionID, messageID)
+	if err != nil {
+		return session.Session{}, nil, fmt.Errorf("failed to fork session: %w", err)
+	}
+	events, err := a.Run(ctx, fork.ID, content, attachments...)
+	if err != nil {
+		return fork, nil, err
+	}
+	return fork, events, nil
+}
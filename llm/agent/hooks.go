@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"gentica/llm/hooks"
+	"gentica/llm/tools"
+	"gentica/message"
+)
+
+// runSessionStartHooks runs the session_start hooks before the first prompt
+// of a session. The context they add is stored as a user message, to be
+// sent before the prompt.
+func (a *agent) runSessionStartHooks(ctx context.Context, sessionID, prompt string) ([]message.Message, error) {
+	output, err := a.config.Hooks.Run(ctx, hooks.Input{
+		Event:     hooks.SessionStart,
+		SessionID: sessionID,
+		Prompt:    prompt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Deny {
+		return nil, deniedByHook(output.Reason)
+	}
+	return a.storeHookContext(ctx, sessionID, output.Context)
+}
+
+// runPreTurnHooks runs the pre_turn hooks and returns the messages to send
+// for the turn. The context the hooks add is only sent with this turn.
+func (a *agent) runPreTurnHooks(ctx context.Context, sessionID string, msgHistory []message.Message) ([]message.Message, error) {
+	output, err := a.config.Hooks.Run(ctx, hooks.Input{
+		Event:     hooks.PreTurn,
+		SessionID: sessionID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Deny {
+		return nil, deniedByHook(output.Reason)
+	}
+	if output.Context == "" {
+		return msgHistory, nil
+	}
+	return append(msgHistory[:len(msgHistory):len(msgHistory)], message.Message{
+		Role:      message.User,
+		SessionID: sessionID,
+		Parts:     []message.ContentPart{message.TextContent{Text: output.Context}},
+	}), nil
+}
+
+// runPostTurnHooks runs the post_turn hooks on the response of a turn. The
+// context they add is stored as a user message, which continues the run.
+// After the end of the model's answer, it is only stored when a hook asks
+// to continue: a hook printing the same output after every answer would
+// otherwise prompt the model forever.
+func (a *agent) runPostTurnHooks(ctx context.Context, sessionID string, response message.Message, ended bool) ([]message.Message, error) {
+	output, err := a.config.Hooks.Run(ctx, hooks.Input{
+		Event:     hooks.PostTurn,
+		SessionID: sessionID,
+		Content:   response.Content().String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ended && !output.Continue {
+		return nil, nil
+	}
+	return a.storeHookContext(ctx, sessionID, output.Context)
+}
+
+func (a *agent) storeHookContext(ctx context.Context, sessionID, hookContext string) ([]message.Message, error) {
+	if hookContext == "" {
+		return nil, nil
+	}
+	msg, err := a.createUserMessage(ctx, sessionID, hookContext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hook message: %w", err)
+	}
+	return []message.Message{msg}, nil
+}
+
+func deniedByHook(reason string) error {
+	if reason == "" {
+		return ErrDeniedByHook
+	}
+	return fmt.Errorf("%w: %s", ErrDeniedByHook, reason)
+}
+
+// toolDeniedByHook is the response to a tool call that a pre_tool_use hook
+// denied or failed on.
+func toolDeniedByHook(output hooks.Output, err error) tools.ToolResponse {
+	if err != nil {
+		return tools.NewTextErrorResponse(err.Error())
+	}
+	return tools.NewTextErrorResponse(withHookContext("Tool call "+deniedByHook(output.Reason).Error(), output.Context))
+}
+
+// withToolHooks adds what the hooks of a tool call said to its response. A
+// post_tool_use hook that denies or fails turns the response into an error.
+func withToolHooks(response tools.ToolResponse, preContext string, post hooks.Output, err error) tools.ToolResponse {
+	response.Content = withHookContext(response.Content, preContext)
+	switch {
+	case err != nil:
+		response.Content = withHookContext(response.Content, err.Error())
+		response.IsError = true
+	case post.Deny:
+		response.Content = withHookContext(response.Content, "Tool response "+deniedByHook(post.Reason).Error())
+		response.IsError = true
+	}
+	response.Content = withHookContext(response.Content, post.Context)
+	return response
+}
+
+func withHookContext(content, hookContext string) string {
+	switch {
+	case hookContext == "":
+		return content
+	case content == "":
+		return hookContext
+	}
+	return content + "\n\n" + hookContext
+}
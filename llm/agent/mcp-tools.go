@@ -15,6 +15,7 @@ import (
 	// "github.com/charmbracelet/crush/internal/csync"
 	"gentica/llm"
 	"gentica/llm/tools"
+	"gentica/permission"
 	"gentica/pubsub"
 	// "github.com/charmbracelet/crush/internal/pubsub"
 	// "github.com/charmbracelet/crush/internal/version"
@@ -83,9 +84,10 @@ var (
 )
 
 type McpTool struct {
-	mcpName    string
-	tool       mcp.Tool
-	workingDir string
+	mcpName     string
+	tool        mcp.Tool
+	permissions permission.Service
+	workingDir  string
 }
 
 func (b *McpTool) Name() string {
@@ -172,27 +174,24 @@ func (b *McpTool) Run(ctx context.Context, params tools.ToolCall) (tools.ToolRes
 	if sessionID == "" || messageID == "" {
 		return tools.ToolResponse{}, fmt.Errorf("session ID and message ID are required for creating a new file")
 	}
-	// Permission check removed - commented out for now
-	// permissionDescription := fmt.Sprintf("execute %s with the following parameters: %s", b.Info().Name, params.Input)
-	// p := permission.Check(
-	//	permission.CreatePermissionRequest{
-	//		SessionID:   sessionID,
-	//		ToolCallID:  params.ID,
-	//		Path:        b.workingDir,
-	//		ToolName:    b.Info().Name,
-	//		Action:      "execute",
-	//		Description: permissionDescription,
-	//		Params:      params.Input,
-	//	},
-	// )
-	// if !p {
-	//	return tools.ToolResponse{}, fmt.Errorf("permission denied")
-	// }
+	permissionDescription := fmt.Sprintf("execute %s with the following parameters: %s", b.Info().Name, params.Input)
+	err := b.permissions.Request(ctx, permission.CreatePermissionRequest{
+		SessionID:   sessionID,
+		ToolCallID:  params.ID,
+		Path:        b.workingDir,
+		ToolName:    b.Info().Name,
+		Action:      "execute",
+		Description: permissionDescription,
+		Params:      params.Input,
+	})
+	if err != nil {
+		return tools.ToolResponse{}, err
+	}
 
 	return runTool(ctx, b.mcpName, b.tool.Name, params.Input)
 }
 
-func getTools(ctx context.Context, name string, c *client.Client, workingDir string) []tools.BaseTool {
+func getTools(ctx context.Context, name string, c *client.Client, permissions permission.Service, workingDir string) []tools.BaseTool {
 	result, err := c.ListTools(ctx, mcp.ListToolsRequest{})
 	if err != nil {
 		slog.Error("error listing tools", "error", err)
@@ -204,10 +203,10 @@ func getTools(ctx context.Context, name string, c *client.Client, workingDir str
 	mcpTools := make([]tools.BaseTool, 0, len(result.Tools))
 	for _, tool := range result.Tools {
 		mcpTools = append(mcpTools, &McpTool{
-			mcpName: name,
-			tool:    tool,
-
-			workingDir: workingDir,
+			mcpName:     name,
+			tool:        tool,
+			permissions: permissions,
+			workingDir:  workingDir,
 		})
 	}
 	return mcpTools
@@ -270,7 +269,16 @@ var mcpInitRequest = mcp.InitializeRequest{
 	},
 }
 
-func doGetMCPTools(ctx context.Context, cfg *config.Config) []tools.BaseTool {
+// GetMCPTools connects to the configured MCP servers on the first call and
+// returns the tools they offer.
+func GetMCPTools(ctx context.Context, permissions permission.Service, cfg *config.Config) []tools.BaseTool {
+	mcpToolsOnce.Do(func() {
+		mcpTools = doGetMCPTools(ctx, permissions, cfg)
+	})
+	return mcpTools
+}
+
+func doGetMCPTools(ctx context.Context, permissions permission.Service, cfg *config.Config) []tools.BaseTool {
 	var wg sync.WaitGroup
 	result := csync.NewSlice[tools.BaseTool]()
 
@@ -312,7 +320,7 @@ func doGetMCPTools(ctx context.Context, cfg *config.Config) []tools.BaseTool {
 			}
 			mcpClients.Set(name, c)
 
-			tools := getTools(ctx, name, c, cfg.WorkingDir())
+			tools := getTools(ctx, name, c, permissions, cfg.WorkingDir())
 			updateMCPState(name, MCPStateConnected, nil, c, len(tools))
 			for _, tool := range tools {
 				result.Append(tool)
@@ -0,0 +1,257 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPromptTokenBudget bounds the system prompt when the agent config
+// does not set a budget.
+const defaultPromptTokenBudget = 20_000
+
+// gitStatusTimeout bounds how long the environment may wait for git.
+const gitStatusTimeout = 5 * time.Second
+
+// maxGitStatusLines keeps a dirty work tree from filling the prompt.
+const maxGitStatusLines = 50
+
+// contextFile is a context file as it was last read.
+type contextFile struct {
+	modTime time.Time
+	size    int64
+	content string
+}
+
+// promptBuilder assembles the system prompt from the configured prompt, the
+// environment the agent runs in and the project's context files. Files are
+// read again when they change between turns; the environment is captured
+// once per session so that it does not change under the model mid-session.
+type promptBuilder struct {
+	base         string
+	workingDir   string
+	contextPaths []string
+	maxTokens    int
+	now          func() time.Time
+
+	mu           sync.Mutex
+	files        map[string]contextFile
+	environments map[string]string
+}
+
+func newPromptBuilder(config AgentConfig) *promptBuilder {
+	maxTokens := config.PromptTokenBudget
+	if maxTokens <= 0 {
+		maxTokens = defaultPromptTokenBudget
+	}
+	return &promptBuilder{
+		base:         strings.TrimSpace(config.SystemPrompt),
+		workingDir:   config.WorkingDir,
+		contextPaths: config.ContextPaths,
+		maxTokens:    maxTokens,
+		now:          time.Now,
+		files:        make(map[string]contextFile),
+		environments: make(map[string]string),
+	}
+}
+
+// forget drops what the builder keeps for the session, once it is deleted.
+func (b *promptBuilder) forget(sessionID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.environments, sessionID)
+}
+
+// build returns the system prompt for the next turn of the session. It is
+// empty when the agent has neither a prompt nor a working directory.
+func (b *promptBuilder) build(ctx context.Context, sessionID string) string {
+	var sections []string
+	if b.base != "" {
+		sections = append(sections, b.base)
+	}
+	if b.workingDir == "" {
+		return strings.Join(sections, "\n\n")
+	}
+
+	// Capturing the environment runs git, which must not hold up the
+	// prompts of other sessions.
+	b.mu.Lock()
+	environment, ok := b.environments[sessionID]
+	b.mu.Unlock()
+	if !ok {
+		environment = b.environment(ctx)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if captured, ok := b.environments[sessionID]; ok {
+		environment = captured
+	} else {
+		b.environments[sessionID] = environment
+	}
+	sections = append(sections, environment)
+
+	// The prompt and the environment are always sent; the context files
+	// share what is left of the budget.
+	budget := b.maxTokens - estimateTokens(strings.Join(sections, "\n\n"))
+	seen := make(map[[sha256.Size]byte]bool)
+	for _, path := range b.contextFiles() {
+		file, ok := b.read(path)
+		if !ok || file.content == "" {
+			continue
+		}
+		// The same file is often listed under several names, e.g. AGENTS.md
+		// and agents.md on case-insensitive file systems, or linked to.
+		sum := sha256.Sum256([]byte(file.content))
+		if seen[sum] {
+			continue
+		}
+		seen[sum] = true
+
+		section := fmt.Sprintf("# From: %s\n%s", b.displayPath(path), file.content)
+		tokens := estimateTokens(section)
+		if tokens > budget {
+			if budget > 0 {
+				sections = append(sections, truncateToTokens(section, budget)+"\n[truncated]")
+			}
+			slog.Warn("Context files exceed the system prompt budget", "path", path, "max_tokens", b.maxTokens)
+			break
+		}
+		budget -= tokens
+		sections = append(sections, section)
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// contextFiles expands the context paths into the files they name, walking
+// directories recursively. Paths are returned once, in configuration order.
+func (b *promptBuilder) contextFiles() []string {
+	var files []string
+	listed := make(map[string]bool)
+	add := func(path string) {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			path = real
+		}
+		if !listed[path] {
+			listed[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, path := range b.contextPaths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(b.workingDir, path)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			add(path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				slog.Warn("Failed to read context directory", "path", file, "error", err)
+				return nil
+			}
+			if entry.Type().IsRegular() {
+				add(file)
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Warn("Failed to read context directory", "path", path, "error", err)
+		}
+	}
+	return files
+}
+
+// read returns the content of a context file, reading it again only when it
+// changed since the last turn. Callers hold b.mu.
+func (b *promptBuilder) read(path string) (contextFile, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		delete(b.files, path)
+		return contextFile{}, false
+	}
+	if file, ok := b.files[path]; ok && file.modTime.Equal(info.ModTime()) && file.size == info.Size() {
+		return file, true
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("Failed to read context file", "path", path, "error", err)
+		delete(b.files, path)
+		return contextFile{}, false
+	}
+	file := contextFile{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		content: strings.TrimSpace(string(content)),
+	}
+	b.files[path] = file
+	return file, true
+}
+
+func (b *promptBuilder) displayPath(path string) string {
+	if rel, err := filepath.Rel(b.workingDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// environment describes where the agent runs.
+func (b *promptBuilder) environment(ctx context.Context) string {
+	var env strings.Builder
+	env.WriteString("<env>\n")
+	fmt.Fprintf(&env, "Working directory: %s\n", b.workingDir)
+	fmt.Fprintf(&env, "Platform: %s\n", runtime.GOOS)
+	fmt.Fprintf(&env, "Today's date: %s\n", b.now().Format("2006-01-02"))
+	if status, ok := gitStatus(ctx, b.workingDir); ok {
+		env.WriteString("Is git repository: yes\n")
+		fmt.Fprintf(&env, "Git status at the start of the session:\n%s\n", status)
+	} else {
+		env.WriteString("Is git repository: no\n")
+	}
+	env.WriteString("</env>")
+	return env.String()
+}
+
+// gitStatus returns the short status of the repository dir is in, or false
+// when it is not in one or git is not available.
+func gitStatus(ctx context.Context, dir string) (string, bool) {
+	ctx, cancel := context.WithTimeout(ctx, gitStatusTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "status", "--short", "--branch").Output()
+	if err != nil {
+		return "", false
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	if len(lines) > maxGitStatusLines {
+		more := len(lines) - maxGitStatusLines
+		lines = append(lines[:maxGitStatusLines], fmt.Sprintf("... and %d more", more))
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// estimateTokens approximates the token count of text at four bytes per
+// token, which is close enough for English text and code.
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+func truncateToTokens(text string, tokens int) string {
+	if limit := tokens * 4; len(text) > limit {
+		text = strings.ToValidUTF8(text[:limit], "")
+	}
+	return text
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"gentica/message"
+
+	"github.com/google/uuid"
+)
+
+// ErrPromptNotQueued is returned when a queued prompt has already been sent
+// or removed.
+var ErrPromptNotQueued = errors.New("prompt is not queued")
+
+// QueuedPrompt is a prompt that waits for the session's running request. It
+// is sent as a user message once the model is ready for it.
+type QueuedPrompt struct {
+	ID          string
+	Content     string
+	Attachments []message.Attachment
+}
+
+// queuePrompt adds a prompt to the session's queue and returns it with its
+// position, 1 being the next to be sent.
+func (a *agent) queuePrompt(sessionID, content string, attachments []message.Attachment) (QueuedPrompt, int) {
+	prompt := QueuedPrompt{
+		ID:          uuid.New().String(),
+		Content:     content,
+		Attachments: attachments,
+	}
+	a.queueMutex.Lock()
+	defer a.queueMutex.Unlock()
+	a.promptQueue[sessionID] = append(a.promptQueue[sessionID], prompt)
+	return prompt, len(a.promptQueue[sessionID])
+}
+
+func (a *agent) ListQueuedPrompts(sessionID string) []QueuedPrompt {
+	a.queueMutex.RLock()
+	defer a.queueMutex.RUnlock()
+	return slices.Clone(a.promptQueue[sessionID])
+}
+
+// UpdateQueuedPrompt replaces the content and attachments of the queued
+// prompt with the same ID.
+func (a *agent) UpdateQueuedPrompt(sessionID string, prompt QueuedPrompt) error {
+	if !a.model.SupportsImages {
+		prompt.Attachments = nil
+	}
+	a.queueMutex.Lock()
+	defer a.queueMutex.Unlock()
+	i, err := a.queuedPromptIndex(sessionID, prompt.ID)
+	if err != nil {
+		return err
+	}
+	a.promptQueue[sessionID][i] = prompt
+	return nil
+}
+
+// MoveQueuedPrompt moves a queued prompt to position, 1 being the next to
+// be sent. Positions past the end move it to the end.
+func (a *agent) MoveQueuedPrompt(sessionID, id string, position int) error {
+	if position < 1 {
+		return fmt.Errorf("invalid queue position %d", position)
+	}
+	a.queueMutex.Lock()
+	defer a.queueMutex.Unlock()
+	i, err := a.queuedPromptIndex(sessionID, id)
+	if err != nil {
+		return err
+	}
+	queue := a.promptQueue[sessionID]
+	prompt := queue[i]
+	queue = slices.Delete(queue, i, i+1)
+	queue = slices.Insert(queue, min(position-1, len(queue)), prompt)
+	a.promptQueue[sessionID] = queue
+	return nil
+}
+
+func (a *agent) RemoveQueuedPrompt(sessionID, id string) error {
+	a.queueMutex.Lock()
+	defer a.queueMutex.Unlock()
+	i, err := a.queuedPromptIndex(sessionID, id)
+	if err != nil {
+		return err
+	}
+	a.promptQueue[sessionID] = slices.Delete(a.promptQueue[sessionID], i, i+1)
+	return nil
+}
+
+// queuedPromptIndex finds a prompt in the session's queue. Callers hold
+// queueMutex.
+func (a *agent) queuedPromptIndex(sessionID, id string) (int, error) {
+	i := slices.IndexFunc(a.promptQueue[sessionID], func(prompt QueuedPrompt) bool {
+		return prompt.ID == id
+	})
+	if i == -1 {
+		return -1, fmt.Errorf("%w: %s", ErrPromptNotQueued, id)
+	}
+	return i, nil
+}
+
+// drainQueue takes the queued prompts of the session and stores them as
+// user messages, in queue order.
+func (a *agent) drainQueue(ctx context.Context, sessionID string) ([]message.Message, error) {
+	a.queueMutex.Lock()
+	queuedPrompts := a.promptQueue[sessionID]
+	delete(a.promptQueue, sessionID)
+	a.queueMutex.Unlock()
+
+	var msgs []message.Message
+	for _, prompt := range queuedPrompts {
+		if prompt.Content == "" && len(prompt.Attachments) == 0 {
+			continue
+		}
+		userMsg, err := a.createUserMessage(ctx, sessionID, prompt.Content, attachmentParts(prompt.Attachments))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create user message for queued prompt: %w", err)
+		}
+		msgs = append(msgs, userMsg)
+	}
+	return msgs, nil
+}
+
+func attachmentParts(attachments []message.Attachment) []message.ContentPart {
+	var parts []message.ContentPart
+	for _, attachment := range attachments {
+		parts = append(parts, message.BinaryContent{Path: attachment.FilePath, MIMEType: attachment.MimeType, Data: attachment.Content})
+	}
+	return parts
+}
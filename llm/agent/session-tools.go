@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"gentica/pubsub"
+	"gentica/session"
+
+	"gentica/llm/tools"
+)
+
+// sessionTools returns the tools that keep processes running for sessions.
+func (a *agent) sessionTools() []tools.SessionTool {
+	var result []tools.SessionTool
+	for _, tool := range a.config.Tools {
+		if sessionTool, ok := tool.(tools.SessionTool); ok {
+			result = append(result, sessionTool)
+		}
+	}
+	return result
+}
+
+// cancelSessionTools stops the processes the tools run in the background for
+// the session.
+func (a *agent) cancelSessionTools(sessionID string) {
+	for _, tool := range a.sessionTools() {
+		tool.CancelSession(sessionID)
+	}
+}
+
+// closeDeletedSessions stops the processes the tools keep for sessions once
+// they are deleted, and drops the environment captured for their prompt. It
+// returns when the session service shuts down.
+func (a *agent) closeDeletedSessions(events <-chan pubsub.Event[session.Session]) {
+	for event := range events {
+		if event.Type != pubsub.DeletedEvent {
+			continue
+		}
+		a.prompt.forget(event.Payload.ID)
+		for _, tool := range a.sessionTools() {
+			tool.CloseSession(event.Payload.ID)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gentica/message"
+)
+
+// ErrSessionNotBusy is returned when steering a session that has no
+// running request.
+var ErrSessionNotBusy = errors.New("session is not busy")
+
+// steering is what the user asked of a running session.
+type steering struct {
+	// notes are interjections waiting for the next safe point.
+	notes []string
+	// pause is set from Pause until Resume, and paused while the run is
+	// held. resume is closed by Resume.
+	pause  bool
+	paused bool
+	resume chan struct{}
+}
+
+// Interject delivers a note to the running session at the next safe point:
+// before its next tool call, or when the model's response ends. Tool calls
+// of the turn that have not started by then are skipped, and the run goes
+// on with the note in context.
+func (a *agent) Interject(sessionID, content string) error {
+	a.steerMutex.Lock()
+	defer a.steerMutex.Unlock()
+	s, ok := a.steering[sessionID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotBusy, sessionID)
+	}
+	s.notes = append(s.notes, content)
+	return nil
+}
+
+// Pause holds the running session once its current tool call is done, or
+// before its next turn, until Resume is called.
+func (a *agent) Pause(sessionID string) error {
+	a.steerMutex.Lock()
+	defer a.steerMutex.Unlock()
+	s, ok := a.steering[sessionID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotBusy, sessionID)
+	}
+	if !s.pause {
+		s.pause = true
+		s.resume = make(chan struct{})
+	}
+	return nil
+}
+
+// Resume lets a paused session go on. It also withdraws a pause that has
+// not taken effect yet.
+func (a *agent) Resume(sessionID string) error {
+	a.steerMutex.Lock()
+	defer a.steerMutex.Unlock()
+	s, ok := a.steering[sessionID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotBusy, sessionID)
+	}
+	if s.pause {
+		s.pause, s.paused = false, false
+		close(s.resume)
+	}
+	return nil
+}
+
+// SessionState returns the state of the session's run.
+func (a *agent) SessionState(sessionID string) AgentState {
+	if !a.IsSessionBusy(sessionID) {
+		return AgentStateIdle
+	}
+	a.steerMutex.Lock()
+	defer a.steerMutex.Unlock()
+	s, ok := a.steering[sessionID]
+	switch {
+	case !ok:
+		return AgentStateProcessing
+	case s.paused:
+		return AgentStatePaused
+	case s.pause:
+		return AgentStatePausing
+	case len(s.notes) > 0:
+		return AgentStateInterjecting
+	}
+	return AgentStateProcessing
+}
+
+// startSteering lets the user steer the session's run until finishSteering.
+func (a *agent) startSteering(sessionID string) {
+	a.steerMutex.Lock()
+	defer a.steerMutex.Unlock()
+	a.steering[sessionID] = &steering{}
+}
+
+// finishSteering ends steering when the session's run is over. Notes that
+// were not delivered are queued, to be sent with the next prompt.
+func (a *agent) finishSteering(sessionID string) {
+	a.steerMutex.Lock()
+	s := a.steering[sessionID]
+	delete(a.steering, sessionID)
+	a.steerMutex.Unlock()
+	if s == nil {
+		return
+	}
+	for _, note := range s.notes {
+		a.queuePrompt(sessionID, note, nil)
+	}
+}
+
+// dropNotes discards the notes of a canceled run.
+func (a *agent) dropNotes(sessionID string) {
+	a.steerMutex.Lock()
+	defer a.steerMutex.Unlock()
+	if s, ok := a.steering[sessionID]; ok {
+		s.notes = nil
+	}
+}
+
+func (a *agent) hasNotes(sessionID string) bool {
+	a.steerMutex.Lock()
+	defer a.steerMutex.Unlock()
+	s, ok := a.steering[sessionID]
+	return ok && len(s.notes) > 0
+}
+
+// deliverNotes stores the session's notes as user messages.
+func (a *agent) deliverNotes(ctx context.Context, sessionID string) ([]message.Message, error) {
+	a.steerMutex.Lock()
+	var notes []string
+	if s, ok := a.steering[sessionID]; ok {
+		notes, s.notes = s.notes, nil
+	}
+	a.steerMutex.Unlock()
+
+	var msgs []message.Message
+	for _, note := range notes {
+		msg, err := a.createUserMessage(ctx, sessionID, note, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create user message for interjection: %w", err)
+		}
+		a.emit(AgentEvent{Type: AgentEventTypeInterjected, SessionID: sessionID, Message: msg})
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// holdIfPaused waits while the session is paused. It returns ctx's error
+// when the run is canceled meanwhile.
+func (a *agent) holdIfPaused(ctx context.Context, sessionID string) error {
+	a.steerMutex.Lock()
+	s, ok := a.steering[sessionID]
+	if !ok || !s.pause {
+		a.steerMutex.Unlock()
+		return nil
+	}
+	s.paused = true
+	resume := s.resume
+	a.steerMutex.Unlock()
+
+	a.emit(AgentEvent{Type: AgentEventTypePaused, SessionID: sessionID})
+	select {
+	case <-resume:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	a.emit(AgentEvent{Type: AgentEventTypeResumed, SessionID: sessionID})
+	return nil
+}
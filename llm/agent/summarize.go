@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"gentica/message"
+	"gentica/session"
+)
+
+// autoSummarizeThreshold is the share of the context window a session may
+// fill before it is summarized automatically.
+const autoSummarizeThreshold = 0.8
+
+const summaryPrompt = `Provide a detailed but concise summary of our conversation above, so that it can replace the conversation as context for continuing the work.
+Focus on what we did, what we are doing at the moment, which files we are working on and what has to happen next.
+Include the user's original request, decisions that were made and anything the user asked for that is not done yet.
+Answer with the summary only.`
+
+// ErrSummaryUnavailable is returned by Summarize when the agent has no
+// summary provider.
+var ErrSummaryUnavailable = errors.New("no summary provider configured")
+
+func (a *agent) Summarize(ctx context.Context, sessionID string) error {
+	if a.config.SummaryProvider == nil {
+		return ErrSummaryUnavailable
+	}
+	if a.IsSessionBusy(sessionID) {
+		return fmt.Errorf("session %s is busy", sessionID)
+	}
+
+	summaryCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	a.requestMutex.Lock()
+	a.activeRequests[sessionID] = cancel
+	a.requestMutex.Unlock()
+	defer func() {
+		a.requestMutex.Lock()
+		delete(a.activeRequests, sessionID)
+		a.requestMutex.Unlock()
+	}()
+
+	msgs, err := a.history(summaryCtx, sessionID)
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return errors.New("no messages to summarize")
+	}
+	_, err = a.summarize(summaryCtx, sessionID, msgs)
+	return err
+}
+
+// shouldSummarize reports whether the last response of the session used
+// enough of the context window to summarize before sending the next request.
+func (a *agent) shouldSummarize(ctx context.Context, sessionID string) bool {
+	if a.config.SummaryProvider == nil || a.config.DisableAutoSummarize || a.model.ContextWindow <= 0 {
+		return false
+	}
+	sess, err := a.sessions.Get(ctx, sessionID)
+	if err != nil {
+		slog.Error("Failed to get session for summarization", "session_id", sessionID, "error", err)
+		return false
+	}
+	last, ok, err := a.ledger.LastMessageUsage(ctx, sessionID)
+	if err != nil {
+		slog.Error("Failed to get usage for summarization", "session_id", sessionID, "error", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	used := last.ContextTokens()
+	if last.MessageID == sess.SummaryMessageID {
+		// The summary is all that is left of the context, so the next
+		// request starts from its size rather than from the summarized
+		// history.
+		used = last.OutputTokens
+	}
+	return float64(used) >= float64(a.model.ContextWindow)*autoSummarizeThreshold
+}
+
+// summarize asks the summary provider to condense msgs, stores the result
+// as the session's summary message and returns it ready to start a new
+// history from.
+func (a *agent) summarize(ctx context.Context, sessionID string, msgs []message.Message) (message.Message, error) {
+	prompt := message.Message{
+		Role:      message.User,
+		SessionID: sessionID,
+		Parts:     []message.ContentPart{message.TextContent{Text: summaryPrompt}},
+	}
+	model := a.config.SummaryProvider.Model()
+
+	var response *ProviderResponse
+	for event := range a.config.SummaryProvider.StreamResponse(ctx, append(slices.Clone(msgs), prompt), nil) {
+		switch event.Type {
+		case EventError:
+			return message.Message{}, fmt.Errorf("failed to summarize session: %w", event.Error)
+		case EventComplete:
+			response = event.Response
+		}
+	}
+	if ctx.Err() != nil {
+		return message.Message{}, ctx.Err()
+	}
+	if response == nil || strings.TrimSpace(response.Content) == "" {
+		return message.Message{}, errors.New("failed to summarize session: empty summary")
+	}
+
+	summaryMsg, err := a.messages.Create(ctx, sessionID, message.CreateMessageParams{
+		Role:     message.Assistant,
+		Parts:    []message.ContentPart{message.TextContent{Text: strings.TrimSpace(response.Content)}},
+		Model:    model.ID,
+		Provider: model.Name,
+	})
+	if err != nil {
+		return message.Message{}, fmt.Errorf("failed to create summary message: %w", err)
+	}
+	a.finishMessage(ctx, &summaryMsg, message.FinishReasonEndTurn, "", "")
+
+	if err := a.TrackUsage(ctx, summaryMsg, model, response.Usage); err != nil {
+		return message.Message{}, err
+	}
+	err = a.updateSession(ctx, sessionID, func(sess *session.Session) {
+		sess.SummaryMessageID = summaryMsg.ID
+	})
+	if err != nil {
+		return message.Message{}, err
+	}
+
+	slog.Info("Session summarized", "session_id", sessionID, "summary_message_id", summaryMsg.ID)
+	a.emit(AgentEvent{
+		Type:      AgentEventTypeSummarize,
+		SessionID: sessionID,
+		Message:   summaryMsg,
+		Done:      true,
+	})
+
+	summaryMsg.Role = message.User
+	return summaryMsg, nil
+}
+
+// history returns the messages of the session that are sent to the model,
+// starting at the summary message if the session has one.
+func (a *agent) history(ctx context.Context, sessionID string) ([]message.Message, error) {
+	msgs, err := a.messages.List(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	sess, err := a.sessions.Get(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return summarizedHistory(sess, msgs), nil
+}
+
+func summarizedHistory(sess session.Session, msgs []message.Message) []message.Message {
+	if sess.SummaryMessageID == "" {
+		return msgs
+	}
+	summaryMsgIndex := slices.IndexFunc(msgs, func(msg message.Message) bool {
+		return msg.ID == sess.SummaryMessageID
+	})
+	if summaryMsgIndex == -1 {
+		return msgs
+	}
+	msgs = msgs[summaryMsgIndex:]
+	msgs[0].Role = message.User
+	return msgs
+}
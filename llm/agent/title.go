@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"gentica/message"
+	"gentica/session"
+)
+
+const titlePrompt = `Generate a short title for a conversation that starts with the message below.
+The title must be a single line of at most 50 characters that summarizes what the user wants.
+Answer with the title only, without quotes or punctuation at the end.
+
+Message:
+`
+
+// titleTimeout bounds how long a title may take, since nobody waits for it.
+const titleTimeout = time.Minute
+
+const maxTitleLength = 100
+
+var thinkTags = regexp.MustCompile(`(?s)<think>.*?</think>`)
+
+// generateTitle asks the title provider to name the session after its first
+// prompt. It runs in the background, so failures are only logged.
+func (a *agent) generateTitle(ctx context.Context, sessionID, content string) {
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, titleTimeout)
+	defer cancel()
+
+	prompt := message.Message{
+		Role:      message.User,
+		SessionID: sessionID,
+		Parts:     []message.ContentPart{message.TextContent{Text: titlePrompt + content}},
+	}
+	var response *ProviderResponse
+	for event := range a.config.TitleProvider.StreamResponse(ctx, []message.Message{prompt}, nil) {
+		switch event.Type {
+		case EventError:
+			slog.Error("Failed to generate title", "session_id", sessionID, "error", event.Error)
+			return
+		case EventComplete:
+			response = event.Response
+		}
+	}
+	if response == nil {
+		return
+	}
+
+	title := cleanTitle(response.Content)
+	model := a.config.TitleProvider.Model()
+	// The title is generated for this session, so it pays for it.
+	if err := a.recordUsage(ctx, sessionID, "", model.Name, model, response.Usage); err != nil {
+		slog.Error("Failed to record title usage", "session_id", sessionID, "error", err)
+	}
+	if title == "" {
+		return
+	}
+	err := a.updateSession(ctx, sessionID, func(sess *session.Session) {
+		sess.Title = title
+	})
+	if err != nil {
+		slog.Error("Failed to save title", "session_id", sessionID, "error", err)
+	}
+}
+
+func cleanTitle(title string) string {
+	title = thinkTags.ReplaceAllString(title, "")
+	title = strings.Join(strings.Fields(title), " ")
+	title = strings.Trim(title, `"'`+"`")
+	if len(title) > maxTitleLength {
+		title = strings.ToValidUTF8(title[:maxTitleLength], "")
+		title = strings.TrimSpace(title) + "…"
+	}
+	return title
+}
@@ -0,0 +1,193 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"gentica/llm/tools"
+)
+
+type Event string
+
+const (
+	// PreToolUse runs before a tool call. Hooks can deny the call or
+	// rewrite its input.
+	PreToolUse Event = "pre_tool_use"
+	// PostToolUse runs after a tool call, with its response. Hooks can
+	// turn the response into an error.
+	PostToolUse Event = "post_tool_use"
+	// PreTurn runs before every request to the model. Hooks can stop the
+	// run.
+	PreTurn Event = "pre_turn"
+	// PostTurn runs after every response of the model that ends with tool
+	// calls or the end of its answer. Context added by hooks is sent to the
+	// model as a new prompt after tool calls. After the end of the answer it
+	// is only sent, continuing the run, when a hook asks to continue.
+	PostTurn Event = "post_turn"
+	// SessionStart runs before the first prompt of a session. Hooks can
+	// refuse the prompt.
+	SessionStart Event = "session_start"
+)
+
+// Events are the events hooks run on.
+var Events = []Event{PreToolUse, PostToolUse, PreTurn, PostTurn, SessionStart}
+
+// DefaultTimeout is how long a command may take unless its hook says
+// otherwise.
+const DefaultTimeout = time.Minute
+
+// DenyExitCode is the exit code with which a command denies what it was
+// asked about, giving the reason on stderr.
+const DenyExitCode = 2
+
+// Input tells a hook what is happening. Commands read it as JSON on stdin.
+type Input struct {
+	Event     Event  `json:"event"`
+	SessionID string `json:"session_id"`
+	// ToolCall is set for tool events and ToolResponse after tool calls.
+	ToolCall     *tools.ToolCall     `json:"tool_call,omitempty"`
+	ToolResponse *tools.ToolResponse `json:"tool_response,omitempty"`
+	// Prompt is set for session starts.
+	Prompt string `json:"prompt,omitempty"`
+	// Content is the text of the response, after turns.
+	Content string `json:"content,omitempty"`
+}
+
+// Output is what a hook decided. Commands print it as JSON on stdout; any
+// other output is taken as Context.
+type Output struct {
+	// Deny vetoes the tool call, tool response, turn or prompt, and Reason
+	// tells the model why. It has no effect after turns.
+	Deny   bool   `json:"deny,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Input replaces the input of the tool call, before tool calls.
+	Input json.RawMessage `json:"input,omitempty"`
+	// Context is added for the model to see.
+	Context string `json:"context,omitempty"`
+	// Continue sends the context to the model after the end of its answer,
+	// which continues the run. Only post_turn hooks use it, and only from
+	// JSON output, so that a command that merely prints something cannot
+	// keep the model running.
+	Continue bool `json:"continue,omitempty"`
+}
+
+// Func runs a hook.
+type Func func(ctx context.Context, input Input) (Output, error)
+
+// Hook is a check of the user's that runs around what the agent does.
+type Hook struct {
+	Event Event
+	// Tools limits a tool hook to the named tools. An empty list matches
+	// every tool.
+	Tools []string
+	Run   Func
+}
+
+// Command returns a Func that runs command with sh in dir. Input is written
+// to its stdin. It exits with DenyExitCode to deny, any other non-zero exit
+// code is a failure. A zero timeout means DefaultTimeout.
+func Command(command, dir string, timeout time.Duration) Func {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return func(ctx context.Context, input Input) (Output, error) {
+		stdin, err := json.Marshal(input)
+		if err != nil {
+			return Output{}, err
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Dir = dir
+		cmd.Stdin = bytes.NewReader(stdin)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		// Do not wait for children that keep the output open after the
+		// command was killed.
+		cmd.WaitDelay = time.Second
+
+		err = cmd.Run()
+		if ctx.Err() == context.DeadlineExceeded {
+			return Output{}, fmt.Errorf("timed out after %s", timeout)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == DenyExitCode {
+			return Output{Deny: true, Reason: strings.TrimSpace(stderr.String())}, nil
+		}
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return Output{}, fmt.Errorf("%w: %s", err, msg)
+			}
+			return Output{}, err
+		}
+
+		out := bytes.TrimSpace(stdout.Bytes())
+		var output Output
+		if len(out) == 0 {
+			return output, nil
+		}
+		if out[0] != '{' || json.Unmarshal(out, &output) != nil {
+			return Output{Context: string(out)}, nil
+		}
+		return output, nil
+	}
+}
+
+// Hooks runs hooks in the order they were configured.
+type Hooks []Hook
+
+// Run runs the hooks of the input's event. Hooks run one after the other
+// until one denies or fails: each sees the tool call as rewritten by the
+// ones before it, and their context is joined. The result's Input is the
+// final input of the tool call when a hook rewrote it.
+func (h Hooks) Run(ctx context.Context, input Input) (Output, error) {
+	var (
+		result   Output
+		contexts []string
+	)
+	for _, hook := range h {
+		if !hook.matches(input) {
+			continue
+		}
+		output, err := hook.Run(ctx, input)
+		if err != nil {
+			return Output{}, fmt.Errorf("%s hook failed: %w", input.Event, err)
+		}
+		if output.Context != "" {
+			contexts = append(contexts, output.Context)
+		}
+		result.Continue = result.Continue || output.Continue
+		if output.Deny {
+			result.Deny = true
+			result.Reason = output.Reason
+			break
+		}
+		if len(output.Input) > 0 && input.ToolCall != nil {
+			call := *input.ToolCall
+			call.Input = string(output.Input)
+			input.ToolCall = &call
+			result.Input = output.Input
+		}
+	}
+	result.Context = strings.Join(contexts, "\n")
+	return result, nil
+}
+
+func (h Hook) matches(input Input) bool {
+	if h.Event != input.Event {
+		return false
+	}
+	if input.ToolCall == nil || len(h.Tools) == 0 {
+		return true
+	}
+	return slices.Contains(h.Tools, input.ToolCall.Name)
+}
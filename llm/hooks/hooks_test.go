@@ -0,0 +1,100 @@
+package hooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gentica/llm/hooks"
+	"gentica/llm/tools"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommand(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	input := hooks.Input{
+		Event:     hooks.PreToolUse,
+		SessionID: "session",
+		ToolCall:  &tools.ToolCall{ID: "call_1", Name: "bash", Input: `{"command":"ls"}`},
+	}
+	run := func(command string) (hooks.Output, error) {
+		return hooks.Command(command, t.TempDir(), 5*time.Second)(ctx, input)
+	}
+
+	t.Run("reads the input on stdin", func(t *testing.T) {
+		output, err := run(`grep -q '"name":"bash"' && echo '{"context":"checked"}'`)
+		require.NoError(t, err)
+		require.Equal(t, hooks.Output{Context: "checked"}, output)
+	})
+
+	t.Run("takes other output as context", func(t *testing.T) {
+		output, err := run("echo formatted")
+		require.NoError(t, err)
+		require.Equal(t, hooks.Output{Context: "formatted"}, output)
+	})
+
+	t.Run("continues only when asked in JSON", func(t *testing.T) {
+		output, err := run(`echo '{"context":"fix a.go","continue":true}'`)
+		require.NoError(t, err)
+		require.Equal(t, hooks.Output{Context: "fix a.go", Continue: true}, output)
+
+		output, err = run(`echo '{"continue":true} a.go'`)
+		require.NoError(t, err)
+		require.Equal(t, hooks.Output{Context: `{"continue":true} a.go`}, output)
+	})
+
+	t.Run("rewrites the input", func(t *testing.T) {
+		output, err := run(`echo '{"input":{"command":"ls -la"}}'`)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"command":"ls -la"}`, string(output.Input))
+	})
+
+	t.Run("denies with the deny exit code", func(t *testing.T) {
+		output, err := run("echo 'no listing' >&2; exit 2")
+		require.NoError(t, err)
+		require.Equal(t, hooks.Output{Deny: true, Reason: "no listing"}, output)
+	})
+
+	t.Run("fails with other exit codes", func(t *testing.T) {
+		_, err := run("echo 'lint failed' >&2; exit 1")
+		require.ErrorContains(t, err, "lint failed")
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		_, err := hooks.Command("sleep 5", t.TempDir(), 100*time.Millisecond)(ctx, input)
+		require.ErrorContains(t, err, "timed out")
+	})
+}
+
+func TestHooksRun(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var seen []string
+	record := func(output hooks.Output) hooks.Func {
+		return func(ctx context.Context, input hooks.Input) (hooks.Output, error) {
+			seen = append(seen, input.ToolCall.Input)
+			return output, nil
+		}
+	}
+	h := hooks.Hooks{
+		{Event: hooks.PreToolUse, Tools: []string{"edit"}, Run: record(hooks.Output{Deny: true})},
+		{Event: hooks.PostToolUse, Run: record(hooks.Output{Deny: true})},
+		{Event: hooks.PreToolUse, Run: record(hooks.Output{Input: json.RawMessage(`{"command":"ls -la"}`), Context: "first"})},
+		{Event: hooks.PreToolUse, Tools: []string{"bash"}, Run: record(hooks.Output{Context: "second"})},
+	}
+
+	output, err := h.Run(ctx, hooks.Input{
+		Event:    hooks.PreToolUse,
+		ToolCall: &tools.ToolCall{Name: "bash", Input: `{"command":"ls"}`},
+	})
+	require.NoError(t, err)
+	require.False(t, output.Deny)
+	require.JSONEq(t, `{"command":"ls -la"}`, string(output.Input))
+	require.Equal(t, "first\nsecond", output.Context)
+	require.Equal(t, []string{`{"command":"ls"}`, `{"command":"ls -la"}`}, seen)
+}
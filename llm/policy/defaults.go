@@ -0,0 +1,117 @@
+package policy
+
+import "runtime"
+
+// safeCommands are read-only commands, which run without asking. The
+// options with which some of them change the system, such as date -s and
+// git diff --output, are asked about, see changers.
+var safeCommands = []string{
+	// Bash builtins and core utils
+	":",
+	"[",
+	"cal",
+	"cd",
+	"date",
+	"df",
+	"du",
+	"echo",
+	"env",
+	"false",
+	"free",
+	"groups",
+	"hostname",
+	"id",
+	"ls",
+	"nice",
+	"nohup",
+	"printenv",
+	"ps",
+	"pwd",
+	"set",
+	"test",
+	"time",
+	"timeout",
+	"top",
+	"true",
+	"type",
+	"uname",
+	"unset",
+	"uptime",
+	"whatis",
+	"whereis",
+	"which",
+	"whoami",
+
+	// Git. Rules match prefixes, so commands that also change the
+	// repository, such as git branch, are only allowed in forms that git
+	// does not combine with changes.
+	"git blame",
+	"git branch --list",
+	"git branch --show-current",
+	"git config --get",
+	"git config --list",
+	"git describe",
+	"git diff",
+	"git grep",
+	"git log",
+	"git ls-files",
+	"git ls-remote",
+	"git remote get-url",
+	"git remote show",
+	"git rev-parse",
+	"git shortlog",
+	"git show",
+	"git status",
+	"git tag --list",
+	"git tag -l",
+}
+
+// bannedCommands are commands that never run: network tools, privilege
+// escalation, and commands that damage the system.
+var bannedCommands = []string{
+	// Network tools
+	"curl", "wget", "nc", "netcat", "telnet", "ssh", "scp", "sftp", "ftp", "rsync", "nmap",
+	// Privilege escalation
+	"sudo", "su", "doas",
+	// Permission and ownership changes
+	"chmod", "chown", "chgrp",
+	// Dangerous destructive commands
+	"rm -rf /", "rm -rf /*", "dd if=/dev/zero", "dd if=/dev/random",
+	// System control
+	"shutdown", "reboot", "halt", "poweroff", "init",
+	// Process control (dangerous variants)
+	"kill -9 -1", "pkill -9", "killall -9",
+	// Disk and filesystem operations
+	"mkfs", "fdisk", "parted", "format",
+	// Package managers (global installs)
+	"apt install", "yum install", "brew install", "pip install --system",
+	"npm install -g", "gem install",
+}
+
+// DefaultRules are the rules policies start with: safeCommands are allowed
+// and bannedCommands denied.
+func DefaultRules() []Rule {
+	var rules []Rule
+	for _, command := range safeCommands {
+		rules = append(rules, Rule{Action: Allow, Command: command})
+	}
+	for _, command := range bannedCommands {
+		rules = append(rules, Rule{Action: Deny, Command: command})
+	}
+	return rules
+}
+
+func init() {
+	if runtime.GOOS == "windows" {
+		safeCommands = append(
+			safeCommands,
+			// Windows-specific commands
+			"ipconfig",
+			"nslookup",
+			"ping",
+			"systeminfo",
+			"tasklist",
+			"where",
+		)
+	}
+}
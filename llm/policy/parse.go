@@ -0,0 +1,745 @@
+package policy
+
+import (
+	"errors"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Command is a simple command of a shell script.
+type Command struct {
+	// Args are the words of the command with quotes and escapes removed.
+	// Words with expansions keep their source text.
+	Args []string
+	// literal tells for each word whether it is known before the script
+	// runs, i.e. has no expansions.
+	literal []bool
+	// Outputs are the files the command's output is redirected to, except
+	// for duplicated file descriptors. A command may only have outputs,
+	// e.g. "> file".
+	Outputs []string
+}
+
+// Name returns the name of the command without its directory, or "" for
+// commands that only redirect.
+func (c Command) Name() string {
+	if len(c.Args) == 0 {
+		return ""
+	}
+	return path.Base(c.Args[0])
+}
+
+// Dynamic reports whether the name of the command is only known when the
+// script runs, e.g. "$(which curl)".
+func (c Command) Dynamic() bool {
+	return len(c.literal) > 0 && !c.literal[0]
+}
+
+func (c Command) String() string {
+	return strings.Join(c.Args, " ")
+}
+
+// shift returns the command without its first n words.
+func (c Command) shift(n int) Command {
+	return Command{Args: c.Args[n:], literal: c.literal[n:]}
+}
+
+// Parse returns the simple commands of script, including the ones in
+// pipelines, lists, compound commands, subshells and command and process
+// substitutions. Commands of substitutions come before the command they are
+// part of, as the shell runs them first.
+func Parse(script string) ([]Command, error) {
+	p := &parser{src: script}
+	if err := p.parseScript(false); err != nil {
+		return nil, err
+	}
+	return p.cmds, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	// literal is set for words without expansions, quoted for words with
+	// quotes and assign for assignments.
+	literal bool
+	quoted  bool
+	assign  bool
+}
+
+type heredoc struct {
+	delim     string
+	expand    bool
+	stripTabs bool
+}
+
+type parser struct {
+	src  string
+	pos  int
+	cmds []Command
+	// heredocs are the here-documents whose bodies start at the next
+	// newline.
+	heredocs []heredoc
+}
+
+// parseState tells how the parser takes the words of compound commands
+// that are not commands.
+type parseState int
+
+const (
+	stateCommand parseState = iota
+	stateCaseWord
+	stateCaseIn
+	stateCasePattern
+	stateFor
+	stateFuncName
+	stateCond
+	stateArray
+)
+
+// reservedWords are the reserved words after which a command may follow.
+var reservedWords = []string{"if", "then", "else", "elif", "fi", "do", "done", "while", "until", "!", "{", "}", "time", "esac", "coproc"}
+
+// operators are the control and redirection operators, longest first.
+var operators = []string{
+	";;&", "&>>", "<<<", "<<-",
+	";;", ";&", "&&", "||", "|&", "&>", "<<", ">>", ">&", "<&", "<>", ">|", "((",
+	";", "&", "|", "(", ")", "<", ">", "\n",
+}
+
+func isRedirect(op string) bool {
+	return strings.ContainsAny(op, "<>") && op != "<(" && op != ">("
+}
+
+// isOutput reports whether the redirection op with the target word writes
+// to a file. >& and <& with a file descriptor or - duplicate or close it.
+func isOutput(op, target string) bool {
+	switch op {
+	case ">", ">>", ">|", "&>", "&>>", "<>":
+		return true
+	case ">&":
+		return target != "-" && strings.Trim(target, "0123456789") != ""
+	}
+	return false
+}
+
+// parseScript parses commands up to the end of the script or, when nested,
+// up to the ) that closes a command substitution.
+func (p *parser) parseScript(nested bool) error {
+	var (
+		cmd      Command
+		state    = stateCommand
+		redirect string
+		heredocs bool
+		stripTab bool
+		funcDef  bool
+		array    bool
+		depth    int
+	)
+	finish := func() {
+		if len(cmd.Args) > 0 || len(cmd.Outputs) > 0 {
+			p.cmds = append(p.cmds, cmd)
+		}
+		cmd = Command{}
+	}
+
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+
+		switch tok.kind {
+		case tokenEOF:
+			if nested {
+				return errors.New("unterminated command substitution")
+			}
+			finish()
+			return nil
+
+		case tokenWord:
+			array = false
+			if redirect != "" {
+				if heredocs {
+					p.heredocs = append(p.heredocs, heredoc{delim: tok.val, expand: !tok.quoted, stripTabs: stripTab})
+				}
+				if isOutput(redirect, tok.val) {
+					cmd.Outputs = append(cmd.Outputs, tok.val)
+				}
+				redirect = ""
+				continue
+			}
+			switch state {
+			case stateCaseWord:
+				state = stateCaseIn
+				continue
+			case stateCaseIn:
+				if tok.val == "in" {
+					state = stateCasePattern
+				}
+				continue
+			case stateCasePattern:
+				if tok.val == "esac" {
+					state = stateCommand
+				}
+				continue
+			case stateFor:
+				if tok.val == "do" {
+					state = stateCommand
+				}
+				continue
+			case stateFuncName:
+				state = stateCommand
+				continue
+			case stateCond:
+				if tok.val == "]]" {
+					state = stateCommand
+				}
+				continue
+			case stateArray:
+				continue
+			}
+
+			if len(cmd.Args) == 0 && tok.literal {
+				switch {
+				case tok.val == "case":
+					state = stateCaseWord
+					continue
+				case tok.val == "for" || tok.val == "select":
+					state = stateFor
+					continue
+				case tok.val == "function":
+					state = stateFuncName
+					continue
+				case tok.val == "[[":
+					state = stateCond
+					continue
+				case tok.assign:
+					// An array assignment continues with the elements in
+					// parentheses.
+					array = strings.HasSuffix(tok.val, "=")
+					continue
+				case slices.Contains(reservedWords, tok.val):
+					continue
+				}
+			}
+			cmd.Args = append(cmd.Args, tok.val)
+			cmd.literal = append(cmd.literal, tok.literal)
+
+		case tokenOp:
+			if array && tok.val == "(" {
+				array = false
+				state = stateArray
+				continue
+			}
+			array = false
+			switch state {
+			case stateArray:
+				if tok.val == ")" {
+					state = stateCommand
+				}
+				continue
+			case stateFor, stateCond:
+				// The words of for loops and conditions are no commands,
+				// whatever operators they contain.
+				continue
+			case stateCasePattern:
+				if tok.val == ")" {
+					state = stateCommand
+				}
+				continue
+			}
+
+			switch {
+			case tok.val == "((":
+				if len(cmd.Args) > 0 {
+					return errors.New("unexpected ((")
+				}
+				if err := p.arithmetic(); err != nil {
+					return err
+				}
+			case isRedirect(tok.val):
+				redirect = tok.val
+				heredocs = tok.val == "<<" || tok.val == "<<-"
+				stripTab = tok.val == "<<-"
+			case tok.val == "(":
+				if len(cmd.Args) == 1 {
+					// A function definition: name() body.
+					cmd = Command{}
+					funcDef = true
+					continue
+				}
+				finish()
+				depth++
+			case tok.val == ")":
+				finish()
+				switch {
+				case funcDef:
+					funcDef = false
+				case depth > 0:
+					depth--
+				case nested:
+					return nil
+				default:
+					return errors.New("unexpected )")
+				}
+			case tok.val == ";;" || tok.val == ";&" || tok.val == ";;&":
+				finish()
+				state = stateCasePattern
+			default:
+				finish()
+			}
+		}
+	}
+}
+
+// next returns the next token.
+func (p *parser) next() (token, error) {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "\\\n"):
+			p.pos += 2
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return p.token()
+		}
+	}
+	return token{kind: tokenEOF}, nil
+}
+
+func (p *parser) token() (token, error) {
+	rest := p.src[p.pos:]
+	if strings.HasPrefix(rest, "<(") || strings.HasPrefix(rest, ">(") {
+		return p.word()
+	}
+	// A redirection may start with the file descriptor it redirects.
+	digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+	for _, op := range operators {
+		if !strings.HasPrefix(rest[digits:], op) || (digits > 0 && !isRedirect(op)) {
+			continue
+		}
+		p.pos += digits + len(op)
+		if op == "\n" {
+			if err := p.readHeredocs(); err != nil {
+				return token{}, err
+			}
+		}
+		return token{kind: tokenOp, val: op}, nil
+	}
+	return p.word()
+}
+
+// word reads a word, running the parser over the commands it substitutes.
+func (p *parser) word() (token, error) {
+	tok := token{kind: tokenWord, literal: true}
+	var val strings.Builder
+	start := p.pos
+	special := false
+
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case strings.ContainsRune(" \t\n;&|", rune(c)):
+			return p.endWord(tok, val.String(), start, special), nil
+		case c == '<' || c == '>':
+			if p.pos+1 < len(p.src) && p.src[p.pos+1] == '(' {
+				// Process substitution.
+				p.pos += 2
+				if err := p.parseScript(true); err != nil {
+					return token{}, err
+				}
+				val.WriteString(p.src[start:p.pos])
+				tok.literal = false
+				continue
+			}
+			return p.endWord(tok, val.String(), start, special), nil
+		case c == '(' || c == ')':
+			return p.endWord(tok, val.String(), start, special), nil
+		case c == '\\':
+			if p.pos+1 < len(p.src) {
+				if p.src[p.pos+1] != '\n' {
+					val.WriteByte(p.src[p.pos+1])
+				}
+				p.pos += 2
+			} else {
+				p.pos++
+			}
+			tok.quoted = true
+		case c == '\'':
+			end := strings.IndexByte(p.src[p.pos+1:], '\'')
+			if end < 0 {
+				return token{}, errors.New("unterminated single quote")
+			}
+			val.WriteString(p.src[p.pos+1 : p.pos+1+end])
+			p.pos += end + 2
+			tok.quoted = true
+		case c == '"':
+			p.pos++
+			if err := p.doubleQuoted(&val, &tok); err != nil {
+				return token{}, err
+			}
+			tok.quoted = true
+		case c == '$':
+			if err := p.dollar(&val, &tok); err != nil {
+				return token{}, err
+			}
+		case c == '`':
+			if err := p.backtick(&val); err != nil {
+				return token{}, err
+			}
+			tok.literal = false
+		default:
+			if strings.ContainsRune("*?[{}", rune(c)) {
+				special = true
+			}
+			val.WriteByte(c)
+			p.pos++
+		}
+	}
+	return p.endWord(tok, val.String(), start, special), nil
+}
+
+func (p *parser) endWord(tok token, val string, start int, special bool) token {
+	tok.val = val
+	// Globs and brace expansions make the word unknown before the script
+	// runs, unlike the brackets of tests and the braces of groups.
+	if special && !slices.Contains([]string{"{", "}", "[", "[[", "]]"}, val) {
+		tok.literal = false
+	}
+	raw := p.src[start:p.pos]
+	if eq := strings.IndexByte(raw, '='); eq > 0 {
+		name := strings.TrimSuffix(raw[:eq], "+")
+		tok.assign = isName(name)
+	}
+	return tok
+}
+
+func isName(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// doubleQuoted reads the rest of a double-quoted string.
+func (p *parser) doubleQuoted(val *strings.Builder, tok *token) error {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; c {
+		case '"':
+			p.pos++
+			return nil
+		case '\\':
+			if p.pos+1 < len(p.src) && strings.ContainsRune("$`\"\\\n", rune(p.src[p.pos+1])) {
+				if p.src[p.pos+1] != '\n' {
+					val.WriteByte(p.src[p.pos+1])
+				}
+				p.pos += 2
+				continue
+			}
+			val.WriteByte(c)
+			p.pos++
+		case '$':
+			if err := p.dollar(val, tok); err != nil {
+				return err
+			}
+		case '`':
+			if err := p.backtick(val); err != nil {
+				return err
+			}
+			tok.literal = false
+		default:
+			val.WriteByte(c)
+			p.pos++
+		}
+	}
+	return errors.New("unterminated double quote")
+}
+
+// dollar reads an expansion starting with $.
+func (p *parser) dollar(val *strings.Builder, tok *token) error {
+	start := p.pos
+	rest := p.src[p.pos+1:]
+	switch {
+	case strings.HasPrefix(rest, "(("):
+		p.pos += 3
+		if err := p.arithmetic(); err != nil {
+			return err
+		}
+	case strings.HasPrefix(rest, "("):
+		p.pos += 2
+		if err := p.parseScript(true); err != nil {
+			return err
+		}
+	case strings.HasPrefix(rest, "{"):
+		p.pos += 2
+		if err := p.parameter(); err != nil {
+			return err
+		}
+	case strings.HasPrefix(rest, "'"):
+		p.pos += 2
+		return p.ansiQuoted(val, tok)
+	case strings.HasPrefix(rest, "\""):
+		// Locale-specific translation, taken as a plain double quote.
+		p.pos += 2
+		tok.quoted = true
+		return p.doubleQuoted(val, tok)
+	case rest != "" && (isName(rest[:1]) || strings.ContainsRune("0123456789@*#?$!-", rune(rest[0]))):
+		p.pos += 2
+		if isName(rest[:1]) {
+			for p.pos < len(p.src) && isName("_"+p.src[p.pos:p.pos+1]) {
+				p.pos++
+			}
+		}
+	default:
+		// A lone dollar sign.
+		val.WriteByte('$')
+		p.pos++
+		return nil
+	}
+	val.WriteString(p.src[start:p.pos])
+	tok.literal = false
+	return nil
+}
+
+// arithmetic reads an arithmetic expression up to the )) that closes it,
+// parsing the commands substituted in it: the shell expands them before it
+// evaluates the expression.
+func (p *parser) arithmetic() error {
+	var discard strings.Builder
+	var tok token
+	depth := 2
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '(':
+			depth++
+			p.pos++
+		case ')':
+			depth--
+			p.pos++
+			if depth == 0 {
+				return nil
+			}
+		case '\\':
+			p.pos += 2
+		case '\'':
+			end := strings.IndexByte(p.src[p.pos+1:], '\'')
+			if end < 0 {
+				return errors.New("unterminated single quote")
+			}
+			p.pos += end + 2
+		case '"':
+			p.pos++
+			if err := p.doubleQuoted(&discard, &tok); err != nil {
+				return err
+			}
+		case '$':
+			if err := p.dollar(&discard, &tok); err != nil {
+				return err
+			}
+		case '`':
+			if err := p.backtick(&discard); err != nil {
+				return err
+			}
+		default:
+			p.pos++
+		}
+	}
+	return errors.New("unterminated arithmetic expression")
+}
+
+// parameter reads a parameter expansion up to the } that closes it.
+func (p *parser) parameter() error {
+	var discard strings.Builder
+	var tok token
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; c {
+		case '}':
+			p.pos++
+			return nil
+		case '\\':
+			p.pos += 2
+		case '\'':
+			end := strings.IndexByte(p.src[p.pos+1:], '\'')
+			if end < 0 {
+				return errors.New("unterminated single quote")
+			}
+			p.pos += end + 2
+		case '"':
+			p.pos++
+			if err := p.doubleQuoted(&discard, &tok); err != nil {
+				return err
+			}
+		case '$':
+			if err := p.dollar(&discard, &tok); err != nil {
+				return err
+			}
+		case '`':
+			if err := p.backtick(&discard); err != nil {
+				return err
+			}
+		default:
+			p.pos++
+		}
+	}
+	return errors.New("unterminated parameter expansion")
+}
+
+// ansiQuoted reads the rest of a $'...' string, decoding its escapes.
+func (p *parser) ansiQuoted(val *strings.Builder, tok *token) error {
+	tok.quoted = true
+	escapes := map[byte]byte{'a': '\a', 'b': '\b', 'e': 0x1b, 'E': 0x1b, 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v'}
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\'':
+			p.pos++
+			return nil
+		case c == '\\' && p.pos+1 < len(p.src):
+			e := p.src[p.pos+1]
+			p.pos += 2
+			if r, ok := escapes[e]; ok {
+				val.WriteByte(r)
+				continue
+			}
+			var digits string
+			base := 8
+			switch {
+			case e == 'x':
+				digits, base = p.digits("0123456789abcdefABCDEF", 2), 16
+			case e >= '0' && e <= '7':
+				p.pos--
+				digits = p.digits("01234567", 3)
+			default:
+				val.WriteByte(e)
+				continue
+			}
+			n, err := strconv.ParseUint(digits, base, 8)
+			if err != nil {
+				return errors.New("invalid escape in $'...'")
+			}
+			val.WriteByte(byte(n))
+		default:
+			val.WriteByte(c)
+			p.pos++
+		}
+	}
+	return errors.New("unterminated $'...'")
+}
+
+func (p *parser) digits(set string, max int) string {
+	start := p.pos
+	for p.pos < len(p.src) && p.pos-start < max && strings.IndexByte(set, p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// backtick reads a `...` command substitution and parses its commands.
+func (p *parser) backtick(val *strings.Builder) error {
+	start := p.pos
+	p.pos++
+	var script strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '`':
+			p.pos++
+			val.WriteString(p.src[start:p.pos])
+			return p.parseNested(script.String())
+		case c == '\\' && p.pos+1 < len(p.src) && strings.ContainsRune("$`\\", rune(p.src[p.pos+1])):
+			script.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+		default:
+			script.WriteByte(c)
+			p.pos++
+		}
+	}
+	return errors.New("unterminated backquote")
+}
+
+func (p *parser) parseNested(script string) error {
+	cmds, err := Parse(script)
+	if err != nil {
+		return err
+	}
+	p.cmds = append(p.cmds, cmds...)
+	return nil
+}
+
+// readHeredocs reads the bodies of the pending here-documents, parsing the
+// commands substituted in the ones with an unquoted delimiter.
+func (p *parser) readHeredocs() error {
+	for _, doc := range p.heredocs {
+		var body strings.Builder
+		for {
+			if p.pos >= len(p.src) {
+				return errors.New("unterminated here-document")
+			}
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				end = len(p.src) - p.pos
+			}
+			line := p.src[p.pos : p.pos+end]
+			p.pos = min(p.pos+end+1, len(p.src))
+			if doc.stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == doc.delim {
+				break
+			}
+			body.WriteString(line + "\n")
+		}
+		if doc.expand {
+			if err := p.expansions(body.String()); err != nil {
+				return err
+			}
+		}
+	}
+	p.heredocs = nil
+	return nil
+}
+
+// expansions parses the commands substituted in text that is not split
+// into words.
+func (p *parser) expansions(text string) error {
+	q := &parser{src: text}
+	var discard strings.Builder
+	var tok token
+	for q.pos < len(q.src) {
+		switch q.src[q.pos] {
+		case '\\':
+			q.pos += 2
+		case '$':
+			if err := q.dollar(&discard, &tok); err != nil {
+				return err
+			}
+		case '`':
+			if err := q.backtick(&discard); err != nil {
+				return err
+			}
+		default:
+			q.pos++
+		}
+	}
+	p.cmds = append(p.cmds, q.cmds...)
+	return nil
+}
@@ -0,0 +1,70 @@
+package policy_test
+
+import (
+	"testing"
+
+	"gentica/llm/policy"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		script string
+		want   [][]string
+	}{
+		{"pipelines and lists", `git log --format=%H | head -1 && echo "done"; ls &`, [][]string{{"git", "log", "--format=%H"}, {"head", "-1"}, {"echo", "done"}, {"ls"}}},
+		{"quotes and escapes", `c\url 'a b' "c $HOME" $'\x75'`, [][]string{{"curl", "a b", "c $HOME", "u"}}},
+		{"substitutions first", "echo $(which curl) `date` <(nc host 1)", [][]string{{"which", "curl"}, {"date"}, {"nc", "host", "1"}, {"echo", "$(which curl)", "`date`", "<(nc host 1)"}}},
+		{"nested substitutions", `echo "$(cat "$(ls)")"`, [][]string{{"ls"}, {"cat", "$(ls)"}, {"echo", `$(cat "$(ls)")`}}},
+		{"subshells and groups", "(cd pkg; make) && { go test; }", [][]string{{"cd", "pkg"}, {"make"}, {"go", "test"}}},
+		{"redirections", "sort < in > out 2>&1 &>> log", [][]string{{"sort"}}},
+		{"assignments", "FOO=bar BAZ=(a b) env X=1 curl", [][]string{{"env", "X=1", "curl"}}},
+		{"compound commands", "if [ -f x ]; then cat x; elif true; then :; fi; while read l; do echo $l; done", [][]string{{"[", "-f", "x", "]"}, {"cat", "x"}, {"true"}, {":"}, {"read", "l"}, {"echo", "$l"}}},
+		{"loops and conditions", "for f in *.go; do gofmt $f; done; (( i++ )); [[ -n $x && -f y ]] || rm y", [][]string{{"gofmt", "$f"}, {"rm", "y"}}},
+		{"arithmetic", "echo $(( $(id -u) + 1 )); (( x = `whoami` )); (( ${y:-$(pwd)} > \"$(ls)\" ))", [][]string{{"id", "-u"}, {"echo", "$(( $(id -u) + 1 ))"}, {"whoami"}, {"pwd"}, {"ls"}}},
+		{"case", "case $x in a|b) rm a;; *) ls;; esac", [][]string{{"rm", "a"}, {"ls"}}},
+		{"functions", "f() { ls; }; function g { pwd; }; f", [][]string{{"ls"}, {"pwd"}, {"f"}}},
+		{"here-documents", "cat <<EOF\n$(whoami)\nEOF\ncat <<'EOF'\n$(id)\nEOF\nls", [][]string{{"whoami"}, {"cat"}, {"cat"}, {"ls"}}},
+		{"comments", "echo a # curl\n# wget", [][]string{{"echo", "a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds, err := policy.Parse(tt.script)
+			require.NoError(t, err)
+			var got [][]string
+			for _, cmd := range cmds {
+				got = append(got, cmd.Args)
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("records outputs", func(t *testing.T) {
+		cmds, err := policy.Parse("sort < in > out 2>&1 &>> log >&2 >& both; > empty")
+		require.NoError(t, err)
+		require.Len(t, cmds, 2)
+		require.Equal(t, []string{"out", "log", "both"}, cmds[0].Outputs)
+		require.Equal(t, policy.Command{Outputs: []string{"empty"}}, cmds[1])
+	})
+
+	t.Run("marks computed command names", func(t *testing.T) {
+		cmds, err := policy.Parse(`$(which curl) x; "$CMD"; {cu,}rl; /usr/bin/cur?; "ls"`)
+		require.NoError(t, err)
+		var dynamic []bool
+		for _, cmd := range cmds {
+			dynamic = append(dynamic, cmd.Dynamic())
+		}
+		require.Equal(t, []bool{false, true, true, true, true, false}, dynamic)
+	})
+
+	t.Run("fails on incomplete scripts", func(t *testing.T) {
+		for _, script := range []string{`echo 'a`, `echo "a`, "echo $(ls", "echo `ls", "cat <<EOF\na", "ls )"} {
+			_, err := policy.Parse(script)
+			require.Error(t, err, script)
+		}
+	})
+}
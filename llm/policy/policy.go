@@ -0,0 +1,366 @@
+package policy
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type Action string
+
+const (
+	// Allow runs the command without asking.
+	Allow Action = "allow"
+	// Ask runs the command once the user allowed it.
+	Ask Action = "ask"
+	// Deny refuses to run the command.
+	Deny Action = "deny"
+)
+
+// severity orders actions from the most to the least permissive.
+var severity = map[Action]int{Allow: 0, Ask: 1, Deny: 2}
+
+// Rule decides about the commands that start with Command: the command's
+// name followed by arguments, e.g. "git push". A name with a directory only
+// matches commands run by that path, any other name matches the command run
+// from any directory.
+type Rule struct {
+	Action  Action
+	Command string
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("%s %q", r.Action, r.Command)
+}
+
+// match returns the number of words of the rule when cmd starts with them,
+// and 0 otherwise.
+func (r Rule) match(cmd Command) int {
+	words := strings.Fields(r.Command)
+	if len(words) == 0 || len(words) > len(cmd.Args) {
+		return 0
+	}
+	name := cmd.Name()
+	if strings.Contains(words[0], "/") {
+		name = cmd.Args[0]
+	}
+	if words[0] != name || !slices.Equal(words[1:], cmd.Args[1:len(words)]) {
+		return 0
+	}
+	return len(words)
+}
+
+// Decision is what a policy decided about a script.
+type Decision struct {
+	Action Action
+	// Command is the command of the script that decided, and Rule the rule
+	// it matched, if any.
+	Command string
+	Rule    *Rule
+	// Reason tells why the command got the action.
+	Reason string
+}
+
+// Policy decides which shell commands run.
+type Policy struct {
+	rules []Rule
+}
+
+// New returns a policy with rules. The rule matching most words of a
+// command decides about it, the later one of equally long rules. Commands
+// that no rule matches are asked about.
+func New(rules ...Rule) *Policy {
+	return &Policy{rules: rules}
+}
+
+// Default returns the policy of DefaultRules.
+func Default() *Policy {
+	return New(DefaultRules()...)
+}
+
+// Check decides about a script from the decisions about each of its
+// commands: it is denied when any command is, asked about when any is, and
+// allowed otherwise. The decision is the one of the first command with the
+// strictest action.
+func (p *Policy) Check(script string) (Decision, error) {
+	cmds, err := Parse(script)
+	if err != nil {
+		return Decision{}, err
+	}
+	decision := Decision{Action: Allow, Reason: "every command is allowed"}
+	for len(cmds) > 0 {
+		cmd := cmds[0]
+		cmds = cmds[1:]
+		d := p.decide(cmd)
+		if severity[d.Action] > severity[decision.Action] {
+			decision = d
+		}
+		inner, err := unwrap(cmd)
+		if err != nil {
+			return Decision{}, err
+		}
+		cmds = append(inner, cmds...)
+	}
+	return decision, nil
+}
+
+// decide decides about a command from its rule, and asks about commands
+// that would be allowed but write to files or change the system.
+func (p *Policy) decide(cmd Command) Decision {
+	decision := p.decideRule(cmd)
+	if decision.Action != Allow {
+		return decision
+	}
+	if changes, ok := changers[cmd.Name()]; ok {
+		if reason := changes(cmd); reason != "" {
+			return Decision{Action: Ask, Command: cmd.String(), Reason: reason}
+		}
+	}
+	for _, output := range cmd.Outputs {
+		if !slices.Contains(harmlessOutputs, output) {
+			return Decision{
+				Action:  Ask,
+				Command: strings.TrimSpace(cmd.String() + " > " + output),
+				Reason:  fmt.Sprintf("it writes to %s", output),
+			}
+		}
+	}
+	return decision
+}
+
+// harmlessOutputs are the files output may be redirected to without asking.
+var harmlessOutputs = []string{"/dev/null", "/dev/stdout", "/dev/stderr"}
+
+// changers tell why allowed commands with options that change the system,
+// which rules cannot tell apart by their prefix, do so. They return "" for
+// the forms that change nothing.
+var changers = map[string]func(Command) string{
+	"date": dateChanges,
+	"git":  gitChanges,
+}
+
+// gitChanges finds the --output option of the commands that show changes,
+// which writes to a file. Git takes unambiguous abbreviations of options.
+func gitChanges(cmd Command) string {
+	for i := 1; i < len(cmd.Args); i++ {
+		arg := cmd.Args[i]
+		name, _, _ := strings.Cut(arg, "=")
+		switch {
+		case arg == "--":
+			return ""
+		case !cmd.literal[i]:
+			return fmt.Sprintf("%s may be an option that writes to a file", arg)
+		case strings.HasPrefix(name, "--o") && strings.HasPrefix("--output", name):
+			return "its --output option writes to a file"
+		}
+	}
+	return ""
+}
+
+// dateValueOptions are the options of date that take a value.
+var dateValueOptions = []string{"-d", "--date", "-f", "--file", "-r", "--reference"}
+
+// dateChanges finds the -s option and the positional time with which date
+// sets the system time. Formats start with + and change nothing.
+func dateChanges(cmd Command) string {
+	for i := 1; i < len(cmd.Args); i++ {
+		arg := cmd.Args[i]
+		name, _, _ := strings.Cut(arg, "=")
+		switch {
+		case !cmd.literal[i]:
+			return fmt.Sprintf("%s may set the time", arg)
+		case slices.Contains(dateValueOptions, arg):
+			i++
+		case strings.HasPrefix(name, "--s") && strings.HasPrefix("--set", name):
+			return "it sets the time"
+		case arg == "--" || strings.HasPrefix(arg, "--") || strings.HasPrefix(arg, "+"):
+			continue
+		case len(arg) > 1 && arg[0] == '-':
+			// Short options, the last of which may take the next word.
+			for j, c := range arg[1:] {
+				if c == 's' {
+					return "it sets the time"
+				}
+				if strings.ContainsRune("dfrI", c) {
+					if j == len(arg)-2 && c != 'I' {
+						i++
+					}
+					break
+				}
+			}
+		default:
+			return fmt.Sprintf("it sets the time to %s", arg)
+		}
+	}
+	return ""
+}
+
+func (p *Policy) decideRule(cmd Command) Decision {
+	decision := Decision{Action: Ask, Command: cmd.String()}
+	if len(cmd.Args) == 0 {
+		decision.Action = Allow
+		decision.Reason = "it only redirects"
+		return decision
+	}
+	if cmd.Dynamic() {
+		decision.Reason = "its name is only known when it runs"
+		return decision
+	}
+	longest := 0
+	for i, rule := range p.rules {
+		if n := rule.match(cmd); n > 0 && n >= longest {
+			longest = n
+			decision.Rule = &p.rules[i]
+		}
+	}
+	if decision.Rule == nil {
+		decision.Reason = "no rule matches it"
+		return decision
+	}
+	decision.Action = decision.Rule.Action
+	decision.Reason = fmt.Sprintf("it matches the rule %s", decision.Rule)
+	return decision
+}
+
+// wrappers are commands that run the command in their arguments, with the
+// options that take a value.
+var wrappers = map[string][]string{
+	"builtin": nil,
+	"command": nil,
+	"env":     {"-u", "--unset", "-C", "--chdir"},
+	"exec":    {"-a"},
+	"ionice":  {"-c", "-n", "-p"},
+	"nice":    {"-n", "--adjustment"},
+	"nohup":   nil,
+	"stdbuf":  {"-i", "-o", "-e"},
+	"time":    {"-f", "-o"},
+	"timeout": {"-k", "--kill-after", "-s", "--signal"},
+	"xargs":   {"-a", "-d", "-E", "-I", "-L", "-n", "-P", "-s", "--arg-file", "--delimiter", "--max-args", "--max-procs", "--replace"},
+}
+
+// shells run the script given with -c.
+var shells = []string{"sh", "bash", "dash", "zsh", "ksh"}
+
+// unwrap returns the commands that cmd runs: the command a wrapper runs,
+// and the commands of scripts given to shells and eval.
+func unwrap(cmd Command) ([]Command, error) {
+	name := cmd.Name()
+	switch {
+	case name == "eval":
+		args := cmd.shift(1)
+		if len(args.Args) == 0 {
+			return nil, nil
+		}
+		if slices.Contains(args.literal, false) {
+			return []Command{args}, nil
+		}
+		return Parse(args.String())
+	case slices.Contains(shells, name):
+		for i := 1; i+1 < len(cmd.Args); i++ {
+			arg := cmd.Args[i]
+			if !strings.HasPrefix(arg, "-") || arg == "--" {
+				break
+			}
+			if strings.HasPrefix(arg, "--") || !strings.Contains(arg, "c") {
+				continue
+			}
+			if !cmd.literal[i+1] {
+				return []Command{cmd.shift(i + 1)}, nil
+			}
+			return Parse(cmd.Args[i+1])
+		}
+		return nil, nil
+	}
+
+	valueOptions, ok := wrappers[name]
+	if !ok {
+		return nil, nil
+	}
+	i := 1
+	for ; i < len(cmd.Args); i++ {
+		arg := cmd.Args[i]
+		switch {
+		case arg == "--":
+			i++
+		case name == "env" && isSplitString(arg):
+			return splitString(cmd, i)
+		case strings.HasPrefix(arg, "-"):
+			if slices.Contains(valueOptions, arg) {
+				i++
+			}
+			continue
+		case name == "env" && strings.Contains(arg, "="):
+			continue
+		case name == "timeout":
+			// The duration.
+			i++
+		}
+		break
+	}
+	if i >= len(cmd.Args) {
+		return nil, nil
+	}
+	return []Command{cmd.shift(i)}, nil
+}
+
+// isSplitString reports whether arg is the -S option of env, alone, with
+// other short options or with its value.
+func isSplitString(arg string) bool {
+	if arg == "--split-string" || strings.HasPrefix(arg, "--split-string=") {
+		return true
+	}
+	if len(arg) < 2 || arg[0] != '-' || arg[1] == '-' {
+		return false
+	}
+	for _, c := range arg[1:] {
+		switch c {
+		case 'S':
+			return true
+		case 'u', 'C':
+			// The rest is the value of -u or -C.
+			return false
+		}
+	}
+	return false
+}
+
+// splitString returns the commands of env -S, whose value env splits into
+// the command it runs, followed by the arguments after it. The value is
+// parsed as a script, which finds every command it could run.
+func splitString(cmd Command, i int) ([]Command, error) {
+	arg := cmd.Args[i]
+	var value string
+	literal := cmd.literal[i]
+	rest := i + 1
+	switch {
+	case strings.HasPrefix(arg, "--split-string="):
+		value = strings.TrimPrefix(arg, "--split-string=")
+	case arg != "--split-string" && !strings.HasSuffix(arg, "S"):
+		value = arg[strings.Index(arg, "S")+1:]
+	case rest < len(cmd.Args):
+		value, literal = cmd.Args[rest], cmd.literal[rest]
+		rest++
+	}
+	args := cmd.shift(rest)
+	if !literal {
+		return []Command{{
+			Args:    append([]string{value}, args.Args...),
+			literal: append([]bool{false}, args.literal...),
+		}}, nil
+	}
+	cmds, err := Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(cmds) == 0 {
+		// An empty value leaves the command to the arguments after it.
+		return unwrap(Command{
+			Args:    append([]string{"env"}, args.Args...),
+			literal: append([]bool{true}, args.literal...),
+		})
+	}
+	last := &cmds[len(cmds)-1]
+	last.Args = append(last.Args, args.Args...)
+	last.literal = append(last.literal, args.literal...)
+	return cmds, nil
+}
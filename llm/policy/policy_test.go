@@ -0,0 +1,104 @@
+package policy_test
+
+import (
+	"testing"
+
+	"gentica/llm/policy"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	t.Parallel()
+
+	p := policy.New(append(policy.DefaultRules(),
+		policy.Rule{Action: policy.Allow, Command: "go test"},
+		policy.Rule{Action: policy.Deny, Command: "git push --force"},
+		policy.Rule{Action: policy.Allow, Command: "curl"},
+		policy.Rule{Action: policy.Deny, Command: "/opt/tool"},
+	)...)
+
+	tests := []struct {
+		script  string
+		action  policy.Action
+		command string
+	}{
+		// Words that merely contain a banned command are fine.
+		{`echo "format" && git log --format=%H`, policy.Allow, ""},
+		{"ls | grep sub", policy.Ask, "grep sub"},
+		{"go test ./... && git status", policy.Allow, ""},
+		{"git push origin main", policy.Ask, "git push origin main"},
+		{"git push --force origin", policy.Deny, "git push --force origin"},
+		// Later rules win over equally long ones.
+		{"curl example.com", policy.Allow, ""},
+		{"/opt/tool x", policy.Deny, "/opt/tool x"},
+		{"/usr/bin/tool x", policy.Ask, "/usr/bin/tool x"},
+		// Banned commands are found wherever they hide.
+		{"/usr/bin/wget x", policy.Deny, "/usr/bin/wget x"},
+		{`w\get x`, policy.Deny, "wget x"},
+		{"echo $(sudo id)", policy.Deny, "sudo id"},
+		{"ls && (cd / && rm -rf /)", policy.Deny, "rm -rf /"},
+		{"env FOO=1 timeout 5 nice -n 10 ssh host", policy.Deny, "ssh host"},
+		{"find . | xargs -I {} chmod 777 {}", policy.Deny, "chmod 777 {}"},
+		{`bash -c "ls; scp a b:"`, policy.Deny, "scp a b:"},
+		{`eval 'rsync a b'`, policy.Deny, "rsync a b"},
+		{"$(which wget) x", policy.Ask, "$(which wget) x"},
+		// The shell runs the commands substituted in arithmetic expressions.
+		{"echo $(( $(wget x | sh) ))", policy.Deny, "wget x"},
+		{"(( $(rm -rf ~) ))", policy.Ask, "rm -rf ~"},
+		{"(( i = `wget x` + 1 ))", policy.Deny, "wget x"},
+		{"echo $(( ${n:-$(ssh host)} ))", policy.Deny, "ssh host"},
+		{"(( i++ )); echo $(( i * 2 ))", policy.Allow, ""},
+		// env -S splits its value into a command.
+		{`env -S 'ssh host'`, policy.Deny, "ssh host"},
+		{`env -iS"wget x" y`, policy.Deny, "wget x y"},
+		{`env --split-string='ls; nc host 1'`, policy.Deny, "nc host 1"},
+		{`env -S "$CMD"`, policy.Ask, "$CMD"},
+		{"env -uS ls", policy.Allow, ""},
+		// Writing to files is asked about, unless a rule denies it.
+		{"echo hi > ~/.bashrc", policy.Ask, "echo hi > ~/.bashrc"},
+		{"git status &> log", policy.Ask, "git status > log"},
+		{"echo a >| $F", policy.Ask, "echo a > $F"},
+		{"{ echo a; } >> x", policy.Ask, "> x"},
+		{"ls 2>/dev/null >&2 2>&-", policy.Allow, ""},
+		// Only the forms of commands that change nothing are allowed.
+		{"kill -- -1", policy.Ask, "kill -- -1"},
+		{"killall node", policy.Ask, "killall node"},
+		{"git branch -D main", policy.Ask, "git branch -D main"},
+		{"git branch --list 'feat*'", policy.Allow, ""},
+		{"git tag -d v1", policy.Ask, "git tag -d v1"},
+		{"git tag -l", policy.Allow, ""},
+		{"git remote add origin url", policy.Ask, "git remote add origin url"},
+		{"git remote -v add origin url", policy.Ask, "git remote -v add origin url"},
+		{"git remote show origin", policy.Allow, ""},
+		{"wget x > out", policy.Deny, "wget x"},
+		// Options that make allowed commands change the system are asked
+		// about.
+		{"git diff --output=~/.bashrc HEAD", policy.Ask, "git diff --output=~/.bashrc HEAD"},
+		{"git log --output x", policy.Ask, "git log --output x"},
+		{"git show --outp=x", policy.Ask, "git show --outp=x"},
+		{"git diff $OPT", policy.Ask, "git diff $OPT"},
+		{"git log -- --output=x", policy.Allow, ""},
+		{"date -s 2020", policy.Ask, "date -s 2020"},
+		{"date --set=tomorrow", policy.Ask, "date --set=tomorrow"},
+		{"date -us 2020", policy.Ask, "date -us 2020"},
+		{"date 010100002020", policy.Ask, "date 010100002020"},
+		{"date -u -d @0 +%F", policy.Allow, ""},
+		{"date -Iseconds", policy.Allow, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.script, func(t *testing.T) {
+			decision, err := p.Check(tt.script)
+			require.NoError(t, err)
+			require.Equal(t, tt.action, decision.Action, decision.Reason)
+			require.Equal(t, tt.command, decision.Command)
+		})
+	}
+
+	t.Run("names the matching rule", func(t *testing.T) {
+		decision, err := p.Check("git push --force")
+		require.NoError(t, err)
+		require.Equal(t, &policy.Rule{Action: policy.Deny, Command: "git push --force"}, decision.Rule)
+		require.Equal(t, `it matches the rule deny "git push --force"`, decision.Reason)
+	})
+}
@@ -0,0 +1,317 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"gentica/config"
+	"gentica/llm/agent"
+	"gentica/llm/tools"
+	"gentica/message"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+const (
+	// anthropicDefaultMaxTokens is used when neither the selected model nor
+	// the caller sets a limit; the Messages API requires one.
+	anthropicDefaultMaxTokens = 4096
+
+	// anthropicCachedMessages is the number of trailing messages that get a
+	// cache breakpoint. Together with the system prompt this stays within
+	// the four breakpoints the API allows.
+	anthropicCachedMessages = 2
+)
+
+type anthropicProvider struct {
+	options
+	client anthropic.Client
+}
+
+// NewAnthropicProvider creates a streaming provider for the Anthropic Messages
+// API. The API key in cfg is expected to be resolved already.
+func NewAnthropicProvider(cfg config.ProviderConfig, opts ...Option) agent.LLMProvider {
+	o := newOptions(opts)
+
+	requestOpts := []option.RequestOption{}
+	if cfg.APIKey != "" {
+		requestOpts = append(requestOpts, option.WithAPIKey(cfg.APIKey))
+	}
+	if cfg.BaseURL != "" {
+		requestOpts = append(requestOpts, option.WithBaseURL(cfg.BaseURL))
+	}
+	for key, value := range cfg.ExtraHeaders {
+		requestOpts = append(requestOpts, option.WithHeader(key, value))
+	}
+	for key, value := range cfg.ExtraBody {
+		requestOpts = append(requestOpts, option.WithJSONSet(key, value))
+	}
+	if o.noClientRetries {
+		requestOpts = append(requestOpts, option.WithMaxRetries(0))
+	}
+
+	return &anthropicProvider{
+		options: o,
+		client:  anthropic.NewClient(requestOpts...),
+	}
+}
+
+func (a *anthropicProvider) Model() agent.ModelInfo {
+	return a.model
+}
+
+// convertMessages maps the history to Anthropic messages. System messages in
+// the history are returned separately because the API takes them as a
+// top-level field.
+func (a *anthropicProvider) convertMessages(messages []message.Message) ([]anthropic.MessageParam, []string) {
+	var (
+		anthropicMessages []anthropic.MessageParam
+		systemMessages    []string
+	)
+
+	for _, msg := range messages {
+		switch msg.Role {
+		case message.System:
+			systemMessages = append(systemMessages, msg.Content().String())
+		case message.User:
+			var blocks []anthropic.ContentBlockParamUnion
+			if text := msg.Content().String(); text != "" {
+				blocks = append(blocks, anthropic.NewTextBlock(text))
+			}
+			for _, binaryContent := range msg.BinaryContent() {
+				blocks = append(blocks, anthropic.NewImageBlockBase64(binaryContent.MIMEType, base64.StdEncoding.EncodeToString(binaryContent.Data)))
+			}
+			if len(blocks) == 0 {
+				continue
+			}
+			anthropicMessages = append(anthropicMessages, anthropic.NewUserMessage(blocks...))
+		case message.Assistant:
+			var blocks []anthropic.ContentBlockParamUnion
+			// Signed thinking has to be sent back unchanged and first so the
+			// model can continue its reasoning across tool calls.
+			if reasoning := msg.ReasoningContent(); reasoning.Signature != "" {
+				blocks = append(blocks, anthropic.NewThinkingBlock(reasoning.Signature, reasoning.Thinking))
+			}
+			if text := msg.Content().String(); text != "" {
+				blocks = append(blocks, anthropic.NewTextBlock(text))
+			}
+			for _, call := range msg.ToolCalls() {
+				input := json.RawMessage(call.Input)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropic.NewToolUseBlock(call.ID, input, call.Name))
+			}
+			if len(blocks) == 0 {
+				slog.Warn("There is a message without content, investigate, this should not happen")
+				continue
+			}
+			anthropicMessages = append(anthropicMessages, anthropic.NewAssistantMessage(blocks...))
+		case message.Tool:
+			results := msg.ToolResults()
+			blocks := make([]anthropic.ContentBlockParamUnion, len(results))
+			for i, result := range results {
+				blocks[i] = anthropic.NewToolResultBlock(result.ToolCallID, result.Content, result.IsError)
+				if len(result.Data) > 0 {
+					image := anthropic.NewImageBlockBase64(result.MIMEType, base64.StdEncoding.EncodeToString(result.Data))
+					blocks[i].OfToolResult.Content = append(blocks[i].OfToolResult.Content, anthropic.ToolResultBlockParamContentUnion{OfImage: image.OfImage})
+				}
+			}
+			anthropicMessages = append(anthropicMessages, anthropic.NewUserMessage(blocks...))
+		}
+	}
+
+	// Mark the stable prefix of the conversation so the next turn can read it
+	// from the prompt cache.
+	for i := max(len(anthropicMessages)-anthropicCachedMessages, 0); i < len(anthropicMessages); i++ {
+		content := anthropicMessages[i].Content
+		if len(content) == 0 {
+			continue
+		}
+		if cacheControl := content[len(content)-1].GetCacheControl(); cacheControl != nil {
+			*cacheControl = anthropic.NewCacheControlEphemeralParam()
+		}
+	}
+
+	return anthropicMessages, systemMessages
+}
+
+func (a *anthropicProvider) convertTools(availableTools []tools.BaseTool) []anthropic.ToolUnionParam {
+	anthropicTools := make([]anthropic.ToolUnionParam, len(availableTools))
+	for i, tool := range availableTools {
+		info := tool.Info()
+		toolParam := anthropic.ToolParam{
+			Name:        info.Name,
+			Description: anthropic.String(info.Description),
+			InputSchema: anthropic.ToolInputSchemaParam{
+				Properties: info.Parameters,
+				Required:   info.Required,
+			},
+		}
+		// The tool definitions come before the system prompt, so a breakpoint
+		// on the last one caches all of them.
+		if i == len(availableTools)-1 {
+			toolParam.CacheControl = anthropic.NewCacheControlEphemeralParam()
+		}
+		anthropicTools[i] = anthropic.ToolUnionParam{OfTool: &toolParam}
+	}
+	return anthropicTools
+}
+
+func (a *anthropicProvider) finishReason(reason anthropic.StopReason) message.FinishReason {
+	switch reason {
+	case anthropic.StopReasonEndTurn, anthropic.StopReasonStopSequence:
+		return message.FinishReasonEndTurn
+	case anthropic.StopReasonMaxTokens:
+		return message.FinishReasonMaxTokens
+	case anthropic.StopReasonToolUse:
+		return message.FinishReasonToolUse
+	default:
+		return message.FinishReasonUnknown
+	}
+}
+
+func (a *anthropicProvider) preparedParams(messages []anthropic.MessageParam, systemMessages []string, tools []anthropic.ToolUnionParam) anthropic.MessageNewParams {
+	maxTokens := a.maxTokens
+	if maxTokens <= 0 {
+		maxTokens = anthropicDefaultMaxTokens
+	}
+
+	var system []anthropic.TextBlockParam
+	if a.systemMessage != "" {
+		system = append(system, anthropic.TextBlockParam{Text: a.systemMessage})
+	}
+	for _, systemMessage := range systemMessages {
+		system = append(system, anthropic.TextBlockParam{Text: systemMessage})
+	}
+	if len(system) > 0 {
+		system[len(system)-1].CacheControl = anthropic.NewCacheControlEphemeralParam()
+	}
+
+	params := anthropic.MessageNewParams{
+		Model:     anthropic.Model(a.model.ID),
+		MaxTokens: maxTokens,
+		Messages:  messages,
+		System:    system,
+		Tools:     tools,
+	}
+	if a.think {
+		// The thinking budget has to stay below max_tokens and the API
+		// rejects budgets smaller than 1024 tokens.
+		budget := maxTokens * 8 / 10
+		if budget >= 1024 {
+			params.Thinking = anthropic.ThinkingConfigParamOfEnabled(budget)
+		}
+	}
+	return params
+}
+
+func (a *anthropicProvider) StreamResponse(ctx context.Context, messages []message.Message, availableTools []tools.BaseTool) <-chan agent.ProviderEvent {
+	anthropicMessages, systemMessages := a.convertMessages(messages)
+	params := a.preparedParams(anthropicMessages, systemMessages, a.convertTools(availableTools))
+	eventChan := make(chan agent.ProviderEvent)
+
+	go func() {
+		defer close(eventChan)
+
+		stream := a.client.Messages.NewStreaming(ctx, params)
+		defer stream.Close()
+
+		accumulated := anthropic.Message{}
+		toolUseIDs := make(map[int64]string)
+
+		for stream.Next() {
+			event := stream.Current()
+			if err := accumulated.Accumulate(event); err != nil {
+				send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventError, Error: fmt.Errorf("anthropic: failed to accumulate stream: %w", err)})
+				return
+			}
+
+			var providerEvent *agent.ProviderEvent
+			switch event := event.AsAny().(type) {
+			case anthropic.ContentBlockStartEvent:
+				if event.ContentBlock.Type == "tool_use" {
+					toolUseIDs[event.Index] = event.ContentBlock.ID
+					providerEvent = &agent.ProviderEvent{
+						Type: agent.EventToolUseStart,
+						ToolCall: &tools.ToolCall{
+							ID:   event.ContentBlock.ID,
+							Name: event.ContentBlock.Name,
+						},
+					}
+				}
+			case anthropic.ContentBlockDeltaEvent:
+				switch event.Delta.Type {
+				case "thinking_delta":
+					providerEvent = &agent.ProviderEvent{Type: agent.EventThinkingDelta, Thinking: event.Delta.Thinking}
+				case "signature_delta":
+					providerEvent = &agent.ProviderEvent{Type: agent.EventSignatureDelta, Signature: event.Delta.Signature}
+				case "text_delta":
+					providerEvent = &agent.ProviderEvent{Type: agent.EventContentDelta, Content: event.Delta.Text}
+				case "input_json_delta":
+					if id, ok := toolUseIDs[event.Index]; ok && event.Delta.PartialJSON != "" {
+						providerEvent = &agent.ProviderEvent{
+							Type:     agent.EventToolUseDelta,
+							ToolCall: &tools.ToolCall{ID: id, Input: event.Delta.PartialJSON},
+						}
+					}
+				}
+			case anthropic.ContentBlockStopEvent:
+				if id, ok := toolUseIDs[event.Index]; ok {
+					providerEvent = &agent.ProviderEvent{Type: agent.EventToolUseStop, ToolCall: &tools.ToolCall{ID: id}}
+				}
+			}
+
+			if providerEvent != nil && !send(ctx, eventChan, *providerEvent) {
+				return
+			}
+		}
+
+		if err := stream.Err(); err != nil {
+			send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventError, Error: a.wrapError(err)})
+			return
+		}
+
+		response := &agent.ProviderResponse{
+			FinishReason: a.finishReason(accumulated.StopReason),
+			Usage: agent.TokenUsage{
+				InputTokens:         accumulated.Usage.InputTokens,
+				OutputTokens:        accumulated.Usage.OutputTokens,
+				CacheCreationTokens: accumulated.Usage.CacheCreationInputTokens,
+				CacheReadTokens:     accumulated.Usage.CacheReadInputTokens,
+			},
+		}
+		for _, block := range accumulated.Content {
+			switch block.Type {
+			case "text":
+				response.Content += block.Text
+			case "tool_use":
+				response.ToolCalls = append(response.ToolCalls, tools.ToolCall{
+					ID:    block.ID,
+					Name:  block.Name,
+					Input: string(block.Input),
+				})
+			}
+		}
+		if len(response.ToolCalls) > 0 {
+			response.FinishReason = message.FinishReasonToolUse
+		}
+
+		send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventComplete, Response: response})
+	}()
+
+	return eventChan
+}
+
+func (a *anthropicProvider) wrapError(err error) error {
+	var apiErr *anthropic.Error
+	if errors.As(err, &apiErr) {
+		return newStatusError(apiErr.StatusCode, apiErr.Response, fmt.Errorf("anthropic: status %d: %w", apiErr.StatusCode, err))
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gentica/config"
+	"gentica/llm/agent"
+	"gentica/llm/tools"
+	"gentica/message"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fixtureServer serves a recorded SSE stream from testdata and stores the
+// decoded request body.
+func fixtureServer(t *testing.T, fixture string, body *map[string]any) *httptest.Server {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", fixture))
+	require.NoError(t, err)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if body != nil {
+			require.NoError(t, json.Unmarshal(raw, body))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write(data)
+	}))
+}
+
+func TestAnthropicProvider(t *testing.T) {
+	t.Parallel()
+
+	t.Run("streams thinking, signature and tool use", func(t *testing.T) {
+		var body map[string]any
+		server := fixtureServer(t, "anthropic_thinking_tool_use.sse", &body)
+		defer server.Close()
+
+		p := NewAnthropicProvider(
+			config.ProviderConfig{BaseURL: server.URL, APIKey: "test"},
+			WithModel(agent.ModelInfo{ID: "claude-test"}),
+			WithSystemMessage("you are a coder"),
+			WithMaxTokens(8000),
+			WithThink(true),
+		)
+		events := collect(p.StreamResponse(context.Background(), []message.Message{userMessage("read a.go")}, []tools.BaseTool{stubTool{name: "view"}}))
+
+		var types []agent.ProviderEventType
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		require.Equal(t, []agent.ProviderEventType{
+			agent.EventThinkingDelta,
+			agent.EventThinkingDelta,
+			agent.EventSignatureDelta,
+			agent.EventContentDelta,
+			agent.EventToolUseStart,
+			agent.EventToolUseDelta,
+			agent.EventToolUseDelta,
+			agent.EventToolUseStop,
+			agent.EventComplete,
+		}, types)
+		require.Equal(t, "c2lnbmF0dXJl", events[2].Signature)
+
+		response := events[len(events)-1].Response
+		require.Equal(t, message.FinishReasonToolUse, response.FinishReason)
+		require.Equal(t, "Let me look.", response.Content)
+		require.Equal(t, []tools.ToolCall{{ID: "toolu_01", Name: "view", Input: `{"path":"a.go"}`}}, response.ToolCalls)
+		require.Equal(t, agent.TokenUsage{InputTokens: 25, OutputTokens: 42, CacheCreationTokens: 1200}, response.Usage)
+
+		require.EqualValues(t, 8000, body["max_tokens"])
+		thinking := body["thinking"].(map[string]any)
+		require.Equal(t, "enabled", thinking["type"])
+		require.EqualValues(t, 6400, thinking["budget_tokens"])
+
+		system := body["system"].([]any)
+		require.Len(t, system, 1)
+		require.Contains(t, system[0], "cache_control")
+
+		toolDefs := body["tools"].([]any)
+		require.Contains(t, toolDefs[len(toolDefs)-1], "cache_control")
+	})
+
+	t.Run("round-trips signed thinking and reports cache reads", func(t *testing.T) {
+		var body map[string]any
+		server := fixtureServer(t, "anthropic_cached_text.sse", &body)
+		defer server.Close()
+
+		p := NewAnthropicProvider(config.ProviderConfig{BaseURL: server.URL}, WithModel(agent.ModelInfo{ID: "claude-test"}))
+		history := []message.Message{
+			{
+				Role:  message.System,
+				Parts: []message.ContentPart{message.TextContent{Text: "project rules"}},
+			},
+			userMessage("read a.go"),
+			{
+				Role: message.Assistant,
+				Parts: []message.ContentPart{
+					message.ReasoningContent{Thinking: "I should read the file.", Signature: "c2lnbmF0dXJl"},
+					message.TextContent{Text: "Let me look."},
+					message.ToolCall{ID: "toolu_01", Name: "view", Input: `{"path":"a.go"}`},
+				},
+			},
+			{
+				Role:  message.Tool,
+				Parts: []message.ContentPart{message.ToolResult{ToolCallID: "toolu_01", Content: "package a"}},
+			},
+		}
+		events := collect(p.StreamResponse(context.Background(), history, nil))
+
+		response := events[len(events)-1].Response
+		require.Equal(t, message.FinishReasonEndTurn, response.FinishReason)
+		require.Equal(t, agent.TokenUsage{InputTokens: 10, OutputTokens: 7, CacheReadTokens: 1200}, response.Usage)
+		require.EqualValues(t, anthropicDefaultMaxTokens, body["max_tokens"])
+		require.NotContains(t, body, "thinking")
+
+		system := body["system"].([]any)
+		require.Equal(t, "project rules", system[0].(map[string]any)["text"])
+
+		messages := body["messages"].([]any)
+		require.Len(t, messages, 3)
+
+		assistant := messages[1].(map[string]any)["content"].([]any)
+		require.Len(t, assistant, 3)
+		thinking := assistant[0].(map[string]any)
+		require.Equal(t, "thinking", thinking["type"])
+		require.Equal(t, "c2lnbmF0dXJl", thinking["signature"])
+		require.Contains(t, assistant[2], "cache_control")
+
+		toolResult := messages[2].(map[string]any)
+		require.Equal(t, "user", toolResult["role"])
+		resultBlocks := toolResult["content"].([]any)
+		require.Equal(t, "tool_result", resultBlocks[0].(map[string]any)["type"])
+		require.Contains(t, resultBlocks[0], "cache_control")
+
+		first := messages[0].(map[string]any)["content"].([]any)
+		require.NotContains(t, first[0], "cache_control")
+	})
+
+	t.Run("sends tool result images", func(t *testing.T) {
+		var body map[string]any
+		server := fixtureServer(t, "anthropic_cached_text.sse", &body)
+		defer server.Close()
+
+		p := NewAnthropicProvider(config.ProviderConfig{BaseURL: server.URL}, WithModel(agent.ModelInfo{ID: "claude-test", SupportsImages: true}))
+		history := []message.Message{
+			userMessage("look at a.png"),
+			{
+				Role:  message.Assistant,
+				Parts: []message.ContentPart{message.ToolCall{ID: "toolu_01", Name: "view", Input: `{"path":"a.png"}`}},
+			},
+			{
+				Role: message.Tool,
+				Parts: []message.ContentPart{message.ToolResult{
+					ToolCallID: "toolu_01",
+					Content:    "Image file a.png",
+					Data:       []byte("png"),
+					MIMEType:   "image/png",
+				}},
+			},
+		}
+		collect(p.StreamResponse(context.Background(), history, nil))
+
+		messages := body["messages"].([]any)
+		require.Len(t, messages, 3)
+		toolResult := messages[2].(map[string]any)["content"].([]any)[0].(map[string]any)
+		content := toolResult["content"].([]any)
+		require.Len(t, content, 2)
+		require.Equal(t, "Image file a.png", content[0].(map[string]any)["text"])
+		image := content[1].(map[string]any)
+		require.Equal(t, "image", image["type"])
+		require.Equal(t, map[string]any{"type": "base64", "media_type": "image/png", "data": "cG5n"}, image["source"])
+	})
+}
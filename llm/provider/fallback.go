@@ -0,0 +1,226 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"gentica/llm/agent"
+	"gentica/llm/tools"
+	"gentica/message"
+)
+
+// Route is one of the providers a fallback provider can send a request to.
+type Route struct {
+	// Name is recorded in message.Message.Provider when the route serves a
+	// turn. It defaults to the name of the route's model.
+	Name     string
+	Provider agent.LLMProvider
+}
+
+// RoutingRule reports whether a model may serve a request.
+type RoutingRule func(model agent.ModelInfo, messages []message.Message, tools []tools.BaseTool) bool
+
+// RequireImageSupport is a RoutingRule that sends turns carrying images,
+// attached or returned by tools, only to models that support them.
+func RequireImageSupport(model agent.ModelInfo, messages []message.Message, _ []tools.BaseTool) bool {
+	if model.SupportsImages {
+		return true
+	}
+	for _, msg := range messages {
+		if len(msg.BinaryContent()) > 0 {
+			return false
+		}
+		for _, result := range msg.ToolResults() {
+			if len(result.Data) > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// FallbackOption configures the provider built by NewFallbackProvider.
+type FallbackOption func(*fallbackOptions)
+
+type fallbackOptions struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	rules      []RoutingRule
+}
+
+// WithMaxRetries sets how many times a route is retried after a transient
+// failure before the next route is tried.
+func WithMaxRetries(retries int) FallbackOption {
+	return func(o *fallbackOptions) {
+		o.maxRetries = retries
+	}
+}
+
+// WithRetryDelay sets the base and maximum delay of the exponential backoff.
+func WithRetryDelay(base, maximum time.Duration) FallbackOption {
+	return func(o *fallbackOptions) {
+		o.baseDelay = base
+		o.maxDelay = maximum
+	}
+}
+
+// WithRoutingRules adds rules that every route's model has to satisfy for
+// a request. Routes that fail a rule are skipped.
+func WithRoutingRules(rules ...RoutingRule) FallbackOption {
+	return func(o *fallbackOptions) {
+		o.rules = append(o.rules, rules...)
+	}
+}
+
+type fallbackProvider struct {
+	fallbackOptions
+	routes []Route
+}
+
+// NewFallbackProvider creates a provider that sends each request to the first
+// route accepted by the routing rules. Transient failures such as 429 or 5xx
+// responses are retried with jittered backoff, honouring Retry-After, before
+// failing over to the next route; other failures fail over right away.
+// Neither happens once the failing route has streamed anything, since the
+// consumer has already seen its output. The routes' providers should be
+// built WithoutClientRetries, so that their clients do not retry as well.
+// The provider reports the model of the first route as its own.
+func NewFallbackProvider(routes []Route, opts ...FallbackOption) agent.LLMProvider {
+	o := fallbackOptions{
+		maxRetries: 2,
+		baseDelay:  time.Second,
+		maxDelay:   30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	routes = slices.Clone(routes)
+	for i, route := range routes {
+		if route.Name == "" {
+			routes[i].Name = route.Provider.Model().Name
+		}
+	}
+	return &fallbackProvider{
+		fallbackOptions: o,
+		routes:          routes,
+	}
+}
+
+func (f *fallbackProvider) Model() agent.ModelInfo {
+	if len(f.routes) == 0 {
+		return agent.ModelInfo{}
+	}
+	return f.routes[0].Provider.Model()
+}
+
+func (f *fallbackProvider) StreamResponse(ctx context.Context, messages []message.Message, availableTools []tools.BaseTool) <-chan agent.ProviderEvent {
+	eventChan := make(chan agent.ProviderEvent)
+
+	go func() {
+		defer close(eventChan)
+
+		var lastErr error
+		for _, route := range f.routes {
+			if !f.accepts(route, messages, availableTools) {
+				continue
+			}
+			for attempt := 0; ; attempt++ {
+				streamed, err := f.stream(ctx, route, eventChan, messages, availableTools)
+				if err == nil || streamed || ctx.Err() != nil {
+					return
+				}
+				lastErr = err
+
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) || !statusErr.Temporary() {
+					break
+				}
+				if attempt >= f.maxRetries {
+					slog.Warn("Provider failed, trying the next one", "provider", route.Name, "error", err)
+					break
+				}
+				delay := f.backoff(attempt, statusErr.RetryAfter)
+				slog.Warn("Provider failed, retrying", "provider", route.Name, "attempt", attempt+1, "delay", delay, "error", err)
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		if lastErr == nil {
+			lastErr = errors.New("no provider accepts the request")
+		}
+		send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventError, Error: lastErr})
+	}()
+
+	return eventChan
+}
+
+func (f *fallbackProvider) accepts(route Route, messages []message.Message, availableTools []tools.BaseTool) bool {
+	model := route.Provider.Model()
+	for _, rule := range f.rules {
+		if !rule(model, messages, availableTools) {
+			return false
+		}
+	}
+	return true
+}
+
+// stream forwards the events of a single attempt. A failure is returned
+// instead of forwarded when nothing has been streamed yet, so that the
+// request can be sent again.
+func (f *fallbackProvider) stream(ctx context.Context, route Route, eventChan chan<- agent.ProviderEvent, messages []message.Message, availableTools []tools.BaseTool) (bool, error) {
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	streamed := false
+	for event := range route.Provider.StreamResponse(attemptCtx, messages, availableTools) {
+		switch event.Type {
+		case agent.EventError:
+			if !streamed {
+				return false, event.Error
+			}
+		case agent.EventComplete:
+			if event.Response != nil {
+				response := *event.Response
+				if response.Provider == "" {
+					response.Provider = route.Name
+				}
+				if response.Model == nil {
+					model := route.Provider.Model()
+					response.Model = &model
+				}
+				event.Response = &response
+			}
+		}
+		if !send(ctx, eventChan, event) {
+			return true, ctx.Err()
+		}
+		streamed = true
+	}
+	if !streamed {
+		return false, fmt.Errorf("provider %s closed the stream without a response", route.Name)
+	}
+	return true, nil
+}
+
+// backoff returns the delay before the given retry. Retry-After wins over
+// the exponential delay, which is jittered to spread out concurrent retries.
+func (f *fallbackProvider) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, f.maxDelay)
+	}
+	delay := min(f.baseDelay<<attempt, f.maxDelay)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
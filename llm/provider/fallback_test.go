@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"gentica/llm/agent"
+	"gentica/llm/tools"
+	"gentica/message"
+
+	"github.com/stretchr/testify/require"
+)
+
+// failingProvider fails the first calls with err before handing over to next.
+type failingProvider struct {
+	err      error
+	failures int
+	calls    int
+	next     agent.LLMProvider
+}
+
+func (f *failingProvider) Model() agent.ModelInfo {
+	return f.next.Model()
+}
+
+func (f *failingProvider) StreamResponse(ctx context.Context, messages []message.Message, availableTools []tools.BaseTool) <-chan agent.ProviderEvent {
+	f.calls++
+	if f.calls > f.failures {
+		return f.next.StreamResponse(ctx, messages, availableTools)
+	}
+	eventChan := make(chan agent.ProviderEvent, 1)
+	eventChan <- agent.ProviderEvent{Type: agent.EventError, Error: f.err}
+	close(eventChan)
+	return eventChan
+}
+
+func TestFallbackProvider(t *testing.T) {
+	t.Parallel()
+
+	overloaded := &StatusError{StatusCode: http.StatusTooManyRequests, Err: errors.New("rate limited")}
+	fast := WithRetryDelay(time.Millisecond, 10*time.Millisecond)
+
+	t.Run("retries transient failures on the same route", func(t *testing.T) {
+		primary := &failingProvider{err: overloaded, failures: 2, next: NewReplayProvider(agent.ModelInfo{Name: "Primary"}, TextTurn("hi"))}
+		p := NewFallbackProvider([]Route{{Provider: primary}, {Name: "backup", Provider: NewReplayProvider(agent.ModelInfo{})}}, fast)
+
+		events := collect(p.StreamResponse(context.Background(), nil, nil))
+		require.Equal(t, 3, primary.calls)
+		require.Len(t, events, 2)
+		require.Equal(t, "hi", events[1].Response.Content)
+		require.Equal(t, "Primary", events[1].Response.Provider)
+	})
+
+	t.Run("fails over once retries are exhausted", func(t *testing.T) {
+		primary := &failingProvider{err: overloaded, failures: 10, next: NewReplayProvider(agent.ModelInfo{})}
+		backup := NewReplayProvider(agent.ModelInfo{ID: "backup-model"}, TextTurn("from backup"))
+		p := NewFallbackProvider([]Route{{Name: "primary", Provider: primary}, {Name: "backup", Provider: backup}}, fast, WithMaxRetries(1))
+
+		events := collect(p.StreamResponse(context.Background(), nil, nil))
+		require.Equal(t, 2, primary.calls)
+		require.Equal(t, "backup", events[len(events)-1].Response.Provider)
+		require.Equal(t, "backup-model", events[len(events)-1].Response.Model.ID)
+	})
+
+	t.Run("fails over right away on other errors", func(t *testing.T) {
+		primary := &failingProvider{err: &StatusError{StatusCode: http.StatusUnauthorized, Err: errors.New("bad key")}, failures: 10, next: NewReplayProvider(agent.ModelInfo{})}
+		backup := NewReplayProvider(agent.ModelInfo{}, TextTurn("from backup"))
+		p := NewFallbackProvider([]Route{{Name: "primary", Provider: primary}, {Name: "backup", Provider: backup}}, fast)
+
+		events := collect(p.StreamResponse(context.Background(), nil, nil))
+		require.Equal(t, 1, primary.calls)
+		require.Equal(t, "from backup", events[len(events)-1].Response.Content)
+	})
+
+	t.Run("reports the last error when every route fails", func(t *testing.T) {
+		p := NewFallbackProvider([]Route{
+			{Name: "primary", Provider: NewReplayProvider(agent.ModelInfo{}, ErrorTurn("first"))},
+			{Name: "backup", Provider: NewReplayProvider(agent.ModelInfo{}, ErrorTurn("second"))},
+		}, fast)
+
+		events := collect(p.StreamResponse(context.Background(), nil, nil))
+		require.Len(t, events, 1)
+		require.EqualError(t, events[0].Error, "second")
+	})
+
+	t.Run("does not fail over after streaming", func(t *testing.T) {
+		primary := NewReplayProvider(agent.ModelInfo{}, ReplayTurn{Events: []ReplayEvent{
+			{Type: agent.EventContentDelta, Content: "partial"},
+			{Type: agent.EventError, Error: "connection reset"},
+		}})
+		backup := NewReplayProvider(agent.ModelInfo{}, TextTurn("from backup"))
+		p := NewFallbackProvider([]Route{{Name: "primary", Provider: primary}, {Name: "backup", Provider: backup}}, fast)
+
+		events := collect(p.StreamResponse(context.Background(), nil, nil))
+		require.Len(t, events, 2)
+		require.Equal(t, "partial", events[0].Content)
+		require.EqualError(t, events[1].Error, "connection reset")
+		require.Equal(t, 1, backup.Remaining())
+	})
+
+	t.Run("routes image turns to models that support images", func(t *testing.T) {
+		textOnly := NewReplayProvider(agent.ModelInfo{Name: "text"}, TextTurn("text"))
+		vision := NewReplayProvider(agent.ModelInfo{Name: "vision", SupportsImages: true}, TextTurn("vision"), TextTurn("vision"))
+		p := NewFallbackProvider([]Route{{Provider: textOnly}, {Provider: vision}}, WithRoutingRules(RequireImageSupport))
+
+		image := message.Message{Role: message.User, Parts: []message.ContentPart{
+			message.TextContent{Text: "what is this?"},
+			message.BinaryContent{MIMEType: "image/png", Data: []byte("png")},
+		}}
+		events := collect(p.StreamResponse(context.Background(), []message.Message{image}, nil))
+		require.Equal(t, "vision", events[len(events)-1].Response.Provider)
+
+		toolImage := message.Message{Role: message.Tool, Parts: []message.ContentPart{
+			message.ToolResult{ToolCallID: "call_1", Content: "Image file a.png", Data: []byte("png"), MIMEType: "image/png"},
+		}}
+		events = collect(p.StreamResponse(context.Background(), []message.Message{userMessage("look"), toolImage}, nil))
+		require.Equal(t, "vision", events[len(events)-1].Response.Provider)
+
+		events = collect(p.StreamResponse(context.Background(), []message.Message{userMessage("hi")}, nil))
+		require.Equal(t, "text", events[len(events)-1].Response.Provider)
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, 3*time.Second, retryAfter("3", now))
+	require.Equal(t, 90*time.Second, retryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	require.Zero(t, retryAfter("soon", now))
+	require.Zero(t, retryAfter("", now))
+}
@@ -0,0 +1,335 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"gentica/config"
+	"gentica/llm/agent"
+	"gentica/llm/tools"
+	"gentica/message"
+
+	"github.com/google/uuid"
+	"google.golang.org/genai"
+)
+
+type geminiProvider struct {
+	options
+	client *genai.Client
+}
+
+// NewGeminiProvider creates a streaming provider for the Gemini API. The API
+// key in cfg is expected to be resolved already.
+func NewGeminiProvider(cfg config.ProviderConfig, opts ...Option) (agent.LLMProvider, error) {
+	return newGeminiProvider(cfg, &genai.ClientConfig{
+		APIKey:  cfg.APIKey,
+		Backend: genai.BackendGeminiAPI,
+	}, opts)
+}
+
+// NewVertexAIProvider creates a streaming provider for Gemini models served by
+// Vertex AI. The project and location are read from cfg.ExtraParams and the
+// credentials from the application default credentials.
+func NewVertexAIProvider(cfg config.ProviderConfig, opts ...Option) (agent.LLMProvider, error) {
+	return newGeminiProvider(cfg, &genai.ClientConfig{
+		Project:  cfg.ExtraParams["project"],
+		Location: cfg.ExtraParams["location"],
+		Backend:  genai.BackendVertexAI,
+	}, opts)
+}
+
+func newGeminiProvider(cfg config.ProviderConfig, clientConfig *genai.ClientConfig, opts []Option) (agent.LLMProvider, error) {
+	clientConfig.HTTPOptions = genai.HTTPOptions{
+		BaseURL:   cfg.BaseURL,
+		ExtraBody: cfg.ExtraBody,
+	}
+	if len(cfg.ExtraHeaders) > 0 {
+		clientConfig.HTTPOptions.Headers = make(http.Header)
+		for key, value := range cfg.ExtraHeaders {
+			clientConfig.HTTPOptions.Headers.Set(key, value)
+		}
+	}
+
+	client, err := genai.NewClient(context.Background(), clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s client: %w", clientConfig.Backend, err)
+	}
+	return &geminiProvider{
+		options: newOptions(opts),
+		client:  client,
+	}, nil
+}
+
+func (g *geminiProvider) Model() agent.ModelInfo {
+	return g.model
+}
+
+// convertMessages maps the history to Gemini contents. System messages in the
+// history are returned separately because the API takes them as the system
+// instruction.
+func (g *geminiProvider) convertMessages(messages []message.Message) ([]*genai.Content, []string) {
+	var (
+		contents       []*genai.Content
+		systemMessages []string
+	)
+	// Function responses must carry the name of the function that was called
+	// but tool results only reference the call ID.
+	toolNames := make(map[string]string)
+
+	for _, msg := range messages {
+		switch msg.Role {
+		case message.System:
+			systemMessages = append(systemMessages, msg.Content().String())
+		case message.User:
+			var parts []*genai.Part
+			if text := msg.Content().String(); text != "" {
+				parts = append(parts, genai.NewPartFromText(text))
+			}
+			for _, binaryContent := range msg.BinaryContent() {
+				parts = append(parts, genai.NewPartFromBytes(binaryContent.Data, binaryContent.MIMEType))
+			}
+			if len(parts) == 0 {
+				continue
+			}
+			contents = append(contents, genai.NewContentFromParts(parts, genai.RoleUser))
+		case message.Assistant:
+			var parts []*genai.Part
+			if text := msg.Content().String(); text != "" {
+				parts = append(parts, genai.NewPartFromText(text))
+			}
+			for _, call := range msg.ToolCalls() {
+				toolNames[call.ID] = call.Name
+				args := map[string]any{}
+				if err := json.Unmarshal([]byte(call.Input), &args); err != nil {
+					slog.Warn("Failed to decode tool call input", "tool", call.Name, "error", err)
+				}
+				parts = append(parts, &genai.Part{
+					FunctionCall: &genai.FunctionCall{
+						ID:   call.ID,
+						Name: call.Name,
+						Args: args,
+					},
+				})
+			}
+			if len(parts) == 0 {
+				slog.Warn("There is a message without content, investigate, this should not happen")
+				continue
+			}
+			contents = append(contents, genai.NewContentFromParts(parts, genai.RoleModel))
+		case message.Tool:
+			var parts []*genai.Part
+			for _, result := range msg.ToolResults() {
+				name := result.Name
+				if name == "" {
+					name = toolNames[result.ToolCallID]
+				}
+				key := "output"
+				if result.IsError {
+					key = "error"
+				}
+				parts = append(parts, &genai.Part{
+					FunctionResponse: &genai.FunctionResponse{
+						ID:       result.ToolCallID,
+						Name:     name,
+						Response: map[string]any{key: result.Content},
+					},
+				})
+				if len(result.Data) > 0 {
+					parts = append(parts, genai.NewPartFromBytes(result.Data, result.MIMEType))
+				}
+			}
+			contents = append(contents, genai.NewContentFromParts(parts, genai.RoleUser))
+		}
+	}
+
+	return contents, systemMessages
+}
+
+func (g *geminiProvider) convertTools(availableTools []tools.BaseTool) []*genai.Tool {
+	if len(availableTools) == 0 {
+		return nil
+	}
+	declarations := make([]*genai.FunctionDeclaration, len(availableTools))
+	for i, tool := range availableTools {
+		info := tool.Info()
+		declarations[i] = &genai.FunctionDeclaration{
+			Name:        info.Name,
+			Description: info.Description,
+			ParametersJsonSchema: map[string]any{
+				"type":       "object",
+				"properties": info.Parameters,
+				"required":   info.Required,
+			},
+		}
+	}
+	return []*genai.Tool{{FunctionDeclarations: declarations}}
+}
+
+func (g *geminiProvider) finishReason(reason genai.FinishReason) message.FinishReason {
+	switch reason {
+	case genai.FinishReasonStop:
+		return message.FinishReasonEndTurn
+	case genai.FinishReasonMaxTokens:
+		return message.FinishReasonMaxTokens
+	case genai.FinishReasonSafety, genai.FinishReasonRecitation, genai.FinishReasonBlocklist,
+		genai.FinishReasonProhibitedContent, genai.FinishReasonSPII, genai.FinishReasonImageSafety:
+		return message.FinishReasonContentFiltered
+	default:
+		return message.FinishReasonUnknown
+	}
+}
+
+// withMessage adds the message Gemini explains a reason with, if any.
+func withMessage(reason, msg string) string {
+	if msg == "" {
+		return reason
+	}
+	return reason + " (" + msg + ")"
+}
+
+func (g *geminiProvider) preparedConfig(systemMessages []string, tools []*genai.Tool) *genai.GenerateContentConfig {
+	generateConfig := &genai.GenerateContentConfig{
+		MaxOutputTokens: int32(g.maxTokens),
+		Tools:           tools,
+	}
+
+	var systemParts []*genai.Part
+	if g.systemMessage != "" {
+		systemParts = append(systemParts, genai.NewPartFromText(g.systemMessage))
+	}
+	for _, systemMessage := range systemMessages {
+		systemParts = append(systemParts, genai.NewPartFromText(systemMessage))
+	}
+	if len(systemParts) > 0 {
+		generateConfig.SystemInstruction = genai.NewContentFromParts(systemParts, genai.RoleUser)
+	}
+
+	if g.think {
+		generateConfig.ThinkingConfig = &genai.ThinkingConfig{IncludeThoughts: true}
+	}
+	return generateConfig
+}
+
+func (g *geminiProvider) StreamResponse(ctx context.Context, messages []message.Message, availableTools []tools.BaseTool) <-chan agent.ProviderEvent {
+	contents, systemMessages := g.convertMessages(messages)
+	generateConfig := g.preparedConfig(systemMessages, g.convertTools(availableTools))
+	eventChan := make(chan agent.ProviderEvent)
+
+	go func() {
+		defer close(eventChan)
+
+		var (
+			content      string
+			finishReason genai.FinishReason
+			usage        *genai.GenerateContentResponseUsageMetadata
+			toolCalls    []tools.ToolCall
+		)
+
+		for resp, err := range g.client.Models.GenerateContentStream(ctx, g.model.ID, contents, generateConfig) {
+			if err != nil {
+				send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventError, Error: g.wrapError(err)})
+				return
+			}
+			if resp.UsageMetadata != nil {
+				usage = resp.UsageMetadata
+			}
+			if feedback := resp.PromptFeedback; feedback != nil && feedback.BlockReason != "" {
+				send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventError, Error: fmt.Errorf("prompt blocked: %s", withMessage(string(feedback.BlockReason), feedback.BlockReasonMessage))})
+				return
+			}
+			if len(resp.Candidates) == 0 {
+				continue
+			}
+
+			candidate := resp.Candidates[0]
+			switch candidate.FinishReason {
+			case "":
+			case genai.FinishReasonMalformedFunctionCall, genai.FinishReasonUnexpectedToolCall:
+				// The function call is lost, so there is no turn to finish.
+				send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventError, Error: fmt.Errorf("invalid function call: %s", withMessage(string(candidate.FinishReason), candidate.FinishMessage))})
+				return
+			default:
+				finishReason = candidate.FinishReason
+			}
+			if candidate.Content == nil {
+				continue
+			}
+
+			for _, part := range candidate.Content.Parts {
+				switch {
+				case part.Thought && part.Text != "":
+					if !send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventThinkingDelta, Thinking: part.Text}) {
+						return
+					}
+				case part.Text != "":
+					content += part.Text
+					if !send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventContentDelta, Content: part.Text}) {
+						return
+					}
+				case part.FunctionCall != nil:
+					// Gemini sends every function call whole, so each one is
+					// reported as a complete start/delta/stop sequence.
+					id := part.FunctionCall.ID
+					if id == "" {
+						id = uuid.NewString()
+					}
+					input, err := json.Marshal(part.FunctionCall.Args)
+					if err != nil {
+						send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventError, Error: fmt.Errorf("failed to encode function call arguments: %w", err)})
+						return
+					}
+					call := tools.ToolCall{ID: id, Name: part.FunctionCall.Name, Input: string(input)}
+					toolCalls = append(toolCalls, call)
+					for _, event := range []agent.ProviderEvent{
+						{Type: agent.EventToolUseStart, ToolCall: &tools.ToolCall{ID: call.ID, Name: call.Name}},
+						{Type: agent.EventToolUseDelta, ToolCall: &tools.ToolCall{ID: call.ID, Input: call.Input}},
+						{Type: agent.EventToolUseStop, ToolCall: &tools.ToolCall{ID: call.ID}},
+					} {
+						if !send(ctx, eventChan, event) {
+							return
+						}
+					}
+				}
+			}
+		}
+
+		response := &agent.ProviderResponse{
+			Content:      content,
+			FinishReason: g.finishReason(finishReason),
+			ToolCalls:    toolCalls,
+			Usage:        g.usage(usage),
+		}
+		if len(toolCalls) > 0 {
+			response.FinishReason = message.FinishReasonToolUse
+		}
+
+		send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventComplete, Response: response})
+	}()
+
+	return eventChan
+}
+
+func (g *geminiProvider) usage(usage *genai.GenerateContentResponseUsageMetadata) agent.TokenUsage {
+	if usage == nil {
+		return agent.TokenUsage{}
+	}
+	cachedTokens := int64(usage.CachedContentTokenCount)
+	return agent.TokenUsage{
+		InputTokens:     int64(usage.PromptTokenCount) - cachedTokens,
+		OutputTokens:    int64(usage.CandidatesTokenCount) + int64(usage.ThoughtsTokenCount),
+		CacheReadTokens: cachedTokens,
+	}
+}
+
+func (g *geminiProvider) wrapError(err error) error {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		// The Gemini SDK does not expose the response headers.
+		return newStatusError(apiErr.Code, nil, fmt.Errorf("gemini: %s (status %d): %w", apiErr.Message, apiErr.Code, err))
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gentica/config"
+	"gentica/llm/agent"
+	"gentica/llm/tools"
+	"gentica/message"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeminiProvider(t *testing.T) {
+	t.Parallel()
+
+	t.Run("streams text, function calls and usage", func(t *testing.T) {
+		var body map[string]any
+		server := sseServer(t, []string{
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":"Checking","thought":true}]}}]}`,
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":"Let me look."}]}}]}`,
+			`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"view","args":{"path":"a.go"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":30,"candidatesTokenCount":9,"cachedContentTokenCount":10,"thoughtsTokenCount":2}}`,
+		}, &body, nil)
+		defer server.Close()
+
+		p, err := NewGeminiProvider(
+			config.ProviderConfig{BaseURL: server.URL, APIKey: "test"},
+			WithModel(agent.ModelInfo{ID: "gemini-test"}),
+			WithSystemMessage("you are a coder"),
+			WithMaxTokens(1000),
+			WithThink(true),
+		)
+		require.NoError(t, err)
+		events := collect(p.StreamResponse(context.Background(), []message.Message{userMessage("read a.go")}, []tools.BaseTool{stubTool{name: "view"}}))
+
+		var types []agent.ProviderEventType
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		require.Equal(t, []agent.ProviderEventType{
+			agent.EventThinkingDelta,
+			agent.EventContentDelta,
+			agent.EventToolUseStart,
+			agent.EventToolUseDelta,
+			agent.EventToolUseStop,
+			agent.EventComplete,
+		}, types)
+
+		response := events[len(events)-1].Response
+		require.Equal(t, message.FinishReasonToolUse, response.FinishReason)
+		require.Equal(t, "Let me look.", response.Content)
+		require.Len(t, response.ToolCalls, 1)
+		require.Equal(t, "view", response.ToolCalls[0].Name)
+		require.NotEmpty(t, response.ToolCalls[0].ID)
+		require.JSONEq(t, `{"path":"a.go"}`, response.ToolCalls[0].Input)
+		require.Equal(t, agent.TokenUsage{InputTokens: 20, OutputTokens: 11, CacheReadTokens: 10}, response.Usage)
+
+		generationConfig := body["generationConfig"].(map[string]any)
+		require.EqualValues(t, 1000, generationConfig["maxOutputTokens"])
+		require.Contains(t, generationConfig, "thinkingConfig")
+		require.Contains(t, body, "systemInstruction")
+
+		declarations := body["tools"].([]any)[0].(map[string]any)["functionDeclarations"].([]any)
+		require.Equal(t, "view", declarations[0].(map[string]any)["name"])
+	})
+
+	t.Run("maps tool history and inline images", func(t *testing.T) {
+		var body map[string]any
+		server := sseServer(t, []string{
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":"It is a Go file."}]},"finishReason":"STOP"}]}`,
+		}, &body, nil)
+		defer server.Close()
+
+		p, err := NewGeminiProvider(config.ProviderConfig{BaseURL: server.URL, APIKey: "test"}, WithModel(agent.ModelInfo{ID: "gemini-test"}))
+		require.NoError(t, err)
+		history := []message.Message{
+			{
+				Role: message.User,
+				Parts: []message.ContentPart{
+					message.TextContent{Text: "what is this?"},
+					message.BinaryContent{MIMEType: "image/png", Data: []byte("png")},
+				},
+			},
+			{
+				Role: message.Assistant,
+				Parts: []message.ContentPart{
+					message.ToolCall{ID: "call_1", Name: "view", Input: `{"path":"a.go"}`},
+					message.ToolCall{ID: "call_2", Name: "view", Input: `{"path":"b.png"}`},
+				},
+			},
+			{
+				Role: message.Tool,
+				Parts: []message.ContentPart{
+					message.ToolResult{ToolCallID: "call_1", Content: "package a"},
+					message.ToolResult{ToolCallID: "call_2", Content: "Image file b.png", Data: []byte("png"), MIMEType: "image/png"},
+				},
+			},
+		}
+		events := collect(p.StreamResponse(context.Background(), history, nil))
+
+		response := events[len(events)-1].Response
+		require.Equal(t, message.FinishReasonEndTurn, response.FinishReason)
+		require.Equal(t, "It is a Go file.", response.Content)
+
+		contents := body["contents"].([]any)
+		require.Len(t, contents, 3)
+
+		userParts := contents[0].(map[string]any)["parts"].([]any)
+		require.Len(t, userParts, 2)
+		require.Equal(t, "image/png", userParts[1].(map[string]any)["inlineData"].(map[string]any)["mimeType"])
+
+		require.Equal(t, "model", contents[1].(map[string]any)["role"])
+		toolParts := contents[2].(map[string]any)["parts"].([]any)
+		require.Len(t, toolParts, 3)
+		functionResponse := toolParts[0].(map[string]any)["functionResponse"].(map[string]any)
+		require.Equal(t, "view", functionResponse["name"])
+		require.Equal(t, "package a", functionResponse["response"].(map[string]any)["output"])
+		require.Equal(t, "call_2", toolParts[1].(map[string]any)["functionResponse"].(map[string]any)["id"])
+		require.Equal(t, map[string]any{"mimeType": "image/png", "data": "cG5n"}, toolParts[2].(map[string]any)["inlineData"])
+	})
+
+	t.Run("reports filtered and invalid responses", func(t *testing.T) {
+		stream := func(t *testing.T, chunk string) []agent.ProviderEvent {
+			t.Helper()
+			server := sseServer(t, []string{chunk}, nil, nil)
+			defer server.Close()
+			p, err := NewGeminiProvider(config.ProviderConfig{BaseURL: server.URL, APIKey: "test"}, WithModel(agent.ModelInfo{ID: "gemini-test"}))
+			require.NoError(t, err)
+			return collect(p.StreamResponse(context.Background(), []message.Message{userMessage("hi")}, nil))
+		}
+
+		events := stream(t, `{"candidates":[{"content":{"role":"model","parts":[{"text":"Sure, "}]},"finishReason":"SAFETY"}]}`)
+		response := events[len(events)-1].Response
+		require.Equal(t, message.FinishReasonContentFiltered, response.FinishReason)
+		require.Equal(t, "Sure, ", response.Content)
+
+		events = stream(t, `{"candidates":[{"finishReason":"MALFORMED_FUNCTION_CALL"}]}`)
+		require.Len(t, events, 1)
+		require.Equal(t, agent.EventError, events[0].Type)
+		require.EqualError(t, events[0].Error, "invalid function call: MALFORMED_FUNCTION_CALL")
+
+		events = stream(t, `{"promptFeedback":{"blockReason":"PROHIBITED_CONTENT"}}`)
+		require.Len(t, events, 1)
+		require.EqualError(t, events[0].Error, "prompt blocked: PROHIBITED_CONTENT")
+	})
+
+	t.Run("reports api errors", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"code":400,"message":"bad model","status":"INVALID_ARGUMENT"}}`)
+		}))
+		defer server.Close()
+
+		p, err := NewGeminiProvider(config.ProviderConfig{BaseURL: server.URL, APIKey: "test"}, WithModel(agent.ModelInfo{ID: "gemini-test"}))
+		require.NoError(t, err)
+		events := collect(p.StreamResponse(context.Background(), []message.Message{userMessage("hi")}, nil))
+
+		require.Len(t, events, 1)
+		require.Equal(t, agent.EventError, events[0].Type)
+		require.ErrorContains(t, events[0].Error, "bad model")
+	})
+}
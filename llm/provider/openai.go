@@ -0,0 +1,341 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"gentica/config"
+	"gentica/llm/agent"
+	"gentica/llm/tools"
+	"gentica/message"
+
+	"github.com/charmbracelet/catwalk/pkg/catwalk"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/azure"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/shared"
+)
+
+type openaiProvider struct {
+	options
+	client openai.Client
+}
+
+// NewOpenAIProvider creates a streaming provider for the chat completions API.
+// It works with any OpenAI compatible endpoint set in cfg.BaseURL, including
+// Azure OpenAI; the API key is expected to be resolved already.
+func NewOpenAIProvider(cfg config.ProviderConfig, opts ...Option) agent.LLMProvider {
+	o := newOptions(opts)
+
+	requestOpts := []option.RequestOption{}
+	if cfg.Type == catwalk.TypeAzure {
+		// Azure routes requests by deployment and needs the API version
+		// that the config loader stores in the extra params.
+		requestOpts = append(requestOpts,
+			azure.WithEndpoint(cfg.BaseURL, cfg.ExtraParams["apiVersion"]),
+			azure.WithAPIKey(cfg.APIKey),
+		)
+	} else {
+		if cfg.APIKey != "" {
+			requestOpts = append(requestOpts, option.WithAPIKey(cfg.APIKey))
+		}
+		if cfg.BaseURL != "" {
+			requestOpts = append(requestOpts, option.WithBaseURL(cfg.BaseURL))
+		}
+	}
+	for key, value := range cfg.ExtraHeaders {
+		requestOpts = append(requestOpts, option.WithHeader(key, value))
+	}
+	for key, value := range cfg.ExtraBody {
+		requestOpts = append(requestOpts, option.WithJSONSet(key, value))
+	}
+	if o.noClientRetries {
+		requestOpts = append(requestOpts, option.WithMaxRetries(0))
+	}
+
+	return &openaiProvider{
+		options: o,
+		client:  openai.NewClient(requestOpts...),
+	}
+}
+
+func (o *openaiProvider) Model() agent.ModelInfo {
+	return o.model
+}
+
+func (o *openaiProvider) convertMessages(messages []message.Message) []openai.ChatCompletionMessageParamUnion {
+	var openaiMessages []openai.ChatCompletionMessageParamUnion
+	if o.systemMessage != "" {
+		openaiMessages = append(openaiMessages, openai.SystemMessage(o.systemMessage))
+	}
+
+	for _, msg := range messages {
+		switch msg.Role {
+		case message.System:
+			openaiMessages = append(openaiMessages, openai.SystemMessage(msg.Content().String()))
+		case message.User:
+			var content []openai.ChatCompletionContentPartUnionParam
+			if text := msg.Content().String(); text != "" {
+				content = append(content, openai.TextContentPart(text))
+			}
+			for _, binaryContent := range msg.BinaryContent() {
+				content = append(content, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{
+					URL: binaryContent.String(catwalk.InferenceProviderOpenAI),
+				}))
+			}
+			for _, imageURL := range msg.ImageURLContent() {
+				content = append(content, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{
+					URL:    imageURL.URL,
+					Detail: imageURL.Detail,
+				}))
+			}
+			openaiMessages = append(openaiMessages, openai.UserMessage(content))
+		case message.Assistant:
+			// Reasoning is not accepted back by the chat completions API, so
+			// only the visible text and the tool calls are replayed.
+			assistantMsg := openai.ChatCompletionAssistantMessageParam{}
+			hasContent := false
+			if text := msg.Content().String(); text != "" {
+				hasContent = true
+				assistantMsg.Content = openai.ChatCompletionAssistantMessageParamContentUnion{
+					OfString: openai.String(text),
+				}
+			}
+			for _, call := range msg.ToolCalls() {
+				hasContent = true
+				assistantMsg.ToolCalls = append(assistantMsg.ToolCalls, openai.ChatCompletionMessageToolCallParam{
+					ID: call.ID,
+					Function: openai.ChatCompletionMessageToolCallFunctionParam{
+						Name:      call.Name,
+						Arguments: call.Input,
+					},
+				})
+			}
+			if !hasContent {
+				slog.Warn("There is a message without content, investigate, this should not happen")
+				continue
+			}
+			openaiMessages = append(openaiMessages, openai.ChatCompletionMessageParamUnion{
+				OfAssistant: &assistantMsg,
+			})
+		case message.Tool:
+			// Tool messages only take text, so images follow the tool
+			// messages in a user message.
+			var images []openai.ChatCompletionContentPartUnionParam
+			for _, result := range msg.ToolResults() {
+				openaiMessages = append(openaiMessages, openai.ToolMessage(result.Content, result.ToolCallID))
+				if len(result.Data) > 0 {
+					binaryContent := message.BinaryContent{MIMEType: result.MIMEType, Data: result.Data}
+					images = append(images,
+						openai.TextContentPart("Image returned by tool call "+result.ToolCallID+":"),
+						openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{
+							URL: binaryContent.String(catwalk.InferenceProviderOpenAI),
+						}),
+					)
+				}
+			}
+			if len(images) > 0 {
+				openaiMessages = append(openaiMessages, openai.UserMessage(images))
+			}
+		}
+	}
+
+	return openaiMessages
+}
+
+func (o *openaiProvider) convertTools(availableTools []tools.BaseTool) []openai.ChatCompletionToolParam {
+	openaiTools := make([]openai.ChatCompletionToolParam, len(availableTools))
+	for i, tool := range availableTools {
+		info := tool.Info()
+		openaiTools[i] = openai.ChatCompletionToolParam{
+			Function: shared.FunctionDefinitionParam{
+				Name:        info.Name,
+				Description: openai.String(info.Description),
+				Parameters: shared.FunctionParameters{
+					"type":       "object",
+					"properties": info.Parameters,
+					"required":   info.Required,
+				},
+			},
+		}
+	}
+	return openaiTools
+}
+
+func (o *openaiProvider) finishReason(reason string) message.FinishReason {
+	switch reason {
+	case "stop":
+		return message.FinishReasonEndTurn
+	case "length":
+		return message.FinishReasonMaxTokens
+	case "tool_calls", "function_call":
+		return message.FinishReasonToolUse
+	default:
+		return message.FinishReasonUnknown
+	}
+}
+
+func (o *openaiProvider) preparedParams(messages []openai.ChatCompletionMessageParamUnion, tools []openai.ChatCompletionToolParam) openai.ChatCompletionNewParams {
+	params := openai.ChatCompletionNewParams{
+		Model:    openai.ChatModel(o.model.ID),
+		Messages: messages,
+		Tools:    tools,
+		StreamOptions: openai.ChatCompletionStreamOptionsParam{
+			IncludeUsage: openai.Bool(true),
+		},
+	}
+	// Reasoning models only accept max_completion_tokens, which also covers
+	// the reasoning tokens.
+	if o.maxTokens > 0 && o.model.CanReason {
+		params.MaxCompletionTokens = openai.Int(o.maxTokens)
+	} else if o.maxTokens > 0 {
+		params.MaxTokens = openai.Int(o.maxTokens)
+	}
+	if o.reasoningEffort != "" {
+		params.ReasoningEffort = shared.ReasoningEffort(o.reasoningEffort)
+	}
+	return params
+}
+
+func (o *openaiProvider) StreamResponse(ctx context.Context, messages []message.Message, availableTools []tools.BaseTool) <-chan agent.ProviderEvent {
+	params := o.preparedParams(o.convertMessages(messages), o.convertTools(availableTools))
+	eventChan := make(chan agent.ProviderEvent)
+
+	go func() {
+		defer close(eventChan)
+
+		stream := o.client.Chat.Completions.NewStreaming(ctx, params)
+		defer stream.Close()
+
+		var (
+			content      string
+			finishReason string
+			usage        openai.CompletionUsage
+			toolCalls    []*openaiToolCall
+		)
+		toolCallsByIndex := make(map[int64]*openaiToolCall)
+
+		for stream.Next() {
+			chunk := stream.Current()
+			if chunk.JSON.Usage.Valid() {
+				usage = chunk.Usage
+			}
+
+			for _, choice := range chunk.Choices {
+				if reasoning := reasoningDelta(choice.Delta); reasoning != "" {
+					if !send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventThinkingDelta, Thinking: reasoning}) {
+						return
+					}
+				}
+				if choice.Delta.Content != "" {
+					content += choice.Delta.Content
+					if !send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventContentDelta, Content: choice.Delta.Content}) {
+						return
+					}
+				}
+
+				for _, delta := range choice.Delta.ToolCalls {
+					call, ok := toolCallsByIndex[delta.Index]
+					if !ok {
+						call = &openaiToolCall{id: delta.ID, name: delta.Function.Name}
+						toolCallsByIndex[delta.Index] = call
+						toolCalls = append(toolCalls, call)
+						if !send(ctx, eventChan, agent.ProviderEvent{
+							Type:     agent.EventToolUseStart,
+							ToolCall: &tools.ToolCall{ID: call.id, Name: call.name},
+						}) {
+							return
+						}
+					}
+					if delta.Function.Arguments == "" {
+						continue
+					}
+					call.input += delta.Function.Arguments
+					if !send(ctx, eventChan, agent.ProviderEvent{
+						Type:     agent.EventToolUseDelta,
+						ToolCall: &tools.ToolCall{ID: call.id, Input: delta.Function.Arguments},
+					}) {
+						return
+					}
+				}
+
+				if choice.FinishReason != "" {
+					finishReason = choice.FinishReason
+				}
+			}
+		}
+
+		if err := stream.Err(); err != nil {
+			send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventError, Error: o.wrapError(err)})
+			return
+		}
+
+		response := &agent.ProviderResponse{
+			Content:      content,
+			FinishReason: o.finishReason(finishReason),
+			Usage:        o.usage(usage),
+		}
+		for _, call := range toolCalls {
+			if !send(ctx, eventChan, agent.ProviderEvent{
+				Type:     agent.EventToolUseStop,
+				ToolCall: &tools.ToolCall{ID: call.id},
+			}) {
+				return
+			}
+			response.ToolCalls = append(response.ToolCalls, tools.ToolCall{
+				ID:    call.id,
+				Name:  call.name,
+				Input: call.input,
+			})
+		}
+		if len(response.ToolCalls) > 0 {
+			response.FinishReason = message.FinishReasonToolUse
+		}
+
+		send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventComplete, Response: response})
+	}()
+
+	return eventChan
+}
+
+func (o *openaiProvider) usage(usage openai.CompletionUsage) agent.TokenUsage {
+	cachedTokens := usage.PromptTokensDetails.CachedTokens
+	return agent.TokenUsage{
+		InputTokens:     usage.PromptTokens - cachedTokens,
+		OutputTokens:    usage.CompletionTokens,
+		CacheReadTokens: cachedTokens,
+	}
+}
+
+func (o *openaiProvider) wrapError(err error) error {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return newStatusError(apiErr.StatusCode, apiErr.Response, fmt.Errorf("openai: %s (status %d): %w", apiErr.Message, apiErr.StatusCode, err))
+	}
+	return err
+}
+
+type openaiToolCall struct {
+	id    string
+	name  string
+	input string
+}
+
+// reasoningDelta extracts the reasoning text that several OpenAI compatible
+// servers stream next to the regular content.
+func reasoningDelta(delta openai.ChatCompletionChunkChoiceDelta) string {
+	for _, field := range []string{"reasoning_content", "reasoning"} {
+		extra, ok := delta.JSON.ExtraFields[field]
+		if !ok || !extra.Valid() {
+			continue
+		}
+		var reasoning string
+		if err := json.Unmarshal([]byte(extra.Raw()), &reasoning); err == nil && reasoning != "" {
+			return reasoning
+		}
+	}
+	return ""
+}
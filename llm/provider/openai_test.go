@@ -0,0 +1,244 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gentica/config"
+	"gentica/llm/agent"
+	"gentica/llm/tools"
+	"gentica/message"
+
+	"github.com/stretchr/testify/require"
+)
+
+type stubTool struct {
+	name string
+}
+
+func (s stubTool) Name() string { return s.name }
+
+func (s stubTool) Info() tools.ToolInfo {
+	return tools.ToolInfo{
+		Name:        s.name,
+		Description: "stub tool",
+		Parameters: map[string]any{
+			"path": map[string]any{"type": "string"},
+		},
+		Required: []string{"path"},
+	}
+}
+
+func (s stubTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolResponse, error) {
+	return tools.NewTextResponse("ok"), nil
+}
+
+// sseServer replays the given data lines as a server-sent event stream and
+// stores the decoded request body.
+func sseServer(t *testing.T, lines []string, body *map[string]any, header *http.Header) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if body != nil {
+			require.NoError(t, json.Unmarshal(raw, body))
+		}
+		if header != nil {
+			*header = r.Header.Clone()
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, line := range lines {
+			fmt.Fprintf(w, "data: %s\n\n", line)
+		}
+	}))
+}
+
+func collect(events <-chan agent.ProviderEvent) []agent.ProviderEvent {
+	var result []agent.ProviderEvent
+	for event := range events {
+		result = append(result, event)
+	}
+	return result
+}
+
+func TestOpenAIProvider(t *testing.T) {
+	t.Parallel()
+
+	t.Run("streams content and usage", func(t *testing.T) {
+		server := sseServer(t, []string{
+			`{"id":"1","object":"chat.completion.chunk","created":1,"model":"gpt-test","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"}}]}`,
+			`{"id":"1","object":"chat.completion.chunk","created":1,"model":"gpt-test","choices":[{"index":0,"delta":{"content":", world"},"finish_reason":"stop"}]}`,
+			`{"id":"1","object":"chat.completion.chunk","created":1,"model":"gpt-test","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3,"total_tokens":15,"prompt_tokens_details":{"cached_tokens":4}}}`,
+			`[DONE]`,
+		}, nil, nil)
+		defer server.Close()
+
+		p := NewOpenAIProvider(config.ProviderConfig{BaseURL: server.URL, APIKey: "test"}, WithModel(agent.ModelInfo{ID: "gpt-test"}))
+		events := collect(p.StreamResponse(context.Background(), []message.Message{userMessage("hi")}, nil))
+
+		require.Len(t, events, 3)
+		require.Equal(t, agent.EventContentDelta, events[0].Type)
+		require.Equal(t, "Hello", events[0].Content)
+		require.Equal(t, ", world", events[1].Content)
+		require.Equal(t, agent.EventComplete, events[2].Type)
+		require.Equal(t, "Hello, world", events[2].Response.Content)
+		require.Equal(t, message.FinishReasonEndTurn, events[2].Response.FinishReason)
+		require.Equal(t, agent.TokenUsage{InputTokens: 8, OutputTokens: 3, CacheReadTokens: 4}, events[2].Response.Usage)
+	})
+
+	t.Run("streams tool calls", func(t *testing.T) {
+		server := sseServer(t, []string{
+			`{"id":"1","object":"chat.completion.chunk","created":1,"model":"gpt-test","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"view","arguments":""}}]}}]}`,
+			`{"id":"1","object":"chat.completion.chunk","created":1,"model":"gpt-test","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"path\":"}}]}}]}`,
+			`{"id":"1","object":"chat.completion.chunk","created":1,"model":"gpt-test","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"a.go\"}"}}]},"finish_reason":"tool_calls"}]}`,
+			`[DONE]`,
+		}, nil, nil)
+		defer server.Close()
+
+		p := NewOpenAIProvider(config.ProviderConfig{BaseURL: server.URL}, WithModel(agent.ModelInfo{ID: "gpt-test"}))
+		events := collect(p.StreamResponse(context.Background(), []message.Message{userMessage("read a.go")}, []tools.BaseTool{stubTool{name: "view"}}))
+
+		var types []agent.ProviderEventType
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		require.Equal(t, []agent.ProviderEventType{
+			agent.EventToolUseStart,
+			agent.EventToolUseDelta,
+			agent.EventToolUseDelta,
+			agent.EventToolUseStop,
+			agent.EventComplete,
+		}, types)
+		require.Equal(t, "view", events[0].ToolCall.Name)
+
+		response := events[len(events)-1].Response
+		require.Equal(t, message.FinishReasonToolUse, response.FinishReason)
+		require.Equal(t, []tools.ToolCall{{ID: "call_1", Name: "view", Input: `{"path":"a.go"}`}}, response.ToolCalls)
+	})
+
+	t.Run("sends history, tools, extra headers and body", func(t *testing.T) {
+		var body map[string]any
+		var header http.Header
+		server := sseServer(t, []string{
+			`{"id":"1","object":"chat.completion.chunk","created":1,"model":"gpt-test","choices":[{"index":0,"delta":{"content":"done"},"finish_reason":"stop"}]}`,
+			`[DONE]`,
+		}, &body, &header)
+		defer server.Close()
+
+		p := NewOpenAIProvider(config.ProviderConfig{
+			BaseURL:      server.URL,
+			ExtraHeaders: map[string]string{"X-Test": "yes"},
+			ExtraBody:    map[string]any{"top_k": 5},
+		}, WithModel(agent.ModelInfo{ID: "gpt-test"}), WithSystemMessage("be brief"), WithMaxTokens(100))
+
+		history := []message.Message{
+			{
+				Role: message.User,
+				Parts: []message.ContentPart{
+					message.TextContent{Text: "look"},
+					message.BinaryContent{MIMEType: "image/png", Data: []byte("png")},
+				},
+			},
+			{
+				Role: message.Assistant,
+				Parts: []message.ContentPart{
+					message.ReasoningContent{Thinking: "hidden"},
+					message.ToolCall{ID: "call_1", Name: "view", Input: `{"path":"a.go"}`},
+					message.ToolCall{ID: "call_2", Name: "view", Input: `{"path":"b.png"}`},
+				},
+			},
+			{
+				Role: message.Tool,
+				Parts: []message.ContentPart{
+					message.ToolResult{ToolCallID: "call_1", Content: "package a"},
+					message.ToolResult{ToolCallID: "call_2", Content: "Image file b.png", Data: []byte("png"), MIMEType: "image/png"},
+				},
+			},
+		}
+		collect(p.StreamResponse(context.Background(), history, []tools.BaseTool{stubTool{name: "view"}}))
+
+		require.Equal(t, "yes", header.Get("X-Test"))
+		require.EqualValues(t, 5, body["top_k"])
+		require.EqualValues(t, 100, body["max_tokens"])
+		require.Equal(t, true, body["stream"])
+
+		messages := body["messages"].([]any)
+		require.Len(t, messages, 6)
+		require.Equal(t, "system", messages[0].(map[string]any)["role"])
+
+		userContent := messages[1].(map[string]any)["content"].([]any)
+		require.Len(t, userContent, 2)
+		imageURL := userContent[1].(map[string]any)["image_url"].(map[string]any)["url"].(string)
+		require.True(t, strings.HasPrefix(imageURL, "data:image/png;base64,"))
+
+		assistant := messages[2].(map[string]any)
+		require.NotContains(t, assistant, "content")
+		require.Len(t, assistant["tool_calls"], 2)
+
+		toolMessage := messages[3].(map[string]any)
+		require.Equal(t, "tool", toolMessage["role"])
+		require.Equal(t, "call_1", toolMessage["tool_call_id"])
+		require.Equal(t, "call_2", messages[4].(map[string]any)["tool_call_id"])
+
+		// Tool messages only take text, so the image follows them.
+		imageMessage := messages[5].(map[string]any)
+		require.Equal(t, "user", imageMessage["role"])
+		imageContent := imageMessage["content"].([]any)
+		require.Len(t, imageContent, 2)
+		require.Equal(t, "Image returned by tool call call_2:", imageContent[0].(map[string]any)["text"])
+		require.Equal(t, "data:image/png;base64,cG5n", imageContent[1].(map[string]any)["image_url"].(map[string]any)["url"])
+
+		toolDefs := body["tools"].([]any)
+		require.Len(t, toolDefs, 1)
+		function := toolDefs[0].(map[string]any)["function"].(map[string]any)
+		require.Equal(t, "view", function["name"])
+	})
+
+	t.Run("reports api errors", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"message":"bad model","type":"invalid_request_error"}}`)
+		}))
+		defer server.Close()
+
+		p := NewOpenAIProvider(config.ProviderConfig{BaseURL: server.URL}, WithModel(agent.ModelInfo{ID: "gpt-test"}))
+		events := collect(p.StreamResponse(context.Background(), []message.Message{userMessage("hi")}, nil))
+
+		require.Len(t, events, 1)
+		require.Equal(t, agent.EventError, events[0].Type)
+		require.ErrorContains(t, events[0].Error, "bad model")
+	})
+
+	t.Run("leaves retries to the caller", func(t *testing.T) {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		p := NewOpenAIProvider(config.ProviderConfig{BaseURL: server.URL}, WithModel(agent.ModelInfo{ID: "gpt-test"}), WithoutClientRetries())
+		events := collect(p.StreamResponse(context.Background(), []message.Message{userMessage("hi")}, nil))
+
+		require.Len(t, events, 1)
+		var statusErr *StatusError
+		require.ErrorAs(t, events[0].Error, &statusErr)
+		require.True(t, statusErr.Temporary())
+		require.Equal(t, int32(1), requests.Load())
+	})
+}
+
+func userMessage(text string) message.Message {
+	return message.Message{
+		Role:  message.User,
+		Parts: []message.ContentPart{message.TextContent{Text: text}},
+	}
+}
@@ -0,0 +1,256 @@
+package provider
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gentica/config"
+	"gentica/llm/agent"
+
+	"github.com/charmbracelet/catwalk/pkg/catwalk"
+)
+
+// Option configures the providers in this package.
+type Option func(*options)
+
+type options struct {
+	model              agent.ModelInfo
+	systemMessage      string
+	systemPromptPrefix string
+	maxTokens          int64
+	reasoningEffort    string
+	think              bool
+	noClientRetries    bool
+}
+
+// WithModel sets the model the provider talks to.
+func WithModel(model agent.ModelInfo) Option {
+	return func(o *options) {
+		o.model = model
+	}
+}
+
+// WithSystemMessage sets the system prompt sent before the conversation.
+func WithSystemMessage(systemMessage string) Option {
+	return func(o *options) {
+		o.systemMessage = systemMessage
+	}
+}
+
+// WithSystemPromptPrefix sets text that is placed before the system prompt.
+func WithSystemPromptPrefix(prefix string) Option {
+	return func(o *options) {
+		o.systemPromptPrefix = prefix
+	}
+}
+
+// WithMaxTokens caps the number of tokens generated per response.
+func WithMaxTokens(maxTokens int64) Option {
+	return func(o *options) {
+		o.maxTokens = maxTokens
+	}
+}
+
+// WithReasoningEffort sets the reasoning effort for models that support it.
+func WithReasoningEffort(effort string) Option {
+	return func(o *options) {
+		o.reasoningEffort = effort
+	}
+}
+
+// WithThink enables extended thinking for models that can reason.
+func WithThink(think bool) Option {
+	return func(o *options) {
+		o.think = think
+	}
+}
+
+// WithoutClientRetries turns off the retries of the API client, for
+// providers that are routes of a fallback provider, which retries them
+// itself.
+func WithoutClientRetries() Option {
+	return func(o *options) {
+		o.noClientRetries = true
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.systemPromptPrefix != "" {
+		if o.systemMessage == "" {
+			o.systemMessage = o.systemPromptPrefix
+		} else {
+			o.systemMessage = o.systemPromptPrefix + "\n\n" + o.systemMessage
+		}
+	}
+	return o
+}
+
+// New builds the provider for the model selected as modelType in cfg. The
+// returned ModelInfo carries the catwalk pricing, context window and
+// capabilities of that model. Options passed by the caller are applied after
+// the ones derived from the configuration.
+func New(cfg *config.Config, modelType config.SelectedModelType, opts ...Option) (agent.LLMProvider, agent.ModelInfo, error) {
+	selected, ok := cfg.Models[modelType]
+	if !ok {
+		return nil, agent.ModelInfo{}, fmt.Errorf("no %s model selected", modelType)
+	}
+	providerCfg := cfg.GetProviderForModel(modelType)
+	if providerCfg == nil {
+		return nil, agent.ModelInfo{}, fmt.Errorf("provider %s is not configured", selected.Provider)
+	}
+	if providerCfg.Disable {
+		return nil, agent.ModelInfo{}, fmt.Errorf("provider %s is disabled", selected.Provider)
+	}
+	model := cfg.GetModelByType(modelType)
+	if model == nil {
+		return nil, agent.ModelInfo{}, fmt.Errorf("model %s not found for provider %s", selected.Model, selected.Provider)
+	}
+
+	resolved, err := resolveProviderConfig(cfg, *providerCfg)
+	if err != nil {
+		return nil, agent.ModelInfo{}, err
+	}
+
+	info := modelInfo(*model)
+	providerOpts := []Option{
+		WithModel(info),
+		WithMaxTokens(cmp.Or(selected.MaxTokens, model.DefaultMaxTokens)),
+		WithSystemPromptPrefix(providerCfg.SystemPromptPrefix),
+		WithThink(selected.Think && model.CanReason),
+	}
+	if model.HasReasoningEffort {
+		providerOpts = append(providerOpts, WithReasoningEffort(cmp.Or(selected.ReasoningEffort, model.DefaultReasoningEffort)))
+	}
+	providerOpts = append(providerOpts, opts...)
+
+	var llmProvider agent.LLMProvider
+	switch resolved.Type {
+	case catwalk.TypeOpenAI, catwalk.TypeAzure, "":
+		llmProvider = NewOpenAIProvider(resolved, providerOpts...)
+	case catwalk.TypeAnthropic:
+		llmProvider = NewAnthropicProvider(resolved, providerOpts...)
+	case catwalk.TypeGemini:
+		llmProvider, err = NewGeminiProvider(resolved, providerOpts...)
+	case catwalk.TypeVertexAI:
+		llmProvider, err = NewVertexAIProvider(resolved, providerOpts...)
+	default:
+		return nil, agent.ModelInfo{}, fmt.Errorf("provider type %s is not supported", resolved.Type)
+	}
+	if err != nil {
+		return nil, agent.ModelInfo{}, err
+	}
+	return llmProvider, info, nil
+}
+
+func modelInfo(model catwalk.Model) agent.ModelInfo {
+	return agent.ModelInfo{
+		ID:                 model.ID,
+		Name:               model.Name,
+		SupportsImages:     model.SupportsImages,
+		SupportsTools:      true,
+		CanReason:          model.CanReason,
+		ContextWindow:      model.ContextWindow,
+		DefaultMaxTokens:   model.DefaultMaxTokens,
+		CostPer1MIn:        model.CostPer1MIn,
+		CostPer1MOut:       model.CostPer1MOut,
+		CostPer1MInCached:  model.CostPer1MInCached,
+		CostPer1MOutCached: model.CostPer1MOutCached,
+	}
+}
+
+// resolveProviderConfig expands the variables in the API key, endpoint and
+// headers. Configs assembled by hand have no resolver and are used as is.
+func resolveProviderConfig(cfg *config.Config, providerCfg config.ProviderConfig) (config.ProviderConfig, error) {
+	resolver := cfg.Resolver()
+	if resolver == nil {
+		return providerCfg, nil
+	}
+
+	apiKey, err := resolver.ResolveValue(providerCfg.APIKey)
+	if err != nil {
+		return providerCfg, fmt.Errorf("failed to resolve API key for provider %s: %w", providerCfg.ID, err)
+	}
+	baseURL, err := resolver.ResolveValue(providerCfg.BaseURL)
+	if err != nil {
+		return providerCfg, fmt.Errorf("failed to resolve base URL for provider %s: %w", providerCfg.ID, err)
+	}
+	headers := maps.Clone(providerCfg.ExtraHeaders)
+	for key, value := range headers {
+		if headers[key], err = resolver.ResolveValue(value); err != nil {
+			return providerCfg, fmt.Errorf("failed to resolve header %s for provider %s: %w", key, providerCfg.ID, err)
+		}
+	}
+
+	providerCfg.APIKey = apiKey
+	providerCfg.BaseURL = baseURL
+	providerCfg.ExtraHeaders = headers
+	return providerCfg, nil
+}
+
+// send delivers an event unless the consumer has gone away.
+func send(ctx context.Context, events chan<- agent.ProviderEvent, event agent.ProviderEvent) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// StatusError is returned by the providers in this package when the API
+// answers with an HTTP error status.
+type StatusError struct {
+	StatusCode int
+	// RetryAfter is the delay asked for by the Retry-After header, if any.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the request may succeed when it is sent again.
+func (e *StatusError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return e.StatusCode >= http.StatusInternalServerError
+}
+
+func newStatusError(statusCode int, resp *http.Response, err error) *StatusError {
+	statusErr := &StatusError{StatusCode: statusCode, Err: err}
+	if resp != nil {
+		statusErr.RetryAfter = retryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return statusErr
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an
+// HTTP date.
+func retryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
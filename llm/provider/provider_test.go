@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"gentica/config"
+	"gentica/llm/agent"
+	"gentica/message"
+
+	"github.com/charmbracelet/catwalk/pkg/catwalk"
+	"github.com/charmbracelet/crush/internal/csync"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig(providers map[string]config.ProviderConfig, models map[config.SelectedModelType]config.SelectedModel) *config.Config {
+	return &config.Config{
+		Models:    models,
+		Providers: csync.NewMapFrom(providers),
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	model := catwalk.Model{
+		ID:                 "gpt-test",
+		Name:               "GPT Test",
+		CostPer1MIn:        2,
+		CostPer1MOut:       8,
+		CostPer1MInCached:  0.5,
+		ContextWindow:      128000,
+		DefaultMaxTokens:   4000,
+		CanReason:          true,
+		HasReasoningEffort: true,
+		SupportsImages:     true,
+	}
+
+	t.Run("builds the selected model's provider", func(t *testing.T) {
+		var body map[string]any
+		server := sseServer(t, []string{
+			`{"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"hi"},"finish_reason":"stop"}]}`,
+			`[DONE]`,
+		}, &body, nil)
+		defer server.Close()
+
+		cfg := testConfig(map[string]config.ProviderConfig{
+			"openai": {
+				ID:                 "openai",
+				Type:               catwalk.TypeOpenAI,
+				BaseURL:            server.URL,
+				APIKey:             "test",
+				SystemPromptPrefix: "prefix",
+				Models:             []catwalk.Model{model},
+			},
+		}, map[config.SelectedModelType]config.SelectedModel{
+			config.SelectedModelTypeLarge: {Model: "gpt-test", Provider: "openai", ReasoningEffort: "high"},
+		})
+
+		p, info, err := New(cfg, config.SelectedModelTypeLarge, WithSystemMessage("you are a coder"))
+		require.NoError(t, err)
+		require.Equal(t, agent.ModelInfo{
+			ID:                "gpt-test",
+			Name:              "GPT Test",
+			CostPer1MIn:       2,
+			CostPer1MOut:      8,
+			CostPer1MInCached: 0.5,
+			SupportsImages:    true,
+			SupportsTools:     true,
+			CanReason:         true,
+			ContextWindow:     128000,
+			DefaultMaxTokens:  4000,
+		}, info)
+		require.Equal(t, info, p.Model())
+
+		events := collect(p.StreamResponse(context.Background(), []message.Message{userMessage("hello")}, nil))
+		require.Equal(t, agent.EventComplete, events[len(events)-1].Type)
+
+		require.Equal(t, "gpt-test", body["model"])
+		require.EqualValues(t, 4000, body["max_completion_tokens"])
+		require.Equal(t, "high", body["reasoning_effort"])
+		system := body["messages"].([]any)[0].(map[string]any)
+		require.Equal(t, "system", system["role"])
+		require.Equal(t, "prefix\n\nyou are a coder", system["content"])
+	})
+
+	t.Run("rejects unusable selections", func(t *testing.T) {
+		providers := map[string]config.ProviderConfig{
+			"openai":   {ID: "openai", Type: catwalk.TypeOpenAI, Models: []catwalk.Model{model}},
+			"disabled": {ID: "disabled", Type: catwalk.TypeOpenAI, Disable: true, Models: []catwalk.Model{model}},
+			"bedrock":  {ID: "bedrock", Type: catwalk.TypeBedrock, Models: []catwalk.Model{model}},
+		}
+		for name, tc := range map[string]struct {
+			selected map[config.SelectedModelType]config.SelectedModel
+			err      string
+		}{
+			"not selected": {
+				err: "no large model selected",
+			},
+			"unknown provider": {
+				selected: map[config.SelectedModelType]config.SelectedModel{config.SelectedModelTypeLarge: {Model: "gpt-test", Provider: "missing"}},
+				err:      "provider missing is not configured",
+			},
+			"disabled provider": {
+				selected: map[config.SelectedModelType]config.SelectedModel{config.SelectedModelTypeLarge: {Model: "gpt-test", Provider: "disabled"}},
+				err:      "provider disabled is disabled",
+			},
+			"unknown model": {
+				selected: map[config.SelectedModelType]config.SelectedModel{config.SelectedModelTypeLarge: {Model: "other", Provider: "openai"}},
+				err:      "model other not found for provider openai",
+			},
+			"unsupported type": {
+				selected: map[config.SelectedModelType]config.SelectedModel{config.SelectedModelTypeLarge: {Model: "gpt-test", Provider: "bedrock"}},
+				err:      "provider type bedrock is not supported",
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, _, err := New(testConfig(providers, tc.selected), config.SelectedModelTypeLarge)
+				require.EqualError(t, err, tc.err)
+			})
+		}
+	})
+}
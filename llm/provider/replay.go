@@ -0,0 +1,276 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"gentica/llm/agent"
+	"gentica/llm/tools"
+	"gentica/message"
+)
+
+// ReplayEvent is the serializable form of an agent.ProviderEvent.
+type ReplayEvent struct {
+	Type      agent.ProviderEventType `json:"type"`
+	Content   string                  `json:"content,omitempty"`
+	Thinking  string                  `json:"thinking,omitempty"`
+	Signature string                  `json:"signature,omitempty"`
+	ToolCall  *tools.ToolCall         `json:"tool_call,omitempty"`
+	Response  *agent.ProviderResponse `json:"response,omitempty"`
+	Error     string                  `json:"error,omitempty"`
+}
+
+// ReplayTurn is the scripted answer to a single StreamResponse call.
+type ReplayTurn struct {
+	Events []ReplayEvent `json:"events"`
+	// WaitForCancel keeps the stream open after the last event until the
+	// request is canceled, which simulates a cancellation mid-stream.
+	WaitForCancel bool `json:"wait_for_cancel,omitempty"`
+}
+
+// ReplayRequest is what the replay provider received for one turn.
+type ReplayRequest struct {
+	Messages []message.Message
+	Tools    []string
+}
+
+type replayFile struct {
+	Turns []ReplayTurn `json:"turns"`
+}
+
+// ReplayProvider is an agent.LLMProvider that answers every StreamResponse
+// call with the next scripted turn. It never talks to the network, so agent
+// tests can run offline and deterministically.
+type ReplayProvider struct {
+	model agent.ModelInfo
+
+	mu       sync.Mutex
+	turns    []ReplayTurn
+	requests []ReplayRequest
+}
+
+// NewReplayProvider creates a provider that plays the given turns in order.
+func NewReplayProvider(model agent.ModelInfo, turns ...ReplayTurn) *ReplayProvider {
+	return &ReplayProvider{
+		model: model,
+		turns: turns,
+	}
+}
+
+// LoadReplayTurns reads the turns from a fixture written by Recorder.Save.
+func LoadReplayTurns(path string) ([]ReplayTurn, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay fixture: %w", err)
+	}
+	var file replayFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode replay fixture %s: %w", path, err)
+	}
+	return file.Turns, nil
+}
+
+func (r *ReplayProvider) Model() agent.ModelInfo {
+	return r.model
+}
+
+// Requests returns the history and tool names passed to each call so far.
+func (r *ReplayProvider) Requests() []ReplayRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.requests)
+}
+
+// Remaining returns the number of turns that have not been played yet.
+func (r *ReplayProvider) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.turns)
+}
+
+func (r *ReplayProvider) StreamResponse(ctx context.Context, messages []message.Message, availableTools []tools.BaseTool) <-chan agent.ProviderEvent {
+	toolNames := make([]string, len(availableTools))
+	for i, tool := range availableTools {
+		toolNames[i] = tool.Name()
+	}
+
+	r.mu.Lock()
+	r.requests = append(r.requests, ReplayRequest{Messages: slices.Clone(messages), Tools: toolNames})
+	request := len(r.requests)
+	var (
+		turn ReplayTurn
+		ok   bool
+	)
+	if len(r.turns) > 0 {
+		turn, r.turns, ok = r.turns[0], r.turns[1:], true
+	}
+	r.mu.Unlock()
+
+	eventChan := make(chan agent.ProviderEvent)
+	go func() {
+		defer close(eventChan)
+
+		if !ok {
+			send(ctx, eventChan, agent.ProviderEvent{Type: agent.EventError, Error: fmt.Errorf("replay: no scripted turn left for request %d", request)})
+			return
+		}
+		for _, event := range turn.Events {
+			if !send(ctx, eventChan, event.providerEvent()) {
+				return
+			}
+		}
+		if turn.WaitForCancel {
+			<-ctx.Done()
+		}
+	}()
+
+	return eventChan
+}
+
+func (e ReplayEvent) providerEvent() agent.ProviderEvent {
+	event := agent.ProviderEvent{
+		Type:      e.Type,
+		Content:   e.Content,
+		Thinking:  e.Thinking,
+		Signature: e.Signature,
+		Response:  e.Response,
+	}
+	if e.ToolCall != nil {
+		call := *e.ToolCall
+		event.ToolCall = &call
+	}
+	if e.Error != "" {
+		event.Error = errors.New(e.Error)
+	}
+	return event
+}
+
+func replayEvent(event agent.ProviderEvent) ReplayEvent {
+	e := ReplayEvent{
+		Type:      event.Type,
+		Content:   event.Content,
+		Thinking:  event.Thinking,
+		Signature: event.Signature,
+		ToolCall:  event.ToolCall,
+		Response:  event.Response,
+	}
+	if event.Error != nil {
+		e.Error = event.Error.Error()
+	}
+	return e
+}
+
+// TextTurn scripts a turn that streams content and ends the turn.
+func TextTurn(content string) ReplayTurn {
+	return ReplayTurn{
+		Events: []ReplayEvent{
+			{Type: agent.EventContentDelta, Content: content},
+			{Type: agent.EventComplete, Response: &agent.ProviderResponse{
+				Content:      content,
+				FinishReason: message.FinishReasonEndTurn,
+			}},
+		},
+	}
+}
+
+// ToolUseTurn scripts a turn that streams the given tool calls and asks for
+// their results.
+func ToolUseTurn(calls ...tools.ToolCall) ReplayTurn {
+	var events []ReplayEvent
+	for _, call := range calls {
+		events = append(events,
+			ReplayEvent{Type: agent.EventToolUseStart, ToolCall: &tools.ToolCall{ID: call.ID, Name: call.Name}},
+			ReplayEvent{Type: agent.EventToolUseDelta, ToolCall: &tools.ToolCall{ID: call.ID, Input: call.Input}},
+			ReplayEvent{Type: agent.EventToolUseStop, ToolCall: &tools.ToolCall{ID: call.ID}},
+		)
+	}
+	events = append(events, ReplayEvent{Type: agent.EventComplete, Response: &agent.ProviderResponse{
+		FinishReason: message.FinishReasonToolUse,
+		ToolCalls:    calls,
+	}})
+	return ReplayTurn{Events: events}
+}
+
+// ErrorTurn scripts a turn that fails with the given provider error.
+func ErrorTurn(err string) ReplayTurn {
+	return ReplayTurn{
+		Events: []ReplayEvent{{Type: agent.EventError, Error: err}},
+	}
+}
+
+// Recorder wraps a provider and captures the events of every call so they
+// can be saved as a fixture for ReplayProvider.
+type Recorder struct {
+	provider agent.LLMProvider
+
+	mu    sync.Mutex
+	turns []ReplayTurn
+}
+
+// NewRecorder creates a recorder around provider.
+func NewRecorder(provider agent.LLMProvider) *Recorder {
+	return &Recorder{provider: provider}
+}
+
+func (r *Recorder) Model() agent.ModelInfo {
+	return r.provider.Model()
+}
+
+func (r *Recorder) StreamResponse(ctx context.Context, messages []message.Message, availableTools []tools.BaseTool) <-chan agent.ProviderEvent {
+	upstream := r.provider.StreamResponse(ctx, messages, availableTools)
+	eventChan := make(chan agent.ProviderEvent)
+
+	go func() {
+		defer close(eventChan)
+
+		var (
+			turn     ReplayTurn
+			finished bool
+		)
+		// The turn is stored even when the consumer goes away so that a
+		// canceled request is recorded as one.
+		defer func() {
+			turn.WaitForCancel = !finished && ctx.Err() != nil
+			r.mu.Lock()
+			r.turns = append(r.turns, turn)
+			r.mu.Unlock()
+		}()
+
+		for event := range upstream {
+			turn.Events = append(turn.Events, replayEvent(event))
+			if event.Type == agent.EventComplete || event.Type == agent.EventError {
+				finished = true
+			}
+			if !send(ctx, eventChan, event) {
+				return
+			}
+		}
+	}()
+
+	return eventChan
+}
+
+// Turns returns the turns recorded so far.
+func (r *Recorder) Turns() []ReplayTurn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.turns)
+}
+
+// Save writes the recorded turns to path in the format read by
+// LoadReplayTurns.
+func (r *Recorder) Save(path string) error {
+	data, err := json.MarshalIndent(replayFile{Turns: r.Turns()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode replay fixture: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write replay fixture: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"gentica/config"
+	"gentica/llm/agent"
+	"gentica/llm/tools"
+	"gentica/message"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayProvider(t *testing.T) {
+	t.Parallel()
+
+	t.Run("plays turns in order and records requests", func(t *testing.T) {
+		call := tools.ToolCall{ID: "call_1", Name: "view", Input: `{"path":"a.go"}`}
+		p := NewReplayProvider(agent.ModelInfo{ID: "replay"}, ToolUseTurn(call), TextTurn("done"))
+
+		events := collect(p.StreamResponse(context.Background(), []message.Message{userMessage("read a.go")}, []tools.BaseTool{stubTool{name: "view"}}))
+		require.Len(t, events, 4)
+		require.Equal(t, agent.EventToolUseStart, events[0].Type)
+		require.Equal(t, []tools.ToolCall{call}, events[3].Response.ToolCalls)
+
+		events = collect(p.StreamResponse(context.Background(), nil, nil))
+		require.Equal(t, "done", events[len(events)-1].Response.Content)
+		require.Zero(t, p.Remaining())
+
+		events = collect(p.StreamResponse(context.Background(), nil, nil))
+		require.Len(t, events, 1)
+		require.EqualError(t, events[0].Error, "replay: no scripted turn left for request 3")
+
+		requests := p.Requests()
+		require.Len(t, requests, 3)
+		require.Equal(t, []string{"view"}, requests[0].Tools)
+		require.Equal(t, "read a.go", requests[0].Messages[0].Content().String())
+	})
+
+	t.Run("holds the stream open until canceled", func(t *testing.T) {
+		p := NewReplayProvider(agent.ModelInfo{}, ReplayTurn{
+			Events:        []ReplayEvent{{Type: agent.EventContentDelta, Content: "partial"}},
+			WaitForCancel: true,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		events := p.StreamResponse(ctx, nil, nil)
+		require.Equal(t, "partial", (<-events).Content)
+		cancel()
+		_, open := <-events
+		require.False(t, open)
+	})
+
+	t.Run("replays a recorded stream", func(t *testing.T) {
+		server := sseServer(t, []string{
+			`{"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"Hello"}}]}`,
+			`{"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":" world"},"finish_reason":"stop"}]}`,
+			`{"id":"1","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2}}`,
+			`[DONE]`,
+		}, nil, nil)
+		defer server.Close()
+
+		recorder := NewRecorder(NewOpenAIProvider(config.ProviderConfig{BaseURL: server.URL, APIKey: "test"}, WithModel(agent.ModelInfo{ID: "gpt-test"})))
+		live := collect(recorder.StreamResponse(context.Background(), []message.Message{userMessage("hi")}, nil))
+
+		path := filepath.Join(t.TempDir(), "fixture.json")
+		require.NoError(t, recorder.Save(path))
+		turns, err := LoadReplayTurns(path)
+		require.NoError(t, err)
+		require.Equal(t, recorder.Turns(), turns)
+
+		replayed := collect(NewReplayProvider(recorder.Model(), turns...).StreamResponse(context.Background(), nil, nil))
+		require.Equal(t, live, replayed)
+	})
+
+	t.Run("records canceled streams", func(t *testing.T) {
+		recorder := NewRecorder(NewReplayProvider(agent.ModelInfo{}, ReplayTurn{
+			Events:        []ReplayEvent{{Type: agent.EventThinkingDelta, Thinking: "hmm"}},
+			WaitForCancel: true,
+		}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		events := recorder.StreamResponse(ctx, nil, nil)
+		<-events
+		cancel()
+		for range events {
+		}
+
+		require.Len(t, recorder.Turns(), 1)
+		turn := recorder.Turns()[0]
+		require.True(t, turn.WaitForCancel)
+		require.Equal(t, []ReplayEvent{{Type: agent.EventThinkingDelta, Thinking: "hmm"}}, turn.Events)
+	})
+}
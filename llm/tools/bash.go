@@ -1,18 +1,26 @@
 package tools
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
-	"strings"
+	"sync"
 	"time"
+
+	"gentica/llm/policy"
+	"gentica/permission"
 )
 
 type BashParams struct {
-	Command string `json:"command"`
-	Timeout int    `json:"timeout"`
+	Command         string `json:"command"`
+	Timeout         int    `json:"timeout"`
+	Reset           bool   `json:"reset"`
+	RunInBackground bool   `json:"run_in_background"`
+}
+
+type BashPermissionsParams struct {
+	Command         string `json:"command"`
+	RunInBackground bool   `json:"run_in_background,omitempty"`
 }
 
 type BashResponseMetadata struct {
@@ -20,10 +28,28 @@ type BashResponseMetadata struct {
 	EndTime          int64  `json:"end_time"`
 	Output           string `json:"output"`
 	WorkingDirectory string `json:"working_directory"`
+	// JobID is set for commands run in the background.
+	JobID string `json:"job_id,omitempty"`
+	// Sandbox is how the command was confined, nil when it was not.
+	Sandbox *SandboxConfig `json:"sandbox,omitempty"`
 }
 
 type bashTool struct {
-	workingDir string
+	permissions permission.Service
+	commands    *policy.Policy
+	jobs        *Jobs
+	sandbox     *Sandbox
+	workingDir  string
+
+	// shells are the persistent shells of the sessions.
+	shells     map[string]*sessionShell
+	shellMutex sync.Mutex
+}
+
+// sessionShell is the shell of a session, whose commands run one at a time.
+type sessionShell struct {
+	mu sync.Mutex
+	*shell
 }
 
 const (
@@ -44,48 +70,66 @@ WHEN TO USE THIS TOOL:
 HOW TO USE:
 - Provide the command you want to execute
 - Optionally specify a timeout in milliseconds (default: 1 minute, max: 10 minutes)
+- Set reset to start over with a fresh shell, with or without a command
+- Set run_in_background for dev servers, watchers and other long-running
+  commands: a job ID is returned immediately, to use with the job_output,
+  job_input and job_kill tools
 
 FEATURES:
-- Executes commands in a shell environment
+- Executes commands in a persistent shell: the working directory, exported
+  variables and activated virtualenvs carry over between calls
+- A timed out command is stopped, the shell and the jobs started with &
+  are kept
+- Background jobs start in the shell's working directory and environment
 - Captures stdout and stderr
 - Returns exit code information
 - Enforces timeout limits
 
 LIMITATIONS:
-- Some commands are blocked for security (like curl, wget)
+- Some commands are blocked for security (like curl, wget), wherever they
+  appear in the command: in pipelines, subshells or substitutions
+- Commands other than read-only ones like ls, pwd or git status, and
+  commands redirecting output to files, may need the user's permission
+- When sandboxed, commands can only write to the working directory and
+  $TMPDIR, may not reach the network, and have limited CPU time, memory and
+  processes
 - Cannot run interactive commands
 - Output is truncated if exceeds 30000 characters
 
 TIPS:
 - Use semicolons or && to chain multiple commands
 - Check exit codes for command success/failure
-- Be mindful of the working directory`
+- Use cd to change the working directory; it is reported after every command`
 )
 
-// Simple list of banned commands for security
-var bannedCommands = []string{
-	// Network tools
-	"curl", "wget", "nc", "netcat", "telnet", "ssh", "scp", "sftp", "ftp", "rsync", "nmap",
-	// Privilege escalation
-	"sudo", "su", "doas",
-	// Permission and ownership changes
-	"chmod", "chown", "chgrp",
-	// Dangerous destructive commands
-	"rm -rf /", "rm -rf /*", "dd if=/dev/zero", "dd if=/dev/random",
-	// System control
-	"shutdown", "reboot", "halt", "poweroff", "init",
-	// Process control (dangerous variants)
-	"kill -9 -1", "pkill -9", "killall -9",
-	// Disk and filesystem operations
-	"mkfs", "fdisk", "parted", "format",
-	// Package managers (global installs)
-	"apt install", "yum install", "brew install", "pip install --system",
-	"npm install -g", "gem install",
-}
-
-func NewBashTool(workingDir string) BaseTool {
+// BashOptions configures the bash tool. Each of them may be left unset.
+type BashOptions struct {
+	// Permissions are asked about the commands the policy asks about. Such
+	// commands run without asking when it is nil.
+	Permissions permission.Service
+	// Commands is the policy commands are checked against, policy.Default
+	// when nil.
+	Commands *policy.Policy
+	// Jobs are where commands run in the background are added. Commands
+	// cannot run in the background when it is nil.
+	Jobs *Jobs
+	// Sandbox confines commands unless it is nil.
+	Sandbox *Sandbox
+}
+
+// NewBashTool returns the bash tool, which runs commands in workingDir.
+func NewBashTool(workingDir string, opts BashOptions) BaseTool {
+	commands := opts.Commands
+	if commands == nil {
+		commands = policy.Default()
+	}
 	return &bashTool{
-		workingDir: workingDir,
+		permissions: opts.Permissions,
+		commands:    commands,
+		jobs:        opts.Jobs,
+		sandbox:     opts.Sandbox,
+		workingDir:  workingDir,
+		shells:      make(map[string]*sessionShell),
 	}
 }
 
@@ -106,6 +150,14 @@ func (b *bashTool) Info() ToolInfo {
 				"type":        "number",
 				"description": "Optional timeout in milliseconds (max 600000)",
 			},
+			"reset": map[string]any{
+				"type":        "boolean",
+				"description": "Start a fresh shell in the original working directory before running the command",
+			},
+			"run_in_background": map[string]any{
+				"type":        "boolean",
+				"description": "Run the command in the background and return a job ID without waiting for it",
+			},
 		},
 		Required: []string{"command"},
 	}
@@ -117,18 +169,49 @@ func (b *bashTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error)
 		return NewTextErrorResponse("invalid parameters: " + err.Error()), nil
 	}
 
+	sessionID, _ := GetContextValues(ctx)
+	if params.Reset {
+		b.resetShell(sessionID)
+		if params.Command == "" {
+			return NewTextResponse("Shell reset"), nil
+		}
+	}
 	if params.Command == "" {
 		return NewTextErrorResponse("command is required"), nil
 	}
 
-	// Check for banned commands
-	cmdLower := strings.ToLower(params.Command)
-	for _, banned := range bannedCommands {
-		if strings.Contains(cmdLower, banned) {
-			return NewTextErrorResponse(fmt.Sprintf("command '%s' is not allowed for security reasons", banned)), nil
+	decision, err := b.commands.Check(params.Command)
+	if err != nil {
+		return NewTextErrorResponse("failed to parse command: " + err.Error()), nil
+	}
+	switch decision.Action {
+	case policy.Deny:
+		return NewTextErrorResponse(fmt.Sprintf("command '%s' is not allowed for security reasons: %s", decision.Command, decision.Reason)), nil
+	case policy.Ask:
+		if b.permissions == nil {
+			break
+		}
+		err := b.permissions.Request(ctx, permission.CreatePermissionRequest{
+			SessionID:   sessionID,
+			ToolCallID:  call.ID,
+			ToolName:    BashToolName,
+			Action:      "execute",
+			Description: fmt.Sprintf("Execute command: %s", params.Command),
+			Params: BashPermissionsParams{
+				Command:         params.Command,
+				RunInBackground: params.RunInBackground,
+			},
+			Path: b.workingDir,
+		})
+		if err != nil {
+			return ToolResponse{}, err
 		}
 	}
 
+	if params.RunInBackground {
+		return b.runInBackground(ctx, sessionID, params.Command)
+	}
+
 	// Set timeout
 	timeout := DefaultTimeout
 	if params.Timeout > 0 {
@@ -143,30 +226,28 @@ func (b *bashTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error)
 	cmdCtx, cancel := context.WithTimeout(ctx, timeoutDuration)
 	defer cancel()
 
+	sh, err := b.shell(sessionID)
+	if err != nil {
+		return ToolResponse{}, fmt.Errorf("failed to start shell: %w", err)
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
 	// Execute command
 	startTime := time.Now().UnixMilli()
-	
-	// Use sh -c to execute the command in a shell
-	cmd := exec.CommandContext(cmdCtx, "sh", "-c", params.Command)
-	cmd.Dir = b.workingDir
-	
-	// Capture output
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	
-	// Run the command
-	err := cmd.Run()
-	
+	result, err := sh.run(cmdCtx, params.Command)
+	if err != nil {
+		return ToolResponse{}, fmt.Errorf("failed to execute command: %w", err)
+	}
 	endTime := time.Now().UnixMilli()
-	
+
 	// Combine output
-	output := stdout.String()
-	if stderr.Len() > 0 {
+	output := result.Stdout
+	if result.Stderr != "" {
 		if output != "" {
 			output += "\n"
 		}
-		output += stderr.String()
+		output += result.Stderr
 	}
 	
 	// Truncate if too long
@@ -187,31 +268,22 @@ func (b *bashTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error)
 				StartTime:        startTime,
 				EndTime:          endTime,
 				Output:           output,
-				WorkingDirectory: b.workingDir,
+				WorkingDirectory: result.Dir,
+				Sandbox:          b.sandboxConfig(),
 			},
 		), nil
 	}
 	
-	// Get exit code
-	exitCode := 0
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
-		} else {
-			return ToolResponse{}, fmt.Errorf("failed to execute command: %w", err)
-		}
-	}
-	
 	// Format result - non-zero exit code is considered an error
-	if exitCode != 0 {
-		result := fmt.Sprintf("%s\n\nExit code: %d", output, exitCode)
+	if result.ExitCode != 0 {
 		return WithResponseMetadata(
-			NewTextErrorResponse(result),
+			NewTextErrorResponse(fmt.Sprintf("%s\n\nExit code: %d", output, result.ExitCode)),
 			BashResponseMetadata{
 				StartTime:        startTime,
 				EndTime:          endTime,
 				Output:           output,
-				WorkingDirectory: b.workingDir,
+				WorkingDirectory: result.Dir,
+				Sandbox:          b.sandboxConfig(),
 			},
 		), nil
 	}
@@ -222,7 +294,100 @@ func (b *bashTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error)
 			StartTime:        startTime,
 			EndTime:          endTime,
 			Output:           output,
-			WorkingDirectory: b.workingDir,
+			WorkingDirectory: result.Dir,
+			Sandbox:          b.sandboxConfig(),
 		},
 	), nil
-}
\ No newline at end of file
+}
+
+// shell returns the shell of the session, starting one when the session has
+// none or its shell exited. A restarted shell starts in the directory the
+// old one was in.
+func (b *bashTool) shell(sessionID string) (*sessionShell, error) {
+	b.shellMutex.Lock()
+	defer b.shellMutex.Unlock()
+	dir := b.workingDir
+	if sh, ok := b.shells[sessionID]; ok {
+		if sh.alive() {
+			return sh, nil
+		}
+		dir = sh.dir
+		sh.close()
+	}
+	s, err := newShell(dir, b.sandbox)
+	if err != nil {
+		return nil, err
+	}
+	sh := &sessionShell{shell: s}
+	b.shells[sessionID] = sh
+	return sh, nil
+}
+
+// resetShell kills the shell of the session, so that the next command starts
+// a fresh one in the working directory.
+func (b *bashTool) resetShell(sessionID string) {
+	b.shellMutex.Lock()
+	sh, ok := b.shells[sessionID]
+	delete(b.shells, sessionID)
+	b.shellMutex.Unlock()
+	if ok {
+		sh.close()
+	}
+}
+
+// runInBackground starts command as a job, in the working directory and
+// environment of the session's shell.
+func (b *bashTool) runInBackground(ctx context.Context, sessionID, command string) (ToolResponse, error) {
+	if b.jobs == nil {
+		return NewTextErrorResponse("running commands in the background is not available"), nil
+	}
+	sh, err := b.shell(sessionID)
+	if err != nil {
+		return ToolResponse{}, fmt.Errorf("failed to start shell: %w", err)
+	}
+	sh.mu.Lock()
+	env, err := sh.environ(ctx)
+	dir := sh.dir
+	sh.mu.Unlock()
+	if err != nil {
+		return ToolResponse{}, fmt.Errorf("failed to read the shell's environment: %w", err)
+	}
+
+	startTime := time.Now().UnixMilli()
+	jb, err := b.jobs.start(sessionID, command, dir, env, b.sandbox)
+	if err != nil {
+		return ToolResponse{}, fmt.Errorf("failed to start command: %w", err)
+	}
+	return WithResponseMetadata(
+		NewTextResponse(fmt.Sprintf("Started job %s in the background. Use %s to read its output, %s to write to its stdin and %s to stop it.",
+			jb.id, JobOutputToolName, JobInputToolName, JobKillToolName)),
+		BashResponseMetadata{
+			StartTime:        startTime,
+			WorkingDirectory: dir,
+			JobID:            jb.id,
+			Sandbox:          b.sandboxConfig(),
+		},
+	), nil
+}
+
+// sandboxConfig returns how commands are confined, nil when they are not.
+func (b *bashTool) sandboxConfig() *SandboxConfig {
+	if b.sandbox == nil {
+		return nil
+	}
+	config := b.sandbox.Config()
+	return &config
+}
+
+// CancelSession kills the background jobs of the session.
+func (b *bashTool) CancelSession(sessionID string) {
+	if b.jobs != nil {
+		b.jobs.KillSession(sessionID)
+	}
+}
+
+// CloseSession kills the background jobs and the shell of the session.
+func (b *bashTool) CloseSession(sessionID string) {
+	b.CancelSession(sessionID)
+	b.resetShell(sessionID)
+}
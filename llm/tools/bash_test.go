@@ -3,16 +3,21 @@ package tools
 import (
 	"context"
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
 
+	"gentica/permission"
+	"gentica/pubsub"
+
 	"github.com/stretchr/testify/require"
 )
 
 func TestBashTool(t *testing.T) {
 	t.Parallel()
 	tempDir := t.TempDir()
-	bashTool := NewBashTool(tempDir)
+	bashTool := NewBashTool(tempDir, BashOptions{})
 
 	t.Run("basic command execution", func(t *testing.T) {
 		params := BashParams{
@@ -96,6 +101,7 @@ func TestBashTool(t *testing.T) {
 		require.NoError(t, err)
 		require.True(t, response.IsError)
 		require.Contains(t, response.Content, "not allowed for security reasons")
+		require.Contains(t, response.Content, `the rule deny "curl"`)
 	})
 
 	t.Run("timeout", func(t *testing.T) {
@@ -138,4 +144,121 @@ func TestBashTool(t *testing.T) {
 		require.True(t, response.IsError)
 		require.Contains(t, response.Content, "invalid parameters")
 	})
-}
\ No newline at end of file
+}
+func TestBashToolShell(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("persistent shells need sh")
+	}
+	tempDir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(tempDir, "pkg"), 0o755))
+	bashTool := NewBashTool(tempDir, BashOptions{})
+
+	run := func(t *testing.T, sessionID string, params BashParams) ToolResponse {
+		t.Helper()
+		paramsJSON, err := json.Marshal(params)
+		require.NoError(t, err)
+		ctx := context.WithValue(context.Background(), SessionIDContextKey, sessionID)
+		response, err := bashTool.Run(ctx, ToolCall{Input: string(paramsJSON)})
+		require.NoError(t, err)
+		return response
+	}
+	workingDir := func(t *testing.T, response ToolResponse) string {
+		t.Helper()
+		var metadata BashResponseMetadata
+		require.NoError(t, json.Unmarshal([]byte(response.Metadata), &metadata))
+		return metadata.WorkingDirectory
+	}
+
+	t.Run("keeps the directory and environment", func(t *testing.T) {
+		response := run(t, "a", BashParams{Command: "cd pkg && export GREETING=hello"})
+		require.False(t, response.IsError)
+		require.Equal(t, filepath.Join(tempDir, "pkg"), workingDir(t, response))
+
+		response = run(t, "a", BashParams{Command: `echo "$GREETING from $(basename "$PWD")"`})
+		require.False(t, response.IsError)
+		require.Equal(t, "hello from pkg\n", response.Content)
+	})
+
+	t.Run("isolates sessions", func(t *testing.T) {
+		response := run(t, "b", BashParams{Command: `echo "[$GREETING]"`})
+		require.Equal(t, "[]\n", response.Content)
+		require.Equal(t, tempDir, workingDir(t, response))
+	})
+
+	t.Run("keeps the shell after a timeout", func(t *testing.T) {
+		run(t, "c", BashParams{Command: "cd pkg"})
+		response := run(t, "c", BashParams{Command: "sleep 10", Timeout: 100})
+		require.True(t, response.IsError)
+		require.Contains(t, response.Content, "Command timed out after")
+
+		response = run(t, "c", BashParams{Command: "pwd"})
+		require.Equal(t, filepath.Join(tempDir, "pkg")+"\n", response.Content)
+	})
+
+	t.Run("keeps background jobs after a timeout", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("Windows has no process groups")
+		}
+		run(t, "f", BashParams{Command: "sleep 30 & job=$!"})
+		response := run(t, "f", BashParams{Command: "sleep 10", Timeout: 100})
+		require.Contains(t, response.Content, "Command timed out after")
+
+		response = run(t, "f", BashParams{Command: "kill -0 $job && echo running; kill $job"})
+		require.Equal(t, "running\n", response.Content)
+	})
+
+	t.Run("restarts an exited shell where it was", func(t *testing.T) {
+		run(t, "d", BashParams{Command: "cd pkg"})
+		response := run(t, "d", BashParams{Command: "exit 3"})
+		require.True(t, response.IsError)
+		require.Contains(t, response.Content, "Exit code: 3")
+
+		response = run(t, "d", BashParams{Command: "pwd"})
+		require.Equal(t, filepath.Join(tempDir, "pkg")+"\n", response.Content)
+	})
+
+	t.Run("resets the shell", func(t *testing.T) {
+		run(t, "e", BashParams{Command: "cd pkg && export GREETING=hello"})
+		response := run(t, "e", BashParams{Reset: true})
+		require.False(t, response.IsError)
+
+		response = run(t, "e", BashParams{Command: `echo "[$GREETING]"`})
+		require.Equal(t, "[]\n", response.Content)
+		require.Equal(t, tempDir, workingDir(t, response))
+	})
+}
+
+func TestBashToolPermissions(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	permissions := permission.NewPermissionService(tempDir, permission.Options{})
+	requests := permissions.Subscribe(t.Context())
+	bashTool := NewBashTool(tempDir, BashOptions{Permissions: permissions})
+
+	run := func(command string) (ToolResponse, error) {
+		paramsJSON, err := json.Marshal(BashParams{Command: command})
+		require.NoError(t, err)
+		ctx := context.WithValue(context.Background(), SessionIDContextKey, "session")
+		return bashTool.Run(ctx, ToolCall{ID: "call", Input: string(paramsJSON)})
+	}
+
+	t.Run("runs read-only commands without asking", func(t *testing.T) {
+		response, err := run("pwd && git status 2>/dev/null; echo done")
+		require.NoError(t, err)
+		require.Contains(t, response.Content, "done")
+		require.Empty(t, requests)
+	})
+
+	t.Run("asks about other commands", func(t *testing.T) {
+		go func() {
+			event := <-requests
+			if event.Type == pubsub.CreatedEvent {
+				permissions.Deny(event.Payload)
+			}
+		}()
+		_, err := run("touch file")
+		require.ErrorIs(t, err, permission.ErrPermissionDenied)
+		require.NoFileExists(t, filepath.Join(tempDir, "file"))
+	})
+}
@@ -10,6 +10,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"gentica/permission"
 )
 
 type DownloadParams struct {
@@ -19,8 +21,9 @@ type DownloadParams struct {
 }
 
 type downloadTool struct {
-	client     *http.Client
-	workingDir string
+	client      *http.Client
+	permissions permission.Service
+	workingDir  string
 }
 
 const (
@@ -56,7 +59,7 @@ TIPS:
 - Set appropriate timeouts for large files or slow connections`
 )
 
-func NewDownloadTool(workingDir string) BaseTool {
+func NewDownloadTool(permissions permission.Service, workingDir string) BaseTool {
 	return &downloadTool{
 		client: &http.Client{
 			Timeout: 5 * time.Minute, // Default 5 minute timeout for downloads
@@ -66,7 +69,8 @@ func NewDownloadTool(workingDir string) BaseTool {
 				IdleConnTimeout:     90 * time.Second,
 			},
 		},
-		workingDir: workingDir,
+		permissions: permissions,
+		workingDir:  workingDir,
 	}
 }
 
@@ -122,7 +126,19 @@ func (t *downloadTool) Run(ctx context.Context, call ToolCall) (ToolResponse, er
 		filePath = filepath.Join(t.workingDir, params.FilePath)
 	}
 
-	// Permission check removed - tool executes directly
+	sessionID, _ := GetContextValues(ctx)
+	err := t.permissions.Request(ctx, permission.CreatePermissionRequest{
+		SessionID:   sessionID,
+		ToolCallID:  call.ID,
+		ToolName:    DownloadToolName,
+		Action:      "download",
+		Description: fmt.Sprintf("Download file from URL: %s to %s", params.URL, filePath),
+		Params:      params,
+		Path:        filePath,
+	})
+	if err != nil {
+		return ToolResponse{}, err
+	}
 
 	// Handle timeout with context
 	requestCtx := ctx
@@ -10,13 +10,15 @@ import (
 	"path/filepath"
 	"testing"
 
+	"gentica/permission"
+
 	"github.com/stretchr/testify/require"
 )
 
 func TestDownloadTool(t *testing.T) {
 	t.Parallel()
 	tempDir := t.TempDir()
-	downloadTool := NewDownloadTool(tempDir)
+	downloadTool := NewDownloadTool(permission.NewPermissionService(tempDir, permission.Options{SkipRequests: true}), tempDir)
 
 	t.Run("successful download", func(t *testing.T) {
 		// Create test server
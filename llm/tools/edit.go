@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"strings"
 	// "time" // commented out - not needed after removing read check
+
+	"gentica/history"
 )
 
 type EditParams struct {
@@ -26,6 +28,7 @@ type EditResponseMetadata struct {
 }
 
 type editTool struct {
+	files      history.Service
 	workingDir string
 }
 
@@ -84,8 +87,11 @@ WINDOWS NOTES:
 - On Windows, absolute paths start with drive letters (C:/) but forward slashes work throughout`
 )
 
-func NewEditTool(workingDir string) BaseTool {
+// NewEditTool returns the edit tool. Files it changes are saved to files,
+// unless it is nil.
+func NewEditTool(files history.Service, workingDir string) BaseTool {
 	return &editTool{
+		files:      files,
 		workingDir: workingDir,
 	}
 }
@@ -175,6 +181,15 @@ func (e *editTool) createNewFile(ctx context.Context, filePath, content string,
 		return ToolResponse{}, fmt.Errorf("failed to create parent directories: %w", err)
 	}
 
+	err = recordFileChange(ctx, e.files, history.Change{
+		Path:    filePath,
+		After:   content,
+		Created: true,
+	})
+	if err != nil {
+		return ToolResponse{}, fmt.Errorf("failed to save file history: %w", err)
+	}
+
 	// Write the file
 	err = os.WriteFile(filePath, []byte(content), 0o644)
 	if err != nil {
@@ -252,6 +267,15 @@ func (e *editTool) deleteContent(ctx context.Context, filePath, oldString string
 		return NewTextErrorResponse("no changes made to file"), nil
 	}
 
+	err = recordFileChange(ctx, e.files, history.Change{
+		Path:   filePath,
+		Before: oldContent,
+		After:  newContent,
+	})
+	if err != nil {
+		return ToolResponse{}, fmt.Errorf("failed to save file history: %w", err)
+	}
+
 	// Write the file
 	err = os.WriteFile(filePath, []byte(newContent), 0o644)
 	if err != nil {
@@ -329,6 +353,15 @@ func (e *editTool) replaceContent(ctx context.Context, filePath, oldString, newS
 		return NewTextErrorResponse("new content is the same as old content. No changes made."), nil
 	}
 
+	err = recordFileChange(ctx, e.files, history.Change{
+		Path:   filePath,
+		Before: oldContent,
+		After:  newContent,
+	})
+	if err != nil {
+		return ToolResponse{}, fmt.Errorf("failed to save file history: %w", err)
+	}
+
 	// Write the file
 	err = os.WriteFile(filePath, []byte(newContent), 0o644)
 	if err != nil {
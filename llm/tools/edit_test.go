@@ -13,7 +13,7 @@ import (
 func TestEditTool(t *testing.T) {
 	t.Parallel()
 	tempDir := t.TempDir()
-	editTool := NewEditTool(tempDir)
+	editTool := NewEditTool(nil, tempDir)
 
 	t.Run("create new file", func(t *testing.T) {
 		filePath := filepath.Join(tempDir, "new_file.txt")
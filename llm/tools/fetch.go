@@ -9,6 +9,8 @@ import (
 	"strings"
 	"time"
 	"unicode/utf8"
+
+	"gentica/permission"
 )
 
 type FetchParams struct {
@@ -24,8 +26,9 @@ type FetchPermissionsParams struct {
 }
 
 type fetchTool struct {
-	client     *http.Client
-	workingDir string
+	client      *http.Client
+	permissions permission.Service
+	workingDir  string
 }
 
 const (
@@ -60,7 +63,7 @@ TIPS:
 - Set appropriate timeouts for potentially slow websites`
 )
 
-func NewFetchTool(workingDir string) BaseTool {
+func NewFetchTool(permissions permission.Service, workingDir string) BaseTool {
 	return &fetchTool{
 		client: &http.Client{
 			Timeout: 30 * time.Second,
@@ -70,7 +73,8 @@ func NewFetchTool(workingDir string) BaseTool {
 				IdleConnTimeout:     90 * time.Second,
 			},
 		},
-		workingDir: workingDir,
+		permissions: permissions,
+		workingDir:  workingDir,
 	}
 }
 
@@ -98,6 +102,7 @@ func (t *fetchTool) Info() ToolInfo {
 			},
 		},
 		Required: []string{"url", "format"},
+		ReadOnly: true,
 	}
 }
 
@@ -124,7 +129,19 @@ func (t *fetchTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error
 		return NewTextErrorResponse("URL must start with http:// or https://"), nil
 	}
 
-	// Permission check removed - tool executes directly
+	sessionID, _ := GetContextValues(ctx)
+	err := t.permissions.Request(ctx, permission.CreatePermissionRequest{
+		SessionID:   sessionID,
+		ToolCallID:  call.ID,
+		ToolName:    FetchToolName,
+		Action:      "fetch",
+		Description: fmt.Sprintf("Fetch content from URL: %s", params.URL),
+		Params:      FetchPermissionsParams(params),
+		Path:        t.workingDir,
+	})
+	if err != nil {
+		return ToolResponse{}, err
+	}
 
 	// Handle timeout with context
 	requestCtx := ctx
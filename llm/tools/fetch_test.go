@@ -8,13 +8,15 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"gentica/permission"
+
 	"github.com/stretchr/testify/require"
 )
 
 func TestFetchTool(t *testing.T) {
 	t.Parallel()
 	tempDir := t.TempDir()
-	fetchTool := NewFetchTool(tempDir)
+	fetchTool := NewFetchTool(permission.NewPermissionService(tempDir, permission.Options{SkipRequests: true}), tempDir)
 
 	t.Run("fetch text format", func(t *testing.T) {
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
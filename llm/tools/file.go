@@ -1,8 +1,11 @@
 package tools
 
 import (
+	"context"
 	"sync"
 	"time"
+
+	"gentica/history"
 )
 
 // File record to track when files were read/written
@@ -51,3 +54,18 @@ func recordFileWrite(path string) {
 	record.writeTime = time.Now()
 	fileRecords[path] = record
 }
+
+// recordFileChange saves a change the tool is about to make to the file
+// history of the session in ctx, as made by the message in ctx. Nothing is
+// saved for tools without a history or calls outside of a session.
+func recordFileChange(ctx context.Context, files history.Service, change history.Change) error {
+	if files == nil {
+		return nil
+	}
+	sessionID, messageID := GetContextValues(ctx)
+	if sessionID == "" {
+		return nil
+	}
+	change.MessageID = messageID
+	return files.Record(ctx, sessionID, change)
+}
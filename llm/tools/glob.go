@@ -97,6 +97,7 @@ func (g *globTool) Info() ToolInfo {
 			},
 		},
 		Required: []string{"pattern"},
+		ReadOnly: true,
 	}
 }
 
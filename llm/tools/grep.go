@@ -170,6 +170,7 @@ func (g *grepTool) Info() ToolInfo {
 			},
 		},
 		Required: []string{"pattern"},
+		ReadOnly: true,
 	}
 }
 
@@ -0,0 +1,138 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // Registered for image.Decode, as are bmp and webp.
+	"image/jpeg"
+	"image/png"
+
+	_ "golang.org/x/image/bmp"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// MaxImageDimension is the longest side of the images sent to models.
+	// Larger images are scaled down, as the providers would do anyway.
+	MaxImageDimension = 1568
+	// MaxImageSize is the largest encoded image sent to models.
+	MaxImageSize = 3 * 1024 * 1024
+	// maxImagePixels guards against images that decompress to far more
+	// memory than their files take.
+	maxImagePixels = 100_000_000
+)
+
+// modelImage is an image prepared to be sent to a model.
+type modelImage struct {
+	data     []byte
+	mimeType string
+	format   string
+	// width and height are those of the original image, scaledWidth and
+	// scaledHeight those of the image sent.
+	width, height             int
+	scaledWidth, scaledHeight int
+}
+
+func (m modelImage) scaled() bool {
+	return m.width != m.scaledWidth || m.height != m.scaledHeight
+}
+
+// prepareImage returns the image to send to a model for the data of an
+// image file. Images that are too large, in bytes or pixels, are scaled
+// down, and formats the providers do not accept are converted to PNG.
+func prepareImage(data []byte) (modelImage, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return modelImage{}, fmt.Errorf("unsupported or invalid image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return modelImage{}, fmt.Errorf("invalid image size %dx%d", config.Width, config.Height)
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return modelImage{}, fmt.Errorf("image is too large (%dx%d pixels)", config.Width, config.Height)
+	}
+
+	result := modelImage{
+		format:       format,
+		width:        config.Width,
+		height:       config.Height,
+		scaledWidth:  config.Width,
+		scaledHeight: config.Height,
+	}
+	mimeType, accepted := imageMIMETypes[format]
+	if accepted && max(config.Width, config.Height) <= MaxImageDimension && len(data) <= MaxImageSize {
+		result.data, result.mimeType = data, mimeType
+		return result, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return modelImage{}, fmt.Errorf("invalid image: %w", err)
+	}
+	// Photos compress much better as JPEG, while PNG keeps the sharp edges
+	// and transparency of screenshots and diagrams.
+	encode, mimeType := encodePNG, "image/png"
+	if format == "jpeg" || format == "webp" {
+		encode, mimeType = encodeJPEG, "image/jpeg"
+	}
+	longest := min(max(config.Width, config.Height), MaxImageDimension)
+	for {
+		scaled := scaleImage(img, longest)
+		encoded, err := encode(scaled)
+		if err != nil {
+			return modelImage{}, fmt.Errorf("error encoding image: %w", err)
+		}
+		bounds := scaled.Bounds()
+		result.scaledWidth, result.scaledHeight = bounds.Dx(), bounds.Dy()
+		if len(encoded) <= MaxImageSize {
+			result.data, result.mimeType = encoded, mimeType
+			return result, nil
+		}
+		if longest <= 64 {
+			return modelImage{}, fmt.Errorf("image is too large to send (%d bytes)", len(encoded))
+		}
+		longest = longest * 3 / 4
+	}
+}
+
+// imageMIMETypes are the MIME types of the image formats that providers
+// accept, by the format names of the image package.
+var imageMIMETypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+// scaleImage scales img down so that its longest side is at most longest
+// pixels, keeping its aspect ratio.
+func scaleImage(img image.Image, longest int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if max(width, height) <= longest {
+		return img
+	}
+	if width >= height {
+		width, height = longest, max(height*longest/width, 1)
+	} else {
+		width, height = max(width*longest/height, 1), longest
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var b bytes.Buffer
+	err := png.Encode(&b, img)
+	return b.Bytes(), err
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var b bytes.Buffer
+	err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 85})
+	return b.Bytes(), err
+}
@@ -0,0 +1,480 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// maxJobOutput is how much unread output a job keeps of each stream. Older
+// output is dropped.
+const maxJobOutput = 1 << 20
+
+// Jobs are the commands the bash tool runs in the background. They are
+// shared with the tools that look after them, and belong to the session
+// that started them.
+type Jobs struct {
+	mu     sync.Mutex
+	jobs   map[string]*job
+	lastID int
+}
+
+func NewJobs() *Jobs {
+	return &Jobs{jobs: make(map[string]*job)}
+}
+
+type job struct {
+	id        string
+	sessionID string
+	command   string
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stdout    *jobOutput
+	stderr    *jobOutput
+	// done is closed when the job exited, with exitCode set.
+	done     chan struct{}
+	exitCode int
+}
+
+// start runs command in the background for the session, in dir and with
+// env, confined by sandbox unless it is nil.
+func (j *Jobs) start(sessionID, command, dir string, env []string, sandbox *Sandbox) (*job, error) {
+	cmd := exec.Command(shellPath(), "-c", command)
+	cmd.Dir = dir
+	cmd.Env = env
+	setProcessGroup(cmd)
+	// Do not wait for processes the job left behind.
+	cmd.WaitDelay = time.Second
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	jb := &job{
+		sessionID: sessionID,
+		command:   command,
+		cmd:       cmd,
+		stdin:     stdin,
+		stdout:    &jobOutput{},
+		stderr:    &jobOutput{},
+		done:      make(chan struct{}),
+	}
+	cmd.Stdout = jb.stdout
+	cmd.Stderr = jb.stderr
+	if err := sandbox.start(cmd); err != nil {
+		return nil, err
+	}
+	go func() {
+		cmd.Wait()
+		jb.exitCode = cmd.ProcessState.ExitCode()
+		close(jb.done)
+	}()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.lastID++
+	jb.id = fmt.Sprintf("job_%d", j.lastID)
+	j.jobs[jb.id] = jb
+	return jb, nil
+}
+
+// get returns the job of the session with the given ID.
+func (j *Jobs) get(sessionID, id string) (*job, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	jb, ok := j.jobs[id]
+	if !ok || jb.sessionID != sessionID {
+		return nil, false
+	}
+	return jb, true
+}
+
+// respond returns the job's status and the output it wrote since the last
+// read. A job that exited is forgotten once all its output has been read.
+func (j *Jobs) respond(jb *job) ToolResponse {
+	response, finished := jobResponse(jb)
+	if finished {
+		j.mu.Lock()
+		delete(j.jobs, jb.id)
+		j.mu.Unlock()
+	}
+	return response
+}
+
+// KillSession kills the jobs of the session and forgets them.
+func (j *Jobs) KillSession(sessionID string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for id, jb := range j.jobs {
+		if jb.sessionID != sessionID {
+			continue
+		}
+		if jb.running() {
+			signalGroup(jb.cmd, syscall.SIGKILL)
+		}
+		delete(j.jobs, id)
+	}
+}
+
+func (jb *job) running() bool {
+	select {
+	case <-jb.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// kill stops the job and the processes it started, forcibly when they do
+// not stop in time.
+func (jb *job) kill() {
+	if !jb.running() {
+		return
+	}
+	signalGroup(jb.cmd, syscall.SIGTERM)
+	select {
+	case <-jb.done:
+	case <-time.After(shellKillDelay):
+		signalGroup(jb.cmd, syscall.SIGKILL)
+		<-jb.done
+	}
+}
+
+// jobOutput keeps what a job wrote to a stream until it is read.
+type jobOutput struct {
+	mu      sync.Mutex
+	buf     []byte
+	dropped bool
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.buf = append(o.buf, p...)
+	if len(o.buf) > maxJobOutput {
+		o.buf = o.buf[len(o.buf)-maxJobOutput:]
+		o.dropped = true
+	}
+	return len(p), nil
+}
+
+// unread returns the output written since the last call, up to
+// MaxOutputLength bytes, and whether more is pending for the next call.
+func (o *jobOutput) unread() (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := len(o.buf)
+	if n > MaxOutputLength {
+		n = MaxOutputLength
+		for n > 0 && !utf8.RuneStart(o.buf[n]) {
+			n--
+		}
+	}
+	output := string(o.buf[:n])
+	if o.dropped {
+		output = "... (earlier output dropped)\n" + output
+	}
+	o.buf, o.dropped = o.buf[n:], false
+	if len(o.buf) == 0 {
+		// Let go of the memory of large outputs.
+		o.buf = nil
+		return output, false
+	}
+	return output + "\n... (more output pending)", true
+}
+
+type JobOutputParams struct {
+	JobID string `json:"job_id"`
+	Wait  int    `json:"wait"`
+}
+
+type JobInputParams struct {
+	JobID string `json:"job_id"`
+	Input string `json:"input"`
+	Close bool   `json:"close"`
+}
+
+type JobKillParams struct {
+	JobID string `json:"job_id"`
+}
+
+type JobResponseMetadata struct {
+	JobID    string `json:"job_id"`
+	Command  string `json:"command"`
+	Running  bool   `json:"running"`
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+}
+
+type jobOutputTool struct {
+	jobs *Jobs
+}
+
+type jobInputTool struct {
+	jobs *Jobs
+}
+
+type jobKillTool struct {
+	jobs *Jobs
+}
+
+const (
+	JobOutputToolName = "job_output"
+	JobInputToolName  = "job_input"
+	JobKillToolName   = "job_kill"
+
+	jobOutputDescription = `Reads the output of a command started with the bash tool in the background.
+
+WHEN TO USE THIS TOOL:
+- Use to check on dev servers, watchers or long test runs started with run_in_background
+- Helpful to find out whether a background job is still running and how it exited
+
+HOW TO USE:
+- Provide the job ID the bash tool returned
+- Optionally wait for the job to exit, in milliseconds (max 600000)
+
+FEATURES:
+- Returns only the stdout and stderr written since the last read
+- Reports whether the job is running, or its exit code
+
+LIMITATIONS:
+- Jobs belong to the session that started them
+- Returns at most 30000 characters of each stream; the rest is pending for
+  the next read
+- A job is forgotten once its exit code and all its output have been
+  returned`
+
+	jobInputDescription = `Writes to the stdin of a command started with the bash tool in the background.
+
+WHEN TO USE THIS TOOL:
+- Use to answer prompts of a background job or to feed it data
+
+HOW TO USE:
+- Provide the job ID the bash tool returned and the input to write
+- Include a trailing newline to send a line
+- Set close to signal the end of the input`
+
+	jobKillDescription = `Stops a command started with the bash tool in the background.
+
+WHEN TO USE THIS TOOL:
+- Use to stop dev servers and watchers you no longer need
+
+HOW TO USE:
+- Provide the job ID the bash tool returned
+
+FEATURES:
+- Stops the job and the processes it started
+- Returns the output the job wrote since the last read, and forgets the job
+  once all of it has been read`
+)
+
+func NewJobOutputTool(jobs *Jobs) BaseTool {
+	return &jobOutputTool{jobs: jobs}
+}
+
+func NewJobInputTool(jobs *Jobs) BaseTool {
+	return &jobInputTool{jobs: jobs}
+}
+
+func NewJobKillTool(jobs *Jobs) BaseTool {
+	return &jobKillTool{jobs: jobs}
+}
+
+func (t *jobOutputTool) Name() string {
+	return JobOutputToolName
+}
+
+func (t *jobOutputTool) Info() ToolInfo {
+	return ToolInfo{
+		Name:        JobOutputToolName,
+		Description: jobOutputDescription,
+		Parameters: map[string]any{
+			"job_id": map[string]any{
+				"type":        "string",
+				"description": "The ID of the background job",
+			},
+			"wait": map[string]any{
+				"type":        "number",
+				"description": "Optional time in milliseconds to wait for the job to exit (max 600000)",
+			},
+		},
+		Required: []string{"job_id"},
+	}
+}
+
+func (t *jobOutputTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error) {
+	var params JobOutputParams
+	if err := json.Unmarshal([]byte(call.Input), &params); err != nil {
+		return NewTextErrorResponse("invalid parameters: " + err.Error()), nil
+	}
+	jb, errResponse := findJob(ctx, t.jobs, params.JobID)
+	if jb == nil {
+		return errResponse, nil
+	}
+
+	if params.Wait > 0 {
+		wait := time.Duration(min(params.Wait, MaxTimeout)) * time.Millisecond
+		select {
+		case <-jb.done:
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ToolResponse{}, ctx.Err()
+		}
+	}
+	return t.jobs.respond(jb), nil
+}
+
+func (t *jobInputTool) Name() string {
+	return JobInputToolName
+}
+
+func (t *jobInputTool) Info() ToolInfo {
+	return ToolInfo{
+		Name:        JobInputToolName,
+		Description: jobInputDescription,
+		Parameters: map[string]any{
+			"job_id": map[string]any{
+				"type":        "string",
+				"description": "The ID of the background job",
+			},
+			"input": map[string]any{
+				"type":        "string",
+				"description": "The text to write to the job's stdin",
+			},
+			"close": map[string]any{
+				"type":        "boolean",
+				"description": "Close the job's stdin after writing the input",
+			},
+		},
+		Required: []string{"job_id"},
+	}
+}
+
+func (t *jobInputTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error) {
+	var params JobInputParams
+	if err := json.Unmarshal([]byte(call.Input), &params); err != nil {
+		return NewTextErrorResponse("invalid parameters: " + err.Error()), nil
+	}
+	jb, errResponse := findJob(ctx, t.jobs, params.JobID)
+	if jb == nil {
+		return errResponse, nil
+	}
+	if !jb.running() {
+		return NewTextErrorResponse(fmt.Sprintf("job %s exited with code %d", jb.id, jb.exitCode)), nil
+	}
+
+	if params.Input != "" {
+		// A job that does not read its stdin blocks the write once the
+		// pipe is full.
+		written := make(chan error, 1)
+		go func() {
+			_, err := io.WriteString(jb.stdin, params.Input)
+			written <- err
+		}()
+		select {
+		case err := <-written:
+			if err != nil {
+				return NewTextErrorResponse("failed to write to the job: " + err.Error()), nil
+			}
+		case <-jb.done:
+			return NewTextErrorResponse(fmt.Sprintf("job %s exited with code %d before reading the input", jb.id, jb.exitCode)), nil
+		case <-ctx.Done():
+			return ToolResponse{}, ctx.Err()
+		}
+	}
+	if params.Close {
+		if err := jb.stdin.Close(); err != nil {
+			return NewTextErrorResponse("failed to close the job's stdin: " + err.Error()), nil
+		}
+		return NewTextResponse(fmt.Sprintf("Wrote %d bytes to job %s and closed its stdin", len(params.Input), jb.id)), nil
+	}
+	return NewTextResponse(fmt.Sprintf("Wrote %d bytes to job %s", len(params.Input), jb.id)), nil
+}
+
+func (t *jobKillTool) Name() string {
+	return JobKillToolName
+}
+
+func (t *jobKillTool) Info() ToolInfo {
+	return ToolInfo{
+		Name:        JobKillToolName,
+		Description: jobKillDescription,
+		Parameters: map[string]any{
+			"job_id": map[string]any{
+				"type":        "string",
+				"description": "The ID of the background job",
+			},
+		},
+		Required: []string{"job_id"},
+	}
+}
+
+func (t *jobKillTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error) {
+	var params JobKillParams
+	if err := json.Unmarshal([]byte(call.Input), &params); err != nil {
+		return NewTextErrorResponse("invalid parameters: " + err.Error()), nil
+	}
+	jb, errResponse := findJob(ctx, t.jobs, params.JobID)
+	if jb == nil {
+		return errResponse, nil
+	}
+	jb.kill()
+	return t.jobs.respond(jb), nil
+}
+
+// findJob looks up the job of the tool call's session. It returns the error
+// response to give instead when there is no such job.
+func findJob(ctx context.Context, jobs *Jobs, id string) (*job, ToolResponse) {
+	if id == "" {
+		return nil, NewTextErrorResponse("job_id is required")
+	}
+	sessionID, _ := GetContextValues(ctx)
+	jb, ok := jobs.get(sessionID, id)
+	if !ok {
+		return nil, NewTextErrorResponse(fmt.Sprintf("job %s not found", id))
+	}
+	return jb, ToolResponse{}
+}
+
+// jobResponse reports the job's status and the output it wrote since the
+// last read, and whether the job exited and that was the last of its
+// output.
+func jobResponse(jb *job) (ToolResponse, bool) {
+	// Read the status first, so that no output written before the job
+	// exited is missed.
+	running := jb.running()
+	stdout, stdoutPending := jb.stdout.unread()
+	stderr, stderrPending := jb.stderr.unread()
+	metadata := JobResponseMetadata{
+		JobID:   jb.id,
+		Command: jb.command,
+		Running: running,
+		Stdout:  stdout,
+		Stderr:  stderr,
+	}
+	status := fmt.Sprintf("Job %s is running", jb.id)
+	if !running {
+		metadata.ExitCode = jb.exitCode
+		status = fmt.Sprintf("Job %s exited with code %d", jb.id, jb.exitCode)
+	}
+
+	var content strings.Builder
+	content.WriteString(status)
+	if metadata.Stdout == "" && metadata.Stderr == "" {
+		content.WriteString("\n\nNo new output")
+	}
+	if metadata.Stdout != "" {
+		fmt.Fprintf(&content, "\n\nSTDOUT:\n%s", metadata.Stdout)
+	}
+	if metadata.Stderr != "" {
+		fmt.Fprintf(&content, "\n\nSTDERR:\n%s", metadata.Stderr)
+	}
+	return WithResponseMetadata(NewTextResponse(content.String()), metadata), !running && !stdoutPending && !stderrPending
+}
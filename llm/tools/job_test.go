@@ -0,0 +1,129 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobs(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("background jobs need sh")
+	}
+	tempDir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(tempDir, "pkg"), 0o755))
+	jobs := NewJobs()
+	bashTool := NewBashTool(tempDir, BashOptions{Jobs: jobs})
+	outputTool := NewJobOutputTool(jobs)
+	inputTool := NewJobInputTool(jobs)
+	killTool := NewJobKillTool(jobs)
+
+	run := func(t *testing.T, tool BaseTool, sessionID string, params any) ToolResponse {
+		t.Helper()
+		paramsJSON, err := json.Marshal(params)
+		require.NoError(t, err)
+		ctx := context.WithValue(context.Background(), SessionIDContextKey, sessionID)
+		response, err := tool.Run(ctx, ToolCall{Input: string(paramsJSON)})
+		require.NoError(t, err)
+		return response
+	}
+	start := func(t *testing.T, sessionID, command string) string {
+		t.Helper()
+		response := run(t, bashTool, sessionID, BashParams{Command: command, RunInBackground: true})
+		require.False(t, response.IsError, response.Content)
+		var metadata BashResponseMetadata
+		require.NoError(t, json.Unmarshal([]byte(response.Metadata), &metadata))
+		require.NotEmpty(t, metadata.JobID)
+		return metadata.JobID
+	}
+	output := func(t *testing.T, sessionID string, params JobOutputParams) JobResponseMetadata {
+		t.Helper()
+		response := run(t, outputTool, sessionID, params)
+		require.False(t, response.IsError, response.Content)
+		var metadata JobResponseMetadata
+		require.NoError(t, json.Unmarshal([]byte(response.Metadata), &metadata))
+		return metadata
+	}
+
+	t.Run("runs in the shell's directory and environment", func(t *testing.T) {
+		run(t, bashTool, "a", BashParams{Command: "cd pkg && export GREETING=hello"})
+		jobID := start(t, "a", `echo "$GREETING from $(basename "$PWD")"; read line; echo "got $line"`)
+
+		response := run(t, inputTool, "a", JobInputParams{JobID: jobID, Input: "input\n"})
+		require.False(t, response.IsError, response.Content)
+
+		metadata := output(t, "a", JobOutputParams{JobID: jobID, Wait: 5000})
+		require.False(t, metadata.Running)
+		require.Zero(t, metadata.ExitCode)
+		require.Equal(t, "hello from pkg\ngot input\n", metadata.Stdout)
+
+		// The job is forgotten once its final output was read.
+		response = run(t, outputTool, "a", JobOutputParams{JobID: jobID})
+		require.True(t, response.IsError)
+		require.Contains(t, response.Content, "not found")
+	})
+
+	t.Run("reports running jobs and kills them", func(t *testing.T) {
+		jobID := start(t, "b", "echo started; sleep 30")
+		metadata := output(t, "b", JobOutputParams{JobID: jobID, Wait: 100})
+		require.True(t, metadata.Running)
+
+		response := run(t, killTool, "b", JobKillParams{JobID: jobID})
+		require.False(t, response.IsError, response.Content)
+		require.NoError(t, json.Unmarshal([]byte(response.Metadata), &metadata))
+		require.False(t, metadata.Running)
+		require.NotZero(t, metadata.ExitCode)
+
+		response = run(t, outputTool, "b", JobOutputParams{JobID: jobID})
+		require.True(t, response.IsError)
+		require.Contains(t, response.Content, "not found")
+	})
+
+	t.Run("keeps output past the limit for the next read", func(t *testing.T) {
+		jobID := start(t, "f", "head -c 45000 /dev/zero | tr '\\0' x")
+		metadata := output(t, "f", JobOutputParams{JobID: jobID, Wait: 5000})
+		require.False(t, metadata.Running)
+		require.Equal(t, strings.Repeat("x", MaxOutputLength)+"\n... (more output pending)", metadata.Stdout)
+
+		metadata = output(t, "f", JobOutputParams{JobID: jobID})
+		require.Equal(t, strings.Repeat("x", 45000-MaxOutputLength), metadata.Stdout)
+
+		response := run(t, outputTool, "f", JobOutputParams{JobID: jobID})
+		require.True(t, response.IsError)
+		require.Contains(t, response.Content, "not found")
+	})
+
+	t.Run("stops writing input when cancelled", func(t *testing.T) {
+		jobID := start(t, "e", "sleep 30")
+		ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), SessionIDContextKey, "e"), 100*time.Millisecond)
+		defer cancel()
+		// More than a pipe holds, which the job never reads.
+		input, err := json.Marshal(JobInputParams{JobID: jobID, Input: strings.Repeat("x", 1<<20)})
+		require.NoError(t, err)
+		_, err = inputTool.Run(ctx, ToolCall{Input: string(input)})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		response := run(t, killTool, "e", JobKillParams{JobID: jobID})
+		require.False(t, response.IsError, response.Content)
+	})
+
+	t.Run("keeps jobs to their session", func(t *testing.T) {
+		jobID := start(t, "c", "sleep 30")
+		response := run(t, outputTool, "d", JobOutputParams{JobID: jobID})
+		require.True(t, response.IsError)
+		require.Contains(t, response.Content, "not found")
+
+		bashTool.(SessionTool).CancelSession("c")
+		response = run(t, outputTool, "c", JobOutputParams{JobID: jobID})
+		require.True(t, response.IsError)
+		require.Contains(t, response.Content, "not found")
+	})
+}
@@ -98,6 +98,7 @@ func (l *lsTool) Info() ToolInfo {
 			},
 		},
 		Required: []string{},
+		ReadOnly: true,
 	}
 }
 
@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"strings"
 	// "time" // commented out - not needed after removing read check
+
+	"gentica/history"
 )
 
 type MultiEditOperation struct {
@@ -26,6 +28,7 @@ type MultiEditResponseMetadata struct {
 }
 
 type multiEditTool struct {
+	files      history.Service
 	workingDir string
 }
 
@@ -74,8 +77,11 @@ If you want to create a new file, use:
 - Subsequent edits: normal edit operations on the created content`
 )
 
-func NewMultiEditTool(workingDir string) BaseTool {
+// NewMultiEditTool returns the multiedit tool. Files it changes are saved
+// to files, unless it is nil.
+func NewMultiEditTool(files history.Service, workingDir string) BaseTool {
 	return &multiEditTool{
+		files:      files,
 		workingDir: workingDir,
 	}
 }
@@ -159,9 +165,9 @@ func (m *multiEditTool) Run(ctx context.Context, call ToolCall) (ToolResponse, e
 
 	// Handle file creation case (first edit has empty old_string)
 	if len(params.Edits) > 0 && params.Edits[0].OldString == "" {
-		response, err = m.processMultiEditWithCreation(params)
+		response, err = m.processMultiEditWithCreation(ctx, params)
 	} else {
-		response, err = m.processMultiEditExistingFile(params)
+		response, err = m.processMultiEditExistingFile(ctx, params)
 	}
 
 	if err != nil {
@@ -191,7 +197,7 @@ func (m *multiEditTool) validateEdits(edits []MultiEditOperation) error {
 	return nil
 }
 
-func (m *multiEditTool) processMultiEditWithCreation(params MultiEditParams) (ToolResponse, error) {
+func (m *multiEditTool) processMultiEditWithCreation(ctx context.Context, params MultiEditParams) (ToolResponse, error) {
 	// First edit creates the file
 	firstEdit := params.Edits[0]
 	if firstEdit.OldString != "" {
@@ -224,8 +230,17 @@ func (m *multiEditTool) processMultiEditWithCreation(params MultiEditParams) (To
 		currentContent = newContent
 	}
 
+	err := recordFileChange(ctx, m.files, history.Change{
+		Path:    params.FilePath,
+		After:   currentContent,
+		Created: true,
+	})
+	if err != nil {
+		return ToolResponse{}, fmt.Errorf("failed to save file history: %w", err)
+	}
+
 	// Write the file
-	err := os.WriteFile(params.FilePath, []byte(currentContent), 0o644)
+	err = os.WriteFile(params.FilePath, []byte(currentContent), 0o644)
 	if err != nil {
 		return ToolResponse{}, fmt.Errorf("failed to write file: %w", err)
 	}
@@ -241,7 +256,7 @@ func (m *multiEditTool) processMultiEditWithCreation(params MultiEditParams) (To
 	), nil
 }
 
-func (m *multiEditTool) processMultiEditExistingFile(params MultiEditParams) (ToolResponse, error) {
+func (m *multiEditTool) processMultiEditExistingFile(ctx context.Context, params MultiEditParams) (ToolResponse, error) {
 	// Validate file exists and is readable
 	fileInfo, err := os.Stat(params.FilePath)
 	if err != nil {
@@ -296,6 +311,15 @@ func (m *multiEditTool) processMultiEditExistingFile(params MultiEditParams) (To
 		finalContent = strings.ReplaceAll(currentContent, "\n", "\r\n")
 	}
 
+	err = recordFileChange(ctx, m.files, history.Change{
+		Path:   params.FilePath,
+		Before: originalContent,
+		After:  finalContent,
+	})
+	if err != nil {
+		return ToolResponse{}, fmt.Errorf("failed to save file history: %w", err)
+	}
+
 	// Write the updated content with original file permissions
 	err = os.WriteFile(params.FilePath, []byte(finalContent), fileInfo.Mode())
 	if err != nil {
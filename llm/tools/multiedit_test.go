@@ -13,7 +13,7 @@ import (
 func TestMultiEditTool(t *testing.T) {
 	t.Parallel()
 	tempDir := t.TempDir()
-	multiEditTool := NewMultiEditTool(tempDir)
+	multiEditTool := NewMultiEditTool(nil, tempDir)
 
 	t.Run("multiple sequential edits", func(t *testing.T) {
 		filePath := filepath.Join(tempDir, "multi.txt")
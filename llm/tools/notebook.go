@@ -0,0 +1,128 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// notebook is a Jupyter notebook, in the nbformat 4 format.
+type notebook struct {
+	Cells    []notebookCell `json:"cells"`
+	Metadata struct {
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+		Kernelspec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+	} `json:"metadata"`
+}
+
+type notebookCell struct {
+	CellType       string           `json:"cell_type"`
+	Source         notebookText     `json:"source"`
+	ExecutionCount *int             `json:"execution_count"`
+	Outputs        []notebookOutput `json:"outputs"`
+}
+
+type notebookOutput struct {
+	OutputType string                     `json:"output_type"`
+	Name       string                     `json:"name"`
+	Text       notebookText               `json:"text"`
+	Data       map[string]json.RawMessage `json:"data"`
+	EName      string                     `json:"ename"`
+	EValue     string                     `json:"evalue"`
+	Traceback  []string                   `json:"traceback"`
+}
+
+// notebookText is multiline text, which notebooks store either as a string
+// or as a list of lines.
+type notebookText string
+
+func (t *notebookText) UnmarshalJSON(data []byte) error {
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err == nil {
+		*t = notebookText(strings.Join(lines, ""))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	*t = notebookText(text)
+	return nil
+}
+
+// ansiEscape matches the color codes of tracebacks.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+func (nb notebook) language() string {
+	if name := nb.Metadata.LanguageInfo.Name; name != "" {
+		return name
+	}
+	if language := nb.Metadata.Kernelspec.Language; language != "" {
+		return language
+	}
+	return "python"
+}
+
+// render returns the source of the cell and the text of its outputs.
+func (c notebookCell) render(number int, language string) string {
+	var b strings.Builder
+	attributes := fmt.Sprintf("number=\"%d\" type=%q", number, c.CellType)
+	if c.CellType == "code" {
+		attributes += fmt.Sprintf(" language=%q", language)
+		if c.ExecutionCount != nil {
+			attributes += fmt.Sprintf(" execution_count=\"%d\"", *c.ExecutionCount)
+		}
+	}
+	fmt.Fprintf(&b, "<cell %s>\n%s\n", attributes, truncateLines(strings.TrimRight(string(c.Source), "\n")))
+	for _, output := range c.Outputs {
+		if text := output.text(); text != "" {
+			fmt.Fprintf(&b, "<output type=%q>\n%s\n</output>\n", output.OutputType, truncateLines(text))
+		}
+	}
+	b.WriteString("</cell>\n")
+	return b.String()
+}
+
+// text returns the text of an output. Outputs that are not text, such as
+// plots, are noted by their type.
+func (o notebookOutput) text() string {
+	switch o.OutputType {
+	case "stream":
+		return strings.TrimRight(string(o.Text), "\n")
+	case "error":
+		if len(o.Traceback) > 0 {
+			return ansiEscape.ReplaceAllString(strings.Join(o.Traceback, "\n"), "")
+		}
+		return o.EName + ": " + o.EValue
+	}
+
+	var text string
+	for _, mimeType := range []string{"text/markdown", "text/plain"} {
+		var t notebookText
+		if raw, ok := o.Data[mimeType]; ok && json.Unmarshal(raw, &t) == nil {
+			text = strings.TrimRight(string(t), "\n")
+			break
+		}
+	}
+	var hidden []string
+	for mimeType := range o.Data {
+		if text == "" || strings.HasPrefix(mimeType, "image/") {
+			hidden = append(hidden, mimeType)
+		}
+	}
+	if len(hidden) == 0 {
+		return text
+	}
+	slices.Sort(hidden)
+	note := fmt.Sprintf("(%s output not shown)", strings.Join(hidden, ", "))
+	if text == "" {
+		return note
+	}
+	return text + "\n" + note
+}
@@ -0,0 +1,414 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+)
+
+var (
+	// ErrEncrypted is returned for encrypted documents, which are not
+	// supported.
+	ErrEncrypted = errors.New("encrypted PDFs are not supported")
+	// ErrNoPages is returned for documents without pages, which usually
+	// are not PDFs or are damaged.
+	ErrNoPages = errors.New("no pages found in the PDF")
+)
+
+// maxDepth bounds the nesting of page trees and form XObjects.
+const maxDepth = 32
+
+// Document is a PDF document whose text can be read page by page.
+//
+// The objects are found by scanning the file rather than through its
+// cross-reference table, which also reads documents whose table is damaged.
+type Document struct {
+	objects map[int]any
+	pages   []page
+}
+
+type page struct {
+	dict      dict
+	resources dict
+}
+
+var objectHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// Parse reads the objects and pages of a PDF document.
+func Parse(data []byte) (*Document, error) {
+	d := &Document{objects: make(map[int]any)}
+	type definition struct {
+		num, pos int
+		value    any
+	}
+	var (
+		definitions []definition
+		trailers    []dict
+	)
+
+	pos := 0
+	for {
+		loc := objectHeader.FindSubmatchIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		start := pos + loc[0]
+		num := atoi(data[pos+loc[2] : pos+loc[3]])
+		p := &parser{data: data, pos: pos + loc[1]}
+		value, err := p.object()
+		if err != nil {
+			pos = pos + loc[1]
+			continue
+		}
+		if dct, ok := value.(dict); ok {
+			if s, ok := p.stream(dct); ok {
+				value = s
+				if dct["Type"] == name("XRef") {
+					trailers = append(trailers, dct)
+				}
+			}
+		}
+		definitions = append(definitions, definition{num: num, pos: start, value: value})
+		pos = p.pos
+	}
+	for _, loc := range regexp.MustCompile(`trailer\s*<<`).FindAllIndex(data, -1) {
+		p := &parser{data: data, pos: loc[1] - 2}
+		if trailer, err := p.dict(); err == nil {
+			trailers = append(trailers, trailer)
+		}
+	}
+
+	// Objects in object streams are defined where their stream is, so that
+	// the later definitions of incremental updates win.
+	for _, def := range definitions {
+		s, ok := def.value.(stream)
+		if !ok || s.dict["Type"] != name("ObjStm") {
+			continue
+		}
+		objects, err := d.objectStream(s)
+		if err != nil {
+			continue
+		}
+		for num, value := range objects {
+			definitions = append(definitions, definition{num: num, pos: def.pos, value: value})
+		}
+	}
+	sort.SliceStable(definitions, func(i, j int) bool {
+		return definitions[i].pos < definitions[j].pos
+	})
+	for _, def := range definitions {
+		d.objects[def.num] = def.value
+	}
+
+	var root any
+	for _, trailer := range trailers {
+		if _, ok := trailer["Encrypt"]; ok {
+			return nil, ErrEncrypted
+		}
+		if r, ok := trailer["Root"]; ok {
+			root = r
+		}
+	}
+	catalog, ok := d.resolve(root).(dict)
+	if !ok {
+		catalog = d.findCatalog()
+	}
+	if catalog != nil {
+		d.addPages(catalog["Pages"], nil, 0)
+	}
+	if len(d.pages) == 0 {
+		return nil, ErrNoPages
+	}
+	return d, nil
+}
+
+// NumPages returns the number of pages of the document.
+func (d *Document) NumPages() int {
+	return len(d.pages)
+}
+
+// stream reads the data of the stream that follows the dictionary of an
+// indirect object, if any.
+func (p *parser) stream(d dict) (stream, bool) {
+	p.skipSpace()
+	if !bytes.HasPrefix(p.data[p.pos:], []byte("stream")) {
+		return stream{}, false
+	}
+	start := p.pos + len("stream")
+	if bytes.HasPrefix(p.data[start:], []byte("\r\n")) {
+		start += 2
+	} else if start < len(p.data) && (p.data[start] == '\n' || p.data[start] == '\r') {
+		start++
+	}
+	// Trust the length when endstream follows it. It may be a reference,
+	// which cannot be resolved before all objects are read.
+	if length, ok := d["Length"].(int); ok && length >= 0 && start+length <= len(p.data) {
+		q := &parser{data: p.data, pos: start + length}
+		q.skipSpace()
+		if bytes.HasPrefix(p.data[q.pos:], []byte("endstream")) {
+			p.pos = q.pos + len("endstream")
+			return stream{dict: d, data: p.data[start : start+length]}, true
+		}
+	}
+	end := bytes.Index(p.data[start:], []byte("endstream"))
+	if end < 0 {
+		p.pos = len(p.data)
+		return stream{dict: d, data: p.data[start:]}, true
+	}
+	p.pos = start + end + len("endstream")
+	return stream{dict: d, data: bytes.TrimRight(p.data[start:start+end], "\r\n")}, true
+}
+
+// objectStream returns the objects compressed in an object stream.
+func (d *Document) objectStream(s stream) (map[int]any, error) {
+	data, err := decode(s)
+	if err != nil {
+		return nil, err
+	}
+	n, _ := s.dict["N"].(int)
+	first, _ := s.dict["First"].(int)
+	if first < 0 || first > len(data) {
+		return nil, fmt.Errorf("invalid object stream")
+	}
+	header := &parser{data: data[:first]}
+	objects := make(map[int]any, n)
+	for range n {
+		v1, err1 := header.object()
+		v2, err2 := header.object()
+		if err1 != nil || err2 != nil {
+			break
+		}
+		num, ok1 := v1.(int)
+		offset, ok2 := v2.(int)
+		if !ok1 || !ok2 || offset < 0 || first+offset >= len(data) {
+			continue
+		}
+		p := &parser{data: data, pos: first + offset}
+		if value, err := p.object(); err == nil {
+			objects[num] = value
+		}
+	}
+	return objects, nil
+}
+
+// resolve returns the object a reference refers to, and any other value as
+// it is.
+func (d *Document) resolve(v any) any {
+	for range maxDepth {
+		r, ok := v.(ref)
+		if !ok {
+			return v
+		}
+		v = d.objects[r.num]
+	}
+	return nil
+}
+
+// dict returns the dictionary of v, which may also be a stream.
+func (d *Document) dict(v any) dict {
+	switch v := d.resolve(v).(type) {
+	case dict:
+		return v
+	case stream:
+		return v.dict
+	}
+	return nil
+}
+
+func (d *Document) findCatalog() dict {
+	nums := make([]int, 0, len(d.objects))
+	for num := range d.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		if catalog, ok := d.objects[num].(dict); ok && catalog["Type"] == name("Catalog") {
+			return catalog
+		}
+	}
+	return nil
+}
+
+// addPages adds the pages of a page tree node, with the resources they
+// inherit.
+func (d *Document) addPages(node any, resources dict, depth int) {
+	n := d.dict(node)
+	if n == nil || depth > maxDepth {
+		return
+	}
+	if r := d.dict(n["Resources"]); r != nil {
+		resources = r
+	}
+	kids, ok := d.resolve(n["Kids"]).(array)
+	if !ok || n["Type"] == name("Page") {
+		d.pages = append(d.pages, page{dict: n, resources: resources})
+		return
+	}
+	for _, kid := range kids {
+		d.addPages(kid, resources, depth+1)
+	}
+}
+
+// decode returns the decoded data of a stream.
+func decode(s stream) ([]byte, error) {
+	var filters, params array
+	switch f := s.dict["Filter"].(type) {
+	case name:
+		filters = array{f}
+		params = array{s.dict["DecodeParms"]}
+	case array:
+		filters = f
+		params, _ = s.dict["DecodeParms"].(array)
+	}
+	data := s.data
+	for i, filter := range filters {
+		var param dict
+		if i < len(params) {
+			param, _ = params[i].(dict)
+		}
+		var err error
+		switch filter {
+		case name("FlateDecode"), name("Fl"):
+			data, err = inflate(data, param)
+		case name("ASCIIHexDecode"), name("AHx"):
+			data, err = asciiHex(data)
+		case name("ASCII85Decode"), name("A85"):
+			data, err = ascii85Decode(data)
+		default:
+			err = fmt.Errorf("unsupported filter %v", filter)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func inflate(data []byte, param dict) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(r)
+	// Keep what was read of truncated streams.
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	predictor, _ := param["Predictor"].(int)
+	if predictor < 10 {
+		return out, nil
+	}
+	columns, colors, bits := 1, 1, 8
+	if n, ok := param["Columns"].(int); ok {
+		columns = n
+	}
+	if n, ok := param["Colors"].(int); ok {
+		colors = n
+	}
+	if n, ok := param["BitsPerComponent"].(int); ok {
+		bits = n
+	}
+	return unpredict(out, columns, colors, bits)
+}
+
+// unpredict undoes the PNG predictors applied to each row of data.
+func unpredict(data []byte, columns, colors, bits int) ([]byte, error) {
+	bpp := max((colors*bits+7)/8, 1)
+	rowLen := (columns*colors*bits + 7) / 8
+	if rowLen <= 0 {
+		return nil, fmt.Errorf("invalid predictor parameters")
+	}
+	var out []byte
+	prev := make([]byte, rowLen)
+	for len(data) > rowLen {
+		filter, row := data[0], data[1:rowLen+1]
+		data = data[rowLen+1:]
+		cur := make([]byte, rowLen)
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = cur[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch filter {
+			case 0:
+				cur[i] = row[i]
+			case 1:
+				cur[i] = row[i] + left
+			case 2:
+				cur[i] = row[i] + up
+			case 3:
+				cur[i] = row[i] + byte((int(left)+int(up))/2)
+			case 4:
+				cur[i] = row[i] + paeth(left, up, upLeft)
+			default:
+				return nil, fmt.Errorf("invalid PNG predictor %d", filter)
+			}
+		}
+		out = append(out, cur...)
+		prev = cur
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func asciiHex(data []byte) ([]byte, error) {
+	if i := bytes.IndexByte(data, '>'); i >= 0 {
+		data = data[:i]
+	}
+	digits := make([]byte, 0, len(data))
+	for _, c := range data {
+		if !isSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	_, err := hex.Decode(out, digits)
+	return out, err
+}
+
+func ascii85Decode(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	if i := bytes.Index(data, []byte("~>")); i >= 0 {
+		data = data[:i]
+	}
+	// A z stands for four zero bytes.
+	out := make([]byte, 4*len(data)+4)
+	n, _, err := ascii85.Decode(out, data, true)
+	return out[:n], err
+}
+
+func atoi(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
@@ -0,0 +1,401 @@
+package pdf
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/unicode/norm"
+)
+
+// font maps the codes of shown strings to text and widths.
+type font struct {
+	// toUnicode is the font's ToUnicode CMap, if any.
+	toUnicode *cmap
+	// composite fonts have codes of more than one byte.
+	composite bool
+	// encoding is the text of the codes of simple fonts.
+	encoding [256]string
+	// widths are in thousandths of the font size, by code.
+	widths       map[int]float64
+	defaultWidth float64
+}
+
+func (d *Document) loadFont(fd dict) *font {
+	f := &font{widths: make(map[int]float64), defaultWidth: 500}
+	if fd == nil {
+		return f
+	}
+	if s, ok := d.resolve(fd["ToUnicode"]).(stream); ok {
+		if data, err := decode(s); err == nil {
+			f.toUnicode = parseCMap(data)
+		}
+	}
+
+	if fd["Subtype"] == name("Type0") {
+		f.composite = true
+		f.defaultWidth = 1000
+		descendants, _ := d.resolve(fd["DescendantFonts"]).(array)
+		if len(descendants) > 0 {
+			cid := d.dict(descendants[0])
+			if dw, ok := toFloat(d.resolve(cid["DW"])); ok {
+				f.defaultWidth = dw
+			}
+			f.cidWidths(d, cid["W"])
+		}
+		return f
+	}
+
+	f.simpleEncoding(d, fd["Encoding"])
+	firstChar, _ := d.resolve(fd["FirstChar"]).(int)
+	widths, _ := d.resolve(fd["Widths"]).(array)
+	for i, w := range widths {
+		if w, ok := toFloat(d.resolve(w)); ok {
+			f.widths[firstChar+i] = w
+		}
+	}
+	return f
+}
+
+// cidWidths reads the widths of a CID font, given as "first [w1 w2 ...]"
+// or "first last w".
+func (f *font) cidWidths(d *Document, v any) {
+	w, _ := d.resolve(v).(array)
+	for i := 0; i+1 < len(w); {
+		first, ok := d.resolve(w[i]).(int)
+		if !ok {
+			return
+		}
+		if list, ok := d.resolve(w[i+1]).(array); ok {
+			for j, width := range list {
+				if width, ok := toFloat(width); ok {
+					f.widths[first+j] = width
+				}
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(w) {
+			return
+		}
+		last, _ := d.resolve(w[i+1]).(int)
+		width, _ := toFloat(d.resolve(w[i+2]))
+		for code := first; code <= last && code-first < 0x10000; code++ {
+			f.widths[code] = width
+		}
+		i += 3
+	}
+}
+
+// simpleEncoding sets up the text of the codes of a simple font from its
+// base encoding and differences.
+func (f *font) simpleEncoding(d *Document, v any) {
+	base := name("StandardEncoding")
+	var differences array
+	switch enc := d.resolve(v).(type) {
+	case name:
+		base = enc
+	case dict:
+		if b, ok := enc["BaseEncoding"].(name); ok {
+			base = b
+		}
+		differences, _ = d.resolve(enc["Differences"]).(array)
+	}
+
+	for code := range 256 {
+		var r rune
+		switch base {
+		case "MacRomanEncoding":
+			r = charmap.Macintosh.DecodeByte(byte(code))
+		default:
+			r = charmap.Windows1252.DecodeByte(byte(code))
+		}
+		if base == "StandardEncoding" {
+			switch code {
+			case '\'':
+				r = '’'
+			case '`':
+				r = '‘'
+			}
+		}
+		if r >= ' ' && r != 0x7f && r != 0xfffd {
+			f.encoding[code] = string(r)
+		}
+	}
+
+	code := 0
+	for _, item := range differences {
+		switch item := item.(type) {
+		case int:
+			code = item
+		case name:
+			if code >= 0 && code < 256 {
+				f.encoding[code] = glyphText(string(item))
+			}
+			code++
+		}
+	}
+}
+
+// codes splits a shown string into character codes.
+func (f *font) codes(s string) []string {
+	var codes []string
+	for len(s) > 0 {
+		n := 1
+		switch {
+		case f.toUnicode != nil:
+			n = f.toUnicode.codeLength(s, f.composite)
+		case f.composite:
+			n = 2
+		}
+		n = min(n, len(s))
+		codes = append(codes, s[:n])
+		s = s[n:]
+	}
+	return codes
+}
+
+// text returns the text of a character code.
+func (f *font) text(code string) string {
+	if f.toUnicode != nil {
+		if text, ok := f.toUnicode.chars[code]; ok {
+			return strings.ReplaceAll(text, "\x00", "")
+		}
+	}
+	if f.composite {
+		return ""
+	}
+	return f.encoding[code[0]]
+}
+
+// width returns the width of a character code in text space units of a
+// font of size 1.
+func (f *font) width(code string) float64 {
+	n := 0
+	for i := range len(code) {
+		n = n<<8 | int(code[i])
+	}
+	if w, ok := f.widths[n]; ok {
+		return w / 1000
+	}
+	return f.defaultWidth / 1000
+}
+
+// cmap is a ToUnicode CMap, which maps character codes to text.
+type cmap struct {
+	// lengths are the lengths of codes of the code space, shortest first.
+	lengths []int
+	chars   map[string]string
+}
+
+// maxRange bounds the number of codes of a range of a CMap.
+const maxRange = 0x10000
+
+func parseCMap(data []byte) *cmap {
+	c := &cmap{chars: make(map[string]string)}
+	p := &parser{data: data}
+	var operands []any
+	for !p.eof() {
+		v, err := p.object()
+		if err != nil {
+			break
+		}
+		op, ok := v.(keyword)
+		if !ok {
+			operands = append(operands, v)
+			continue
+		}
+		switch op {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				if lo, ok := operands[i].(string); ok && len(lo) > 0 {
+					c.addLength(len(lo))
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(string)
+				dst, ok2 := operands[i+1].(string)
+				if ok1 && ok2 {
+					c.chars[src] = utf16Text(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				c.addRange(operands[i], operands[i+1], operands[i+2])
+			}
+		}
+		operands = operands[:0]
+	}
+	if len(c.lengths) == 0 {
+		for src := range c.chars {
+			c.addLength(len(src))
+		}
+	}
+	return c
+}
+
+func (c *cmap) addLength(n int) {
+	for i, length := range c.lengths {
+		if length == n {
+			return
+		}
+		if length > n {
+			c.lengths = append(c.lengths[:i], append([]int{n}, c.lengths[i:]...)...)
+			return
+		}
+	}
+	c.lengths = append(c.lengths, n)
+}
+
+func (c *cmap) addRange(loValue, hiValue, dst any) {
+	lo, ok1 := loValue.(string)
+	hi, ok2 := hiValue.(string)
+	if !ok1 || !ok2 || len(lo) != len(hi) || len(lo) == 0 || len(lo) > 4 {
+		return
+	}
+	first, last := codeValue(lo), codeValue(hi)
+	if last < first || last-first >= maxRange {
+		return
+	}
+	for code := first; code <= last; code++ {
+		src := make([]byte, len(lo))
+		for i, n := len(src)-1, code; i >= 0; i, n = i-1, n>>8 {
+			src[i] = byte(n)
+		}
+		offset := code - first
+		switch dst := dst.(type) {
+		case string:
+			runes := []rune(utf16Text(dst))
+			if len(runes) == 0 {
+				continue
+			}
+			runes[len(runes)-1] += rune(offset)
+			c.chars[string(src)] = string(runes)
+		case array:
+			if offset < len(dst) {
+				if s, ok := dst[offset].(string); ok {
+					c.chars[string(src)] = utf16Text(s)
+				}
+			}
+		}
+	}
+}
+
+// codeLength returns the length of the code at the start of s: the
+// shortest one the CMap maps, or the shortest of its code space.
+func (c *cmap) codeLength(s string, composite bool) int {
+	for _, n := range c.lengths {
+		if n <= len(s) {
+			if _, ok := c.chars[s[:n]]; ok {
+				return n
+			}
+		}
+	}
+	if len(c.lengths) > 0 {
+		return c.lengths[0]
+	}
+	if composite {
+		return 2
+	}
+	return 1
+}
+
+func codeValue(s string) int {
+	n := 0
+	for i := range len(s) {
+		n = n<<8 | int(s[i])
+	}
+	return n
+}
+
+// utf16Text decodes the UTF-16BE text of a CMap.
+func utf16Text(s string) string {
+	if len(s)%2 == 1 {
+		return s
+	}
+	units := make([]uint16, len(s)/2)
+	for i := range units {
+		units[i] = uint16(s[2*i])<<8 | uint16(s[2*i+1])
+	}
+	return string(utf16.Decode(units))
+}
+
+// glyphs are the text of glyph names that are not single characters or
+// derived from other names.
+var glyphs = map[string]string{
+	"space": " ", "exclam": "!", "quotedbl": "\"", "numbersign": "#",
+	"dollar": "$", "percent": "%", "ampersand": "&", "quotesingle": "'",
+	"parenleft": "(", "parenright": ")", "asterisk": "*", "plus": "+",
+	"comma": ",", "hyphen": "-", "period": ".", "slash": "/",
+	"zero": "0", "one": "1", "two": "2", "three": "3", "four": "4",
+	"five": "5", "six": "6", "seven": "7", "eight": "8", "nine": "9",
+	"colon": ":", "semicolon": ";", "less": "<", "equal": "=",
+	"greater": ">", "question": "?", "at": "@", "bracketleft": "[",
+	"backslash": "\\", "bracketright": "]", "asciicircum": "^",
+	"underscore": "_", "grave": "`", "braceleft": "{", "bar": "|",
+	"braceright": "}", "asciitilde": "~", "quoteleft": "‘",
+	"quoteright": "’", "quotedblleft": "“", "quotedblright": "”",
+	"quotesinglbase": "‚", "quotedblbase": "„", "bullet": "•",
+	"endash": "–", "emdash": "—", "ellipsis": "…", "minus": "−",
+	"fi": "fi", "fl": "fl", "ff": "ff", "ffi": "ffi", "ffl": "ffl",
+	"trademark": "™", "copyright": "©", "registered": "®",
+	"degree": "°", "section": "§", "paragraph": "¶", "dagger": "†",
+	"daggerdbl": "‡", "germandbls": "ß", "AE": "Æ", "ae": "æ",
+	"OE": "Œ", "oe": "œ", "Oslash": "Ø", "oslash": "ø", "dotlessi": "ı",
+	"Euro": "€", "sterling": "£", "yen": "¥", "cent": "¢",
+	"multiply": "×", "divide": "÷", "plusminus": "±", "mu": "µ",
+	"nbspace": " ", "periodcentered": "·", "guillemotleft": "«",
+	"guillemotright": "»", "exclamdown": "¡", "questiondown": "¿",
+}
+
+// accents are the combining marks of the accents in glyph names such as
+// eacute.
+var accents = map[string]string{
+	"acute": "́", "grave": "̀", "circumflex": "̂",
+	"dieresis": "̈", "tilde": "̃", "ring": "̊",
+	"cedilla": "̧", "caron": "̌",
+}
+
+// glyphText returns the text of a glyph name, following the conventions of
+// the Adobe Glyph List: uniXXXX and uXXXX name code points, a suffix after
+// a period is a variant and underscores join ligatures.
+func glyphText(glyph string) string {
+	glyph, _, _ = strings.Cut(glyph, ".")
+	if strings.Contains(glyph, "_") {
+		var text strings.Builder
+		for part := range strings.SplitSeq(glyph, "_") {
+			text.WriteString(glyphText(part))
+		}
+		return text.String()
+	}
+	if text, ok := glyphs[glyph]; ok {
+		return text
+	}
+	if len(glyph) == 1 {
+		return glyph
+	}
+	if hexDigits, ok := strings.CutPrefix(glyph, "uni"); ok && len(hexDigits)%4 == 0 {
+		var units []uint16
+		for i := 0; i < len(hexDigits); i += 4 {
+			n, err := strconv.ParseUint(hexDigits[i:i+4], 16, 16)
+			if err != nil {
+				return ""
+			}
+			units = append(units, uint16(n))
+		}
+		return string(utf16.Decode(units))
+	}
+	if hexDigits, ok := strings.CutPrefix(glyph, "u"); ok && len(hexDigits) >= 4 && len(hexDigits) <= 6 {
+		if n, err := strconv.ParseUint(hexDigits, 16, 32); err == nil {
+			return string(rune(n))
+		}
+	}
+	for accent, mark := range accents {
+		if base, ok := strings.CutSuffix(glyph, accent); ok && len(base) == 1 {
+			return norm.NFC.String(base + mark)
+		}
+	}
+	return ""
+}
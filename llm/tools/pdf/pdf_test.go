@@ -0,0 +1,160 @@
+package pdf_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"testing"
+
+	"gentica/llm/tools/pdf"
+
+	"github.com/stretchr/testify/require"
+)
+
+// build writes a PDF whose objects are numbered from 1 in order, with a
+// cross-reference table and a trailer whose root is object 1.
+func build(objects []string, trailer string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R %s >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailer, xref)
+	return b.Bytes()
+}
+
+func contentStream(content string) string {
+	return fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content)
+}
+
+func flateStream(dict, content string) string {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write([]byte(content))
+	w.Close()
+	return fmt.Sprintf("<< /Length %d /Filter /FlateDecode %s >>\nstream\n%s\nendstream", b.Len(), dict, b.String())
+}
+
+func pageTexts(t *testing.T, data []byte) []string {
+	t.Helper()
+	doc, err := pdf.Parse(data)
+	require.NoError(t, err)
+	var texts []string
+	for i := range doc.NumPages() {
+		text, err := doc.PageText(i)
+		require.NoError(t, err)
+		texts = append(texts, text)
+	}
+	return texts
+}
+
+func TestPageText(t *testing.T) {
+	t.Parallel()
+
+	t.Run("reads the text of each page", func(t *testing.T) {
+		data := build([]string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 5 0 R >> >> >>",
+			"<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>",
+			"<< /Type /Page /Parent 2 0 R /Contents [7 0 R 8 0 R] >>",
+			"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+			contentStream("BT /F1 12 Tf 72 720 Td [(Hello,) -250 (wor) -30 (ld)] TJ 0 -14 Td (Second \\(line\\)) Tj ET"),
+			contentStream("BT /F1 12 Tf 72 720 Td [(Caf\\351) -600 (au lait)] TJ"),
+			contentStream("T* 14 TL T* (\\223Quoted\\224) ' ET"),
+		}, "")
+		require.Equal(t, []string{
+			"Hello, world\nSecond (line)",
+			"Café au lait\n“Quoted”",
+		}, pageTexts(t, data))
+	})
+
+	t.Run("reads compressed objects and glyph names", func(t *testing.T) {
+		page := "<< /Type /Page /Parent 2 0 R /Contents 5 0 R /Resources << /Font << /F1 6 0 R >> >> >>"
+		font := "<< /Type /Font /Subtype /Type1 /Encoding << /Differences [65 /e.sc /f_f /uni00E9 /Aacute /space] >> >>"
+		header := fmt.Sprintf("3 0 6 %d ", len(page)+1)
+		data := build([]string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"null",
+			flateStream(fmt.Sprintf("/Type /ObjStm /N 2 /First %d", len(header)), header+page+" "+font),
+			flateStream("", "BT /F1 10 Tf (ABCDEB) Tj ET"),
+		}, "")
+		require.Equal(t, []string{"efféÁ ff"}, pageTexts(t, data))
+	})
+
+	t.Run("maps codes with ToUnicode CMaps", func(t *testing.T) {
+		cmap := `/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+1 begincodespacerange
+<0000> <FFFF>
+endcodespacerange
+2 beginbfchar
+<0003> <0020>
+<0010> <D83DDE00>
+endbfchar
+2 beginbfrange
+<0020> <0022> <0041>
+<0030> <0031> [<006F006B> <0021>]
+endbfrange
+endcmap
+end end`
+		data := build([]string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+			contentStream("BT /F1 11 Tf <0020002100220003> Tj <003000310010> Tj ET"),
+			"<< /Type /Font /Subtype /Type0 /Encoding /Identity-H /ToUnicode 6 0 R /DescendantFonts [7 0 R] >>",
+			contentStream(cmap),
+			"<< /Type /Font /Subtype /CIDFontType2 /DW 500 /W [3 [250] 32 34 600] >>",
+		}, "")
+		require.Equal(t, []string{"ABC ok!😀"}, pageTexts(t, data))
+	})
+
+	t.Run("reads the text of forms", func(t *testing.T) {
+		data := build([]string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /Contents 4 0 R /Resources << /XObject << /X1 5 0 R >> >> >>",
+			contentStream("q BI /W 1 /H 1 /BPC 8 /CS /G ID \x00\xff EI Q /X1 Do"),
+			"<< /Type /XObject /Subtype /Form /Length 31 /Resources << /Font << /F1 6 0 R >> >> >>\nstream\nBT /F1 9 Tf (In a form) Tj ET\nendstream",
+			"<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>",
+		}, "")
+		require.Equal(t, []string{"In a form"}, pageTexts(t, data))
+	})
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	_, err := pdf.Parse([]byte("not a pdf"))
+	require.ErrorIs(t, err, pdf.ErrNoPages)
+
+	data := build([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [] /Count 0 >>",
+		"<< /Filter /Standard /V 2 >>",
+	}, "/Encrypt 3 0 R")
+	_, err = pdf.Parse(data)
+	require.ErrorIs(t, err, pdf.ErrEncrypted)
+
+	doc, err := pdf.Parse(build([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R >>",
+	}, ""))
+	require.NoError(t, err)
+	require.Equal(t, 1, doc.NumPages())
+	text, err := doc.PageText(0)
+	require.NoError(t, err)
+	require.Empty(t, text)
+	_, err = doc.PageText(1)
+	require.EqualError(t, err, "page 2 out of range, the document has 1 pages")
+}
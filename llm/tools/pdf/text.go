@@ -0,0 +1,280 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// PageText returns the text of page i, counted from 0, in the order the
+// page draws it. Lines and spaces are guessed from where the text is drawn.
+func (d *Document) PageText(i int) (string, error) {
+	if i < 0 || i >= len(d.pages) {
+		return "", fmt.Errorf("page %d out of range, the document has %d pages", i+1, len(d.pages))
+	}
+	pg := d.pages[i]
+	content, err := d.contents(pg.dict["Contents"])
+	if err != nil {
+		return "", err
+	}
+	e := &extractor{doc: d, fonts: make(map[ref]*font), state: textState{scale: 1}}
+	e.run(content, pg.resources, 0)
+	return e.text(), nil
+}
+
+// contents returns the decoded content of a page, whose streams may be
+// split.
+func (d *Document) contents(v any) ([]byte, error) {
+	switch v := d.resolve(v).(type) {
+	case stream:
+		return decode(v)
+	case array:
+		var content []byte
+		for _, part := range v {
+			s, ok := d.resolve(part).(stream)
+			if !ok {
+				continue
+			}
+			data, err := decode(s)
+			if err != nil {
+				return nil, err
+			}
+			content = append(content, data...)
+			content = append(content, '\n')
+		}
+		return content, nil
+	}
+	return nil, nil
+}
+
+// textState is the part of the graphics state that text depends on.
+type textState struct {
+	font      *font
+	size      float64
+	charSpace float64
+	wordSpace float64
+	scale     float64
+	leading   float64
+}
+
+// extractor runs content streams and writes out the text they show.
+type extractor struct {
+	doc   *Document
+	fonts map[ref]*font
+	out   strings.Builder
+
+	state  textState
+	saved  []textState
+	tm     [6]float64
+	tlm    [6]float64
+	shown  bool
+	lastX  float64
+	lastY  float64
+	lastSz float64
+}
+
+func (e *extractor) run(content []byte, resources dict, depth int) {
+	if depth > maxDepth {
+		return
+	}
+	p := &parser{data: content}
+	var operands []any
+	for !p.eof() {
+		v, err := p.object()
+		if err != nil {
+			return
+		}
+		op, ok := v.(keyword)
+		if !ok {
+			operands = append(operands, v)
+			continue
+		}
+		e.operator(p, op, operands, resources, depth)
+		operands = operands[:0]
+	}
+}
+
+func (e *extractor) operator(p *parser, op keyword, operands []any, resources dict, depth int) {
+	num := func(i int) float64 {
+		if i >= len(operands) {
+			return 0
+		}
+		n, _ := toFloat(operands[i])
+		return n
+	}
+	last := func() any {
+		if len(operands) == 0 {
+			return nil
+		}
+		return operands[len(operands)-1]
+	}
+
+	switch op {
+	case "q":
+		e.saved = append(e.saved, e.state)
+	case "Q":
+		if n := len(e.saved); n > 0 {
+			e.state, e.saved = e.saved[n-1], e.saved[:n-1]
+		}
+	case "BT":
+		e.tm = [6]float64{1, 0, 0, 1, 0, 0}
+		e.tlm = e.tm
+	case "Tf":
+		if len(operands) >= 2 {
+			fontName, _ := operands[0].(name)
+			e.state.font = e.font(resources, fontName)
+			e.state.size = num(1)
+		}
+	case "Tc":
+		e.state.charSpace = num(0)
+	case "Tw":
+		e.state.wordSpace = num(0)
+	case "Tz":
+		e.state.scale = num(0) / 100
+	case "TL":
+		e.state.leading = num(0)
+	case "Td":
+		e.moveLine(num(0), num(1))
+	case "TD":
+		e.state.leading = -num(1)
+		e.moveLine(num(0), num(1))
+	case "Tm":
+		for i := range e.tm {
+			e.tm[i] = num(i)
+		}
+		e.tlm = e.tm
+	case "T*":
+		e.moveLine(0, -e.state.leading)
+	case "Tj":
+		e.show(last())
+	case "'":
+		e.moveLine(0, -e.state.leading)
+		e.show(last())
+	case "\"":
+		e.state.wordSpace = num(0)
+		e.state.charSpace = num(1)
+		e.moveLine(0, -e.state.leading)
+		e.show(last())
+	case "TJ":
+		items, _ := last().(array)
+		for _, item := range items {
+			if n, ok := toFloat(item); ok {
+				e.advance(-n / 1000 * e.state.size * e.state.scale)
+				continue
+			}
+			e.show(item)
+		}
+	case "Do":
+		xobjects := e.doc.dict(resources["XObject"])
+		xobjectName, _ := last().(name)
+		form, ok := e.doc.resolve(xobjects[xobjectName]).(stream)
+		if !ok || form.dict["Subtype"] != name("Form") {
+			return
+		}
+		content, err := decode(form)
+		if err != nil {
+			return
+		}
+		formResources := e.doc.dict(form.dict["Resources"])
+		if formResources == nil {
+			formResources = resources
+		}
+		saved := e.state
+		e.run(content, formResources, depth+1)
+		e.state = saved
+	case "ID":
+		// Skip the data of an inline image, which ends at EI.
+		for i := p.pos; i+2 <= len(p.data); i++ {
+			if p.data[i] == 'E' && p.data[i+1] == 'I' && i > 0 && isSpace(p.data[i-1]) && (i+2 == len(p.data) || isSpace(p.data[i+2])) {
+				p.pos = i + 2
+				return
+			}
+		}
+		p.pos = len(p.data)
+	}
+}
+
+// moveLine starts a new line offset from the start of the current one.
+func (e *extractor) moveLine(tx, ty float64) {
+	e.tlm[4] += tx*e.tlm[0] + ty*e.tlm[2]
+	e.tlm[5] += tx*e.tlm[1] + ty*e.tlm[3]
+	e.tm = e.tlm
+}
+
+// advance moves the text position by tx in text space.
+func (e *extractor) advance(tx float64) {
+	e.tm[4] += tx * e.tm[0]
+	e.tm[5] += tx * e.tm[1]
+}
+
+// show writes out the text of a string, separated from the text before it
+// by a line break or a space when it is drawn apart from it.
+func (e *extractor) show(v any) {
+	s, ok := v.(string)
+	if !ok || e.state.font == nil {
+		return
+	}
+	size := math.Abs(e.state.size * e.tm[3])
+	if size == 0 {
+		size = math.Abs(e.state.size)
+	}
+	if e.shown {
+		tolerance := max(size, e.lastSz) / 2
+		switch {
+		case math.Abs(e.tm[5]-e.lastY) > tolerance:
+			e.out.WriteString("\n")
+		case math.Abs(e.tm[4]-e.lastX) > size*0.15:
+			e.space()
+		}
+	}
+
+	for _, c := range e.state.font.codes(s) {
+		e.out.WriteString(e.state.font.text(c))
+		width := e.state.font.width(c)*e.state.size + e.state.charSpace
+		if len(c) == 1 && c[0] == ' ' {
+			width += e.state.wordSpace
+		}
+		e.advance(width * e.state.scale)
+	}
+	e.shown = true
+	e.lastX, e.lastY, e.lastSz = e.tm[4], e.tm[5], size
+}
+
+func (e *extractor) space() {
+	out := e.out.String()
+	if out != "" && !strings.HasSuffix(out, " ") && !strings.HasSuffix(out, "\n") {
+		e.out.WriteString(" ")
+	}
+}
+
+// text returns the text written out, without trailing spaces and runs of
+// blank lines.
+func (e *extractor) text() string {
+	lines := strings.Split(e.out.String(), "\n")
+	var result []string
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" && (len(result) == 0 || result[len(result)-1] == "") {
+			continue
+		}
+		result = append(result, line)
+	}
+	return strings.TrimSpace(strings.Join(result, "\n"))
+}
+
+// font returns the font of the resources with the given name.
+func (e *extractor) font(resources dict, fontName name) *font {
+	fonts := e.doc.dict(resources["Font"])
+	v := fonts[fontName]
+	r, isRef := v.(ref)
+	if isRef {
+		if f, ok := e.fonts[r]; ok {
+			return f
+		}
+	}
+	f := e.doc.loadFont(e.doc.dict(v))
+	if isRef {
+		e.fonts[r] = f
+	}
+	return f
+}
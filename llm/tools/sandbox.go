@@ -0,0 +1,106 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// ErrSandboxUnsupported is returned when the system cannot confine commands.
+var ErrSandboxUnsupported = errors.New("sandbox is not supported")
+
+const (
+	DefaultSandboxCPUTime   = 600  // seconds
+	DefaultSandboxMemory    = 4096 // MiB
+	DefaultSandboxProcesses = 512
+)
+
+// SandboxConfig is how the sandbox confines commands.
+type SandboxConfig struct {
+	// Network lets commands reach the network.
+	Network bool `json:"network"`
+	// CPUTime is the CPU time each process may use, in seconds, and Memory
+	// the data memory each process may use, in MiB. Processes is how many
+	// processes may run at once in the user namespace of the commands, on
+	// Linux 5.14 or later; earlier kernels count every process of the user.
+	// It is not limited when Network is set, as the commands then stay in
+	// the user's namespace. Zero takes the default.
+	CPUTime   int `json:"cpu_time"`
+	Memory    int `json:"memory"`
+	Processes int `json:"processes"`
+}
+
+// Sandbox confines the commands of the bash tool: they may only write to
+// the working directory and a temporary directory of the sandbox, reach the
+// network when the config allows it, and use limited resources. It is only
+// supported on Linux.
+type Sandbox struct {
+	config SandboxConfig
+	// writable are the directories commands may write to.
+	writable []string
+	// tempDir is the temporary directory of the commands.
+	tempDir string
+}
+
+// NewSandbox returns a sandbox whose commands write to workingDir. It
+// returns an error wrapping ErrSandboxUnsupported that says why when the
+// system cannot confine commands this way.
+func NewSandbox(config SandboxConfig, workingDir string) (*Sandbox, error) {
+	if config.CPUTime == 0 {
+		config.CPUTime = DefaultSandboxCPUTime
+	}
+	if config.Memory == 0 {
+		config.Memory = DefaultSandboxMemory
+	}
+	if config.Network {
+		// The limit would count every process of the user.
+		config.Processes = 0
+	} else if config.Processes == 0 {
+		config.Processes = DefaultSandboxProcesses
+	}
+	workingDir, err := filepath.Abs(workingDir)
+	if err != nil {
+		return nil, err
+	}
+	tempDir, err := os.MkdirTemp("", "gentica-sandbox-")
+	if err != nil {
+		return nil, err
+	}
+	s := &Sandbox{
+		config:   config,
+		writable: []string{workingDir, tempDir},
+		tempDir:  tempDir,
+	}
+	if err := s.check(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Config returns how the sandbox confines commands, with the defaults
+// filled in.
+func (s *Sandbox) Config() SandboxConfig {
+	return s.config
+}
+
+// Close removes the temporary directory of the sandbox.
+func (s *Sandbox) Close() error {
+	return os.RemoveAll(s.tempDir)
+}
+
+// start starts cmd confined by the sandbox, or as it is when s is nil.
+func (s *Sandbox) start(cmd *exec.Cmd) error {
+	if s == nil {
+		return cmd.Start()
+	}
+	return s.startConfined(cmd)
+}
+
+// unsupported returns an error that tells why the sandbox is not
+// supported.
+func unsupported(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrSandboxUnsupported, fmt.Sprintf(format, args...))
+}
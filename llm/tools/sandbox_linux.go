@@ -0,0 +1,202 @@
+//go:build linux
+
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// check starts a confined command, to find out whether the kernel supports
+// what the sandbox needs.
+func (s *Sandbox) check() error {
+	cmd := exec.Command("/bin/sh", "-c", "exit 0")
+	if err := s.startConfined(cmd); err != nil {
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return unsupported("a confined command failed: %v", err)
+	}
+	return nil
+}
+
+// startConfined starts cmd with landlock limiting where it writes, in user
+// and network namespaces of its own unless the network is allowed, and with
+// resource limits.
+//
+// Landlock confines the thread that starts cmd, which cmd inherits. The
+// resource limits are set once cmd started, which a shell in front of cmd
+// waits for before running it.
+func (s *Sandbox) startConfined(cmd *exec.Cmd) error {
+	abi, err := landlockABI()
+	if err != nil {
+		return err
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if !s.config.Network {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "TMPDIR="+s.tempDir)
+
+	gate, release, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer gate.Close()
+	defer release.Close()
+	fd := 3 + len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, gate)
+	cmd.Args = append([]string{"sh", "-c", fmt.Sprintf(`read _ <&%d; exec "$@" %d<&-`, fd, fd), "sh", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+
+	started := make(chan error)
+	go func() {
+		// The thread stays confined, so it is not unlocked: it ends with
+		// the goroutine instead of running others.
+		runtime.LockOSThread()
+		if err := restrictThread(abi, s.writable); err != nil {
+			started <- err
+			return
+		}
+		err := cmd.Start()
+		if err != nil && !s.config.Network {
+			err = namespaceError(err)
+		}
+		started <- err
+	}()
+	if err := <-started; err != nil {
+		return err
+	}
+
+	type limit struct {
+		resource int
+		value    uint64
+	}
+	limits := []limit{
+		{unix.RLIMIT_CPU, uint64(s.config.CPUTime)},
+		{unix.RLIMIT_DATA, uint64(s.config.Memory) << 20},
+	}
+	// Processes are counted in the user namespace the commands run in.
+	if s.config.Processes > 0 {
+		limits = append(limits, limit{unix.RLIMIT_NPROC, uint64(s.config.Processes)})
+	}
+	for _, limit := range limits {
+		rlimit := unix.Rlimit{Cur: limit.value, Max: limit.value}
+		if err := unix.Prlimit(cmd.Process.Pid, limit.resource, &rlimit, nil); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return fmt.Errorf("failed to limit the resources of the command: %w", err)
+		}
+	}
+	if _, err := release.Write([]byte("\n")); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	return nil
+}
+
+// landlockABI returns the version of landlock the kernel supports.
+func landlockABI() (int, error) {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	switch errno {
+	case 0:
+		return int(abi), nil
+	case unix.ENOSYS:
+		return 0, unsupported("the kernel has no landlock, which needs Linux 5.13 or later")
+	case unix.EOPNOTSUPP:
+		return 0, unsupported("landlock is disabled in the kernel, add it to the lsm= boot parameter to enable it")
+	}
+	return 0, unsupported("landlock is not available: %v", errno)
+}
+
+// writeAccess returns the landlock rights to change files that the ABI
+// version supports.
+func writeAccess(abi int) uint64 {
+	access := uint64(unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM)
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	return access
+}
+
+// restrictThread confines the calling thread, and the processes it starts,
+// to change only files beneath the writable directories. Devices such as
+// /dev/null may still be written to, as may /proc, where the ID mappings of
+// new user namespaces are written.
+func restrictThread(abi int, writable []string) error {
+	access := writeAccess(abi)
+	attr := unix.LandlockRulesetAttr{Access_fs: access}
+	ruleset, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create landlock ruleset: %w", errno)
+	}
+	defer unix.Close(int(ruleset))
+
+	rules := map[string]uint64{
+		"/dev":  access & (unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE),
+		"/proc": unix.LANDLOCK_ACCESS_FS_WRITE_FILE,
+	}
+	for _, dir := range writable {
+		rules[dir] = access
+	}
+	for path, allowed := range rules {
+		fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		rule := unix.LandlockPathBeneathAttr{Allowed_access: allowed, Parent_fd: int32(fd)}
+		_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, ruleset, unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+		unix.Close(fd)
+		if errno != 0 {
+			return fmt.Errorf("failed to allow writing to %s: %w", path, errno)
+		}
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, ruleset, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enforce landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+// namespaceError tells why a command could not start in namespaces of its
+// own, when the kernel is to blame.
+func namespaceError(err error) error {
+	switch {
+	case errors.Is(err, syscall.EPERM), errors.Is(err, syscall.EACCES), errors.Is(err, syscall.ENOSPC):
+		return unsupported("the kernel does not let unprivileged users create user namespaces, which turning off the network needs: %v", err)
+	case errors.Is(err, syscall.EINVAL):
+		return unsupported("the kernel has no user or network namespaces, which turning off the network needs: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package tools
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+func (s *Sandbox) check() error {
+	return unsupported("commands can only be confined on Linux, not on %s", runtime.GOOS)
+}
+
+func (s *Sandbox) startConfined(cmd *exec.Cmd) error {
+	return s.check()
+}
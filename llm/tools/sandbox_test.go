@@ -0,0 +1,106 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandbox(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	outsideDir := t.TempDir()
+	sandbox, err := NewSandbox(SandboxConfig{Processes: 100}, tempDir)
+	if runtime.GOOS != "linux" {
+		require.ErrorIs(t, err, ErrSandboxUnsupported)
+		return
+	}
+	if errors.Is(err, ErrSandboxUnsupported) {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { sandbox.Close() })
+	jobs := NewJobs()
+	bashTool := NewBashTool(tempDir, BashOptions{Jobs: jobs, Sandbox: sandbox})
+
+	run := func(t *testing.T, params BashParams) (ToolResponse, BashResponseMetadata) {
+		t.Helper()
+		paramsJSON, err := json.Marshal(params)
+		require.NoError(t, err)
+		ctx := context.WithValue(context.Background(), SessionIDContextKey, t.Name())
+		response, err := bashTool.Run(ctx, ToolCall{Input: string(paramsJSON)})
+		require.NoError(t, err)
+		var metadata BashResponseMetadata
+		require.NoError(t, json.Unmarshal([]byte(response.Metadata), &metadata))
+		return response, metadata
+	}
+
+	t.Run("reports the sandbox", func(t *testing.T) {
+		_, metadata := run(t, BashParams{Command: "true"})
+		require.Equal(t, &SandboxConfig{
+			CPUTime:   DefaultSandboxCPUTime,
+			Memory:    DefaultSandboxMemory,
+			Processes: 100,
+		}, metadata.Sandbox)
+	})
+
+	t.Run("writes to the working directory", func(t *testing.T) {
+		response, _ := run(t, BashParams{Command: "mkdir pkg && echo hello > pkg/file && rm -r pkg"})
+		require.False(t, response.IsError, response.Content)
+	})
+
+	t.Run("writes to the temporary directory", func(t *testing.T) {
+		response, _ := run(t, BashParams{Command: `f=$(mktemp) && echo hello > "$f" && cat "$f" && rm "$f"`})
+		require.False(t, response.IsError, response.Content)
+		require.Equal(t, "hello\n", response.Content)
+	})
+
+	t.Run("does not write elsewhere", func(t *testing.T) {
+		response, _ := run(t, BashParams{Command: "touch " + filepath.Join(outsideDir, "file")})
+		require.True(t, response.IsError)
+		require.Contains(t, response.Content, "Permission denied")
+		require.NoFileExists(t, filepath.Join(outsideDir, "file"))
+	})
+
+	t.Run("has no network", func(t *testing.T) {
+		// Only the loopback interface is listed.
+		response, _ := run(t, BashParams{Command: "grep -c : /proc/net/dev"})
+		require.Equal(t, "1\n", response.Content)
+	})
+
+	t.Run("limits resources", func(t *testing.T) {
+		response, _ := run(t, BashParams{Command: "ulimit -t; ulimit -d; ulimit -u"})
+		require.Equal(t, "600\n4194304\n100\n", response.Content)
+	})
+
+	t.Run("does not limit processes with the network", func(t *testing.T) {
+		sandbox, err := NewSandbox(SandboxConfig{Network: true, Processes: 100}, tempDir)
+		require.NoError(t, err)
+		defer sandbox.Close()
+		require.Zero(t, sandbox.Config().Processes)
+	})
+
+	t.Run("interrupts commands", func(t *testing.T) {
+		response, _ := run(t, BashParams{Command: "sleep 10", Timeout: 100})
+		require.Contains(t, response.Content, "Command timed out after")
+		response, _ = run(t, BashParams{Command: "echo still here"})
+		require.Equal(t, "still here\n", response.Content)
+	})
+
+	t.Run("confines background jobs", func(t *testing.T) {
+		_, metadata := run(t, BashParams{Command: "touch " + filepath.Join(outsideDir, "job"), RunInBackground: true})
+		require.NotNil(t, metadata.Sandbox)
+		jb, ok := jobs.get(t.Name(), metadata.JobID)
+		require.True(t, ok)
+		<-jb.done
+		require.NotZero(t, jb.exitCode)
+		_, err := os.Stat(filepath.Join(outsideDir, "job"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
@@ -0,0 +1,281 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// shellKillDelay is how long a command may take to stop after it was
+// interrupted before the shell is killed with it.
+const shellKillDelay = 2 * time.Second
+
+// shell is a shell process that runs commands one after the other, so that
+// the working directory and environment carry over between them. Commands
+// are written to the shell's stdin and their output goes to files, while the
+// shell reports on its stdout how each command ended.
+type shell struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	// lines are what the shell prints. It is closed when the shell exits.
+	lines chan string
+	// exited is closed when the shell exited.
+	exited chan struct{}
+	// dir is the working directory after the last command.
+	dir string
+	// tempDir is where the output of commands goes, the default temporary
+	// directory when empty.
+	tempDir string
+	// terminal controls the terminal of a shell with job control, which
+	// runs every command in a process group of its own. It is nil on
+	// systems without terminals, where commands run in the shell's group.
+	terminal *os.File
+
+	jobsMu sync.Mutex
+	// jobs are the process groups of the background jobs the shell reported
+	// after the last command.
+	jobs []int
+}
+
+type shellResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	// Dir is the working directory after the command.
+	Dir string
+}
+
+// shellPath returns the shell to run commands with: bash when available,
+// sh otherwise.
+func shellPath() string {
+	if path, err := exec.LookPath("bash"); err == nil {
+		return path
+	}
+	return "sh"
+}
+
+// newShell starts a shell in dir, confined by sandbox unless it is nil.
+func newShell(dir string, sandbox *Sandbox) (*shell, error) {
+	cmd := exec.Command(shellPath())
+	cmd.Dir = dir
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	terminal, tty, err := openTerminal()
+	if err != nil {
+		setProcessGroup(cmd)
+	} else {
+		defer tty.Close()
+		setTerminal(cmd, tty)
+		// Job control is turned on at start, as the shell then keeps its
+		// own descriptor of the terminal, which redirecting the stderr of
+		// commands does not replace.
+		cmd.Args = append(cmd.Args, "-m")
+	}
+	if err := sandbox.start(cmd); err != nil {
+		if terminal != nil {
+			terminal.Close()
+		}
+		return nil, err
+	}
+
+	s := &shell{
+		cmd:      cmd,
+		stdin:    stdin,
+		lines:    make(chan string),
+		exited:   make(chan struct{}),
+		dir:      dir,
+		terminal: terminal,
+	}
+	if sandbox != nil {
+		s.tempDir = sandbox.tempDir
+	}
+	if terminal != nil {
+		// Only the shell's reports on jobs reach the terminal.
+		go io.Copy(io.Discard, terminal)
+	}
+	go func() {
+		r := bufio.NewReader(stdout)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			s.lines <- strings.TrimSuffix(line, "\n")
+		}
+		cmd.Wait()
+		if terminal != nil {
+			terminal.Close()
+		}
+		close(s.exited)
+		close(s.lines)
+	}()
+
+	// Interrupting a command signals its process group. With job control,
+	// that group is the command's own, so that background jobs keep
+	// running; without it, it is the shell's. The shell survives being
+	// signalled, while the commands it runs do not, since trapped signals
+	// are reset for them.
+	if _, err := io.WriteString(stdin, "trap : TERM\n"); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// run runs command in the shell. When ctx is done, the command is
+// interrupted and the shell kept, unless the command does not stop, in which
+// case the shell is killed with it. A command may also exit the shell, which
+// alive reports afterwards.
+func (s *shell) run(ctx context.Context, command string) (shellResult, error) {
+	stdoutPath, err := tempFile(s.tempDir)
+	if err != nil {
+		return shellResult{}, err
+	}
+	defer os.Remove(stdoutPath)
+	stderrPath, err := tempFile(s.tempDir)
+	if err != nil {
+		return shellResult{}, err
+	}
+	defer os.Remove(stderrPath)
+
+	marker := uuid.NewString()
+	script := fmt.Sprintf("eval %s < /dev/null > %s 2> %s\nprintf '%s %%d %%s:%%s\\n' \"$?\" \"$(echo $(jobs -p))\" \"$PWD\"\n",
+		shellQuote(command), shellQuote(stdoutPath), shellQuote(stderrPath), marker)
+	if _, err := io.WriteString(s.stdin, script); err != nil {
+		return shellResult{}, fmt.Errorf("shell is gone: %w", err)
+	}
+
+	result := shellResult{Dir: s.dir}
+	var kill <-chan time.Time
+	done := ctx.Done()
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				result.ExitCode = s.cmd.ProcessState.ExitCode()
+				return s.readOutput(result, stdoutPath, stderrPath), nil
+			}
+			status, ok := strings.CutPrefix(line, marker+" ")
+			if !ok {
+				continue
+			}
+			// The background jobs come before the directory, which may
+			// contain colons.
+			code, rest, _ := strings.Cut(status, " ")
+			jobs, dir, _ := strings.Cut(rest, ":")
+			result.ExitCode, _ = strconv.Atoi(code)
+			result.Dir = dir
+			s.dir = dir
+			s.setJobs(jobs)
+			return s.readOutput(result, stdoutPath, stderrPath), nil
+		case <-done:
+			done = nil
+			s.interrupt(syscall.SIGTERM)
+			kill = time.After(shellKillDelay)
+		case <-kill:
+			kill = nil
+			s.interrupt(syscall.SIGKILL)
+			signalGroup(s.cmd, syscall.SIGKILL)
+		}
+	}
+}
+
+// interrupt sends sig to the command the shell runs: the process group in
+// the foreground of its terminal, or the shell's whole group when it has no
+// terminal or runs the command itself.
+func (s *shell) interrupt(sig syscall.Signal) {
+	if s.terminal != nil {
+		if pgid, err := foregroundGroup(s.terminal); err == nil && pgid > 0 && pgid != s.cmd.Process.Pid {
+			signalProcessGroup(pgid, sig)
+			return
+		}
+	}
+	signalGroup(s.cmd, sig)
+}
+
+// setJobs records the process groups of the background jobs, which the
+// shell reports separated by spaces.
+func (s *shell) setJobs(jobs string) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	s.jobs = s.jobs[:0]
+	for _, job := range strings.Fields(jobs) {
+		if pgid, err := strconv.Atoi(job); err == nil {
+			s.jobs = append(s.jobs, pgid)
+		}
+	}
+}
+
+// environ returns the environment the shell exports.
+func (s *shell) environ(ctx context.Context) ([]string, error) {
+	result, err := s.run(ctx, "env -0")
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("env exited with code %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	return strings.Split(strings.TrimSuffix(result.Stdout, "\x00"), "\x00"), nil
+}
+
+func (s *shell) readOutput(result shellResult, stdoutPath, stderrPath string) shellResult {
+	stdout, _ := os.ReadFile(stdoutPath)
+	stderr, _ := os.ReadFile(stderrPath)
+	result.Stdout = string(stdout)
+	result.Stderr = string(stderr)
+	return result
+}
+
+// alive reports whether the shell is still running.
+func (s *shell) alive() bool {
+	select {
+	case <-s.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// close kills the shell and the processes it started.
+func (s *shell) close() {
+	if s.alive() {
+		s.interrupt(syscall.SIGKILL)
+		s.jobsMu.Lock()
+		for _, pgid := range s.jobs {
+			signalProcessGroup(pgid, syscall.SIGKILL)
+		}
+		s.jobsMu.Unlock()
+		signalGroup(s.cmd, syscall.SIGKILL)
+		for range s.lines {
+		}
+	}
+}
+
+// tempFile creates an empty file in dir for the output of a command.
+func tempFile(dir string) (string, error) {
+	f, err := os.CreateTemp(dir, "gentica-shell-")
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
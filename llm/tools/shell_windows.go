@@ -0,0 +1,21 @@
+package tools
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup kills cmd, since Windows has no signals to interrupt only the
+// command it runs.
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	return cmd.Process.Kill()
+}
+
+func setTerminal(cmd *exec.Cmd, tty *os.File) {}
+
+func signalProcessGroup(pgid int, sig syscall.Signal) error {
+	return nil
+}
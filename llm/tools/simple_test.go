@@ -14,7 +14,7 @@ func TestBasicTools(t *testing.T) {
 	tempDir := t.TempDir()
 
 	t.Run("TestBashTool_Echo", func(t *testing.T) {
-		bashTool := NewBashTool(tempDir)
+		bashTool := NewBashTool(tempDir, BashOptions{})
 		params := BashParams{
 			Command: "echo 'hello world'",
 		}
@@ -37,7 +37,7 @@ func TestBasicTools(t *testing.T) {
 	})
 
 	t.Run("TestWriteTool_CreateFile", func(t *testing.T) {
-		writeTool := NewWriteTool(tempDir)
+		writeTool := NewWriteTool(nil, tempDir)
 		filePath := filepath.Join(tempDir, "test.txt")
 		content := "Test content"
 
@@ -101,7 +101,7 @@ func TestBasicTools(t *testing.T) {
 	})
 
 	t.Run("TestEditTool_CreateNewFile", func(t *testing.T) {
-		editTool := NewEditTool(tempDir)
+		editTool := NewEditTool(nil, tempDir)
 		filePath := filepath.Join(tempDir, "edit_test.txt")
 
 		params := EditParams{
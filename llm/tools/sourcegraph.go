@@ -165,6 +165,7 @@ func (t *sourcegraphTool) Info() ToolInfo {
 			},
 		},
 		Required: []string{"query"},
+		ReadOnly: true,
 	}
 }
 
@@ -0,0 +1,44 @@
+//go:build darwin
+
+package tools
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// openTerminal opens a pseudo-terminal. control is the side that reads what
+// is written to tty, the side processes use.
+func openTerminal() (control, tty *os.File, err error) {
+	control, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var name [128]byte
+	err = ioctl(control, func(fd int) error {
+		if err := unix.IoctlSetInt(fd, unix.TIOCPTYGRANT, 0); err != nil {
+			return err
+		}
+		if err := unix.IoctlSetInt(fd, unix.TIOCPTYUNLK, 0); err != nil {
+			return err
+		}
+		// x/sys/unix has no wrapper for ioctls that fill a buffer.
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), unix.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0]))); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+	if err == nil {
+		path, _, _ := bytes.Cut(name[:], []byte{0})
+		tty, err = os.OpenFile(string(path), os.O_RDWR|syscall.O_NOCTTY, 0)
+	}
+	if err != nil {
+		control.Close()
+		return nil, nil, err
+	}
+	return control, tty, nil
+}
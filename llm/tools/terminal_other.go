@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package tools
+
+import (
+	"errors"
+	"os"
+)
+
+func openTerminal() (control, tty *os.File, err error) {
+	return nil, nil, errors.ErrUnsupported
+}
+
+func foregroundGroup(control *os.File) (int, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,35 @@
+//go:build linux || darwin
+
+package tools
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// foregroundGroup returns the process group in the foreground of the
+// terminal, which a shell with job control gives to the command it waits
+// for.
+func foregroundGroup(control *os.File) (int, error) {
+	var pgid int
+	err := ioctl(control, func(fd int) (err error) {
+		pgid, err = unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+		return err
+	})
+	return pgid, err
+}
+
+// ioctl calls f with the descriptor of file, without making it blocking as
+// Fd does.
+func ioctl(file *os.File, f func(fd int) error) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err := conn.Control(func(fd uintptr) { ferr = f(int(fd)) }); err != nil {
+		return err
+	}
+	return ferr
+}
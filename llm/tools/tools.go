@@ -10,6 +10,9 @@ type ToolInfo struct {
 	Description string
 	Parameters  map[string]any
 	Required    []string
+	// ReadOnly marks tools without side effects, which the agent may run
+	// concurrently with other read-only tools.
+	ReadOnly bool
 }
 
 type toolResponseType string
@@ -32,6 +35,9 @@ type ToolResponse struct {
 	Content  string           `json:"content"`
 	Metadata string           `json:"metadata,omitempty"`
 	IsError  bool             `json:"is_error"`
+	// Data and MIMEType hold the image of image responses.
+	Data     []byte `json:"data,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
 }
 
 func NewTextResponse(content string) ToolResponse {
@@ -41,6 +47,17 @@ func NewTextResponse(content string) ToolResponse {
 	}
 }
 
+// NewImageResponse returns a response carrying an image, described by
+// content for models that cannot see it.
+func NewImageResponse(content string, data []byte, mimeType string) ToolResponse {
+	return ToolResponse{
+		Type:     ToolResponseTypeImage,
+		Content:  content,
+		Data:     data,
+		MIMEType: mimeType,
+	}
+}
+
 func WithResponseMetadata(response ToolResponse, metadata any) ToolResponse {
 	if metadata != nil {
 		metadataBytes, err := json.Marshal(metadata)
@@ -72,6 +89,18 @@ type BaseTool interface {
 	Run(ctx context.Context, params ToolCall) (ToolResponse, error)
 }
 
+// SessionTool is implemented by tools that keep processes running for a
+// session after their calls return.
+type SessionTool interface {
+	BaseTool
+	// CancelSession stops the processes running in the background, when
+	// the session is canceled.
+	CancelSession(sessionID string)
+	// CloseSession stops every process kept for the session, when the
+	// session is deleted.
+	CloseSession(sessionID string)
+}
+
 func GetContextValues(ctx context.Context) (string, string) {
 	sessionID := ctx.Value(SessionIDContextKey)
 	messageID := ctx.Value(MessageIDContextKey)
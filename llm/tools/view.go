@@ -10,6 +10,8 @@ import (
 	"path/filepath"
 	"strings"
 	"unicode/utf8"
+
+	"gentica/llm/tools/pdf"
 )
 
 type ViewParams struct {
@@ -25,6 +27,8 @@ type viewTool struct {
 type ViewResponseMetadata struct {
 	FilePath string `json:"file_path"`
 	Content  string `json:"content"`
+	// MIMEType is the type of the image of image files.
+	MIMEType string `json:"mime_type,omitempty"`
 }
 
 const (
@@ -32,17 +36,20 @@ const (
 	MaxReadSize      = 250 * 1024
 	DefaultReadLimit = 2000
 	MaxLineLength    = 2000
-	viewDescription  = `File viewing tool that reads and displays the contents of files with line numbers, allowing you to examine code, logs, or text data.
+	viewDescription  = `File viewing tool that reads and displays the contents of files with line numbers, allowing you to examine code, logs, or text data. It also shows images, the text of PDFs and the cells of Jupyter notebooks.
 
 WHEN TO USE THIS TOOL:
 - Use when you need to read the contents of a specific file
 - Helpful for examining source code, configuration files, or log files
 - Perfect for looking at text-based file formats
+- Use to look at screenshots, diagrams and other images
+- Use to read PDF documents and Jupyter notebooks (.ipynb)
 
 HOW TO USE:
 - Provide the path to the file you want to view
 - Optionally specify an offset to start reading from a specific line
 - Optionally specify a limit to control how many lines are read
+- For PDFs, offset and limit count pages; for notebooks, they count cells
 - Do not use this for directories use the ls tool instead
 
 FEATURES:
@@ -51,13 +58,17 @@ FEATURES:
 - Handles large files by limiting the number of lines read
 - Automatically truncates very long lines for better display
 - Suggests similar file names when the requested file isn't found
+- Returns images (PNG, JPEG, GIF, WebP, BMP) as images, scaled down when larger than 1568 pixels
+- Extracts the text of PDFs page by page
+- Renders the cells of notebooks with their outputs
 
 LIMITATIONS:
-- Maximum file size is 250KB
-- Default reading limit is 2000 lines
+- Maximum file size is 250KB, or 20MB for images, PDFs and notebooks
+- Default reading limit is 2000 lines, 20 PDF pages or 200 notebook cells
 - Lines longer than 2000 characters are truncated
-- Cannot display binary files or images
-- Images can be identified but not displayed
+- Cannot display other binary files
+- Images are only shown to models that support them
+- Text is not extracted from scanned or encrypted PDFs
 
 WINDOWS NOTES:
 - Handles both Windows (CRLF) and Unix (LF) line endings automatically
@@ -70,6 +81,14 @@ TIPS:
 - When viewing large files, use the offset parameter to read specific sections`
 )
 
+const (
+	// MaxDocumentSize is the maximum size of images, PDFs and notebooks,
+	// which are read whole.
+	MaxDocumentSize          = 20 * 1024 * 1024
+	DefaultPDFPageLimit      = 20
+	DefaultNotebookCellLimit = 200
+)
+
 func NewViewTool(workingDir string) BaseTool {
 	return &viewTool{
 		workingDir: workingDir,
@@ -91,14 +110,15 @@ func (v *viewTool) Info() ToolInfo {
 			},
 			"offset": map[string]any{
 				"type":        "integer",
-				"description": "Number of lines to skip from the beginning (0-based, default 0)",
+				"description": "Number of lines to skip from the beginning (0-based, default 0). Pages for PDFs, cells for notebooks",
 			},
 			"limit": map[string]any{
 				"type":        "integer",
-				"description": "The number of lines to read (defaults to 2000)",
+				"description": "The number of lines to read (defaults to 2000). Pages for PDFs (defaults to 20), cells for notebooks (defaults to 200)",
 			},
 		},
 		Required: []string{"file_path"},
+		ReadOnly: true,
 	}
 }
 
@@ -164,6 +184,28 @@ func (v *viewTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error)
 		return NewTextErrorResponse(fmt.Sprintf("Path is a directory, not a file: %s", filePath)), nil
 	}
 
+	// Images, PDFs and notebooks are rendered rather than read line by line.
+	ext := strings.ToLower(filepath.Ext(filePath))
+	isImage, imageType := isImageFile(filePath)
+	if isImage || ext == ".pdf" || ext == ".ipynb" {
+		if fileInfo.Size() > MaxDocumentSize {
+			return NewTextErrorResponse(fmt.Sprintf("File is too large (%d bytes). Maximum size is %d bytes",
+				fileInfo.Size(), MaxDocumentSize)), nil
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return ToolResponse{}, fmt.Errorf("error reading file: %w", err)
+		}
+		switch {
+		case isImage:
+			return viewImage(filePath, imageType, data), nil
+		case ext == ".pdf":
+			return viewPDF(filePath, data, params.Offset, params.Limit), nil
+		default:
+			return viewNotebook(filePath, data, params.Offset, params.Limit), nil
+		}
+	}
+
 	// Check file size
 	if fileInfo.Size() > MaxReadSize {
 		return NewTextErrorResponse(fmt.Sprintf("File is too large (%d bytes). Maximum size is %d bytes",
@@ -175,12 +217,6 @@ func (v *viewTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error)
 		params.Limit = DefaultReadLimit
 	}
 
-	// Check if it's an image file
-	isImage, imageType := isImageFile(filePath)
-	if isImage {
-		return NewTextErrorResponse(fmt.Sprintf("This is an image file of type: %s\n", imageType)), nil
-	}
-
 	// Read the file content
 	content, lineCount, err := readTextFile(filePath, params.Offset, params.Limit)
 	if err != nil {
@@ -220,6 +256,130 @@ func (v *viewTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error)
 	), nil
 }
 
+// viewImage returns an image, scaled down when it is too large to send.
+func viewImage(filePath, imageType string, data []byte) ToolResponse {
+	img, err := prepareImage(data)
+	if err != nil {
+		return NewTextErrorResponse(fmt.Sprintf("Cannot read image file %s: %s", filePath, err))
+	}
+	content := fmt.Sprintf("Image file %s (%s, %dx%d pixels)", filePath, imageType, img.width, img.height)
+	if img.scaled() {
+		content += fmt.Sprintf(", scaled down to %dx%d pixels", img.scaledWidth, img.scaledHeight)
+	}
+	recordFileRead(filePath)
+	return WithResponseMetadata(
+		NewImageResponse(content, img.data, img.mimeType),
+		ViewResponseMetadata{
+			FilePath: filePath,
+			MIMEType: img.mimeType,
+		},
+	)
+}
+
+// viewPDF returns the text of the pages of a PDF, from page offset+1.
+// Pages whose text cannot be read are reported in their place.
+func viewPDF(filePath string, data []byte, offset, limit int) ToolResponse {
+	doc, err := pdf.Parse(data)
+	if err != nil {
+		return NewTextErrorResponse(fmt.Sprintf("Cannot read PDF file %s: %s", filePath, err))
+	}
+	if limit <= 0 {
+		limit = DefaultPDFPageLimit
+	}
+	pages := doc.NumPages()
+	if offset < 0 || offset >= pages {
+		return NewTextErrorResponse(fmt.Sprintf("Offset %d is out of range, the PDF has %d pages", offset, pages))
+	}
+
+	var content strings.Builder
+	end := min(offset+limit, pages)
+	for i := offset; i < end; i++ {
+		if i > offset && content.Len() >= MaxReadSize {
+			end = i
+			break
+		}
+		text, err := doc.PageText(i)
+		switch {
+		case err != nil:
+			text = fmt.Sprintf("(error reading page: %s)", err)
+		case text == "":
+			text = "(no text on this page)"
+		}
+		fmt.Fprintf(&content, "<page number=\"%d\">\n%s\n</page>\n", i+1, truncateLines(strings.ToValidUTF8(text, "\uFFFD")))
+	}
+
+	output := "<file>\n" + content.String()
+	if end < pages {
+		output += fmt.Sprintf("\n(PDF has %d more pages. Use 'offset' parameter to read from page %d)\n", pages-end, end+1)
+	}
+	output += "</file>"
+
+	recordFileRead(filePath)
+	return WithResponseMetadata(
+		NewTextResponse(output),
+		ViewResponseMetadata{
+			FilePath: filePath,
+			Content:  content.String(),
+		},
+	)
+}
+
+// viewNotebook returns the cells of a Jupyter notebook with their outputs,
+// from cell offset+1.
+func viewNotebook(filePath string, data []byte, offset, limit int) ToolResponse {
+	var nb notebook
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return NewTextErrorResponse(fmt.Sprintf("Cannot read notebook %s: %s", filePath, err))
+	}
+	if limit <= 0 {
+		limit = DefaultNotebookCellLimit
+	}
+	cells := len(nb.Cells)
+	if cells == 0 {
+		recordFileRead(filePath)
+		return NewTextResponse("<file>\n(empty notebook)\n</file>")
+	}
+	if offset < 0 || offset >= cells {
+		return NewTextErrorResponse(fmt.Sprintf("Offset %d is out of range, the notebook has %d cells", offset, cells))
+	}
+
+	var content strings.Builder
+	end := min(offset+limit, cells)
+	for i := offset; i < end; i++ {
+		if i > offset && content.Len() >= MaxReadSize {
+			end = i
+			break
+		}
+		content.WriteString(nb.Cells[i].render(i+1, nb.language()))
+	}
+
+	output := "<file>\n" + content.String()
+	if end < cells {
+		output += fmt.Sprintf("\n(Notebook has %d more cells. Use 'offset' parameter to read from cell %d)\n", cells-end, end+1)
+	}
+	output += "</file>"
+
+	recordFileRead(filePath)
+	return WithResponseMetadata(
+		NewTextResponse(output),
+		ViewResponseMetadata{
+			FilePath: filePath,
+			Content:  content.String(),
+		},
+	)
+}
+
+// truncateLines truncates the lines of text longer than MaxLineLength.
+func truncateLines(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if len(line) > MaxLineLength {
+			lines[i] = strings.ToValidUTF8(line[:MaxLineLength], "") + "... (truncated)"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 func addLineNumbers(content string, startLine int) string {
 	if content == "" {
 		return ""
@@ -305,8 +465,6 @@ func isImageFile(filePath string) (bool, string) {
 		return true, "GIF"
 	case ".bmp":
 		return true, "BMP"
-	case ".svg":
-		return true, "SVG"
 	case ".webp":
 		return true, "WebP"
 	default:
@@ -1,8 +1,13 @@
 package tools
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"os"
 	"path/filepath"
 	"strings"
@@ -231,4 +236,144 @@ func TestViewTool(t *testing.T) {
 		require.True(t, response.IsError)
 		require.Contains(t, response.Content, "invalid parameters")
 	})
-}
\ No newline at end of file
+
+	t.Run("image", func(t *testing.T) {
+		filePath := filepath.Join(tempDir, "small.png")
+		data := pngImage(t, 40, 30)
+		require.NoError(t, os.WriteFile(filePath, data, 0o644))
+
+		response := runView(t, viewTool, ViewParams{FilePath: filePath})
+		require.False(t, response.IsError)
+		require.Equal(t, ToolResponseTypeImage, response.Type)
+		require.Equal(t, "image/png", response.MIMEType)
+		require.Equal(t, data, response.Data)
+		require.Contains(t, response.Content, "PNG, 40x30 pixels")
+	})
+
+	t.Run("large image is scaled down", func(t *testing.T) {
+		filePath := filepath.Join(tempDir, "large.png")
+		require.NoError(t, os.WriteFile(filePath, pngImage(t, 3136, 200), 0o644))
+
+		response := runView(t, viewTool, ViewParams{FilePath: filePath})
+		require.False(t, response.IsError)
+		require.Contains(t, response.Content, "scaled down to 1568x100 pixels")
+		config, format, err := image.DecodeConfig(bytes.NewReader(response.Data))
+		require.NoError(t, err)
+		require.Equal(t, "png", format)
+		require.Equal(t, image.Config{ColorModel: config.ColorModel, Width: 1568, Height: 100}, config)
+	})
+
+	t.Run("invalid image", func(t *testing.T) {
+		filePath := filepath.Join(tempDir, "broken.jpg")
+		require.NoError(t, os.WriteFile(filePath, []byte("not an image"), 0o644))
+
+		response := runView(t, viewTool, ViewParams{FilePath: filePath})
+		require.True(t, response.IsError)
+		require.Contains(t, response.Content, "Cannot read image file")
+	})
+
+	t.Run("pdf pages", func(t *testing.T) {
+		filePath := filepath.Join(tempDir, "doc.pdf")
+		require.NoError(t, os.WriteFile(filePath, pdfDocument("First page", "", "Third page"), 0o644))
+
+		response := runView(t, viewTool, ViewParams{FilePath: filePath})
+		require.False(t, response.IsError)
+		require.Contains(t, response.Content, "<page number=\"1\">\nFirst page\n</page>")
+		require.Contains(t, response.Content, "<page number=\"2\">\n(no text on this page)\n</page>")
+		require.Contains(t, response.Content, "Third page")
+
+		response = runView(t, viewTool, ViewParams{FilePath: filePath, Offset: 1, Limit: 1})
+		require.False(t, response.IsError)
+		require.NotContains(t, response.Content, "First page")
+		require.NotContains(t, response.Content, "Third page")
+		require.Contains(t, response.Content, "(PDF has 1 more pages. Use 'offset' parameter to read from page 3)")
+
+		response = runView(t, viewTool, ViewParams{FilePath: filePath, Offset: 3})
+		require.True(t, response.IsError)
+		require.Contains(t, response.Content, "the PDF has 3 pages")
+	})
+
+	t.Run("notebook cells", func(t *testing.T) {
+		filePath := filepath.Join(tempDir, "analysis.ipynb")
+		notebook := `{
+  "cells": [
+    {"cell_type": "markdown", "metadata": {}, "source": ["# Analysis\n", "Loads the data."]},
+    {"cell_type": "code", "execution_count": 1, "metadata": {}, "source": "print(1)\n1 / 0", "outputs": [
+      {"output_type": "stream", "name": "stdout", "text": ["1\n"]},
+      {"output_type": "error", "ename": "ZeroDivisionError", "evalue": "division by zero",
+       "traceback": ["\u001b[0;31mZeroDivisionError\u001b[0m: division by zero"]}
+    ]},
+    {"cell_type": "code", "execution_count": 2, "metadata": {}, "source": ["plot()"], "outputs": [
+      {"output_type": "display_data", "data": {"image/png": "iVBORw0KGgo=", "text/plain": ["<Figure>"]}, "metadata": {}}
+    ]}
+  ],
+  "metadata": {"language_info": {"name": "python"}},
+  "nbformat": 4,
+  "nbformat_minor": 5
+}`
+		require.NoError(t, os.WriteFile(filePath, []byte(notebook), 0o644))
+
+		response := runView(t, viewTool, ViewParams{FilePath: filePath})
+		require.False(t, response.IsError)
+		require.Contains(t, response.Content, "<cell number=\"1\" type=\"markdown\">\n# Analysis\nLoads the data.\n</cell>")
+		require.Contains(t, response.Content, "<cell number=\"2\" type=\"code\" language=\"python\" execution_count=\"1\">\nprint(1)\n1 / 0\n")
+		require.Contains(t, response.Content, "<output type=\"stream\">\n1\n</output>")
+		require.Contains(t, response.Content, "<output type=\"error\">\nZeroDivisionError: division by zero\n</output>")
+		require.Contains(t, response.Content, "<Figure>\n(image/png output not shown)")
+
+		response = runView(t, viewTool, ViewParams{FilePath: filePath, Offset: 1, Limit: 1})
+		require.False(t, response.IsError)
+		require.NotContains(t, response.Content, "# Analysis")
+		require.NotContains(t, response.Content, "plot()")
+		require.Contains(t, response.Content, "(Notebook has 1 more cells. Use 'offset' parameter to read from cell 3)")
+	})
+}
+
+func runView(t *testing.T, viewTool BaseTool, params ViewParams) ToolResponse {
+	t.Helper()
+	paramsJSON, err := json.Marshal(params)
+	require.NoError(t, err)
+	response, err := viewTool.Run(context.Background(), ToolCall{Input: string(paramsJSON)})
+	require.NoError(t, err)
+	return response
+}
+
+func pngImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		for y := range height {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var b bytes.Buffer
+	require.NoError(t, png.Encode(&b, img))
+	return b.Bytes()
+}
+
+// pdfDocument returns a PDF with a page showing each text.
+func pdfDocument(texts ...string) []byte {
+	objects := []string{"<< /Type /Catalog /Pages 2 0 R >>", ""}
+	var kids []string
+	for _, text := range texts {
+		content := ""
+		if text != "" {
+			content = fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+		}
+		kids = append(kids, fmt.Sprintf("%d 0 R", len(objects)+1))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /Contents %d 0 R >>", len(objects)+2),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d /Resources << /Font << /F1 %d 0 R >> >> >>",
+		strings.Join(kids, " "), len(texts), len(objects)+1)
+	objects = append(objects, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>")
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, object := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\n%%%%EOF\n", len(objects)+1)
+	return b.Bytes()
+}
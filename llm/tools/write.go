@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"gentica/history"
 )
 
 type WriteParams struct {
@@ -16,6 +18,7 @@ type WriteParams struct {
 }
 
 type writeTool struct {
+	files      history.Service
 	workingDir string
 }
 
@@ -60,8 +63,11 @@ TIPS:
 - Always include descriptive comments when making changes to existing code`
 )
 
-func NewWriteTool(workingDir string) BaseTool {
+// NewWriteTool returns the write tool. Files it writes are saved to files,
+// unless it is nil.
+func NewWriteTool(files history.Service, workingDir string) BaseTool {
 	return &writeTool{
+		files:      files,
 		workingDir: workingDir,
 	}
 }
@@ -147,6 +153,17 @@ func (w *writeTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error
 		return NewTextErrorResponse(fmt.Sprintf("failed to create parent directories: %v", err)), nil
 	}
 
+	// Save the change before making it, so that it can be undone
+	err = recordFileChange(ctx, w.files, history.Change{
+		Path:    filePath,
+		Before:  string(existingContent),
+		After:   params.Content,
+		Created: !existingFile,
+	})
+	if err != nil {
+		return NewTextErrorResponse(fmt.Sprintf("failed to save file history: %v", err)), nil
+	}
+
 	// Write the file
 	err = os.WriteFile(filePath, []byte(params.Content), 0o644)
 	if err != nil {
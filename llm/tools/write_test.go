@@ -7,13 +7,18 @@ import (
 	"path/filepath"
 	"testing"
 
+	"gentica/db"
+	"gentica/history"
+	"gentica/message"
+	"gentica/session"
+
 	"github.com/stretchr/testify/require"
 )
 
 func TestWriteTool(t *testing.T) {
 	t.Parallel()
 	tempDir := t.TempDir()
-	writeTool := NewWriteTool(tempDir)
+	writeTool := NewWriteTool(nil, tempDir)
 
 	t.Run("create new file", func(t *testing.T) {
 		filePath := filepath.Join(tempDir, "new.txt")
@@ -217,4 +222,42 @@ func TestWriteTool(t *testing.T) {
 			require.Greater(t, metadata.Additions, 0)
 		}
 	})
-}
\ No newline at end of file
+}
+func TestWriteToolHistory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn, err := db.Connect(ctx, t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	q := db.New(conn)
+	sess, err := session.NewService(q).Create(ctx, "test")
+	require.NoError(t, err)
+	msg, err := message.NewService(q).Create(ctx, sess.ID, message.CreateMessageParams{Role: message.Assistant})
+	require.NoError(t, err)
+	files := history.NewService(q)
+
+	tempDir := t.TempDir()
+	writeTool := NewWriteTool(files, tempDir)
+	filePath := filepath.Join(tempDir, "main.go")
+	ctx = context.WithValue(ctx, SessionIDContextKey, sess.ID)
+	ctx = context.WithValue(ctx, MessageIDContextKey, msg.ID)
+
+	params, err := json.Marshal(WriteParams{FilePath: filePath, Content: "package main"})
+	require.NoError(t, err)
+	response, err := writeTool.Run(ctx, ToolCall{Input: string(params)})
+	require.NoError(t, err)
+	require.False(t, response.IsError)
+
+	versions, err := files.List(ctx, sess.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.True(t, versions[0].IsNew)
+	require.Equal(t, msg.ID, versions[1].MessageID)
+	require.Equal(t, "package main", versions[1].Content)
+
+	restored, err := files.Rewind(ctx, sess.ID, msg.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{filePath}, restored)
+	require.NoFileExists(t, filePath)
+}
@@ -26,6 +26,10 @@ const (
 	FinishReasonCanceled         FinishReason = "canceled"
 	FinishReasonError            FinishReason = "error"
 	FinishReasonPermissionDenied FinishReason = "permission_denied"
+	FinishReasonBudgetExceeded   FinishReason = "budget_exceeded"
+	// FinishReasonContentFiltered is the reason of responses the provider
+	// stopped or withheld for safety, e.g. harmful or recited content.
+	FinishReasonContentFiltered FinishReason = "content_filtered"
 
 	// Should never happen
 	FinishReasonUnknown FinishReason = "unknown"
@@ -100,6 +104,9 @@ type ToolResult struct {
 	Content    string `json:"content"`
 	Metadata   string `json:"metadata"`
 	IsError    bool   `json:"is_error"`
+	// Data and MIMEType hold the image of tool results that return one.
+	Data     []byte `json:"data,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
 }
 
 func (ToolResult) isPart() {}
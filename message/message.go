@@ -113,6 +113,7 @@ func (s *service) Update(ctx context.Context, message Message) error {
 		ID:         message.ID,
 		Parts:      string(parts),
 		FinishedAt: finishedAt,
+		Provider:   sql.NullString{String: message.Provider, Valid: message.Provider != ""},
 	})
 	if err != nil {
 		return err
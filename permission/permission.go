@@ -0,0 +1,299 @@
+package permission
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"gentica/pubsub"
+
+	"github.com/google/uuid"
+)
+
+// ErrPermissionDenied is returned by tools when the user refused to let
+// them run.
+var ErrPermissionDenied = errors.New("permission denied")
+
+type CreatePermissionRequest struct {
+	SessionID   string `json:"session_id"`
+	ToolCallID  string `json:"tool_call_id"`
+	ToolName    string `json:"tool_name"`
+	Description string `json:"description"`
+	Action      string `json:"action"`
+	Params      any    `json:"params"`
+	Path        string `json:"path"`
+}
+
+type PermissionRequest struct {
+	ID          string `json:"id"`
+	SessionID   string `json:"session_id"`
+	ToolCallID  string `json:"tool_call_id"`
+	ToolName    string `json:"tool_name"`
+	Description string `json:"description"`
+	Action      string `json:"action"`
+	Params      any    `json:"params"`
+	Path        string `json:"path"`
+}
+
+// Service decides whether a tool may run. Requests that are not covered by
+// a grant are published as created events and block until Grant,
+// GrantSession, GrantPersistent or Deny is called with them; a deleted event
+// is published once they are answered.
+type Service interface {
+	pubsub.Suscriber[PermissionRequest]
+	// Request returns nil when the tool may run and ErrPermissionDenied
+	// when it may not.
+	Request(ctx context.Context, opts CreatePermissionRequest) error
+	// Grant allows this request only.
+	Grant(permission PermissionRequest)
+	// GrantSession allows the same action on the same path for the rest of
+	// the session.
+	GrantSession(permission PermissionRequest)
+	// GrantPersistent always allows the action of the tool, in every session.
+	GrantPersistent(permission PermissionRequest)
+	Deny(permission PermissionRequest)
+	AutoApproveSession(sessionID string)
+	SetSkipRequests(skip bool)
+	SkipRequests() bool
+}
+
+// Options configures the permission service.
+type Options struct {
+	// AllowedTools never ask for permission. Entries are either a tool
+	// name or "tool:action".
+	AllowedTools []string
+	// SkipRequests grants every request without asking.
+	SkipRequests bool
+	// Persist is called with the "tool:action" entry of a persistent grant
+	// so that it can be stored, e.g. in the config file.
+	Persist func(allowedTool string) error
+}
+
+type sessionGrant struct {
+	sessionID string
+	toolName  string
+	action    string
+	path      string
+}
+
+type permissionService struct {
+	*pubsub.Broker[PermissionRequest]
+
+	workingDir string
+	persist    func(allowedTool string) error
+
+	mu                  sync.RWMutex
+	skipRequests        bool
+	allowedTools        []string
+	sessionGrants       []sessionGrant
+	autoApproveSessions map[string]bool
+	pending             map[string]chan bool
+	// turns makes the requests of a session wait for each other, so that
+	// only one is pending at a time and an answer can cover the ones queued
+	// behind it. Sessions do not wait for each other.
+	turns map[string]*requestTurn
+}
+
+// requestTurn is held by the request of a session that is pending. Its
+// channel has room for one token, so that waiting for it can be cancelled.
+type requestTurn struct {
+	token   chan struct{}
+	waiters int
+}
+
+// NewPermissionService creates a permission service. Paths of requests are
+// resolved against workingDir.
+func NewPermissionService(workingDir string, opts Options) Service {
+	return &permissionService{
+		Broker:              pubsub.NewBroker[PermissionRequest](),
+		workingDir:          workingDir,
+		persist:             opts.Persist,
+		skipRequests:        opts.SkipRequests,
+		allowedTools:        slices.Clone(opts.AllowedTools),
+		autoApproveSessions: make(map[string]bool),
+		pending:             make(map[string]chan bool),
+		turns:               make(map[string]*requestTurn),
+	}
+}
+
+func (s *permissionService) Request(ctx context.Context, opts CreatePermissionRequest) error {
+	request := PermissionRequest{
+		ID:          uuid.New().String(),
+		SessionID:   opts.SessionID,
+		ToolCallID:  opts.ToolCallID,
+		ToolName:    opts.ToolName,
+		Description: opts.Description,
+		Action:      opts.Action,
+		Params:      opts.Params,
+		Path:        s.resolvePath(opts.Path),
+	}
+	if s.granted(request) {
+		return nil
+	}
+
+	release, err := s.waitTurn(ctx, request.SessionID)
+	if err != nil {
+		return err
+	}
+	defer release()
+	// An answer to the request before this one may cover this one too.
+	if s.granted(request) {
+		return nil
+	}
+
+	answer := make(chan bool, 1)
+	s.mu.Lock()
+	s.pending[request.ID] = answer
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, request.ID)
+		s.mu.Unlock()
+	}()
+
+	s.Publish(pubsub.CreatedEvent, request)
+	select {
+	case granted := <-answer:
+		if !granted {
+			return ErrPermissionDenied
+		}
+		return nil
+	case <-ctx.Done():
+		s.Publish(pubsub.DeletedEvent, request)
+		return ctx.Err()
+	}
+}
+
+// waitTurn waits until no other request of the session is pending, or until
+// ctx is done. The returned function ends the turn.
+func (s *permissionService) waitTurn(ctx context.Context, sessionID string) (func(), error) {
+	s.mu.Lock()
+	turn, ok := s.turns[sessionID]
+	if !ok {
+		turn = &requestTurn{token: make(chan struct{}, 1)}
+		s.turns[sessionID] = turn
+	}
+	turn.waiters++
+	s.mu.Unlock()
+
+	leave := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		turn.waiters--
+		if turn.waiters == 0 {
+			delete(s.turns, sessionID)
+		}
+	}
+	select {
+	case turn.token <- struct{}{}:
+		return func() {
+			<-turn.token
+			leave()
+		}, nil
+	case <-ctx.Done():
+		leave()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *permissionService) Grant(permission PermissionRequest) {
+	s.answer(permission, true)
+}
+
+func (s *permissionService) GrantSession(permission PermissionRequest) {
+	s.mu.Lock()
+	s.sessionGrants = append(s.sessionGrants, sessionGrant{
+		sessionID: permission.SessionID,
+		toolName:  permission.ToolName,
+		action:    permission.Action,
+		path:      permission.Path,
+	})
+	s.mu.Unlock()
+	s.answer(permission, true)
+}
+
+func (s *permissionService) GrantPersistent(permission PermissionRequest) {
+	allowedTool := permission.ToolName + ":" + permission.Action
+	s.mu.Lock()
+	if !slices.Contains(s.allowedTools, allowedTool) {
+		s.allowedTools = append(s.allowedTools, allowedTool)
+	}
+	s.mu.Unlock()
+	if s.persist != nil {
+		if err := s.persist(allowedTool); err != nil {
+			slog.Error("Failed to persist permission", "tool", allowedTool, "error", err)
+		}
+	}
+	s.answer(permission, true)
+}
+
+func (s *permissionService) Deny(permission PermissionRequest) {
+	s.answer(permission, false)
+}
+
+func (s *permissionService) AutoApproveSession(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.autoApproveSessions[sessionID] = true
+}
+
+func (s *permissionService) SetSkipRequests(skip bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipRequests = skip
+}
+
+func (s *permissionService) SkipRequests() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.skipRequests
+}
+
+func (s *permissionService) answer(permission PermissionRequest, granted bool) {
+	s.mu.RLock()
+	answer, ok := s.pending[permission.ID]
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+	select {
+	case answer <- granted:
+		s.Publish(pubsub.DeletedEvent, permission)
+	default:
+		// Already answered.
+	}
+}
+
+func (s *permissionService) granted(request PermissionRequest) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.skipRequests || s.autoApproveSessions[request.SessionID] {
+		return true
+	}
+	if slices.Contains(s.allowedTools, request.ToolName) || slices.Contains(s.allowedTools, request.ToolName+":"+request.Action) {
+		return true
+	}
+	return slices.Contains(s.sessionGrants, sessionGrant{
+		sessionID: request.SessionID,
+		toolName:  request.ToolName,
+		action:    request.Action,
+		path:      request.Path,
+	})
+}
+
+func (s *permissionService) resolvePath(path string) string {
+	if path == "" {
+		path = s.workingDir
+	}
+	if path == "" {
+		return ""
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.workingDir, path)
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,119 @@
+package permission
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gentica/pubsub"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionService(t *testing.T) {
+	t.Parallel()
+
+	fetch := CreatePermissionRequest{
+		SessionID: "session",
+		ToolName:  "fetch",
+		Action:    "fetch",
+		Path:      "docs",
+	}
+
+	// answer requests the permission and answers it with respond once it
+	// has been published.
+	answer := func(t *testing.T, s Service, opts CreatePermissionRequest, respond func(PermissionRequest)) error {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := s.Subscribe(ctx)
+
+		result := make(chan error, 1)
+		go func() { result <- s.Request(context.Background(), opts) }()
+
+		select {
+		case event := <-events:
+			require.Equal(t, pubsub.CreatedEvent, event.Type)
+			require.Equal(t, "/work/docs", event.Payload.Path)
+			respond(event.Payload)
+		case <-time.After(5 * time.Second):
+			t.Fatal("permission request was not published")
+		}
+		return <-result
+	}
+
+	t.Run("grants once", func(t *testing.T) {
+		s := NewPermissionService("/work", Options{})
+		require.NoError(t, answer(t, s, fetch, s.Grant))
+		require.ErrorIs(t, answer(t, s, fetch, s.Deny), ErrPermissionDenied)
+	})
+
+	t.Run("grants for the session", func(t *testing.T) {
+		s := NewPermissionService("/work", Options{})
+		require.NoError(t, answer(t, s, fetch, s.GrantSession))
+		require.NoError(t, s.Request(context.Background(), fetch))
+
+		other := fetch
+		other.SessionID = "other"
+		require.ErrorIs(t, answer(t, s, other, s.Deny), ErrPermissionDenied)
+	})
+
+	t.Run("grants persistently", func(t *testing.T) {
+		var persisted []string
+		s := NewPermissionService("/work", Options{Persist: func(allowedTool string) error {
+			persisted = append(persisted, allowedTool)
+			return nil
+		}})
+		require.NoError(t, answer(t, s, fetch, s.GrantPersistent))
+
+		other := fetch
+		other.SessionID = "other"
+		require.NoError(t, s.Request(context.Background(), other))
+		require.Equal(t, []string{"fetch:fetch"}, persisted)
+	})
+
+	t.Run("honours allowed tools and skipped requests", func(t *testing.T) {
+		s := NewPermissionService("/work", Options{AllowedTools: []string{"fetch"}})
+		require.NoError(t, s.Request(context.Background(), fetch))
+
+		s = NewPermissionService("/work", Options{AllowedTools: []string{"download:download"}})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, s.Request(ctx, fetch), context.DeadlineExceeded)
+
+		s.SetSkipRequests(true)
+		require.NoError(t, s.Request(context.Background(), fetch))
+
+		s = NewPermissionService("/work", Options{})
+		s.AutoApproveSession("session")
+		require.NoError(t, s.Request(context.Background(), fetch))
+	})
+
+	t.Run("queues requests per session", func(t *testing.T) {
+		s := NewPermissionService("/work", Options{})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := s.Subscribe(ctx)
+		go func() { _ = s.Request(ctx, fetch) }()
+		<-events
+
+		// A request of the same session waits for the pending one, but
+		// gives up when its context is done.
+		waitCtx, waitCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer waitCancel()
+		require.ErrorIs(t, s.Request(waitCtx, fetch), context.DeadlineExceeded)
+
+		other := fetch
+		other.SessionID = "other"
+		result := make(chan error, 1)
+		go func() { result <- s.Request(context.Background(), other) }()
+		select {
+		case event := <-events:
+			require.Equal(t, "other", event.Payload.SessionID)
+			s.Grant(event.Payload)
+		case <-time.After(5 * time.Second):
+			t.Fatal("request of another session waited for the pending one")
+		}
+		require.NoError(t, <-result)
+	})
+}
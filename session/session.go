@@ -3,12 +3,20 @@ package session
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"slices"
 
-	"github.com/charmbracelet/crush/internal/db"
-	"github.com/charmbracelet/crush/internal/pubsub"
+	"gentica/db"
+	"gentica/pubsub"
 	"github.com/google/uuid"
 )
 
+// Session is a conversation. PromptTokens, CompletionTokens and Cost are
+// the totals of its usage ledger; Save leaves them alone, they change when
+// usage is recorded. Cost also includes what its task sessions spent.
+//
+// A fork is a session that branched off its parent session at
+// ForkMessageID; task sessions have a parent but no ForkMessageID.
 type Session struct {
 	ID               string
 	ParentSessionID  string
@@ -17,6 +25,7 @@ type Session struct {
 	PromptTokens     int64
 	CompletionTokens int64
 	SummaryMessageID string
+	ForkMessageID    string
 	Cost             float64
 	CreatedAt        int64
 	UpdatedAt        int64
@@ -27,6 +36,10 @@ type Service interface {
 	Create(ctx context.Context, title string) (Session, error)
 	CreateTitleSession(ctx context.Context, parentSessionID string) (Session, error)
 	CreateTaskSession(ctx context.Context, toolCallID, parentSessionID, title string) (Session, error)
+	// Fork copies the messages that came before atMessageID into a new
+	// session, so that the conversation can continue differently from that
+	// message on without losing the original.
+	Fork(ctx context.Context, sessionID, atMessageID string) (Session, error)
 	Get(ctx context.Context, id string) (Session, error)
 	List(ctx context.Context) ([]Session, error)
 	Save(ctx context.Context, session Session) (Session, error)
@@ -79,6 +92,116 @@ func (s *service) CreateTitleSession(ctx context.Context, parentSessionID string
 	return session, nil
 }
 
+func (s *service) Fork(ctx context.Context, sessionID, atMessageID string) (Session, error) {
+	parent, err := s.q.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		return Session{}, err
+	}
+	msgs, err := s.q.ListMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return Session{}, err
+	}
+	at := slices.IndexFunc(msgs, func(msg db.Message) bool {
+		return msg.ID == atMessageID
+	})
+	if at == -1 {
+		return Session{}, fmt.Errorf("message %s is not in session %s", atMessageID, sessionID)
+	}
+	files, err := s.q.ListFilesBySession(ctx, sessionID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	dbSession, err := s.q.CreateForkSession(ctx, db.CreateForkSessionParams{
+		ID:              uuid.New().String(),
+		ParentSessionID: sql.NullString{String: sessionID, Valid: true},
+		ForkMessageID:   sql.NullString{String: atMessageID, Valid: true},
+		Title:           parent.Title,
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	forkID := dbSession.ID
+	dbSession, err = s.copyHistory(ctx, dbSession, parent, msgs[:at], files)
+	if err != nil {
+		// Do not leave a partial fork behind.
+		_ = s.q.DeleteSession(ctx, forkID)
+		return Session{}, err
+	}
+	session := s.fromDBItem(dbSession)
+	s.Publish(pubsub.CreatedEvent, session)
+	return session, nil
+}
+
+// copyHistory copies msgs, and the file versions saved by them, into fork.
+// Copies keep their timestamps so that they sort like the originals. The
+// fork's summary is the copy of the parent's summary when it was copied.
+func (s *service) copyHistory(ctx context.Context, fork, parent db.Session, msgs []db.Message, files []db.File) (db.Session, error) {
+	var summaryMessageID string
+	copied := make(map[string]string, len(msgs))
+	for _, msg := range msgs {
+		msgCopy, err := s.q.CopyMessage(ctx, db.CopyMessageParams{
+			ID:         uuid.New().String(),
+			SessionID:  fork.ID,
+			Role:       msg.Role,
+			Parts:      msg.Parts,
+			Model:      msg.Model,
+			Provider:   msg.Provider,
+			CreatedAt:  msg.CreatedAt,
+			UpdatedAt:  msg.UpdatedAt,
+			FinishedAt: msg.FinishedAt,
+		})
+		if err != nil {
+			return db.Session{}, fmt.Errorf("failed to copy message %s: %w", msg.ID, err)
+		}
+		copied[msg.ID] = msgCopy.ID
+		if msg.ID == parent.SummaryMessageID.String {
+			summaryMessageID = msgCopy.ID
+		}
+	}
+	// A path's history stops at the first version saved by a message that
+	// was not copied. Versions without a message record a file as it was
+	// before a change and are kept.
+	cut := make(map[string]bool)
+	for _, file := range files {
+		if cut[file.Path] {
+			continue
+		}
+		messageID := file.MessageID
+		if messageID.Valid {
+			copyID, ok := copied[messageID.String]
+			if !ok {
+				cut[file.Path] = true
+				continue
+			}
+			messageID.String = copyID
+		}
+		_, err := s.q.CopyFile(ctx, db.CopyFileParams{
+			ID:        uuid.New().String(),
+			SessionID: fork.ID,
+			MessageID: messageID,
+			Path:      file.Path,
+			Content:   file.Content,
+			Version:   file.Version,
+			IsNew:     file.IsNew,
+			CreatedAt: file.CreatedAt,
+			UpdatedAt: file.UpdatedAt,
+		})
+		if err != nil {
+			return db.Session{}, fmt.Errorf("failed to copy file %s: %w", file.Path, err)
+		}
+	}
+	if summaryMessageID != "" {
+		return s.q.UpdateSession(ctx, db.UpdateSessionParams{
+			ID:               fork.ID,
+			Title:            fork.Title,
+			SummaryMessageID: sql.NullString{String: summaryMessageID, Valid: true},
+		})
+	}
+	// The message count changed while copying.
+	return s.q.GetSessionByID(ctx, fork.ID)
+}
+
 func (s *service) Delete(ctx context.Context, id string) error {
 	session, err := s.Get(ctx, id)
 	if err != nil {
@@ -102,15 +225,12 @@ func (s *service) Get(ctx context.Context, id string) (Session, error) {
 
 func (s *service) Save(ctx context.Context, session Session) (Session, error) {
 	dbSession, err := s.q.UpdateSession(ctx, db.UpdateSessionParams{
-		ID:               session.ID,
-		Title:            session.Title,
-		PromptTokens:     session.PromptTokens,
-		CompletionTokens: session.CompletionTokens,
+		ID:    session.ID,
+		Title: session.Title,
 		SummaryMessageID: sql.NullString{
 			String: session.SummaryMessageID,
 			Valid:  session.SummaryMessageID != "",
 		},
-		Cost: session.Cost,
 	})
 	if err != nil {
 		return Session{}, err
@@ -141,6 +261,7 @@ func (s service) fromDBItem(item db.Session) Session {
 		PromptTokens:     item.PromptTokens,
 		CompletionTokens: item.CompletionTokens,
 		SummaryMessageID: item.SummaryMessageID.String,
+		ForkMessageID:    item.ForkMessageID.String,
 		Cost:             item.Cost,
 		CreatedAt:        item.CreatedAt,
 		UpdatedAt:        item.UpdatedAt,
@@ -0,0 +1,112 @@
+package session_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"gentica/db"
+	"gentica/message"
+	"gentica/session"
+	"gentica/usage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFork(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn, err := db.Connect(ctx, t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	q := db.New(conn)
+	sessions := session.NewService(q)
+	messages := message.NewService(q)
+
+	parent, err := sessions.Create(ctx, "parent")
+	require.NoError(t, err)
+	var msgs []message.Message
+	for _, text := range []string{"first", "summary", "second", "third"} {
+		msg, err := messages.Create(ctx, parent.ID, message.CreateMessageParams{
+			Role:  message.User,
+			Parts: []message.ContentPart{message.TextContent{Text: text}},
+		})
+		require.NoError(t, err)
+		msgs = append(msgs, msg)
+	}
+	parent.SummaryMessageID = msgs[1].ID
+	parent, err = sessions.Save(ctx, parent)
+	require.NoError(t, err)
+	_, err = q.CreateFile(ctx, db.CreateFileParams{ID: "file_1", SessionID: parent.ID, Path: "main.go", Content: "package main"})
+	require.NoError(t, err)
+	_, err = q.CreateFile(ctx, db.CreateFileParams{
+		ID:        "file_2",
+		SessionID: parent.ID,
+		MessageID: sql.NullString{String: msgs[0].ID, Valid: true},
+		Path:      "main.go",
+		Content:   "package main\n\nfunc main() {}",
+		Version:   1,
+	})
+	require.NoError(t, err)
+	_, err = q.CreateFile(ctx, db.CreateFileParams{
+		ID:        "file_3",
+		SessionID: parent.ID,
+		MessageID: sql.NullString{String: msgs[3].ID, Valid: true},
+		Path:      "main.go",
+		Content:   "package lib",
+		Version:   2,
+	})
+	require.NoError(t, err)
+
+	fork, err := sessions.Fork(ctx, parent.ID, msgs[2].ID)
+	require.NoError(t, err)
+	require.Equal(t, parent.ID, fork.ParentSessionID)
+	require.Equal(t, msgs[2].ID, fork.ForkMessageID)
+	require.Equal(t, "parent", fork.Title)
+	require.Equal(t, int64(2), fork.MessageCount)
+
+	t.Run("copies the messages before the fork message", func(t *testing.T) {
+		forked, err := messages.List(ctx, fork.ID)
+		require.NoError(t, err)
+		require.Len(t, forked, 2)
+		require.Equal(t, "first", forked[0].Content().String())
+		require.Equal(t, "summary", forked[1].Content().String())
+		require.Equal(t, msgs[0].CreatedAt, forked[0].CreatedAt)
+		require.Equal(t, forked[1].ID, fork.SummaryMessageID)
+	})
+
+	t.Run("copies the file versions saved before the fork message", func(t *testing.T) {
+		files, err := q.ListFilesBySession(ctx, fork.ID)
+		require.NoError(t, err)
+		require.Len(t, files, 2)
+		require.Equal(t, "main.go", files[0].Path)
+		require.Equal(t, "package main", files[0].Content)
+		require.False(t, files[0].MessageID.Valid)
+
+		forked, err := messages.List(ctx, fork.ID)
+		require.NoError(t, err)
+		require.Equal(t, "package main\n\nfunc main() {}", files[1].Content)
+		require.Equal(t, forked[0].ID, files[1].MessageID.String)
+	})
+
+	t.Run("is listed and pays for itself", func(t *testing.T) {
+		listed, err := sessions.List(ctx)
+		require.NoError(t, err)
+		require.Len(t, listed, 2)
+
+		_, err = usage.NewService(q).Create(ctx, fork.ID, usage.CreateUsageParams{Model: "m", Provider: "p", Cost: 1})
+		require.NoError(t, err)
+		got, err := sessions.Get(ctx, parent.ID)
+		require.NoError(t, err)
+		require.Zero(t, got.Cost)
+		got, err = sessions.Get(ctx, fork.ID)
+		require.NoError(t, err)
+		require.Equal(t, 1.0, got.Cost)
+	})
+
+	t.Run("needs a message of the session", func(t *testing.T) {
+		_, err := sessions.Fork(ctx, parent.ID, "missing")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,213 @@
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"gentica/db"
+	"gentica/pubsub"
+	"github.com/google/uuid"
+)
+
+// Usage is what one model response consumed. Responses that are not stored
+// as messages, like session titles, have no MessageID.
+type Usage struct {
+	ID                  string
+	SessionID           string
+	MessageID           string
+	Model               string
+	Provider            string
+	InputTokens         int64
+	OutputTokens        int64
+	CacheCreationTokens int64
+	CacheReadTokens     int64
+	Cost                float64
+	CreatedAt           int64
+}
+
+// ContextTokens is the size of the context the response was generated
+// from, plus the response itself.
+func (u Usage) ContextTokens() int64 {
+	return u.InputTokens + u.CacheCreationTokens + u.CacheReadTokens + u.OutputTokens
+}
+
+type CreateUsageParams struct {
+	MessageID           string
+	Model               string
+	Provider            string
+	InputTokens         int64
+	OutputTokens        int64
+	CacheCreationTokens int64
+	CacheReadTokens     int64
+	Cost                float64
+}
+
+// Totals adds up the usage of several responses.
+type Totals struct {
+	Responses           int64
+	InputTokens         int64
+	OutputTokens        int64
+	CacheCreationTokens int64
+	CacheReadTokens     int64
+	Cost                float64
+}
+
+type ModelTotals struct {
+	Provider string
+	Model    string
+	Totals
+}
+
+type DayTotals struct {
+	// Day is the UTC date, as YYYY-MM-DD.
+	Day string
+	Totals
+}
+
+type Service interface {
+	pubsub.Suscriber[Usage]
+	// Create records usage. The totals of the session, and the cost of its
+	// parent session, are updated along with it.
+	Create(ctx context.Context, sessionID string, params CreateUsageParams) (Usage, error)
+	List(ctx context.Context, sessionID string) ([]Usage, error)
+	// LastMessageUsage returns the usage of the latest response of the
+	// session that was stored as a message, and false if there is none.
+	LastMessageUsage(ctx context.Context, sessionID string) (Usage, bool, error)
+	SessionTotals(ctx context.Context, sessionID string) (Totals, error)
+	ModelTotals(ctx context.Context, since time.Time) ([]ModelTotals, error)
+	DailyTotals(ctx context.Context, since time.Time) ([]DayTotals, error)
+}
+
+type service struct {
+	*pubsub.Broker[Usage]
+	q db.Querier
+}
+
+func NewService(q db.Querier) Service {
+	return &service{
+		Broker: pubsub.NewBroker[Usage](),
+		q:      q,
+	}
+}
+
+func (s *service) Create(ctx context.Context, sessionID string, params CreateUsageParams) (Usage, error) {
+	dbUsage, err := s.q.CreateUsage(ctx, db.CreateUsageParams{
+		ID:                  uuid.New().String(),
+		SessionID:           sessionID,
+		MessageID:           sql.NullString{String: params.MessageID, Valid: params.MessageID != ""},
+		Model:               params.Model,
+		Provider:            params.Provider,
+		InputTokens:         params.InputTokens,
+		OutputTokens:        params.OutputTokens,
+		CacheCreationTokens: params.CacheCreationTokens,
+		CacheReadTokens:     params.CacheReadTokens,
+		Cost:                params.Cost,
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+	usage := fromDBItem(dbUsage)
+	s.Publish(pubsub.CreatedEvent, usage)
+	return usage, nil
+}
+
+func (s *service) List(ctx context.Context, sessionID string) ([]Usage, error) {
+	dbUsage, err := s.q.ListUsageBySession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	usage := make([]Usage, len(dbUsage))
+	for i, item := range dbUsage {
+		usage[i] = fromDBItem(item)
+	}
+	return usage, nil
+}
+
+func (s *service) LastMessageUsage(ctx context.Context, sessionID string) (Usage, bool, error) {
+	dbUsage, err := s.q.GetLastMessageUsage(ctx, sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Usage{}, false, nil
+	}
+	if err != nil {
+		return Usage{}, false, err
+	}
+	return fromDBItem(dbUsage), true, nil
+}
+
+func (s *service) SessionTotals(ctx context.Context, sessionID string) (Totals, error) {
+	row, err := s.q.GetSessionUsageTotals(ctx, sessionID)
+	if err != nil {
+		return Totals{}, err
+	}
+	return Totals{
+		Responses:           row.Responses,
+		InputTokens:         row.InputTokens,
+		OutputTokens:        row.OutputTokens,
+		CacheCreationTokens: row.CacheCreationTokens,
+		CacheReadTokens:     row.CacheReadTokens,
+		Cost:                row.Cost,
+	}, nil
+}
+
+func (s *service) ModelTotals(ctx context.Context, since time.Time) ([]ModelTotals, error) {
+	rows, err := s.q.ListUsageTotalsByModel(ctx, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	totals := make([]ModelTotals, len(rows))
+	for i, row := range rows {
+		totals[i] = ModelTotals{
+			Provider: row.Provider,
+			Model:    row.Model,
+			Totals: Totals{
+				Responses:           row.Responses,
+				InputTokens:         row.InputTokens,
+				OutputTokens:        row.OutputTokens,
+				CacheCreationTokens: row.CacheCreationTokens,
+				CacheReadTokens:     row.CacheReadTokens,
+				Cost:                row.Cost,
+			},
+		}
+	}
+	return totals, nil
+}
+
+func (s *service) DailyTotals(ctx context.Context, since time.Time) ([]DayTotals, error) {
+	rows, err := s.q.ListUsageTotalsByDay(ctx, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	totals := make([]DayTotals, len(rows))
+	for i, row := range rows {
+		totals[i] = DayTotals{
+			Day: row.Day,
+			Totals: Totals{
+				Responses:           row.Responses,
+				InputTokens:         row.InputTokens,
+				OutputTokens:        row.OutputTokens,
+				CacheCreationTokens: row.CacheCreationTokens,
+				CacheReadTokens:     row.CacheReadTokens,
+				Cost:                row.Cost,
+			},
+		}
+	}
+	return totals, nil
+}
+
+func fromDBItem(item db.Usage) Usage {
+	return Usage{
+		ID:                  item.ID,
+		SessionID:           item.SessionID,
+		MessageID:           item.MessageID.String,
+		Model:               item.Model,
+		Provider:            item.Provider,
+		InputTokens:         item.InputTokens,
+		OutputTokens:        item.OutputTokens,
+		CacheCreationTokens: item.CacheCreationTokens,
+		CacheReadTokens:     item.CacheReadTokens,
+		Cost:                item.Cost,
+		CreatedAt:           item.CreatedAt,
+	}
+}
@@ -0,0 +1,126 @@
+package usage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gentica/db"
+	"gentica/session"
+	"gentica/usage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsage(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conn, err := db.Connect(ctx, t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	q := db.New(conn)
+	sessions := session.NewService(q)
+	ledger := usage.NewService(q)
+
+	parent, err := sessions.Create(ctx, "parent")
+	require.NoError(t, err)
+	task, err := sessions.CreateTaskSession(ctx, "call_1", parent.ID, "task")
+	require.NoError(t, err)
+
+	_, err = ledger.Create(ctx, parent.ID, usage.CreateUsageParams{
+		MessageID: "msg_1", Model: "big", Provider: "anthropic",
+		InputTokens: 10, OutputTokens: 5, CacheCreationTokens: 100, Cost: 1,
+	})
+	require.NoError(t, err)
+	_, err = ledger.Create(ctx, parent.ID, usage.CreateUsageParams{
+		MessageID: "msg_2", Model: "big", Provider: "anthropic",
+		InputTokens: 20, OutputTokens: 7, CacheReadTokens: 110, Cost: 0.5,
+	})
+	require.NoError(t, err)
+	_, err = ledger.Create(ctx, parent.ID, usage.CreateUsageParams{
+		Model: "small", Provider: "openai", InputTokens: 30, OutputTokens: 1, Cost: 0.25,
+	})
+	require.NoError(t, err)
+	_, err = ledger.Create(ctx, task.ID, usage.CreateUsageParams{
+		MessageID: "msg_3", Model: "small", Provider: "openai", InputTokens: 40, OutputTokens: 2, Cost: 2,
+	})
+	require.NoError(t, err)
+
+	t.Run("sessions add up their usage", func(t *testing.T) {
+		sess, err := sessions.Get(ctx, parent.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(10+100+20+110+30), sess.PromptTokens)
+		require.Equal(t, int64(5+7+1), sess.CompletionTokens)
+		// The task session's cost counts toward its parent.
+		require.Equal(t, 1+0.5+0.25+2, sess.Cost)
+
+		sess, err = sessions.Get(ctx, task.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(40), sess.PromptTokens)
+		require.Equal(t, 2.0, sess.Cost)
+	})
+
+	t.Run("saving a session keeps its totals", func(t *testing.T) {
+		sess, err := sessions.Get(ctx, task.ID)
+		require.NoError(t, err)
+		sess.Title = "renamed"
+		sess.PromptTokens = 0
+		sess.Cost = 0
+		saved, err := sessions.Save(ctx, sess)
+		require.NoError(t, err)
+		require.Equal(t, "renamed", saved.Title)
+		require.Equal(t, int64(40), saved.PromptTokens)
+		require.Equal(t, 2.0, saved.Cost)
+	})
+
+	t.Run("last message usage skips responses without a message", func(t *testing.T) {
+		last, ok, err := ledger.LastMessageUsage(ctx, parent.ID)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "msg_2", last.MessageID)
+		require.Equal(t, int64(20+110+7), last.ContextTokens())
+
+		empty, err := sessions.Create(ctx, "empty")
+		require.NoError(t, err)
+		_, ok, err = ledger.LastMessageUsage(ctx, empty.ID)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("totals", func(t *testing.T) {
+		totals, err := ledger.SessionTotals(ctx, parent.ID)
+		require.NoError(t, err)
+		require.Equal(t, usage.Totals{
+			Responses:           3,
+			InputTokens:         60,
+			OutputTokens:        13,
+			CacheCreationTokens: 100,
+			CacheReadTokens:     110,
+			Cost:                1.75,
+		}, totals)
+
+		byModel, err := ledger.ModelTotals(ctx, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		require.Len(t, byModel, 2)
+		require.Equal(t, "openai", byModel[0].Provider)
+		require.Equal(t, "small", byModel[0].Model)
+		require.Equal(t, int64(2), byModel[0].Responses)
+		require.Equal(t, 2.25, byModel[0].Cost)
+		require.Equal(t, "big", byModel[1].Model)
+		require.Equal(t, 1.5, byModel[1].Cost)
+
+		byDay, err := ledger.DailyTotals(ctx, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		require.NotEmpty(t, byDay)
+		var responses int64
+		for _, day := range byDay {
+			responses += day.Responses
+		}
+		require.Equal(t, int64(4), responses)
+
+		later, err := ledger.DailyTotals(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.Empty(t, later)
+	})
+}